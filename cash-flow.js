@@ -1,19 +1,23 @@
 import http from 'k6/http';
 import { sleep, check } from 'k6';
 import exec from 'k6/execution';
-import { createFinancialRecords } from './populate.js';
+import { createFinancialRecords, ensureOrganizations, headers } from './populate.js';
 export const options = {
   vus: 100,
   duration: '15s',
   // duration: '60s',
 };
 
-const BASE_URL = 'http://localhost:8080';
+const BASE_URL = 'http://localhost:8080/api/v1';
 
-export default function () {
-  const orgId = Math.max(1, (exec.vu.idInTest % 10) + 1);
+export function setup() {
+  return { orgIds: ensureOrganizations() };
+}
+
+export default function (data) {
+  const orgId = data.orgIds[exec.vu.idInTest % data.orgIds.length];
 
-  const response = http.get(`${BASE_URL}/organizations/${orgId}/financial-records/reports/cash-flow`);
+  const response = http.get(`${BASE_URL}/organizations/${orgId}/financial-records/reports/cash-flow`, { headers });
 
   if (response.status !== 200) {
     console.log(`Failed to get cash flow for organization ${orgId}: ${response.status} ${response.body}`);
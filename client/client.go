@@ -0,0 +1,682 @@
+// Package client is a typed Go client for the financial records API.
+//
+// Failed requests are retried with exponential backoff when the failure is
+// transient: a network error, 429, 502, 503 or 504. Every write call sends
+// an Idempotency-Key header, kept across its retries, so that a retried
+// write is applied once.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures a Client. The zero value is ready to use.
+type Options struct {
+	// APIKey is sent as the bearer token of every request. A JWT of the
+	// identity provider works as well.
+	APIKey string
+
+	// HTTPClient sends the requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times a request failing with a transient error
+	// is retried. Zero uses 3 retries; a negative value disables retries.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the randomized exponential delay
+	// between attempts. They default to 100ms and 5s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Client calls the financial records API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	http       *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// New returns a client for the API served at baseURL, including the version
+// prefix, e.g. "http://localhost:8080/api/v1".
+func New(baseURL string, opts Options) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     opts.APIKey,
+		http:       opts.HTTPClient,
+		maxRetries: opts.MaxRetries,
+		minBackoff: opts.MinBackoff,
+		maxBackoff: opts.MaxBackoff,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	switch {
+	case c.maxRetries == 0:
+		c.maxRetries = 3
+	case c.maxRetries < 0:
+		c.maxRetries = 0
+	}
+	if c.minBackoff <= 0 {
+		c.minBackoff = 100 * time.Millisecond
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = 5 * time.Second
+	}
+	c.maxBackoff = max(c.maxBackoff, c.minBackoff)
+	return c
+}
+
+// Error is returned for responses with a non-2xx status, decoded from the
+// RFC 7807 problem details the API answers with.
+type Error struct {
+	StatusCode int
+	// Code is the stable, machine-readable error code, e.g.
+	// "validation_failed". Empty when the response carried no problem
+	// details, e.g. when it came from a proxy.
+	Code    string
+	Message string
+	// FieldErrors lists the invalid fields of a rejected request body.
+	FieldErrors []FieldError
+	// RequestID is the X-Request-ID of the failed request, to correlate
+	// with the server logs.
+	RequestID string
+}
+
+// FieldError is one invalid field of a request body.
+type FieldError struct {
+	// Field is the JSON field, prefixed with the item index for bulk
+	// requests, e.g. "[0].direction".
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	for _, f := range e.FieldErrors {
+		msg += "; " + f.Field + ": " + f.Message
+	}
+	return fmt.Sprintf("financial records API: %d %s", e.StatusCode, msg)
+}
+
+type idempotencyKeyKey struct{}
+
+// WithIdempotencyKey makes the write call made with ctx send key as its
+// Idempotency-Key, instead of a random one. Use it to make a write
+// idempotent across process restarts, e.g. with a key derived from the
+// source of the data.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// CreateOrganization creates an organization.
+func (c *Client) CreateOrganization(ctx context.Context, settings OrganizationSettings) (*Organization, error) {
+	var org Organization
+	if err := c.do(ctx, http.MethodPost, "/organizations", nil, settings, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetOrganization returns an organization. It fails with an *Error with
+// code "organization_not_found" when there is no such organization.
+func (c *Client) GetOrganization(ctx context.Context, orgID uint) (*Organization, error) {
+	var org Organization
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, ""), nil, nil, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// ListOrganizations returns one page of organizations.
+func (c *Client) ListOrganizations(ctx context.Context, opts ListOptions) (*Page[Organization], error) {
+	var page Page[Organization]
+	if err := c.do(ctx, http.MethodGet, "/organizations", opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllOrganizations iterates over the organizations, fetching pages of
+// pageSize items as needed. A zero pageSize uses the server default. The
+// iteration stops at the first error.
+func (c *Client) AllOrganizations(ctx context.Context, pageSize int) iter.Seq2[Organization, error] {
+	return paginate(func(page int) (*Page[Organization], error) {
+		return c.ListOrganizations(ctx, ListOptions{Page: page, PageSize: pageSize})
+	})
+}
+
+// UpdateOrganization replaces the name and settings of an organization.
+func (c *Client) UpdateOrganization(ctx context.Context, orgID uint, settings OrganizationSettings) (*Organization, error) {
+	var org Organization
+	if err := c.do(ctx, http.MethodPut, orgPath(orgID, ""), nil, settings, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// DeleteOrganization deletes an organization. Its tags and financial
+// records are kept but can no longer be reached.
+func (c *Client) DeleteOrganization(ctx context.Context, orgID uint) error {
+	return c.do(ctx, http.MethodDelete, orgPath(orgID, ""), nil, nil, nil)
+}
+
+// CreateAPIKey issues an API key, which needs the admin key. The secret of
+// the key is only returned here.
+func (c *Client) CreateAPIKey(ctx context.Context, key NewAPIKey) (*IssuedAPIKey, error) {
+	var issued IssuedAPIKey
+	if err := c.do(ctx, http.MethodPost, "/api-keys", nil, key, &issued); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// ListAPIKeys returns one page of the API keys that are not revoked.
+func (c *Client) ListAPIKeys(ctx context.Context, opts ListOptions) (*Page[APIKey], error) {
+	var page Page[APIKey]
+	if err := c.do(ctx, http.MethodGet, "/api-keys", opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// RevokeAPIKey revokes an API key. It fails with an *Error with code
+// "api_key_not_found" when there is no such key or it is already revoked.
+func (c *Client) RevokeAPIKey(ctx context.Context, keyID uint) error {
+	return c.do(ctx, http.MethodDelete, "/api-keys/"+strconv.FormatUint(uint64(keyID), 10), nil, nil, nil)
+}
+
+// CreateTag creates a tag in the organization. It fails with an *Error
+// with code "tag_exists" when the organization already has a tag with this
+// name, ignoring case and whitespace.
+func (c *Client) CreateTag(ctx context.Context, orgID uint, name string) (*Tag, error) {
+	return c.createTag(ctx, orgID, name, nil)
+}
+
+// UpsertTag creates a tag in the organization, or returns the existing tag
+// when the name is taken.
+func (c *Client) UpsertTag(ctx context.Context, orgID uint, name string) (*Tag, error) {
+	return c.createTag(ctx, orgID, name, url.Values{"upsert": {"true"}})
+}
+
+func (c *Client) createTag(ctx context.Context, orgID uint, name string, query url.Values) (*Tag, error) {
+	var tag Tag
+	body := map[string]string{"name": name}
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "tags"), query, body, &tag); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// ListTags returns one page of the organization's tags.
+func (c *Client) ListTags(ctx context.Context, orgID uint, opts ListOptions) (*Page[Tag], error) {
+	return c.ListTagsWithOptions(ctx, orgID, ListTagsOptions{ListOptions: opts})
+}
+
+// ListTagsWithOptions returns one page of the organization's tags, deleted
+// ones included when asked for.
+func (c *Client) ListTagsWithOptions(ctx context.Context, orgID uint, opts ListTagsOptions) (*Page[Tag], error) {
+	query := opts.query()
+	if opts.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+
+	var page Page[Tag]
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "tags"), query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllTags iterates over the organization's tags, fetching pages of
+// pageSize items as needed. A zero pageSize uses the server default. The
+// iteration stops at the first error.
+func (c *Client) AllTags(ctx context.Context, orgID uint, pageSize int) iter.Seq2[Tag, error] {
+	return paginate(func(page int) (*Page[Tag], error) {
+		return c.ListTags(ctx, orgID, ListOptions{Page: page, PageSize: pageSize})
+	})
+}
+
+// DeleteTag soft-deletes the organization's tag. It fails with an *Error
+// with code "tag_not_found" when the tag does not exist or is already
+// deleted.
+func (c *Client) DeleteTag(ctx context.Context, orgID, tagID uint) error {
+	return c.do(ctx, http.MethodDelete, orgPath(orgID, "tags/"+strconv.FormatUint(uint64(tagID), 10)), nil, nil, nil)
+}
+
+// RestoreTag undeletes the organization's tag. It fails with an *Error with
+// code "tag_exists" when another tag took its name in the meantime.
+func (c *Client) RestoreTag(ctx context.Context, orgID, tagID uint) (*Tag, error) {
+	var tag Tag
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "tags/"+strconv.FormatUint(uint64(tagID), 10)+"/restore"), nil, nil, &tag); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// CreateFinancialRecord creates a financial record in the organization.
+func (c *Client) CreateFinancialRecord(ctx context.Context, orgID uint, record NewFinancialRecord) (*FinancialRecord, error) {
+	var created FinancialRecord
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "financial-records"), nil, record, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// CreateFinancialRecords creates several financial records in a single
+// transaction: either all of them are stored or none is.
+func (c *Client) CreateFinancialRecords(ctx context.Context, orgID uint, records []NewFinancialRecord) ([]FinancialRecord, error) {
+	var created []FinancialRecord
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "financial-records/bulk"), nil, records, &created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ListFinancialRecords returns one page of the organization's financial
+// records.
+func (c *Client) ListFinancialRecords(ctx context.Context, orgID uint, opts ListFinancialRecordsOptions) (*Page[FinancialRecord], error) {
+	query := opts.query()
+	if len(opts.TagIDs) > 0 {
+		ids := make([]string, len(opts.TagIDs))
+		for i, id := range opts.TagIDs {
+			ids[i] = strconv.FormatUint(uint64(id), 10)
+		}
+		query.Set("tags", strings.Join(ids, ","))
+	}
+	if opts.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+	if opts.Counterparty != "" {
+		query.Set("counterparty", opts.Counterparty)
+	}
+	if opts.CounterpartyDocument != "" {
+		query.Set("counterparty_document", opts.CounterpartyDocument)
+	}
+	if opts.Reference != "" {
+		query.Set("reference", opts.Reference)
+	}
+	if opts.Metadata != nil {
+		metadata, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("metadata", string(metadata))
+	}
+	if opts.Query != "" {
+		query.Set("q", opts.Query)
+	}
+
+	var page Page[FinancialRecord]
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "financial-records"), query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllFinancialRecords iterates over the organization's financial records
+// matching opts, starting at opts.Page and fetching pages as needed. The
+// iteration stops at the first error.
+func (c *Client) AllFinancialRecords(ctx context.Context, orgID uint, opts ListFinancialRecordsOptions) iter.Seq2[FinancialRecord, error] {
+	start := max(opts.Page, 1)
+	return paginate(func(page int) (*Page[FinancialRecord], error) {
+		opts.Page = start + page - 1
+		return c.ListFinancialRecords(ctx, orgID, opts)
+	})
+}
+
+// DeleteFinancialRecord soft-deletes the organization's financial record.
+// It fails with an *Error with code "financial_record_not_found" when the
+// record does not exist or is already deleted.
+func (c *Client) DeleteFinancialRecord(ctx context.Context, orgID, recordID uint) error {
+	return c.do(ctx, http.MethodDelete, orgPath(orgID, "financial-records/"+strconv.FormatUint(uint64(recordID), 10)), nil, nil, nil)
+}
+
+// RestoreFinancialRecord undeletes the organization's financial record.
+func (c *Client) RestoreFinancialRecord(ctx context.Context, orgID, recordID uint) (*FinancialRecord, error) {
+	var record FinancialRecord
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "financial-records/"+strconv.FormatUint(uint64(recordID), 10)+"/restore"), nil, nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// SettleFinancialRecord pays the whole balance of the organization's
+// financial record, or part of it. It fails with an *Error with code
+// "financial_record_paid" or "financial_record_cancelled" when the record
+// cannot be paid.
+func (c *Client) SettleFinancialRecord(ctx context.Context, orgID, recordID uint, settlement Settlement) (*FinancialRecord, error) {
+	var record FinancialRecord
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "financial-records/"+strconv.FormatUint(uint64(recordID), 10)+"/settle"), nil, settlement, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CancelFinancialRecord cancels the organization's financial record. It
+// fails with an *Error with code "financial_record_paid" when the record is
+// paid in full.
+func (c *Client) CancelFinancialRecord(ctx context.Context, orgID, recordID uint) (*FinancialRecord, error) {
+	var record FinancialRecord
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "financial-records/"+strconv.FormatUint(uint64(recordID), 10)+"/cancel"), nil, nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// EditFinancialRecord changes the fields of the organization's financial
+// record set in change, with scope EditThis, or also those of the later
+// occurrences of its recurrence with EditFuture. It fails with an *Error
+// with code "financial_record_not_recurring" when EditFuture is used on a
+// record that does not recur.
+func (c *Client) EditFinancialRecord(ctx context.Context, orgID, recordID uint, scope string, change RecordChange) (*FinancialRecord, error) {
+	query := url.Values{}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	var record FinancialRecord
+	if err := c.do(ctx, http.MethodPatch, orgPath(orgID, "financial-records/"+strconv.FormatUint(uint64(recordID), 10)), query, change, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CreateRecurrence makes the organization's financial record the first
+// occurrence of a recurrence repeating it by rule. It fails with an *Error
+// with code "financial_record_recurring" when the record already recurs.
+func (c *Client) CreateRecurrence(ctx context.Context, orgID, recordID uint, rule RecurrenceRule) (*Recurrence, error) {
+	var rec Recurrence
+	if err := c.do(ctx, http.MethodPost, orgPath(orgID, "financial-records/"+strconv.FormatUint(uint64(recordID), 10)+"/recurrence"), nil, rule, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ListRecurrences returns one page of the organization's recurrences.
+func (c *Client) ListRecurrences(ctx context.Context, orgID uint, opts ListOptions) (*Page[Recurrence], error) {
+	var page Page[Recurrence]
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "recurrences"), opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetRecurrence returns the organization's recurrence. It fails with an
+// *Error with code "recurrence_not_found" when it does not exist.
+func (c *Client) GetRecurrence(ctx context.Context, orgID, recurrenceID uint) (*Recurrence, error) {
+	var rec Recurrence
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "recurrences/"+strconv.FormatUint(uint64(recurrenceID), 10)), nil, nil, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// DeleteRecurrence ends the organization's recurrence: no occurrence is
+// created after, and the ones created are kept. It fails with an *Error
+// with code "recurrence_not_found" when it does not exist or has ended.
+func (c *Client) DeleteRecurrence(ctx context.Context, orgID, recurrenceID uint) error {
+	return c.do(ctx, http.MethodDelete, orgPath(orgID, "recurrences/"+strconv.FormatUint(uint64(recurrenceID), 10)), nil, nil, nil)
+}
+
+// CashFlowReport returns the organization's monthly cash flow for the last
+// two years, by due date.
+func (c *Client) CashFlowReport(ctx context.Context, orgID uint) (*CashFlowReport, error) {
+	return c.CashFlowReportWithOptions(ctx, orgID, CashFlowReportOptions{})
+}
+
+// CashFlowReportWithOptions returns the organization's monthly cash flow
+// for the last two years, by due date or by payment date.
+func (c *Client) CashFlowReportWithOptions(ctx context.Context, orgID uint, opts CashFlowReportOptions) (*CashFlowReport, error) {
+	query := url.Values{}
+	if opts.Basis != "" {
+		query.Set("basis", opts.Basis)
+	}
+	var report CashFlowReport
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "financial-records/reports/cash-flow"), query, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ListAuditEvents returns one page of the organization's audit events,
+// newest first.
+func (c *Client) ListAuditEvents(ctx context.Context, orgID uint, opts ListAuditEventsOptions) (*Page[AuditEvent], error) {
+	query := opts.query()
+	for name, value := range map[string]string{
+		"entity_type": opts.EntityType,
+		"action":      opts.Action,
+		"actor":       opts.Actor,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if opts.EntityID != 0 {
+		query.Set("entity_id", strconv.FormatUint(uint64(opts.EntityID), 10))
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	if !opts.Until.IsZero() {
+		query.Set("until", opts.Until.Format(time.RFC3339Nano))
+	}
+
+	var page Page[AuditEvent]
+	if err := c.do(ctx, http.MethodGet, orgPath(orgID, "audit-events"), query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// paginate yields the items of consecutive pages, numbered from 1, until a
+// page reports it is the last one. Items created or deleted while iterating
+// may shift between pages, since the API paginates by offset.
+func paginate[T any](fetch func(page int) (*Page[T], error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for number := 1; ; number++ {
+			page, err := fetch(number)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range page.Data {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if len(page.Data) == 0 || int64(page.Pagination.CurrentPage) >= page.Pagination.TotalPages {
+				return
+			}
+		}
+	}
+}
+
+// orgPath returns the path of a resource of the organization, or of the
+// organization itself when resource is empty.
+func orgPath(orgID uint, resource string) string {
+	path := "/organizations/" + strconv.FormatUint(uint64(orgID), 10)
+	if resource != "" {
+		path += "/" + resource
+	}
+	return path
+}
+
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	if o.Page > 0 {
+		query.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PageSize > 0 {
+		query.Set("page_size", strconv.Itoa(o.PageSize))
+	}
+	return query
+}
+
+// do sends a request with an optional JSON body, retrying transient
+// failures, and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	var idempotencyKey string
+	if method != http.MethodGet {
+		idempotencyKey, _ = ctx.Value(idempotencyKeyKey{}).(string)
+		if idempotencyKey == "" {
+			idempotencyKey = newIdempotencyKey()
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, method, u, idempotencyKey, body, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(ctx, err) {
+			return err
+		}
+
+		timer := time.NewTimer(max(c.backoff(attempt), retryAfter))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// send makes a single attempt. On failure it also returns the delay the
+// server asked for in a Retry-After header, if any.
+func (c *Client) send(ctx context.Context, method, u, idempotencyKey string, body []byte, out any) (time.Duration, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return retryAfter(resp), decodeError(resp)
+	}
+	if out == nil {
+		return 0, nil
+	}
+	return 0, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryable reports whether err is worth another attempt.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// Anything else failed before a response arrived: a refused or reset
+	// connection, or a timeout of the HTTP client.
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoff returns the delay before retry number attempt+1: a random
+// duration up to minBackoff doubled attempt times, capped at maxBackoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.maxBackoff
+	if attempt < 32 && c.minBackoff <= c.maxBackoff>>attempt {
+		d = c.minBackoff << attempt
+	}
+	return c.minBackoff/2 + mathrand.N(d-c.minBackoff/2+1)
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func decodeError(resp *http.Response) error {
+	var problem struct {
+		Title     string       `json:"title"`
+		Detail    string       `json:"detail"`
+		Code      string       `json:"code"`
+		RequestID string       `json:"requestId"`
+		Errors    []FieldError `json:"errors"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&problem)
+
+	err := &Error{
+		StatusCode:  resp.StatusCode,
+		Code:        problem.Code,
+		Message:     problem.Detail,
+		FieldErrors: problem.Errors,
+		RequestID:   problem.RequestID,
+	}
+	if err.Message == "" {
+		err.Message = problem.Title
+	}
+	if err.RequestID == "" {
+		err.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return err
+}
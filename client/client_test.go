@@ -0,0 +1,520 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/client"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/httpapi"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// newTestServer serves the real router on an in-memory store holding
+// organizations 1 and 2. wrap, if not nil, can intercept requests before
+// they reach the router.
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *client.Client {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mem := store.NewMemoryStore()
+	for _, name := range []string{"Acme", "Globex"} {
+		org := domain.Organization{Name: name, TimeZone: domain.DefaultTimeZone, Currency: domain.DefaultCurrency}
+		require.NoError(t, mem.CreateOrganization(context.Background(), &org))
+	}
+	var h http.Handler = httpapi.NewRouter(httpapi.Deps{
+		Stores:      store.Stores{Organizations: mem, Tags: mem, FinancialRecords: mem, Recurrences: mem, AuditEvents: mem},
+		Logger:      slog.New(slog.DiscardHandler),
+		Idempotency: httpapi.NewIdempotency(time.Hour),
+	})
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return client.New(srv.URL+httpapi.APIPrefix, client.Options{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	})
+}
+
+func TestClientOrganizations(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	org, err := c.CreateOrganization(ctx, client.OrganizationSettings{Name: "Initech", TimeZone: "America/Chicago"})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), org.ID)
+	assert.Equal(t, "America/Chicago", org.TimeZone)
+	assert.Equal(t, "USD", org.Currency)
+
+	org, err = c.UpdateOrganization(ctx, org.ID, client.OrganizationSettings{Name: "Initech", Currency: "EUR"})
+	require.NoError(t, err)
+	assert.Equal(t, "UTC", org.TimeZone)
+	assert.Equal(t, "EUR", org.Currency)
+
+	got, err := c.GetOrganization(ctx, org.ID)
+	require.NoError(t, err)
+	assert.Equal(t, *org, *got)
+
+	var names []string
+	for org, err := range c.AllOrganizations(ctx, 2) {
+		require.NoError(t, err)
+		names = append(names, org.Name)
+	}
+	assert.Equal(t, []string{"Acme", "Globex", "Initech"}, names)
+
+	require.NoError(t, c.DeleteOrganization(ctx, org.ID))
+	_, err = c.CreateTag(ctx, org.ID, "Rent")
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "organization_not_found", apiErr.Code)
+}
+
+func TestClientTags(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		tag, err := c.CreateTag(ctx, 1, name)
+		require.NoError(t, err)
+		assert.Equal(t, name, tag.Name)
+		assert.Equal(t, uint(1), tag.OrganizationID)
+	}
+	_, err := c.CreateTag(ctx, 2, "other org")
+	require.NoError(t, err)
+
+	page, err := c.ListTags(ctx, 1, client.ListOptions{Page: 2, PageSize: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 2)
+	assert.Equal(t, "c", page.Data[0].Name)
+	assert.Equal(t, int64(5), page.Pagination.TotalItems)
+	assert.Equal(t, int64(3), page.Pagination.TotalPages)
+
+	var names []string
+	for tag, err := range c.AllTags(ctx, 1, 2) {
+		require.NoError(t, err)
+		names = append(names, tag.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+}
+
+func TestClientUpsertTag(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	created, err := c.UpsertTag(ctx, 1, "Rent")
+	require.NoError(t, err)
+
+	_, err = c.CreateTag(ctx, 1, "rent")
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+	assert.Equal(t, "tag_exists", apiErr.Code)
+
+	existing, err := c.UpsertTag(ctx, 1, " RENT ")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, existing.ID)
+	assert.Equal(t, "Rent", existing.Name)
+}
+
+func TestClientFinancialRecords(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	rent, err := c.CreateTag(ctx, 1, "Rent")
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	record, err := c.CreateFinancialRecord(ctx, 1, client.NewFinancialRecord{
+		Direction: client.DirectionOut,
+		Amount:    1000,
+		DueDate:   now,
+		TagIDs:    []uint{rent.ID},
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, record.ID)
+	require.Len(t, record.Tags, 1)
+	assert.Equal(t, rent.ID, record.Tags[0].ID)
+
+	created, err := c.CreateFinancialRecords(ctx, 1, []client.NewFinancialRecord{
+		{Direction: client.DirectionIn, Amount: 300, DueDate: now},
+		{Direction: client.DirectionIn, Amount: 200, DueDate: now, CounterpartyName: "ACME", Reference: "NF-7",
+			Metadata: map[string]any{"project": "apollo", "hours": 12}},
+		{Direction: client.DirectionOut, Amount: 50, DueDate: now, TagIDs: []uint{rent.ID}},
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 3)
+
+	page, err := c.ListFinancialRecords(ctx, 1, client.ListFinancialRecordsOptions{
+		Counterparty: "acme",
+		Metadata:     map[string]any{"project": "apollo"},
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 1)
+	assert.Equal(t, "NF-7", page.Data[0].Reference)
+	assert.Equal(t, map[string]any{"project": "apollo", "hours": 12.0}, page.Data[0].Metadata)
+	page, err = c.ListFinancialRecords(ctx, 1, client.ListFinancialRecordsOptions{Query: "nf-7"})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 1)
+	assert.Equal(t, created[1].ID, page.Data[0].ID)
+
+	var amounts []float64
+	for r, err := range c.AllFinancialRecords(ctx, 1, client.ListFinancialRecordsOptions{
+		ListOptions: client.ListOptions{PageSize: 1},
+		TagIDs:      []uint{rent.ID},
+	}) {
+		require.NoError(t, err)
+		amounts = append(amounts, r.Amount)
+	}
+	assert.ElementsMatch(t, []float64{1000, 50}, amounts)
+
+	report, err := c.CashFlowReport(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, client.BasisDue, report.Basis)
+	assert.Equal(t, []client.MonthlyCashFlow{
+		{Year: now.Year(), Month: int(now.Month()), In: 500, Out: 1050},
+	}, report.MonthlyData)
+}
+
+func TestClientSettleAndCancel(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	records, err := c.CreateFinancialRecords(ctx, 1, []client.NewFinancialRecord{
+		{Direction: client.DirectionIn, Amount: 300, DueDate: now.AddDate(0, 1, 0)},
+		{Direction: client.DirectionOut, Amount: 80, DueDate: now.AddDate(0, 0, -1)},
+		{Direction: client.DirectionOut, Amount: 20, DueDate: now, Status: client.StatusPaid, PaidAmount: 20, PaidAt: &now},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, client.StatusPending, records[0].Status)
+	assert.Equal(t, client.StatusOverdue, records[1].Status)
+	assert.Equal(t, client.StatusPaid, records[2].Status)
+
+	record, err := c.SettleFinancialRecord(ctx, 1, records[0].ID, client.Settlement{Amount: 100, PaidAt: now})
+	require.NoError(t, err)
+	assert.Equal(t, client.StatusPending, record.Status)
+	assert.Equal(t, 100.0, record.PaidAmount)
+	record, err = c.SettleFinancialRecord(ctx, 1, records[0].ID, client.Settlement{})
+	require.NoError(t, err)
+	assert.Equal(t, client.StatusPaid, record.Status)
+	assert.Equal(t, 300.0, record.PaidAmount)
+
+	_, err = c.SettleFinancialRecord(ctx, 1, records[0].ID, client.Settlement{})
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+	assert.Equal(t, "financial_record_paid", apiErr.Code)
+
+	record, err = c.CancelFinancialRecord(ctx, 1, records[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, client.StatusCancelled, record.Status)
+
+	report, err := c.CashFlowReportWithOptions(ctx, 1, client.CashFlowReportOptions{Basis: client.BasisPaid})
+	require.NoError(t, err)
+	assert.Equal(t, client.BasisPaid, report.Basis)
+	require.Len(t, report.MonthlyData, 1)
+	assert.Equal(t, 300.0, report.MonthlyData[0].In)
+	assert.Equal(t, 20.0, report.MonthlyData[0].Out)
+}
+
+func TestClientRecurrences(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	records, err := c.CreateFinancialRecords(ctx, 1, []client.NewFinancialRecord{
+		{Direction: client.DirectionOut, Amount: 500, DueDate: now.AddDate(0, 0, -14), Description: "Cleaning"},
+	})
+	require.NoError(t, err)
+
+	rec, err := c.CreateRecurrence(ctx, 1, records[0].ID, client.RecurrenceRule{Frequency: client.FrequencyWeekly, Count: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 1, rec.Interval)
+	assert.Equal(t, 3, rec.Generated)
+	assert.Equal(t, "Cleaning", rec.Description)
+
+	_, err = c.CreateRecurrence(ctx, 1, records[0].ID, client.RecurrenceRule{Frequency: client.FrequencyWeekly})
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "financial_record_recurring", apiErr.Code)
+
+	amount := 450.0
+	record, err := c.EditFinancialRecord(ctx, 1, records[0].ID, client.EditThis, client.RecordChange{Amount: &amount, Metadata: map[string]any{"room": "lobby"}})
+	require.NoError(t, err)
+	assert.Equal(t, 450.0, record.Amount)
+	assert.Equal(t, map[string]any{"room": "lobby"}, record.Metadata)
+	assert.Equal(t, 0, record.Occurrence)
+
+	record, err = c.EditFinancialRecord(ctx, 1, records[0].ID, client.EditFuture, client.RecordChange{
+		RemoveMetadata: true,
+		Recurrence:     &client.RecurrenceRule{Frequency: client.FrequencyWeekly, Interval: 2},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, record.Metadata)
+
+	got, err := c.GetRecurrence(ctx, 1, rec.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 450.0, got.Amount)
+	assert.Equal(t, 2, got.Interval)
+	assert.Zero(t, got.Count)
+
+	page, err := c.ListRecurrences(ctx, 1, client.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 1)
+	assert.Equal(t, rec.ID, page.Data[0].ID)
+
+	_, err = c.GetRecurrence(ctx, 2, rec.ID)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	require.NoError(t, c.DeleteRecurrence(ctx, 1, rec.ID))
+	err = c.DeleteRecurrence(ctx, 1, rec.ID)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "recurrence_not_found", apiErr.Code)
+}
+
+func TestClientDeleteAndRestore(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	rent, err := c.CreateTag(ctx, 1, "Rent")
+	require.NoError(t, err)
+	record, err := c.CreateFinancialRecord(ctx, 1, client.NewFinancialRecord{
+		Direction: client.DirectionOut,
+		Amount:    1000,
+		DueDate:   time.Now().UTC(),
+		TagIDs:    []uint{rent.ID},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.DeleteTag(ctx, 1, rent.ID))
+	require.NoError(t, c.DeleteFinancialRecord(ctx, 1, record.ID))
+
+	err = c.DeleteTag(ctx, 1, rent.ID)
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "tag_not_found", apiErr.Code)
+
+	tags, err := c.ListTags(ctx, 1, client.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, tags.Data)
+	tags, err = c.ListTagsWithOptions(ctx, 1, client.ListTagsOptions{IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Len(t, tags.Data, 1)
+	records, err := c.ListFinancialRecords(ctx, 1, client.ListFinancialRecordsOptions{IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Len(t, records.Data, 1)
+
+	restoredTag, err := c.RestoreTag(ctx, 1, rent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Rent", restoredTag.Name)
+	restored, err := c.RestoreFinancialRecord(ctx, 1, record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, record.ID, restored.ID)
+	require.Len(t, restored.Tags, 1)
+
+	records, err = c.ListFinancialRecords(ctx, 1, client.ListFinancialRecordsOptions{})
+	require.NoError(t, err)
+	assert.Len(t, records.Data, 1)
+}
+
+func TestClientAuditEvents(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := context.Background()
+
+	start := time.Now().Add(-time.Second)
+	rent, err := c.CreateTag(ctx, 1, "Rent")
+	require.NoError(t, err)
+	record, err := c.CreateFinancialRecord(ctx, 1, client.NewFinancialRecord{
+		Direction: client.DirectionOut,
+		Amount:    1000,
+		DueDate:   time.Now().UTC(),
+		TagIDs:    []uint{rent.ID},
+	})
+	require.NoError(t, err)
+
+	page, err := c.ListAuditEvents(ctx, 1, client.ListAuditEventsOptions{Since: start})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 2)
+	assert.Equal(t, client.AuditEntityFinancialRecord, page.Data[0].EntityType)
+	assert.Equal(t, record.ID, page.Data[0].EntityID)
+	assert.Equal(t, client.AuditCreate, page.Data[1].Action)
+	assert.NotEmpty(t, page.Data[1].RequestID)
+
+	page, err = c.ListAuditEvents(ctx, 1, client.ListAuditEventsOptions{EntityType: client.AuditEntityTag, EntityID: rent.ID})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 1)
+	assert.Contains(t, string(page.Data[0].After), `"name":"Rent"`)
+}
+
+func TestClientReturnsAPIErrors(t *testing.T) {
+	var requests atomic.Int32
+	c := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	_, err := c.CreateFinancialRecord(context.Background(), 1, client.NewFinancialRecord{
+		Direction: "SIDEWAYS",
+		DueDate:   time.Now(),
+	})
+	var apiErr *client.Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "validation_failed", apiErr.Code)
+	assert.Equal(t, []client.FieldError{{
+		Field:   "direction",
+		Code:    "invalid_direction",
+		Message: "Direction must be either 'IN' or 'OUT'",
+	}}, apiErr.FieldErrors)
+	assert.NotEmpty(t, apiErr.RequestID)
+	assert.Equal(t, int32(1), requests.Load(), "client errors must not be retried")
+}
+
+func TestClientRetriesTransientErrors(t *testing.T) {
+	var requests atomic.Int32
+	c := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	page, err := c.ListTags(context.Background(), 1, client.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Data)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestClientGivesUpAfterMaxRetries(t *testing.T) {
+	var requests atomic.Int32
+	c := newTestServer(t, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	})
+
+	_, err := c.ListTags(context.Background(), 1, client.ListOptions{})
+	var apiErr *client.Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.Equal(t, int32(4), requests.Load())
+}
+
+func TestClientRetriedWriteIsAppliedOnce(t *testing.T) {
+	// The first attempt reaches the server and is applied, but its response
+	// is replaced by a gateway error, as if a proxy had timed out.
+	var requests atomic.Int32
+	var keys []string
+	c := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keys = append(keys, r.Header.Get(httpapi.IdempotencyKeyHeader))
+			if requests.Add(1) == 1 {
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	ctx := context.Background()
+
+	tag, err := c.CreateTag(ctx, 1, "Rent")
+	require.NoError(t, err)
+	assert.Equal(t, "Rent", tag.Name)
+
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+
+	page, err := c.ListTags(ctx, 1, client.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 1)
+	assert.Equal(t, tag.ID, page.Data[0].ID)
+}
+
+func TestClientWithIdempotencyKey(t *testing.T) {
+	c := newTestServer(t, nil)
+	ctx := client.WithIdempotencyKey(context.Background(), "import-2024-01")
+
+	records := []client.NewFinancialRecord{
+		{Direction: client.DirectionIn, Amount: 10, DueDate: time.Now()},
+		{Direction: client.DirectionOut, Amount: 20, DueDate: time.Now()},
+	}
+	first, err := c.CreateFinancialRecords(ctx, 1, records)
+	require.NoError(t, err)
+	second, err := c.CreateFinancialRecords(ctx, 1, records)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	page, err := c.ListFinancialRecords(context.Background(), 1, client.ListFinancialRecordsOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), page.Pagination.TotalItems)
+}
+
+func TestClientAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mem := store.NewMemoryStore()
+	org := domain.Organization{Name: "Acme", TimeZone: domain.DefaultTimeZone, Currency: domain.DefaultCurrency}
+	require.NoError(t, mem.CreateOrganization(context.Background(), &org))
+	srv := httptest.NewServer(httpapi.NewRouter(httpapi.Deps{
+		Stores: store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+		Auth:   httpapi.NewAuth(httpapi.NewAPIKeyAuthenticator(mem, "admin-secret", time.Hour)),
+	}))
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	admin := client.New(srv.URL+httpapi.APIPrefix, client.Options{APIKey: "admin-secret"})
+	issued, err := admin.CreateAPIKey(ctx, client.NewAPIKey{
+		Name:   "reader",
+		Grants: []client.APIKeyGrant{{OrganizationID: org.ID, Scope: client.ScopeRead}},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, issued.Secret)
+	assert.Equal(t, issued.Secret[:len(issued.Prefix)], issued.Prefix)
+
+	page, err := admin.ListAPIKeys(ctx, client.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Data, 1)
+	assert.Equal(t, issued.APIKey.Grants, page.Data[0].Grants)
+
+	reader := client.New(srv.URL+httpapi.APIPrefix, client.Options{APIKey: issued.Secret})
+	_, err = reader.ListTags(ctx, org.ID, client.ListOptions{})
+	require.NoError(t, err)
+	_, err = reader.CreateTag(ctx, org.ID, "Rent")
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.Equal(t, "forbidden", apiErr.Code)
+
+	require.NoError(t, admin.RevokeAPIKey(ctx, issued.ID))
+	_, err = reader.ListTags(ctx, org.ID, client.ListOptions{})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	assert.Equal(t, "unauthenticated", apiErr.Code)
+}
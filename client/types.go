@@ -0,0 +1,396 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Directions of a financial record.
+const (
+	DirectionIn  = "IN"
+	DirectionOut = "OUT"
+)
+
+// Statuses of a financial record. StatusOverdue is reported for pending
+// records past their due date and cannot be set.
+const (
+	StatusPending   = "pending"
+	StatusPaid      = "paid"
+	StatusCancelled = "cancelled"
+	StatusOverdue   = "overdue"
+)
+
+// Bases of the cash-flow report.
+const (
+	BasisDue  = "due"
+	BasisPaid = "paid"
+)
+
+// Organization owns tags and financial records and holds their settings.
+type Organization struct {
+	ID        uint       `json:"ID"`
+	CreatedAt time.Time  `json:"CreatedAt"`
+	UpdatedAt time.Time  `json:"UpdatedAt"`
+	DeletedAt *time.Time `json:"DeletedAt"`
+	Name      string     `json:"name"`
+	// TimeZone is an IANA time zone name, such as "America/Sao_Paulo".
+	TimeZone string `json:"timeZone"`
+	// Currency is an ISO 4217 code, such as "USD".
+	Currency string `json:"currency"`
+	// MaxTags and MaxFinancialRecords are the organization's quotas; nil
+	// takes the server-wide quota, and zero is unlimited.
+	MaxTags             *int `json:"maxTags"`
+	MaxFinancialRecords *int `json:"maxFinancialRecords"`
+}
+
+// OrganizationSettings is the payload for creating or updating an
+// organization. Settings left empty take the server defaults, UTC and USD.
+type OrganizationSettings struct {
+	Name                string `json:"name"`
+	TimeZone            string `json:"timeZone,omitempty"`
+	Currency            string `json:"currency,omitempty"`
+	MaxTags             *int   `json:"maxTags,omitempty"`
+	MaxFinancialRecords *int   `json:"maxFinancialRecords,omitempty"`
+}
+
+// Scopes of an API key grant. A write grant also allows reading.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIKey authenticates a caller and grants it access to organizations.
+type APIKey struct {
+	ID        uint       `json:"ID"`
+	CreatedAt time.Time  `json:"CreatedAt"`
+	UpdatedAt time.Time  `json:"UpdatedAt"`
+	DeletedAt *time.Time `json:"DeletedAt"`
+	Name      string     `json:"name"`
+	// Prefix is the start of the secret, to tell keys apart.
+	Prefix string        `json:"prefix"`
+	Grants []APIKeyGrant `json:"grants"`
+}
+
+// APIKeyGrant gives an API key a scope on one organization.
+type APIKeyGrant struct {
+	OrganizationID uint   `json:"organizationId"`
+	Scope          string `json:"scope"`
+}
+
+// NewAPIKey is the payload for issuing an API key.
+type NewAPIKey struct {
+	Name   string        `json:"name"`
+	Grants []APIKeyGrant `json:"grants"`
+}
+
+// IssuedAPIKey is a newly issued API key with its secret, to be sent as
+// Options.APIKey.
+type IssuedAPIKey struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+// Tag labels financial records within an organization.
+type Tag struct {
+	ID             uint       `json:"ID"`
+	CreatedAt      time.Time  `json:"CreatedAt"`
+	UpdatedAt      time.Time  `json:"UpdatedAt"`
+	DeletedAt      *time.Time `json:"DeletedAt"`
+	OrganizationID uint       `json:"organizationId"`
+	Name           string     `json:"name"`
+}
+
+// FinancialRecord is an amount of money due to (IN) or by (OUT) an
+// organization on a date, and how much of it was paid.
+type FinancialRecord struct {
+	ID             uint       `json:"ID"`
+	CreatedAt      time.Time  `json:"CreatedAt"`
+	UpdatedAt      time.Time  `json:"UpdatedAt"`
+	DeletedAt      *time.Time `json:"DeletedAt"`
+	OrganizationID uint       `json:"organizationId"`
+	Direction      string     `json:"direction"`
+	Amount         float64    `json:"amount"`
+	Tags           []Tag      `json:"tags"`
+	DueDate        time.Time  `json:"dueDate"`
+	Status         string     `json:"status"`
+	// PaidAmount is the part of Amount settled so far, and PaidAt the time
+	// of the last payment.
+	PaidAmount float64    `json:"paidAmount"`
+	PaidAt     *time.Time `json:"paidAt"`
+	// Description, the counterparty and Reference are empty when unset.
+	Description          string `json:"description"`
+	CounterpartyName     string `json:"counterpartyName"`
+	CounterpartyDocument string `json:"counterpartyDocument"`
+	Reference            string `json:"reference"`
+	// Metadata holds the caller's own fields; nil when unset.
+	Metadata map[string]any `json:"metadata"`
+	// RecurrenceID is the recurrence the record is occurrence number
+	// Occurrence of, or nil.
+	RecurrenceID *uint `json:"recurrenceId"`
+	Occurrence   int   `json:"occurrence"`
+}
+
+// NewFinancialRecord is the payload for creating a financial record.
+type NewFinancialRecord struct {
+	Direction string
+	Amount    float64
+	DueDate   time.Time
+	// TagIDs links the record to existing tags of the same organization.
+	TagIDs []uint
+	// Status defaults to StatusPending. Records created paid, or partly
+	// paid, also set PaidAmount and PaidAt.
+	Status     string
+	PaidAmount float64
+	PaidAt     *time.Time
+	// Description, CounterpartyName, CounterpartyDocument, Reference and
+	// Metadata are optional details of the record.
+	Description          string
+	CounterpartyName     string
+	CounterpartyDocument string
+	Reference            string
+	Metadata             map[string]any
+}
+
+// MarshalJSON encodes the record in the shape the API binds, where tags are
+// referenced as objects carrying only their ID.
+func (r NewFinancialRecord) MarshalJSON() ([]byte, error) {
+	type tagRef struct {
+		ID uint `json:"ID"`
+	}
+	tags := make([]tagRef, len(r.TagIDs))
+	for i, id := range r.TagIDs {
+		tags[i] = tagRef{ID: id}
+	}
+	return json.Marshal(struct {
+		Direction  string     `json:"direction"`
+		Amount     float64    `json:"amount"`
+		DueDate    time.Time  `json:"dueDate"`
+		Tags       []tagRef   `json:"tags"`
+		Status     string     `json:"status,omitempty"`
+		PaidAmount float64    `json:"paidAmount,omitempty"`
+		PaidAt     *time.Time `json:"paidAt,omitempty"`
+
+		Description          string         `json:"description,omitempty"`
+		CounterpartyName     string         `json:"counterpartyName,omitempty"`
+		CounterpartyDocument string         `json:"counterpartyDocument,omitempty"`
+		Reference            string         `json:"reference,omitempty"`
+		Metadata             map[string]any `json:"metadata,omitempty"`
+	}{r.Direction, r.Amount, r.DueDate, tags, r.Status, r.PaidAmount, r.PaidAt,
+		r.Description, r.CounterpartyName, r.CounterpartyDocument, r.Reference, r.Metadata})
+}
+
+// Scopes of an edit of a financial record.
+const (
+	// EditThis changes the record alone.
+	EditThis = "this"
+	// EditFuture also changes the later occurrences of its recurrence.
+	EditFuture = "future"
+)
+
+// RecordChange is the payload for editing a financial record. Nil fields
+// are kept.
+type RecordChange struct {
+	Direction            *string
+	Amount               *float64
+	DueDate              *time.Time
+	Description          *string
+	CounterpartyName     *string
+	CounterpartyDocument *string
+	Reference            *string
+	// Metadata replaces the metadata when not nil; RemoveMetadata removes
+	// it.
+	Metadata       map[string]any
+	RemoveMetadata bool
+	// Recurrence replaces the rule of the record's recurrence from this
+	// occurrence on. It requires EditFuture.
+	Recurrence *RecurrenceRule
+}
+
+// MarshalJSON encodes the change, sending a null metadata to remove it.
+func (c RecordChange) MarshalJSON() ([]byte, error) {
+	var metadata any
+	if c.Metadata != nil {
+		metadata = c.Metadata
+	} else if c.RemoveMetadata {
+		metadata = json.RawMessage("null")
+	}
+	return json.Marshal(struct {
+		Direction            *string         `json:"direction,omitempty"`
+		Amount               *float64        `json:"amount,omitempty"`
+		DueDate              *time.Time      `json:"dueDate,omitempty"`
+		Description          *string         `json:"description,omitempty"`
+		CounterpartyName     *string         `json:"counterpartyName,omitempty"`
+		CounterpartyDocument *string         `json:"counterpartyDocument,omitempty"`
+		Reference            *string         `json:"reference,omitempty"`
+		Metadata             any             `json:"metadata,omitempty"`
+		Recurrence           *RecurrenceRule `json:"recurrence,omitempty"`
+	}{c.Direction, c.Amount, c.DueDate, c.Description, c.CounterpartyName, c.CounterpartyDocument, c.Reference,
+		metadata, c.Recurrence})
+}
+
+// Frequencies of a recurrence rule.
+const (
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyYearly  = "yearly"
+)
+
+// RecurrenceRule is the schedule of a recurrence, counted from the due
+// date of its first occurrence.
+type RecurrenceRule struct {
+	Frequency string `json:"frequency"`
+	// Interval repeats every Interval weeks, months or years; zero is 1.
+	Interval int `json:"interval,omitempty"`
+	// DayOfMonth is the day monthly occurrences are due, or the last day of
+	// shorter months; zero keeps the day of the first occurrence.
+	DayOfMonth int `json:"dayOfMonth,omitempty"`
+	// EndDate or Count, the number of occurrences of the whole series,
+	// ends the series. With neither it never ends.
+	EndDate *time.Time `json:"endDate,omitempty"`
+	Count   int        `json:"count,omitempty"`
+}
+
+// Recurrence repeats a financial record, the template, on a schedule.
+type Recurrence struct {
+	ID             uint       `json:"ID"`
+	CreatedAt      time.Time  `json:"CreatedAt"`
+	UpdatedAt      time.Time  `json:"UpdatedAt"`
+	DeletedAt      *time.Time `json:"DeletedAt"`
+	OrganizationID uint       `json:"organizationId"`
+	RecurrenceRule
+	// The template of the occurrences still to be created.
+	Direction            string         `json:"direction"`
+	Amount               float64        `json:"amount"`
+	Description          string         `json:"description"`
+	CounterpartyName     string         `json:"counterpartyName"`
+	CounterpartyDocument string         `json:"counterpartyDocument"`
+	Reference            string         `json:"reference"`
+	Metadata             map[string]any `json:"metadata"`
+	TagIDs               []uint         `json:"tagIds"`
+	// StartDate is the due date of occurrence StartOccurrence, which the
+	// schedule counts from.
+	StartDate       time.Time `json:"startDate"`
+	StartOccurrence int       `json:"startOccurrence"`
+	// Generated counts the occurrences created so far, and NextDate is the
+	// due date of the next one, or nil once the series ended.
+	Generated int        `json:"generated"`
+	NextDate  *time.Time `json:"nextDate"`
+}
+
+// Settlement is a payment of a financial record.
+type Settlement struct {
+	// Amount is the part of the balance paid; zero pays all of it.
+	Amount float64 `json:"amount,omitempty"`
+	// PaidAt is when the payment was made; zero means now.
+	PaidAt time.Time `json:"paidAt,omitzero"`
+}
+
+// CashFlowReport aggregates financial records per month.
+type CashFlowReport struct {
+	Basis       string            `json:"basis"`
+	MonthlyData []MonthlyCashFlow `json:"monthlyData"`
+}
+
+// MonthlyCashFlow is the total incoming and outgoing amount of one month.
+type MonthlyCashFlow struct {
+	Year  int     `json:"year"`
+	Month int     `json:"month"`
+	In    float64 `json:"in"`
+	Out   float64 `json:"out"`
+}
+
+// Audit actions and entity types.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+
+	AuditEntityTag             = "tag"
+	AuditEntityFinancialRecord = "financial_record"
+	AuditEntityRecurrence      = "recurrence"
+)
+
+// AuditEvent records one change to a tag, financial record or recurrence.
+type AuditEvent struct {
+	ID             uint      `json:"ID"`
+	CreatedAt      time.Time `json:"CreatedAt"`
+	OrganizationID uint      `json:"organizationId"`
+	// Actor is the subject of the caller, such as "api-key:3".
+	Actor      string `json:"actor"`
+	Action     string `json:"action"`
+	EntityType string `json:"entityType"`
+	EntityID   uint   `json:"entityId"`
+	// Before and After hold the fields that changed, as JSON objects.
+	// Before is null for a creation and After for a deletion.
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	RequestID string          `json:"requestId"`
+}
+
+// Page is one page of a listing.
+type Page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Pagination describes the position of a page within a listing.
+type Pagination struct {
+	CurrentPage int   `json:"current_page"`
+	PageSize    int   `json:"page_size"`
+	TotalItems  int64 `json:"total_items"`
+	TotalPages  int64 `json:"total_pages"`
+}
+
+// ListOptions selects a page of a listing. Zero values use the server
+// defaults (the first page of 20 items).
+type ListOptions struct {
+	Page     int
+	PageSize int
+}
+
+// ListTagsOptions selects a page of tags.
+type ListTagsOptions struct {
+	ListOptions
+	// IncludeDeleted also lists deleted tags.
+	IncludeDeleted bool
+}
+
+// ListFinancialRecordsOptions selects a page of financial records.
+type ListFinancialRecordsOptions struct {
+	ListOptions
+	// TagIDs keeps only records linked to any of the tags.
+	TagIDs []uint
+	// IncludeDeleted also lists deleted records.
+	IncludeDeleted bool
+	// Counterparty keeps only records of the counterparty, ignoring case.
+	Counterparty string
+	// CounterpartyDocument and Reference keep only records with exactly
+	// this document or reference.
+	CounterpartyDocument string
+	Reference            string
+	// Metadata keeps only records whose metadata contains these fields.
+	Metadata map[string]any
+	// Query searches the description, counterparty name and reference,
+	// listing the matches by relevance.
+	Query string
+}
+
+// CashFlowReportOptions selects the basis of a cash-flow report.
+type CashFlowReportOptions struct {
+	// Basis is BasisDue, the default, or BasisPaid.
+	Basis string
+}
+
+// ListAuditEventsOptions selects a page of audit events. Zero values do not
+// filter.
+type ListAuditEventsOptions struct {
+	ListOptions
+	EntityType string
+	EntityID   uint
+	Action     string
+	Actor      string
+	// Since and Until keep events at or after Since and before Until.
+	Since time.Time
+	Until time.Time
+}
@@ -0,0 +1,64 @@
+// Command purge hard-deletes the tags and financial records that were
+// soft-deleted more than PURGE_RETENTION ago. It reads the same environment
+// variables as the server and is meant to run periodically, for instance
+// from cron.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/telemetry"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load configuration:", err)
+		os.Exit(1)
+	}
+	logger := telemetry.NewLogger(cfg.LogLevel)
+	slog.SetDefault(logger)
+
+	if cfg.PurgeRetention <= 0 || cfg.PurgeBatchSize <= 0 {
+		fatal("Invalid purge settings", fmt.Errorf("PURGE_RETENTION and PURGE_BATCH_SIZE must be positive"))
+	}
+
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{Logger: telemetry.NewGormLogger(logger, cfg.SlowQueryThreshold)})
+	if err != nil {
+		fatal("Failed to connect to database", err)
+	}
+
+	before := time.Now().Add(-cfg.PurgeRetention)
+	slog.Info("Purging deleted rows", "deleted_before", before, "batch_size", cfg.PurgeBatchSize)
+	start := time.Now()
+	result, err := store.PurgeDeleted(ctx, db, before, cfg.PurgeBatchSize)
+	if err != nil {
+		// Batches already done stay purged; log what they removed.
+		slog.Error("Purge failed", "error", err,
+			"financial_records", result.FinancialRecords, "tags", result.Tags, "links", result.Links)
+		os.Exit(1)
+	}
+	slog.Info("Purged deleted rows",
+		"financial_records", result.FinancialRecords, "tags", result.Tags, "links", result.Links,
+		"duration", time.Since(start))
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
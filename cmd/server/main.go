@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/httpapi"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/telemetry"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Configuration
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load configuration:", err)
+		os.Exit(1)
+	}
+
+	// Logging
+	logger := telemetry.NewLogger(cfg.LogLevel)
+	slog.SetDefault(logger)
+
+	// Tracing
+	shutdownTracing, err := telemetry.SetupTracing(ctx)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+	}()
+
+	// Open database connection
+	gormLogger := telemetry.NewGormLogger(logger, cfg.SlowQueryThreshold)
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{Logger: gormLogger})
+	if err != nil {
+		fatal("Failed to connect to database", err)
+	}
+
+	// Trace every GORM statement as a child of the request span
+	if err := db.Use(&telemetry.GormTracing{}); err != nil {
+		fatal("Failed to register tracing plugin", err)
+	}
+
+	// Get the underlying *sql.DB
+	sqlDB, err := db.DB()
+	if err != nil {
+		fatal("Failed to get database instance", err)
+	}
+
+	// Set connection pool settings
+	sqlDB.SetMaxIdleConns(10)           // Maximum number of idle connections
+	sqlDB.SetMaxOpenConns(90)           // Maximum number of open connections
+	sqlDB.SetConnMaxLifetime(time.Hour) // Maximum lifetime of a connection
+
+	// Migrate the schema and apply database indexes
+	if err := store.Migrate(db); err != nil {
+		fatal("Failed to migrate database", err)
+	}
+	if cfg.RowLevelSecurity {
+		if err := store.EnableRowLevelSecurity(db, cfg.RowLevelSecurityRole); err != nil {
+			fatal("Failed to enable row-level security", err)
+		}
+		slog.Info("Row-level security enabled", "role", cfg.RowLevelSecurityRole)
+	}
+
+	// Data access
+	var stores store.Stores
+	switch cfg.Backend {
+	case "pgx":
+		// GORM is only used for migrations; release its connections so the
+		// pgx pool gets the whole connection budget.
+		sqlDB.Close()
+
+		pool, err := store.NewPgxPool(ctx, cfg.DSN(), telemetry.NewPgxTracer(logger, cfg.SlowQueryThreshold))
+		if err != nil {
+			fatal("Failed to connect to database", err)
+		}
+		defer pool.Close()
+		expvar.Publish("db_pool", expvar.Func(func() any { return store.PgxPoolStats(pool.Stat()) }))
+
+		pgxStore := store.NewPgxStore(pool)
+		if cfg.RowLevelSecurity {
+			pgxStore = pgxStore.WithRowLevelSecurity(cfg.RowLevelSecurityRole)
+		}
+		stores = store.Stores{Organizations: pgxStore, APIKeys: pgxStore, Tags: pgxStore, FinancialRecords: pgxStore, Recurrences: pgxStore, AuditEvents: pgxStore}
+	default:
+		expvar.Publish("db_pool", expvar.Func(func() any { return sqlDB.Stats() }))
+
+		gormStore := store.NewGormStore(db)
+		if cfg.RowLevelSecurity {
+			gormStore = gormStore.WithRowLevelSecurity(cfg.RowLevelSecurityRole)
+		}
+		stores = store.Stores{Organizations: gormStore, APIKeys: gormStore, Tags: gormStore, FinancialRecords: gormStore, Recurrences: gormStore, AuditEvents: gormStore}
+	}
+	slog.Info("Using database backend", "backend", cfg.Backend)
+
+	// Create the occurrences of recurring financial records ahead of time
+	if cfg.RecurrenceInterval > 0 {
+		go store.ScheduleRecurrences(ctx, stores.Recurrences, cfg.RecurrenceInterval, cfg.RecurrenceHorizon, cfg.MaxFinancialRecordsPerOrganization)
+	}
+
+	var auth *httpapi.Auth
+	if cfg.AuthDisabled {
+		slog.Warn("Authentication is disabled")
+	} else {
+		if cfg.AdminAPIKey == "" {
+			slog.Warn("ADMIN_API_KEY is not set, so no API key can be issued")
+		}
+		var authenticators []httpapi.Authenticator
+		if cfg.JWT.Enabled() {
+			jwtAuth, err := httpapi.NewJWTAuthenticator(cfg.JWT)
+			if err != nil {
+				fatal("Failed to load the JWT keys", err)
+			}
+			authenticators = append(authenticators, jwtAuth)
+		}
+		// API keys come last: they take every token the others leave.
+		authenticators = append(authenticators, httpapi.NewAPIKeyAuthenticator(stores.APIKeys, cfg.AdminAPIKey, cfg.APIKeyCacheTTL))
+		auth = httpapi.NewAuth(authenticators...)
+	}
+
+	// Initialize router
+	admission := httpapi.NewAdmission(cfg)
+	admission.Publish()
+	r := httpapi.NewRouter(httpapi.Deps{
+		Stores:      stores,
+		Logger:      logger,
+		Config:      cfg,
+		Admission:   admission,
+		RateLimits:  httpapi.NewRateLimits(cfg),
+		Idempotency: httpapi.NewIdempotency(cfg.IdempotencyTTL),
+		Auth:        auth,
+	})
+
+	// Start server
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		slog.Info("Server listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start server", err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Server shutdown failed", "error", err)
+	}
+}
+
+// fatal logs err and exits. Deferred functions do not run.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
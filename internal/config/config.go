@@ -0,0 +1,329 @@
+// Package config loads the server configuration from environment variables.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the process configuration, read from environment variables.
+type Config struct {
+	DatabaseURL string
+
+	// Backend selects the data-access stack: "gorm" (GORM on database/sql)
+	// or "pgx" (hand-written SQL on a native pgxpool).
+	Backend string
+
+	LogLevel           slog.Level
+	SlowQueryThreshold time.Duration
+
+	// StatementTimeout is applied as the Postgres statement_timeout of every
+	// pooled connection. Zero leaves the server default in place.
+	StatementTimeout time.Duration
+
+	// Per-route-class request deadlines. Zero disables the deadline.
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	ReportTimeout time.Duration
+
+	// Per-route-class admission control.
+	ReadAdmission   AdmissionConfig
+	WriteAdmission  AdmissionConfig
+	ReportAdmission AdmissionConfig
+
+	// Per-route-class rate limits, per organization and per caller.
+	ReadRateLimit   RateLimitConfig
+	WriteRateLimit  RateLimitConfig
+	ReportRateLimit RateLimitConfig
+
+	// MaxTagsPerOrganization and MaxFinancialRecordsPerOrganization cap
+	// what an organization may hold, unless it sets limits of its own.
+	// Zero is unlimited.
+	MaxTagsPerOrganization             int
+	MaxFinancialRecordsPerOrganization int
+
+	// IdempotencyTTL is how long the response to a write carrying an
+	// Idempotency-Key header is replayed for retries. Zero disables replay.
+	IdempotencyTTL time.Duration
+
+	// OrganizationCacheTTL is how long an organization found to exist is
+	// remembered by the routes nested under it. Zero looks it up on every
+	// request.
+	OrganizationCacheTTL time.Duration
+
+	// RowLevelSecurity has Postgres restrict the statements on tags and
+	// financial records to the organization of the route, as
+	// RowLevelSecurityRole, so that a query missing its organization filter
+	// cannot read or write another organization's rows.
+	RowLevelSecurity     bool
+	RowLevelSecurityRole string
+
+	// PurgeRetention is how long soft-deleted tags and financial records
+	// are kept before the purge command removes them for good, and
+	// PurgeBatchSize how many rows it removes per statement.
+	PurgeRetention time.Duration
+	PurgeBatchSize int
+
+	// RecurrenceHorizon is how far ahead of their due date the occurrences
+	// of recurring financial records are created, and RecurrenceInterval
+	// how often the server looks for new ones. Zero RecurrenceInterval
+	// leaves it to another instance.
+	RecurrenceHorizon  time.Duration
+	RecurrenceInterval time.Duration
+
+	// AdminAPIKey is the secret of the admin key, allowed every route. Empty
+	// disables it.
+	AdminAPIKey string
+	// AuthDisabled serves the API without authentication, for local
+	// experiments only.
+	AuthDisabled bool
+	// APIKeyCacheTTL is how long an API key found is remembered, and thus
+	// how long a key revoked through another instance keeps working. Zero
+	// looks it up on every request.
+	APIKeyCacheTTL time.Duration
+
+	// JWT configures the authentication of the identity provider's JWTs.
+	JWT JWTConfig
+}
+
+// JWTConfig holds the keys verifying JWTs and the claims they must carry.
+// JWTs are accepted only when at least one key is configured.
+type JWTConfig struct {
+	// HMACSecret verifies HS256 tokens.
+	HMACSecret string
+	// RSAPublicKeyFile is a PEM file holding a public key verifying RS256
+	// tokens.
+	RSAPublicKeyFile string
+	// JWKSFile is a JSON Web Key Set file of RSA and symmetric keys,
+	// selected by the "kid" header of the tokens.
+	JWKSFile string
+
+	// Issuer and Audience, when set, must match the "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+}
+
+// Enabled reports whether any key is configured.
+func (c JWTConfig) Enabled() bool {
+	return c.HMACSecret != "" || c.RSAPublicKeyFile != "" || c.JWKSFile != ""
+}
+
+// AdmissionConfig sizes the concurrency limiter of a route class: at most
+// Limit requests run at once and up to Queue more wait at most MaxWait for a
+// slot. A zero Limit disables admission control for the route class.
+type AdmissionConfig struct {
+	Limit   int
+	Queue   int
+	MaxWait time.Duration
+}
+
+// RateLimitConfig sizes the token buckets of a route class: every
+// organization and every caller gets a bucket of Burst tokens, refilled at
+// Rate tokens per second, and each request takes a token from both. A zero
+// Rate disables the corresponding limit; a zero Burst holds one second's
+// worth of tokens.
+type RateLimitConfig struct {
+	OrganizationRate  float64
+	OrganizationBurst int
+	CallerRate        float64
+	CallerBurst       int
+}
+
+// Load reads the configuration from the environment, applying defaults
+// for unset variables.
+func Load() (Config, error) {
+	var p envParser
+
+	cfg := Config{
+		DatabaseURL: p.string("DATABASE_URL", "host=localhost user=postgres password=postgres dbname=financial_db port=5432 sslmode=disable"),
+		Backend:     p.oneOf("DB_BACKEND", "gorm", "gorm", "pgx"),
+
+		LogLevel:           p.level("LOG_LEVEL", slog.LevelInfo),
+		SlowQueryThreshold: p.duration("DB_SLOW_QUERY_THRESHOLD", 200*time.Millisecond),
+		StatementTimeout:   p.duration("DB_STATEMENT_TIMEOUT", 30*time.Second),
+
+		ReadTimeout:   p.duration("READ_TIMEOUT", 5*time.Second),
+		WriteTimeout:  p.duration("WRITE_TIMEOUT", 10*time.Second),
+		ReportTimeout: p.duration("REPORT_TIMEOUT", 30*time.Second),
+
+		// The defaults split the 90 pooled connections between the classes.
+		ReadAdmission:   p.admission("READ", AdmissionConfig{Limit: 40, Queue: 100, MaxWait: time.Second}),
+		WriteAdmission:  p.admission("WRITE", AdmissionConfig{Limit: 30, Queue: 100, MaxWait: time.Second}),
+		ReportAdmission: p.admission("REPORT", AdmissionConfig{Limit: 20, Queue: 50, MaxWait: 2 * time.Second}),
+
+		ReadRateLimit:   p.rateLimit("READ"),
+		WriteRateLimit:  p.rateLimit("WRITE"),
+		ReportRateLimit: p.rateLimit("REPORT"),
+
+		MaxTagsPerOrganization:             p.int("ORGANIZATION_MAX_TAGS", 0),
+		MaxFinancialRecordsPerOrganization: p.int("ORGANIZATION_MAX_FINANCIAL_RECORDS", 0),
+
+		IdempotencyTTL:       p.duration("IDEMPOTENCY_TTL", 24*time.Hour),
+		OrganizationCacheTTL: p.duration("ORGANIZATION_CACHE_TTL", time.Minute),
+
+		RowLevelSecurity:     p.bool("ROW_LEVEL_SECURITY", false),
+		RowLevelSecurityRole: p.string("ROW_LEVEL_SECURITY_ROLE", "financial_tenant"),
+
+		PurgeRetention: p.duration("PURGE_RETENTION", 30*24*time.Hour),
+		PurgeBatchSize: p.int("PURGE_BATCH_SIZE", 1000),
+
+		RecurrenceHorizon:  p.duration("RECURRENCE_HORIZON", 90*24*time.Hour),
+		RecurrenceInterval: p.duration("RECURRENCE_INTERVAL", time.Hour),
+
+		AdminAPIKey:    p.string("ADMIN_API_KEY", ""),
+		AuthDisabled:   p.bool("AUTH_DISABLED", false),
+		APIKeyCacheTTL: p.duration("API_KEY_CACHE_TTL", 30*time.Second),
+
+		JWT: JWTConfig{
+			HMACSecret:       p.string("JWT_HMAC_SECRET", ""),
+			RSAPublicKeyFile: p.string("JWT_RSA_PUBLIC_KEY_FILE", ""),
+			JWKSFile:         p.string("JWT_JWKS_FILE", ""),
+			Issuer:           p.string("JWT_ISSUER", ""),
+			Audience:         p.string("JWT_AUDIENCE", ""),
+		},
+	}
+
+	return cfg, p.err
+}
+
+// DSN returns the database connection string with the configured
+// statement_timeout added as a connection runtime parameter.
+func (c Config) DSN() string {
+	if c.StatementTimeout <= 0 {
+		return c.DatabaseURL
+	}
+	timeout := fmt.Sprintf("%d", c.StatementTimeout.Milliseconds())
+
+	if strings.HasPrefix(c.DatabaseURL, "postgres://") || strings.HasPrefix(c.DatabaseURL, "postgresql://") {
+		u, err := url.Parse(c.DatabaseURL)
+		if err == nil {
+			q := u.Query()
+			q.Set("statement_timeout", timeout)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return c.DatabaseURL + " statement_timeout=" + timeout
+}
+
+// envParser reads typed environment variables, collecting every parse error
+// so that a misconfigured deployment reports all of them at once.
+type envParser struct {
+	err error
+}
+
+func (p *envParser) fail(name, value string, err error) {
+	p.err = errors.Join(p.err, fmt.Errorf("invalid %s %q: %w", name, value, err))
+}
+
+func (p *envParser) string(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func (p *envParser) oneOf(name, def string, allowed ...string) string {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	if !slices.Contains(allowed, v) {
+		p.fail(name, v, fmt.Errorf("must be one of %s", strings.Join(allowed, ", ")))
+		return def
+	}
+	return v
+}
+
+func (p *envParser) duration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.fail(name, v, err)
+		return def
+	}
+	return d
+}
+
+func (p *envParser) int(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail(name, v, err)
+		return def
+	}
+	return n
+}
+
+func (p *envParser) float(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		p.fail(name, v, err)
+		return def
+	}
+	return f
+}
+
+func (p *envParser) bool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(name, v, err)
+		return def
+	}
+	return b
+}
+
+func (p *envParser) level(name string, def slog.Level) slog.Level {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		p.fail(name, v, err)
+		return def
+	}
+	return level
+}
+
+// admission reads <prefix>_CONCURRENCY, <prefix>_QUEUE and <prefix>_MAX_WAIT.
+func (p *envParser) admission(prefix string, def AdmissionConfig) AdmissionConfig {
+	return AdmissionConfig{
+		Limit:   p.int(prefix+"_CONCURRENCY", def.Limit),
+		Queue:   p.int(prefix+"_QUEUE", def.Queue),
+		MaxWait: p.duration(prefix+"_MAX_WAIT", def.MaxWait),
+	}
+}
+
+// rateLimit reads <prefix>_ORG_RATE_LIMIT, <prefix>_ORG_RATE_BURST,
+// <prefix>_CALLER_RATE_LIMIT and <prefix>_CALLER_RATE_BURST. Every limit
+// is disabled by default.
+func (p *envParser) rateLimit(prefix string) RateLimitConfig {
+	return RateLimitConfig{
+		OrganizationRate:  p.float(prefix+"_ORG_RATE_LIMIT", 0),
+		OrganizationBurst: p.int(prefix+"_ORG_RATE_BURST", 0),
+		CallerRate:        p.float(prefix+"_CALLER_RATE_LIMIT", 0),
+		CallerBurst:       p.int(prefix+"_CALLER_RATE_BURST", 0),
+	}
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// Audited entity types.
+const (
+	AuditEntityTag             = "tag"
+	AuditEntityFinancialRecord = "financial_record"
+	AuditEntityRecurrence      = "recurrence"
+)
+
+// AuditEvent records one change to a tag, financial record or recurrence.
+// Events are written in the transaction of the change and never updated or
+// deleted.
+type AuditEvent struct {
+	ID             uint      `json:"ID" gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"CreatedAt" gorm:"not null"`
+	OrganizationID uint      `json:"organizationId" gorm:"not null"`
+	// Actor is the subject of the caller, such as "api-key:3" or
+	// "user:alice"; empty when authentication is disabled.
+	Actor      string `json:"actor" gorm:"not null"`
+	Action     string `json:"action" gorm:"not null"`     // "create", "update", "delete" or "restore"
+	EntityType string `json:"entityType" gorm:"not null"` // "tag", "financial_record" or "recurrence"
+	EntityID   uint   `json:"entityId" gorm:"not null"`
+	// Before and After are the fields that changed, as JSON objects. Before
+	// is null for a creation and After for a deletion.
+	Before    json.RawMessage `json:"before" gorm:"type:jsonb"`
+	After     json.RawMessage `json:"after" gorm:"type:jsonb"`
+	RequestID string          `json:"requestId" gorm:"not null"`
+}
+
+// financialRecordSnapshot is how financial records appear in audit events:
+// their tags are listed by ID.
+type financialRecordSnapshot struct {
+	*FinancialRecord
+	Tags []uint `json:"tags"`
+}
+
+// auditSnapshot returns what audit events record of a *Tag or a
+// *FinancialRecord.
+func auditSnapshot(entity any) any {
+	r, ok := entity.(*FinancialRecord)
+	if !ok {
+		return entity
+	}
+	s := financialRecordSnapshot{FinancialRecord: r, Tags: []uint{}}
+	for _, t := range r.Tags {
+		s.Tags = append(s.Tags, t.ID)
+	}
+	return s
+}
+
+// AuditDiff returns the Before and After of an audit event from the states
+// of a *Tag or *FinancialRecord before and after the change, either of
+// which is nil for a creation or a deletion. When both are given, only the
+// top-level fields that differ are kept.
+func AuditDiff(before, after any) (json.RawMessage, json.RawMessage, error) {
+	b, err := marshalSnapshot(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := marshalSnapshot(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b == nil || a == nil {
+		return b, a, nil
+	}
+
+	var bFields, aFields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &bFields); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(a, &aFields); err != nil {
+		return nil, nil, err
+	}
+	for name, value := range bFields {
+		if other, ok := aFields[name]; ok && bytes.Equal(value, other) {
+			delete(bFields, name)
+			delete(aFields, name)
+		}
+	}
+	if b, err = json.Marshal(bFields); err != nil {
+		return nil, nil, err
+	}
+	if a, err = json.Marshal(aFields); err != nil {
+		return nil, nil, err
+	}
+	return b, a, nil
+}
+
+func marshalSnapshot(snapshot any) (json.RawMessage, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	return json.Marshal(auditSnapshot(snapshot))
+}
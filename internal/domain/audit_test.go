@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestAuditDiff(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := &FinancialRecord{
+		Model:          gorm.Model{ID: 7, CreatedAt: created, UpdatedAt: created},
+		OrganizationID: 1,
+		Direction:      DirectionOut,
+		Amount:         100,
+		DueDate:        created,
+		Tags:           []Tag{{Model: gorm.Model{ID: 3}}},
+	}
+	after := *before
+	after.Amount = 150
+	after.UpdatedAt = created.Add(time.Hour)
+	after.Tags = []Tag{{Model: gorm.Model{ID: 3}}, {Model: gorm.Model{ID: 4}}}
+
+	b, a, err := AuditDiff(before, &after)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"amount": 100, "UpdatedAt": "2024-01-01T00:00:00Z", "tags": [3]}`, string(b))
+	assert.JSONEq(t, `{"amount": 150, "UpdatedAt": "2024-01-01T01:00:00Z", "tags": [3, 4]}`, string(a))
+
+	b, a, err = AuditDiff(nil, before)
+	require.NoError(t, err)
+	assert.Nil(t, b)
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(a, &fields))
+	assert.JSONEq(t, `7`, string(fields["ID"]))
+	assert.JSONEq(t, `[3]`, string(fields["tags"]))
+
+	b, a, err = AuditDiff(&Tag{Name: "Rent"}, nil)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"name":"Rent"`)
+	assert.Nil(t, a)
+}
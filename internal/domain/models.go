@@ -0,0 +1,205 @@
+// Package domain defines the entities of the financial records API and the
+// rules they must satisfy.
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Organization owns tags and financial records and holds the settings that
+// apply to them.
+type Organization struct {
+	gorm.Model
+	Name string `json:"name" gorm:"not null"`
+	// TimeZone is an IANA time zone name, such as "America/Sao_Paulo". The
+	// cash-flow report counts months in it.
+	TimeZone string `json:"timeZone" gorm:"not null;default:UTC"`
+	// Currency is the ISO 4217 code of the organization's amounts.
+	Currency string `json:"currency" gorm:"not null;default:USD"`
+	// MaxTags and MaxFinancialRecords cap how many tags and financial
+	// records the organization may hold. Nil takes the server-wide quota,
+	// and zero lifts the limit.
+	MaxTags             *int `json:"maxTags"`
+	MaxFinancialRecords *int `json:"maxFinancialRecords"`
+}
+
+// Location returns the time zone of the organization, or UTC when it is not
+// a valid one.
+func (o *Organization) Location() *time.Location {
+	loc, err := time.LoadLocation(o.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// TagLimit returns how many tags the organization may hold, or def, the
+// server-wide quota, when it has no limit of its own. Zero is unlimited.
+func (o *Organization) TagLimit(def int) int {
+	if o.MaxTags != nil {
+		return *o.MaxTags
+	}
+	return def
+}
+
+// FinancialRecordLimit returns how many financial records the organization
+// may hold, or def, the server-wide quota, when it has no limit of its own.
+// Zero is unlimited.
+func (o *Organization) FinancialRecordLimit(def int) int {
+	if o.MaxFinancialRecords != nil {
+		return *o.MaxFinancialRecords
+	}
+	return def
+}
+
+// APIKey authenticates a caller and grants it access to organizations. Only
+// a hash of its secret is stored; revoking a key soft-deletes it.
+type APIKey struct {
+	gorm.Model
+	Name string `json:"name" gorm:"not null"`
+	// Prefix is the start of the secret, to tell keys apart without
+	// revealing them.
+	Prefix string        `json:"prefix" gorm:"not null"`
+	Hash   string        `json:"-" gorm:"not null;uniqueIndex"`
+	Grants []APIKeyGrant `json:"grants"`
+}
+
+// APIKeyGrant gives an API key a scope on one organization.
+type APIKeyGrant struct {
+	APIKeyID       uint   `json:"-" gorm:"primaryKey"`
+	OrganizationID uint   `json:"organizationId" gorm:"primaryKey"`
+	Scope          string `json:"scope" gorm:"not null"` // "read" or "write"
+}
+
+// Allows reports whether the key may act on the organization with scope.
+func (k *APIKey) Allows(orgID uint, scope string) bool {
+	return Grants(k.Grants).Allow(orgID, scope)
+}
+
+// Grants are the organizations a caller may act on, whether it holds an API
+// key or another credential.
+type Grants []APIKeyGrant
+
+// Allow reports whether the grants let the caller act on the organization
+// with scope. A write grant also allows reading.
+func (gs Grants) Allow(orgID uint, scope string) bool {
+	for _, g := range gs {
+		if g.OrganizationID == orgID && (g.Scope == scope || g.Scope == ScopeWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tag labels financial records within an organization.
+type Tag struct {
+	gorm.Model
+	OrganizationID uint   `json:"organizationId" gorm:"not null"`
+	Name           string `json:"name" gorm:"not null"`
+}
+
+// FinancialRecord is an amount of money due to (IN) or by (OUT) an
+// organization on a date, and how much of it was paid.
+type FinancialRecord struct {
+	gorm.Model
+	OrganizationID uint      `json:"organizationId" gorm:"not null"`
+	Direction      string    `json:"direction" gorm:"not null"` // "IN" or "OUT"
+	Amount         float64   `json:"amount" gorm:"not null"`
+	Tags           []Tag     `json:"tags" gorm:"many2many:financial_record_tags;"`
+	DueDate        time.Time `json:"dueDate" gorm:"not null"`
+	// Status is "pending", "paid" or "cancelled" once stored; a pending
+	// record is reported "overdue" by MarkOverdue.
+	Status string `json:"status" gorm:"not null;default:pending"`
+	// PaidAmount is the part of Amount settled so far, and PaidAt the time
+	// of the last payment, if any. The stores keep each payment as a
+	// Payment of its own.
+	PaidAmount float64    `json:"paidAmount" gorm:"not null;default:0"`
+	PaidAt     *time.Time `json:"paidAt"`
+	// Description says what the record is for. The counterparty is who
+	// pays or is paid, named and identified by a document such as a tax
+	// ID, and Reference is an external reference such as an invoice
+	// number.
+	Description          string `json:"description" gorm:"not null;default:''"`
+	CounterpartyName     string `json:"counterpartyName" gorm:"not null;default:''"`
+	CounterpartyDocument string `json:"counterpartyDocument" gorm:"not null;default:''"`
+	Reference            string `json:"reference" gorm:"not null;default:''"`
+	// Metadata is a free-form JSON object, or null.
+	Metadata json.RawMessage `json:"metadata" gorm:"type:jsonb"`
+	// RecurrenceID is the recurrence the record is an occurrence of, if
+	// any, and Occurrence its number in the series, 0 for the record the
+	// recurrence was attached to.
+	RecurrenceID *uint `json:"recurrenceId"`
+	Occurrence   int   `json:"occurrence" gorm:"not null;default:0"`
+}
+
+// Payment is an amount paid of a financial record at a time. A record paid
+// in several parts has a payment for each, so that the paid-basis cash-flow
+// report books every part in the month it was paid.
+type Payment struct {
+	ID                uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt         time.Time `json:"createdAt" gorm:"not null"`
+	OrganizationID    uint      `json:"organizationId" gorm:"not null"`
+	FinancialRecordID uint      `json:"financialRecordId" gorm:"not null;index"`
+	Amount            float64   `json:"amount" gorm:"not null"`
+	PaidAt            time.Time `json:"paidAt" gorm:"not null"`
+}
+
+// RecurrenceRule is when a recurrence repeats: every Interval weeks, months
+// or years from its start date, until EndDate or for Count occurrences.
+type RecurrenceRule struct {
+	Frequency string `json:"frequency" gorm:"not null"` // "weekly", "monthly" or "yearly"
+	Interval  int    `json:"interval" gorm:"column:repeat_interval;not null"`
+	// DayOfMonth is the day monthly occurrences are due, moved to the last
+	// day of shorter months; zero keeps the day of the start date.
+	DayOfMonth int `json:"dayOfMonth" gorm:"not null"`
+	// EndDate is the last time an occurrence may be due. Count is the
+	// number of occurrences of the whole series, the first included. A
+	// series with neither never ends.
+	EndDate *time.Time `json:"endDate"`
+	Count   int        `json:"count" gorm:"column:repeat_count;not null"`
+}
+
+// Recurrence repeats a financial record, the template, on a schedule. Its
+// occurrences are financial records numbered from 0, the record it was
+// attached to, and are created ahead of their due dates.
+type Recurrence struct {
+	gorm.Model
+	OrganizationID uint `json:"organizationId" gorm:"not null;index"`
+	RecurrenceRule `gorm:"embedded"`
+	// The template, copied into every new occurrence.
+	Direction            string          `json:"direction" gorm:"not null"`
+	Amount               float64         `json:"amount" gorm:"not null"`
+	Description          string          `json:"description" gorm:"not null;default:''"`
+	CounterpartyName     string          `json:"counterpartyName" gorm:"not null;default:''"`
+	CounterpartyDocument string          `json:"counterpartyDocument" gorm:"not null;default:''"`
+	Reference            string          `json:"reference" gorm:"not null;default:''"`
+	Metadata             json.RawMessage `json:"metadata" gorm:"type:jsonb"`
+	TagIDs               []uint          `json:"tagIds" gorm:"type:jsonb;serializer:json"`
+	// StartDate is the due date of occurrence StartOccurrence, which the
+	// schedule counts from.
+	StartDate       time.Time `json:"startDate" gorm:"not null"`
+	StartOccurrence int       `json:"startOccurrence" gorm:"not null"`
+	// Generated is the number of occurrences created so far, and NextDate
+	// the due date of the next one, or nil once the series ended.
+	Generated int        `json:"generated" gorm:"not null"`
+	NextDate  *time.Time `json:"nextDate" gorm:"index"`
+}
+
+// CashFlowReport aggregates financial records per month, either by due
+// date (projected) or by payment date (realized).
+type CashFlowReport struct {
+	Basis       string            `json:"basis"` // "due" or "paid"
+	MonthlyData []MonthlyCashFlow `json:"monthlyData"`
+}
+
+// MonthlyCashFlow is the total incoming and outgoing amount of one month:
+// the amounts due in it, or the amounts paid in it.
+type MonthlyCashFlow struct {
+	Year  int     `json:"year"`
+	Month int     `json:"month"`
+	In    float64 `json:"in"`
+	Out   float64 `json:"out"`
+}
@@ -0,0 +1,301 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Frequencies of a recurrence rule.
+const (
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyYearly  = "yearly"
+)
+
+// Scopes of an edit of a financial record: the record only, or the record
+// and the later occurrences of its recurrence.
+const (
+	EditScopeThis   = "this"
+	EditScopeFuture = "future"
+)
+
+// Limits of a recurrence rule.
+const (
+	MaxRecurrenceInterval = 100
+	MaxRecurrenceCount    = 1000
+)
+
+// MaxOccurrencesPerAdvance caps the occurrences one Advance creates, so
+// that a series starting far in the past catches up over several runs of
+// the scheduler rather than in one huge insert.
+const MaxOccurrencesPerAdvance = 100
+
+var (
+	// ErrRecordRecurring is returned when attaching a recurrence to a
+	// record that already has one.
+	ErrRecordRecurring = errors.New("domain: financial record already recurs")
+	// ErrRecordNotRecurring is returned when editing the recurrence of a
+	// record that has none.
+	ErrRecordNotRecurring = errors.New("domain: financial record does not recur")
+)
+
+// SetDefaults makes a rule without an interval repeat every period.
+func (r *RecurrenceRule) SetDefaults() {
+	if r.Interval == 0 {
+		r.Interval = 1
+	}
+}
+
+// Validate checks the rule of a series starting on start, returning a
+// *ValidationError listing all broken rules.
+func (r *RecurrenceRule) Validate(start time.Time) error {
+	var vs Violations
+	switch r.Frequency {
+	case FrequencyWeekly, FrequencyMonthly, FrequencyYearly:
+	case "":
+		vs.Add("frequency", "required", "Frequency is required")
+	default:
+		vs.Add("frequency", "invalid_frequency", "Frequency must be 'weekly', 'monthly' or 'yearly'")
+	}
+	if r.Interval < 1 || r.Interval > MaxRecurrenceInterval {
+		vs.Add("interval", "out_of_range", fmt.Sprintf("Interval must be between 1 and %d", MaxRecurrenceInterval))
+	}
+	switch {
+	case r.DayOfMonth != 0 && r.Frequency != FrequencyMonthly:
+		vs.Add("dayOfMonth", "not_monthly", "Day of month only applies to monthly recurrences")
+	case r.DayOfMonth < 0 || r.DayOfMonth > 31:
+		vs.Add("dayOfMonth", "out_of_range", "Day of month must be between 1 and 31")
+	}
+	if r.EndDate != nil && r.Count != 0 {
+		vs.Add("count", "end_date_and_count", "Set either an end date or a count, not both")
+	}
+	if r.EndDate != nil && r.EndDate.Before(start) {
+		vs.Add("endDate", "before_start", "End date must not be before the start date")
+	}
+	if r.Count < 0 || r.Count > MaxRecurrenceCount {
+		vs.Add("count", "out_of_range", fmt.Sprintf("Count must be between 0 and %d", MaxRecurrenceCount))
+	}
+	return vs.Err()
+}
+
+// Attach makes record the template and first occurrence of the recurrence,
+// whose rule it validates against the due date of the record. The caller
+// links the record to the recurrence once it has an ID. It returns
+// ErrRecordRecurring when the record is already an occurrence.
+func (r *Recurrence) Attach(record *FinancialRecord) error {
+	if record.RecurrenceID != nil {
+		return ErrRecordRecurring
+	}
+	r.SetDefaults()
+	if err := r.RecurrenceRule.Validate(record.DueDate); err != nil {
+		return err
+	}
+	r.OrganizationID = record.OrganizationID
+	r.setTemplate(record)
+	r.StartDate, r.StartOccurrence, r.Generated = record.DueDate, 0, 1
+	r.schedule()
+	return nil
+}
+
+// setTemplate copies the fields of record that occurrences repeat.
+func (r *Recurrence) setTemplate(record *FinancialRecord) {
+	r.Direction, r.Amount = record.Direction, record.Amount
+	r.Description, r.Reference, r.Metadata = record.Description, record.Reference, record.Metadata
+	r.CounterpartyName, r.CounterpartyDocument = record.CounterpartyName, record.CounterpartyDocument
+	r.TagIDs = make([]uint, len(record.Tags))
+	for i, t := range record.Tags {
+		r.TagIDs[i] = t.ID
+	}
+}
+
+// Date returns the due date of occurrence i of the schedule, which must not
+// come before StartOccurrence.
+func (r *Recurrence) Date(i int) time.Time {
+	n := (i - r.StartOccurrence) * r.Interval
+	switch r.Frequency {
+	case FrequencyWeekly:
+		return r.StartDate.AddDate(0, 0, 7*n)
+	case FrequencyMonthly:
+		day := r.StartDate.Day()
+		if r.DayOfMonth != 0 && n != 0 {
+			day = r.DayOfMonth
+		}
+		return addMonths(r.StartDate, n, day)
+	default:
+		return addMonths(r.StartDate, 12*n, r.StartDate.Day())
+	}
+}
+
+// addMonths returns t moved by months, on day of the month or on its last
+// day when the month is shorter.
+func addMonths(t time.Time, months, day int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(day, last)-1)
+}
+
+// ended reports whether occurrence i, due on date, falls past the end of
+// the series. Series end before MaxDueDate at the latest.
+func (r *Recurrence) ended(i int, date time.Time) bool {
+	return r.Count > 0 && i >= r.Count || r.EndDate != nil && date.After(*r.EndDate) || !date.Before(MaxDueDate)
+}
+
+// schedule sets NextDate to the due date of occurrence Generated, or to nil
+// once the series ended.
+func (r *Recurrence) schedule() {
+	r.NextDate = nil
+	if date := r.Date(r.Generated); !r.ended(r.Generated, date) {
+		r.NextDate = &date
+	}
+}
+
+// Advance returns the occurrences due up to until that were not created
+// yet, at most n and MaxOccurrencesPerAdvance, and counts them as created.
+// The others stay due for a later call.
+func (r *Recurrence) Advance(until time.Time, n int) []FinancialRecord {
+	var records []FinancialRecord
+	for r.NextDate != nil && !r.NextDate.After(until) && len(records) < min(n, MaxOccurrencesPerAdvance) {
+		records = append(records, r.occurrence(r.Generated, *r.NextDate))
+		r.Generated++
+		r.schedule()
+	}
+	return records
+}
+
+// occurrence returns occurrence i of the series, due on date, made from the
+// template.
+func (r *Recurrence) occurrence(i int, date time.Time) FinancialRecord {
+	id := r.ID
+	record := FinancialRecord{
+		OrganizationID:       r.OrganizationID,
+		Direction:            r.Direction,
+		Amount:               r.Amount,
+		Tags:                 make([]Tag, len(r.TagIDs)),
+		DueDate:              date,
+		Status:               StatusPending,
+		Description:          r.Description,
+		CounterpartyName:     r.CounterpartyName,
+		CounterpartyDocument: r.CounterpartyDocument,
+		Reference:            r.Reference,
+		Metadata:             r.Metadata,
+		RecurrenceID:         &id,
+		Occurrence:           i,
+	}
+	for j, tagID := range r.TagIDs {
+		record.Tags[j].ID = tagID
+	}
+	return record
+}
+
+// RecordChange is an edit of a financial record. Fields left out, or nil,
+// are kept; a null Metadata removes the metadata.
+type RecordChange struct {
+	Direction            *string         `json:"direction"`
+	Amount               *float64        `json:"amount"`
+	DueDate              *time.Time      `json:"dueDate"`
+	Description          *string         `json:"description"`
+	CounterpartyName     *string         `json:"counterpartyName"`
+	CounterpartyDocument *string         `json:"counterpartyDocument"`
+	Reference            *string         `json:"reference"`
+	Metadata             json.RawMessage `json:"metadata"`
+	// Recurrence replaces the rule of the series from the edited
+	// occurrence on. It only applies to edits of EditScopeFuture.
+	Recurrence *RecurrenceRule `json:"recurrence"`
+}
+
+// Apply sets the fields of the change on the record.
+func (c *RecordChange) Apply(r *FinancialRecord) {
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	set(&r.Direction, c.Direction)
+	set(&r.Description, c.Description)
+	set(&r.CounterpartyName, c.CounterpartyName)
+	set(&r.CounterpartyDocument, c.CounterpartyDocument)
+	set(&r.Reference, c.Reference)
+	if c.Amount != nil {
+		r.Amount = *c.Amount
+	}
+	if c.DueDate != nil {
+		r.DueDate = *c.DueDate
+	}
+	if c.Metadata != nil {
+		r.Metadata = c.Metadata
+		if bytes.Equal(bytes.TrimSpace(r.Metadata), []byte("null")) {
+			r.Metadata = nil
+		}
+	}
+}
+
+// untouched reports whether an occurrence is still as it was created:
+// pending, with nothing paid.
+func (r *FinancialRecord) untouched() bool {
+	return r.Status == StatusPending && r.PaidAmount == 0
+}
+
+// EditFuture applies change to occurrences[0], the edited occurrence of the
+// recurrence, and from it on to the series: the template takes the fields
+// of the edited occurrence, and a new due date or rule moves the schedule
+// to count from it. The later occurrences, occurrences[1:], that are
+// pending with nothing paid take the change and their new due date, or are
+// deleted when the series now ends before them; the others are kept as
+// they are. The series counts the occurrences deleted at its end as not
+// created, so that extending it again creates them anew. It returns a
+// *ValidationError when the edited occurrence or the rule is invalid.
+func (r *Recurrence) EditFuture(occurrences []FinancialRecord, change RecordChange) error {
+	edited := &occurrences[0]
+	change.Apply(edited)
+	if change.Recurrence != nil {
+		r.RecurrenceRule = *change.Recurrence
+		r.SetDefaults()
+	}
+	var vs Violations
+	if err := vs.Merge("", edited.Validate()); err != nil {
+		return err
+	}
+	if err := vs.Merge("recurrence.", r.RecurrenceRule.Validate(edited.DueDate)); err != nil {
+		return err
+	}
+	if r.Count > 0 && r.Count <= edited.Occurrence {
+		vs.Add("recurrence.count", "ends_before_occurrence", fmt.Sprintf("Count must be more than %d to keep the edited occurrence", edited.Occurrence))
+	}
+	if err := vs.Err(); err != nil {
+		return err
+	}
+
+	r.setTemplate(edited)
+	reschedule := change.DueDate != nil || change.Recurrence != nil
+	if reschedule {
+		r.StartDate, r.StartOccurrence = edited.DueDate, edited.Occurrence
+	}
+	deleted := make(map[int]bool)
+	for i := range occurrences[1:] {
+		o := &occurrences[i+1]
+		if !o.untouched() {
+			continue
+		}
+		date := o.DueDate
+		if reschedule {
+			date = r.Date(o.Occurrence)
+		}
+		if r.ended(o.Occurrence, date) {
+			o.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+			deleted[o.Occurrence] = true
+			continue
+		}
+		change.Apply(o)
+		o.DueDate = date
+	}
+	for deleted[r.Generated-1] {
+		r.Generated--
+	}
+	r.schedule()
+	return nil
+}
@@ -0,0 +1,208 @@
+package domain
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRecurrenceDate(t *testing.T) {
+	tests := []struct {
+		rule  RecurrenceRule
+		start time.Time
+		want  []time.Time
+	}{
+		{
+			RecurrenceRule{Frequency: FrequencyWeekly, Interval: 2},
+			date(2024, 1, 29),
+			[]time.Time{date(2024, 1, 29), date(2024, 2, 12), date(2024, 2, 26)},
+		},
+		{
+			// Short months get the last day, and the next month is back
+			// on the 31st.
+			RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1},
+			date(2024, 1, 31),
+			[]time.Time{date(2024, 1, 31), date(2024, 2, 29), date(2024, 3, 31), date(2024, 4, 30)},
+		},
+		{
+			// The first occurrence keeps its own date.
+			RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1, DayOfMonth: 5},
+			date(2024, 1, 20),
+			[]time.Time{date(2024, 1, 20), date(2024, 2, 5), date(2024, 3, 5)},
+		},
+		{
+			RecurrenceRule{Frequency: FrequencyYearly, Interval: 1},
+			date(2024, 2, 29),
+			[]time.Time{date(2024, 2, 29), date(2025, 2, 28), date(2026, 2, 28), date(2027, 2, 28), date(2028, 2, 29)},
+		},
+	}
+	for _, tt := range tests {
+		r := Recurrence{RecurrenceRule: tt.rule, StartDate: tt.start}
+		var got []time.Time
+		for i := range tt.want {
+			got = append(got, r.Date(i))
+		}
+		assert.Equal(t, tt.want, got, "%+v from %s", tt.rule, tt.start.Format(time.DateOnly))
+	}
+}
+
+func TestRecurrenceRuleValidate(t *testing.T) {
+	start := date(2024, 1, 31)
+	end := date(2023, 12, 31)
+	tests := []struct {
+		name string
+		rule RecurrenceRule
+		want []string
+	}{
+		{"valid", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1, DayOfMonth: 31, Count: 12}, nil},
+		{"missing frequency", RecurrenceRule{Interval: 1}, []string{"frequency:required"}},
+		{"unknown frequency", RecurrenceRule{Frequency: "daily", Interval: 1}, []string{"frequency:invalid_frequency"}},
+		{"interval", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 101}, []string{"interval:out_of_range"}},
+		{"day of weekly", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 1, DayOfMonth: 5}, []string{"dayOfMonth:not_monthly"}},
+		{"day out of range", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1, DayOfMonth: 32}, []string{"dayOfMonth:out_of_range"}},
+		{"end before start", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1, EndDate: &end}, []string{"endDate:before_start"}},
+		{"count", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1, Count: 1001}, []string{"count:out_of_range"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, violationCodes(t, tt.rule.Validate(start)))
+		})
+	}
+
+	both := RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1, EndDate: &start, Count: 3}
+	assert.Equal(t, []string{"count:end_date_and_count"}, violationCodes(t, both.Validate(start)))
+}
+
+func TestRecurrenceAdvance(t *testing.T) {
+	record := FinancialRecord{
+		Model:          gorm.Model{ID: 7},
+		OrganizationID: 3,
+		Direction:      DirectionOut,
+		Amount:         1500,
+		Tags:           []Tag{{Model: gorm.Model{ID: 4}}},
+		DueDate:        date(2024, 1, 10),
+		Status:         StatusPaid,
+		PaidAmount:     1500,
+		Description:    "Rent",
+	}
+	rec := Recurrence{RecurrenceRule: RecurrenceRule{Frequency: FrequencyMonthly, Count: 4}}
+	require.NoError(t, rec.Attach(&record))
+	rec.ID = 9
+	assert.Equal(t, 1, rec.Interval)
+	assert.Equal(t, []uint{4}, rec.TagIDs)
+	assert.Equal(t, date(2024, 2, 10), *rec.NextDate)
+
+	assert.Empty(t, rec.Advance(date(2024, 2, 9), MaxOccurrencesPerAdvance))
+	occurrences := rec.Advance(date(2024, 3, 10), MaxOccurrencesPerAdvance)
+	require.Len(t, occurrences, 2)
+	o := occurrences[1]
+	assert.Equal(t, date(2024, 3, 10), o.DueDate)
+	assert.Equal(t, 2, o.Occurrence)
+	assert.Equal(t, uint(9), *o.RecurrenceID)
+	assert.Equal(t, StatusPending, o.Status)
+	assert.Zero(t, o.PaidAmount)
+	assert.Equal(t, "Rent", o.Description)
+	assert.Equal(t, uint(4), o.Tags[0].ID)
+
+	// The series ends after its fourth occurrence.
+	assert.Len(t, rec.Advance(date(2030, 1, 1), MaxOccurrencesPerAdvance), 1)
+	assert.Equal(t, 4, rec.Generated)
+	assert.Nil(t, rec.NextDate)
+
+	record.RecurrenceID = &rec.ID
+	assert.ErrorIs(t, (&Recurrence{}).Attach(&record), ErrRecordRecurring)
+}
+
+func TestRecurrenceAdvanceIsCapped(t *testing.T) {
+	// A weekly series backdated ten years catches up a little at a time.
+	first := FinancialRecord{Direction: DirectionOut, Amount: 100, DueDate: date(2014, 1, 6)}
+	rec := Recurrence{RecurrenceRule: RecurrenceRule{Frequency: FrequencyWeekly}}
+	require.NoError(t, rec.Attach(&first))
+	now := date(2024, 1, 1)
+
+	assert.Len(t, rec.Advance(now, 3), 3)
+	assert.Equal(t, 4, rec.Generated)
+	assert.Empty(t, rec.Advance(now, 0))
+	occurrences := rec.Advance(now, 1000)
+	require.Len(t, occurrences, MaxOccurrencesPerAdvance)
+	assert.Equal(t, 4, occurrences[0].Occurrence)
+	assert.True(t, rec.NextDate.Before(now))
+}
+
+func TestRecurrenceEditFuture(t *testing.T) {
+	first := FinancialRecord{Direction: DirectionOut, Amount: 100, DueDate: date(2024, 1, 10), Status: StatusPending}
+	rec := Recurrence{RecurrenceRule: RecurrenceRule{Frequency: FrequencyMonthly}}
+	require.NoError(t, rec.Attach(&first))
+	occurrences := append([]FinancialRecord{first}, rec.Advance(date(2024, 5, 10), MaxOccurrencesPerAdvance)...)
+	occurrences[2].Status, occurrences[2].PaidAmount = StatusPaid, 100
+
+	// Edit the second occurrence and the ones after it: the paid one keeps
+	// its fields, the others move to the 20th and cost 120.
+	amount, due := 120.0, date(2024, 2, 20)
+	edited := occurrences[1:]
+	require.NoError(t, rec.EditFuture(edited, RecordChange{Amount: &amount, DueDate: &due}))
+	assert.Equal(t, 120.0, rec.Amount)
+	assert.Equal(t, due, rec.StartDate)
+	assert.Equal(t, 1, rec.StartOccurrence)
+	assert.Equal(t, date(2024, 6, 20), *rec.NextDate)
+	assert.Equal(t, []float64{120, 100, 120, 120}, []float64{edited[0].Amount, edited[1].Amount, edited[2].Amount, edited[3].Amount})
+	assert.Equal(t, date(2024, 3, 10), edited[1].DueDate)
+	assert.Equal(t, date(2024, 4, 20), edited[2].DueDate)
+
+	// A count of 4 ends the series before the last occurrence, which is
+	// deleted.
+	rule := RecurrenceRule{Frequency: FrequencyMonthly, Count: 4}
+	edited = occurrences[3:]
+	require.NoError(t, rec.EditFuture(edited, RecordChange{Recurrence: &rule}))
+	assert.False(t, edited[0].DeletedAt.Valid)
+	assert.True(t, edited[1].DeletedAt.Valid)
+	assert.Nil(t, rec.NextDate)
+	assert.Equal(t, 4, rec.Generated)
+
+	// The store discards the recurrence and occurrences of a rejected edit.
+	rule.Count = 3
+	bad := rec
+	err := bad.EditFuture(slices.Clone(occurrences[3:]), RecordChange{Recurrence: &rule})
+	assert.Equal(t, []string{"recurrence.count:ends_before_occurrence"}, violationCodes(t, err))
+	negative := -1.0
+	bad = rec
+	err = bad.EditFuture(slices.Clone(occurrences[3:]), RecordChange{Amount: &negative})
+	assert.Equal(t, []string{"amount:negative_amount"}, violationCodes(t, err))
+}
+
+func TestRecurrenceEditFutureShortenThenExtend(t *testing.T) {
+	first := FinancialRecord{Direction: DirectionOut, Amount: 100, DueDate: date(2024, 1, 10), Status: StatusPending}
+	rec := Recurrence{RecurrenceRule: RecurrenceRule{Frequency: FrequencyMonthly, Count: 6}}
+	require.NoError(t, rec.Attach(&first))
+	occurrences := append([]FinancialRecord{first}, rec.Advance(date(2024, 6, 10), MaxOccurrencesPerAdvance)...)
+	require.Len(t, occurrences, 6)
+	occurrences[4].Status, occurrences[4].PaidAmount = StatusPaid, 100
+
+	// Ending the series after the second occurrence deletes the third,
+	// fourth and sixth; the paid fifth is kept, so only the sixth is
+	// counted as not created.
+	rule := RecurrenceRule{Frequency: FrequencyMonthly, Count: 2}
+	edited := occurrences[1:]
+	require.NoError(t, rec.EditFuture(edited, RecordChange{Recurrence: &rule}))
+	deleted := []bool{edited[1].DeletedAt.Valid, edited[2].DeletedAt.Valid, edited[3].DeletedAt.Valid, edited[4].DeletedAt.Valid}
+	assert.Equal(t, []bool{true, true, false, true}, deleted)
+	assert.Equal(t, 5, rec.Generated)
+	assert.Nil(t, rec.NextDate)
+
+	// Extending it again creates the sixth anew.
+	rule.Count = 6
+	live := []FinancialRecord{occurrences[1], occurrences[4]}
+	require.NoError(t, rec.EditFuture(live, RecordChange{Recurrence: &rule}))
+	again := rec.Advance(date(2030, 1, 1), MaxOccurrencesPerAdvance)
+	require.Len(t, again, 1)
+	assert.Equal(t, 5, again[0].Occurrence)
+	assert.Equal(t, date(2024, 6, 10), again[0].DueDate)
+}
@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrRecordPaid is returned when settling or cancelling a record that
+	// is paid in full.
+	ErrRecordPaid = errors.New("domain: financial record is paid")
+	// ErrRecordCancelled is returned when settling a cancelled record.
+	ErrRecordCancelled = errors.New("domain: financial record is cancelled")
+)
+
+// amountTolerance absorbs the rounding of amounts summed over several
+// partial payments, so that paying the balance that is left always settles
+// a record in full.
+const amountTolerance = 1e-6
+
+// Settlement is a payment of a financial record.
+type Settlement struct {
+	// Amount is the part of the balance paid; zero pays all of it.
+	Amount float64 `json:"amount"`
+	// PaidAt is when the payment was made; zero means now.
+	PaidAt time.Time `json:"paidAt"`
+}
+
+// Balance returns the part of the record's amount that is left to pay.
+func (r *FinancialRecord) Balance() float64 {
+	return r.Amount - r.PaidAmount
+}
+
+// Settle applies a payment to the record: its paid amount grows by
+// s.Amount and its status becomes paid once nothing is left to pay. It
+// returns ErrRecordPaid or ErrRecordCancelled when the record cannot be
+// paid, and a *ValidationError when s.Amount exceeds the balance.
+func (r *FinancialRecord) Settle(s Settlement) error {
+	switch r.Status {
+	case StatusPaid:
+		return ErrRecordPaid
+	case StatusCancelled:
+		return ErrRecordCancelled
+	}
+	balance := r.Balance()
+	amount := s.Amount
+	if amount == 0 {
+		amount = balance
+	}
+	if amount > balance+amountTolerance {
+		var vs Violations
+		vs.Add("amount", "exceeds_balance", fmt.Sprintf("Amount must be at most the balance of %g", balance))
+		return vs.Err()
+	}
+	paidAt := s.PaidAt
+	if paidAt.IsZero() {
+		paidAt = time.Now()
+	}
+
+	r.PaidAmount += amount
+	r.PaidAt = &paidAt
+	if r.Balance() <= amountTolerance {
+		r.PaidAmount = r.Amount
+		r.Status = StatusPaid
+	}
+	return nil
+}
+
+// PaymentSince returns the payment that took the record's paid amount from
+// paid to what it is now: the difference, made at PaidAt. It returns false
+// when the paid amount did not grow.
+func (r *FinancialRecord) PaymentSince(paid float64) (Payment, bool) {
+	if r.PaidAmount <= paid || r.PaidAt == nil {
+		return Payment{}, false
+	}
+	return Payment{
+		OrganizationID:    r.OrganizationID,
+		FinancialRecordID: r.ID,
+		Amount:            r.PaidAmount - paid,
+		PaidAt:            *r.PaidAt,
+	}, true
+}
+
+// Cancel cancels the record, keeping what was paid of it. Cancelling a
+// cancelled record changes nothing; a record paid in full cannot be
+// cancelled and returns ErrRecordPaid.
+func (r *FinancialRecord) Cancel() error {
+	if r.Status == StatusPaid {
+		return ErrRecordPaid
+	}
+	r.Status = StatusCancelled
+	return nil
+}
+
+// MarkOverdue reports a pending record whose due date is before now as
+// overdue. It only changes how the record is presented; its stored status
+// stays pending until it is settled or cancelled.
+func (r *FinancialRecord) MarkOverdue(now time.Time) {
+	if r.Status == StatusPending && r.DueDate.Before(now) {
+		r.Status = StatusOverdue
+	}
+}
@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestFinancialRecordSettle(t *testing.T) {
+	first := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	second := first.AddDate(0, 0, 7)
+	r := FinancialRecord{Direction: DirectionOut, Amount: 0.3, Status: StatusPending}
+
+	require.NoError(t, r.Settle(Settlement{Amount: 0.1, PaidAt: first}))
+	assert.Equal(t, StatusPending, r.Status)
+	assert.Equal(t, 0.1, r.PaidAmount)
+	assert.Equal(t, first, *r.PaidAt)
+
+	err := r.Settle(Settlement{Amount: 0.5})
+	assert.Equal(t, []string{"amount:exceeds_balance"}, violationCodes(t, err))
+	assert.Equal(t, 0.1, r.PaidAmount)
+
+	// 0.1 + 0.2 is not quite 0.3 in floating point; the record is paid
+	// all the same.
+	require.NoError(t, r.Settle(Settlement{Amount: 0.2, PaidAt: second}))
+	assert.Equal(t, StatusPaid, r.Status)
+	assert.Equal(t, 0.3, r.PaidAmount)
+	assert.Equal(t, second, *r.PaidAt)
+
+	assert.ErrorIs(t, r.Settle(Settlement{}), ErrRecordPaid)
+	assert.ErrorIs(t, r.Cancel(), ErrRecordPaid)
+}
+
+func TestFinancialRecordSettleBalance(t *testing.T) {
+	r := FinancialRecord{Amount: 100, Status: StatusPending, PaidAmount: 40}
+	before := time.Now()
+	require.NoError(t, r.Settle(Settlement{}))
+	assert.Equal(t, StatusPaid, r.Status)
+	assert.Equal(t, 100.0, r.PaidAmount)
+	assert.False(t, r.PaidAt.Before(before))
+}
+
+func TestFinancialRecordPaymentSince(t *testing.T) {
+	paidAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	r := FinancialRecord{Model: gorm.Model{ID: 7}, OrganizationID: 2, Amount: 100, Status: StatusPending, PaidAmount: 40, PaidAt: &paidAt}
+	_, ok := r.PaymentSince(40)
+	assert.False(t, ok)
+
+	require.NoError(t, r.Settle(Settlement{PaidAt: paidAt.AddDate(0, 1, 0)}))
+	p, ok := r.PaymentSince(40)
+	require.True(t, ok)
+	assert.Equal(t, Payment{OrganizationID: 2, FinancialRecordID: 7, Amount: 60, PaidAt: paidAt.AddDate(0, 1, 0)}, p)
+}
+
+func TestFinancialRecordCancel(t *testing.T) {
+	r := FinancialRecord{Amount: 100, Status: StatusPending}
+	require.NoError(t, r.Cancel())
+	assert.Equal(t, StatusCancelled, r.Status)
+	require.NoError(t, r.Cancel())
+	assert.ErrorIs(t, r.Settle(Settlement{}), ErrRecordCancelled)
+}
+
+func TestFinancialRecordMarkOverdue(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status string
+		due    time.Time
+		want   string
+	}{
+		{StatusPending, now.Add(-time.Minute), StatusOverdue},
+		{StatusPending, now, StatusPending},
+		{StatusPaid, now.Add(-time.Minute), StatusPaid},
+		{StatusCancelled, now.Add(-time.Minute), StatusCancelled},
+	}
+	for _, tt := range tests {
+		r := FinancialRecord{Status: tt.status, DueDate: tt.due}
+		r.MarkOverdue(now)
+		assert.Equal(t, tt.want, r.Status, "%s due %s", tt.status, tt.due)
+	}
+}
@@ -0,0 +1,338 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	// Embedded so that time zones validate the same way on hosts without a
+	// zoneinfo database, such as minimal container images.
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+// Directions of a financial record.
+const (
+	DirectionIn  = "IN"
+	DirectionOut = "OUT"
+)
+
+// Statuses of a financial record. Overdue is never stored: it is how a
+// pending record past its due date is reported.
+const (
+	StatusPending   = "pending"
+	StatusPaid      = "paid"
+	StatusCancelled = "cancelled"
+	StatusOverdue   = "overdue"
+)
+
+// Bases of the cash-flow report.
+const (
+	BasisDue  = "due"
+	BasisPaid = "paid"
+)
+
+// Scopes of an API key grant.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Defaults of the organization settings.
+const (
+	DefaultTimeZone = "UTC"
+	DefaultCurrency = "USD"
+)
+
+// Limits enforced by validation.
+const (
+	MaxOrganizationNameLength = 200
+	MaxAPIKeyNameLength       = 100
+	MaxAPIKeyGrants           = 100
+	MaxTagNameLength          = 100
+	MaxRecordTags             = 20
+	MaxAmount                 = 1e12
+	MaxDescriptionLength      = 1000
+	MaxCounterpartyNameLength = 200
+	MaxDocumentLength         = 50
+	MaxReferenceLength        = 100
+	MaxMetadataSize           = 16 << 10 // bytes
+)
+
+// Due dates must fall in [MinDueDate, MaxDueDate).
+var (
+	MinDueDate = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	MaxDueDate = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Violation is one broken validation rule, tied to the JSON field it
+// concerns. Code is stable and meant for programs; Message is worded for
+// people.
+type Violation struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+// ValidationError lists every rule an input breaks.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Violations collects the rules broken by an input.
+type Violations []Violation
+
+// Add records a broken rule.
+func (vs *Violations) Add(field, code, message string) {
+	*vs = append(*vs, Violation{Field: field, Code: code, Message: message})
+}
+
+// Merge records the violations of err, a *ValidationError, with their
+// fields prefixed by prefix. It returns err unchanged when it is another
+// kind of error, and nil otherwise.
+func (vs *Violations) Merge(prefix string, err error) error {
+	if err == nil {
+		return nil
+	}
+	validation, ok := err.(*ValidationError)
+	if !ok {
+		return err
+	}
+	for _, v := range validation.Violations {
+		v.Field = prefix + v.Field
+		*vs = append(*vs, v)
+	}
+	return nil
+}
+
+// Err returns a *ValidationError listing the violations, or nil when there
+// are none.
+func (vs Violations) Err() error {
+	if len(vs) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: vs}
+}
+
+// currencyCode matches the shape of an ISO 4217 alphabetic code.
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// SetDefaults fills in the settings left empty with their defaults.
+func (o *Organization) SetDefaults() {
+	if o.TimeZone == "" {
+		o.TimeZone = DefaultTimeZone
+	}
+	if o.Currency == "" {
+		o.Currency = DefaultCurrency
+	}
+}
+
+// Validate checks the rules every organization must satisfy before it is
+// stored, returning a *ValidationError listing all broken rules.
+func (o *Organization) Validate() error {
+	var vs Violations
+	switch name := strings.TrimSpace(o.Name); {
+	case name == "":
+		vs.Add("name", "required", "Name is required")
+	case utf8.RuneCountInString(name) > MaxOrganizationNameLength:
+		vs.Add("name", "too_long", fmt.Sprintf("Name must be at most %d characters long", MaxOrganizationNameLength))
+	}
+	// "Local" names the server's zone, which is not a setting of the
+	// organization.
+	if _, err := time.LoadLocation(o.TimeZone); err != nil || o.TimeZone == "" || o.TimeZone == "Local" {
+		vs.Add("timeZone", "invalid_time_zone", "Time zone must be an IANA time zone name, such as 'America/Sao_Paulo'")
+	}
+	if !currencyCode.MatchString(o.Currency) {
+		vs.Add("currency", "invalid_currency", "Currency must be an ISO 4217 code, such as 'USD'")
+	}
+	if o.MaxTags != nil && *o.MaxTags < 0 {
+		vs.Add("maxTags", "negative_limit", "Max tags must not be negative")
+	}
+	if o.MaxFinancialRecords != nil && *o.MaxFinancialRecords < 0 {
+		vs.Add("maxFinancialRecords", "negative_limit", "Max financial records must not be negative")
+	}
+	return vs.Err()
+}
+
+// Validate checks the rules every API key must satisfy before it is issued,
+// returning a *ValidationError listing all broken rules. Whether the
+// granted organizations exist is checked by the caller.
+func (k *APIKey) Validate() error {
+	var vs Violations
+	switch name := strings.TrimSpace(k.Name); {
+	case name == "":
+		vs.Add("name", "required", "Name is required")
+	case utf8.RuneCountInString(name) > MaxAPIKeyNameLength:
+		vs.Add("name", "too_long", fmt.Sprintf("Name must be at most %d characters long", MaxAPIKeyNameLength))
+	}
+	switch {
+	case len(k.Grants) == 0:
+		vs.Add("grants", "required", "At least one grant is required")
+	case len(k.Grants) > MaxAPIKeyGrants:
+		vs.Add("grants", "too_many_grants", fmt.Sprintf("A key can have at most %d grants", MaxAPIKeyGrants))
+	}
+	seen := map[uint]bool{}
+	for i, g := range k.Grants {
+		if g.Scope != ScopeRead && g.Scope != ScopeWrite {
+			vs.Add(fmt.Sprintf("grants[%d].scope", i), "invalid_scope", "Scope must be either 'read' or 'write'")
+		}
+		if seen[g.OrganizationID] {
+			vs.Add(fmt.Sprintf("grants[%d].organizationId", i), "duplicate_grant", "The organization is granted more than once")
+		}
+		seen[g.OrganizationID] = true
+	}
+	return vs.Err()
+}
+
+// NormalizeTagName returns the form under which tag names are compared:
+// lower case, without surrounding whitespace and with inner runs of
+// whitespace collapsed to one space, so that "Red  Cat" and " red cat"
+// name the same tag. The database enforces uniqueness on the same
+// expression.
+func NormalizeTagName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// Validate checks the rules every tag must satisfy before it is stored,
+// returning a *ValidationError listing all broken rules. Uniqueness of the
+// name within the organization is enforced by the store.
+func (t *Tag) Validate() error {
+	var vs Violations
+	switch name := strings.TrimSpace(t.Name); {
+	case name == "":
+		vs.Add("name", "required", "Name is required")
+	case utf8.RuneCountInString(name) > MaxTagNameLength:
+		vs.Add("name", "too_long", fmt.Sprintf("Name must be at most %d characters long", MaxTagNameLength))
+	}
+	return vs.Err()
+}
+
+// SetDefaults makes a record without a status pending, and stores a null
+// metadata as no metadata.
+func (r *FinancialRecord) SetDefaults() {
+	if r.Status == "" {
+		r.Status = StatusPending
+	}
+	if bytes.Equal(bytes.TrimSpace(r.Metadata), []byte("null")) {
+		r.Metadata = nil
+	}
+}
+
+// Validate checks the rules every financial record must satisfy before it is
+// stored, returning a *ValidationError listing all broken rules.
+func (r *FinancialRecord) Validate() error {
+	var vs Violations
+	if r.Direction != DirectionIn && r.Direction != DirectionOut {
+		vs.Add("direction", "invalid_direction", "Direction must be either 'IN' or 'OUT'")
+	}
+	switch {
+	case r.Amount < 0:
+		vs.Add("amount", "negative_amount", "Amount must be greater than or equal to zero")
+	case r.Amount > MaxAmount:
+		vs.Add("amount", "amount_too_large", fmt.Sprintf("Amount must be at most %.0f", MaxAmount))
+	}
+	switch {
+	case r.DueDate.IsZero():
+		vs.Add("dueDate", "required", "Due date is required")
+	case r.DueDate.Before(MinDueDate) || !r.DueDate.Before(MaxDueDate):
+		vs.Add("dueDate", "out_of_range", fmt.Sprintf("Due date must be on or after %s and before %s",
+			MinDueDate.Format(time.DateOnly), MaxDueDate.Format(time.DateOnly)))
+	}
+	if len(r.Tags) > MaxRecordTags {
+		vs.Add("tags", "too_many_tags", fmt.Sprintf("A record can have at most %d tags", MaxRecordTags))
+	}
+	switch r.Status {
+	// An empty status is pending, as SetDefaults makes it.
+	case "", StatusPending, StatusCancelled:
+		if r.PaidAmount > 0 && r.PaidAmount >= r.Amount {
+			vs.Add("paidAmount", "fully_paid", "A record paid in full must have the status 'paid'")
+		}
+	case StatusPaid:
+		if r.PaidAmount != r.Amount {
+			vs.Add("paidAmount", "not_fully_paid", "A paid record must have paidAmount equal to amount")
+		}
+	default:
+		vs.Add("status", "invalid_status", "Status must be 'pending', 'paid' or 'cancelled'")
+	}
+	switch {
+	case r.PaidAmount < 0:
+		vs.Add("paidAmount", "negative_amount", "Paid amount must be greater than or equal to zero")
+	case r.PaidAmount > r.Amount && r.Amount >= 0:
+		vs.Add("paidAmount", "exceeds_amount", "Paid amount must be at most the amount")
+	}
+	switch {
+	case r.PaidAt == nil:
+		if r.PaidAmount > 0 || r.Status == StatusPaid {
+			vs.Add("paidAt", "required", "Payment date is required once a record is paid")
+		}
+	case r.PaidAt.Before(MinDueDate) || !r.PaidAt.Before(MaxDueDate):
+		vs.Add("paidAt", "out_of_range", fmt.Sprintf("Payment date must be on or after %s and before %s",
+			MinDueDate.Format(time.DateOnly), MaxDueDate.Format(time.DateOnly)))
+	}
+	for _, f := range []struct {
+		field, name, value string
+		max                int
+	}{
+		{"description", "Description", r.Description, MaxDescriptionLength},
+		{"counterpartyName", "Counterparty name", r.CounterpartyName, MaxCounterpartyNameLength},
+		{"counterpartyDocument", "Counterparty document", r.CounterpartyDocument, MaxDocumentLength},
+		{"reference", "Reference", r.Reference, MaxReferenceLength},
+	} {
+		if utf8.RuneCountInString(f.value) > f.max {
+			vs.Add(f.field, "too_long", fmt.Sprintf("%s must be at most %d characters long", f.name, f.max))
+		}
+	}
+	switch {
+	case r.Metadata == nil:
+	case !IsJSONObject(r.Metadata):
+		vs.Add("metadata", "invalid_metadata", "Metadata must be a JSON object")
+	case len(r.Metadata) > MaxMetadataSize:
+		vs.Add("metadata", "metadata_too_large", fmt.Sprintf("Metadata must be at most %d bytes long", MaxMetadataSize))
+	}
+	return vs.Err()
+}
+
+// IsJSONObject reports whether data is a valid JSON object.
+func IsJSONObject(data []byte) bool {
+	var object map[string]json.RawMessage
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) && json.Unmarshal(data, &object) == nil
+}
+
+// Validate checks the rules of a settlement, returning a *ValidationError
+// listing all broken rules. Whether the amount fits the balance of the
+// record is checked by FinancialRecord.Settle.
+func (s *Settlement) Validate() error {
+	var vs Violations
+	switch {
+	case s.Amount < 0:
+		vs.Add("amount", "negative_amount", "Amount must be greater than or equal to zero")
+	case s.Amount > MaxAmount:
+		vs.Add("amount", "amount_too_large", fmt.Sprintf("Amount must be at most %.0f", MaxAmount))
+	}
+	if !s.PaidAt.IsZero() && (s.PaidAt.Before(MinDueDate) || !s.PaidAt.Before(MaxDueDate)) {
+		vs.Add("paidAt", "out_of_range", fmt.Sprintf("Payment date must be on or after %s and before %s",
+			MinDueDate.Format(time.DateOnly), MaxDueDate.Format(time.DateOnly)))
+	}
+	return vs.Err()
+}
+
+// ValidateFinancialRecords validates every record of a batch, returning a
+// single *ValidationError whose fields are prefixed with the record index,
+// e.g. "[2].amount".
+func ValidateFinancialRecords(records []FinancialRecord) error {
+	var vs Violations
+	for i := range records {
+		vs.Merge(fmt.Sprintf("[%d].", i), records[i].Validate())
+	}
+	return vs.Err()
+}
@@ -0,0 +1,223 @@
+package domain
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// violationCodes returns the "field:code" pairs of a *ValidationError.
+func violationCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	validation, ok := err.(*ValidationError)
+	require.True(t, ok, "unexpected error type %T", err)
+	codes := make([]string, len(validation.Violations))
+	for i, v := range validation.Violations {
+		codes[i] = v.Field + ":" + v.Code
+	}
+	return codes
+}
+
+func TestOrganizationValidate(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name string
+		org  Organization
+		want []string
+	}{
+		{"valid", Organization{Name: "Acme", TimeZone: "America/Sao_Paulo", Currency: "BRL"}, nil},
+		{"UTC", Organization{Name: "Acme", TimeZone: "UTC", Currency: "USD"}, nil},
+		{"blank name", Organization{Name: " ", TimeZone: "UTC", Currency: "USD"}, []string{"name:required"}},
+		{"name too long", Organization{Name: strings.Repeat("x", MaxOrganizationNameLength+1), TimeZone: "UTC", Currency: "USD"}, []string{"name:too_long"}},
+		{"unknown time zone", Organization{Name: "Acme", TimeZone: "Mars/Olympus", Currency: "USD"}, []string{"timeZone:invalid_time_zone"}},
+		{"local time zone", Organization{Name: "Acme", TimeZone: "Local", Currency: "USD"}, []string{"timeZone:invalid_time_zone"}},
+		{"negative limits", Organization{Name: "Acme", TimeZone: "UTC", Currency: "USD", MaxTags: &negative, MaxFinancialRecords: &negative}, []string{"maxTags:negative_limit", "maxFinancialRecords:negative_limit"}},
+		{"lower case currency", Organization{Name: "Acme", TimeZone: "UTC", Currency: "usd"}, []string{"currency:invalid_currency"}},
+		{"every violation", Organization{}, []string{"name:required", "timeZone:invalid_time_zone", "currency:invalid_currency"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, violationCodes(t, tt.org.Validate()))
+		})
+	}
+}
+
+func TestOrganizationSetDefaults(t *testing.T) {
+	org := Organization{Name: "Acme"}
+	org.SetDefaults()
+	assert.Equal(t, DefaultTimeZone, org.TimeZone)
+	assert.Equal(t, DefaultCurrency, org.Currency)
+	assert.NoError(t, org.Validate())
+
+	org = Organization{Name: "Acme", TimeZone: "Europe/Lisbon", Currency: "EUR"}
+	org.SetDefaults()
+	assert.Equal(t, "Europe/Lisbon", org.TimeZone)
+	assert.Equal(t, "EUR", org.Currency)
+}
+
+func TestAPIKeyValidate(t *testing.T) {
+	read := []APIKeyGrant{{OrganizationID: 1, Scope: ScopeRead}}
+	tooMany := make([]APIKeyGrant, MaxAPIKeyGrants+1)
+	for i := range tooMany {
+		tooMany[i] = APIKeyGrant{OrganizationID: uint(i + 1), Scope: ScopeRead}
+	}
+	tests := []struct {
+		name string
+		key  APIKey
+		want []string
+	}{
+		{"valid", APIKey{Name: "ci", Grants: []APIKeyGrant{{OrganizationID: 1, Scope: ScopeRead}, {OrganizationID: 2, Scope: ScopeWrite}}}, nil},
+		{"blank name", APIKey{Name: " ", Grants: read}, []string{"name:required"}},
+		{"name too long", APIKey{Name: strings.Repeat("x", MaxAPIKeyNameLength+1), Grants: read}, []string{"name:too_long"}},
+		{"no grants", APIKey{Name: "ci"}, []string{"grants:required"}},
+		{"too many grants", APIKey{Name: "ci", Grants: tooMany}, []string{"grants:too_many_grants"}},
+		{"invalid scope", APIKey{Name: "ci", Grants: []APIKeyGrant{{OrganizationID: 1, Scope: "admin"}}}, []string{"grants[0].scope:invalid_scope"}},
+		{"duplicate organization", APIKey{Name: "ci", Grants: []APIKeyGrant{{OrganizationID: 1, Scope: ScopeRead}, {OrganizationID: 1, Scope: ScopeWrite}}},
+			[]string{"grants[1].organizationId:duplicate_grant"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, violationCodes(t, tt.key.Validate()))
+		})
+	}
+}
+
+func TestAPIKeyAllows(t *testing.T) {
+	key := APIKey{Grants: []APIKeyGrant{{OrganizationID: 1, Scope: ScopeRead}, {OrganizationID: 2, Scope: ScopeWrite}}}
+	assert.True(t, key.Allows(1, ScopeRead))
+	assert.False(t, key.Allows(1, ScopeWrite))
+	assert.True(t, key.Allows(2, ScopeRead), "write implies read")
+	assert.True(t, key.Allows(2, ScopeWrite))
+	assert.False(t, key.Allows(3, ScopeRead))
+}
+
+func TestTagValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  Tag
+		want []string
+	}{
+		{"valid", Tag{Name: "Rent"}, nil},
+		{"multibyte name at the limit", Tag{Name: strings.Repeat("é", MaxTagNameLength)}, nil},
+		{"surrounding whitespace is not counted", Tag{Name: " " + strings.Repeat("x", MaxTagNameLength) + " "}, nil},
+		{"empty", Tag{}, []string{"name:required"}},
+		{"blank", Tag{Name: " \t"}, []string{"name:required"}},
+		{"too long", Tag{Name: strings.Repeat("x", MaxTagNameLength+1)}, []string{"name:too_long"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, violationCodes(t, tt.tag.Validate()))
+		})
+	}
+}
+
+func TestFinancialRecordValidate(t *testing.T) {
+	due := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	valid := FinancialRecord{Direction: DirectionIn, Amount: 10, DueDate: due}
+
+	tests := []struct {
+		name   string
+		modify func(r *FinancialRecord)
+		want   []string
+	}{
+		{"valid", func(r *FinancialRecord) {}, nil},
+		{"zero amount", func(r *FinancialRecord) { r.Amount = 0 }, nil},
+		{"maximum amount", func(r *FinancialRecord) { r.Amount = MaxAmount }, nil},
+		{"earliest due date", func(r *FinancialRecord) { r.DueDate = MinDueDate }, nil},
+		{"maximum tags", func(r *FinancialRecord) { r.Tags = make([]Tag, MaxRecordTags) }, nil},
+		{"invalid direction", func(r *FinancialRecord) { r.Direction = "in" }, []string{"direction:invalid_direction"}},
+		{"negative amount", func(r *FinancialRecord) { r.Amount = -0.01 }, []string{"amount:negative_amount"}},
+		{"amount too large", func(r *FinancialRecord) { r.Amount = MaxAmount + 1 }, []string{"amount:amount_too_large"}},
+		{"missing due date", func(r *FinancialRecord) { r.DueDate = time.Time{} }, []string{"dueDate:required"}},
+		{"due date too early", func(r *FinancialRecord) { r.DueDate = MinDueDate.Add(-time.Second) }, []string{"dueDate:out_of_range"}},
+		{"due date too late", func(r *FinancialRecord) { r.DueDate = MaxDueDate }, []string{"dueDate:out_of_range"}},
+		{"too many tags", func(r *FinancialRecord) { r.Tags = make([]Tag, MaxRecordTags+1) }, []string{"tags:too_many_tags"}},
+		{"pending", func(r *FinancialRecord) { r.Status = StatusPending }, nil},
+		{"partially paid", func(r *FinancialRecord) { r.Status, r.PaidAmount, r.PaidAt = StatusPending, 4, &due }, nil},
+		{"paid", func(r *FinancialRecord) { r.Status, r.PaidAmount, r.PaidAt = StatusPaid, 10, &due }, nil},
+		{"cancelled after a payment", func(r *FinancialRecord) { r.Status, r.PaidAmount, r.PaidAt = StatusCancelled, 4, &due }, nil},
+		{"overdue status", func(r *FinancialRecord) { r.Status = StatusOverdue }, []string{"status:invalid_status"}},
+		{"pending but paid in full", func(r *FinancialRecord) { r.Status, r.PaidAmount, r.PaidAt = StatusPending, 10, &due }, []string{"paidAmount:fully_paid"}},
+		{"paid in part", func(r *FinancialRecord) { r.Status, r.PaidAmount, r.PaidAt = StatusPaid, 4, &due }, []string{"paidAmount:not_fully_paid"}},
+		{"negative paid amount", func(r *FinancialRecord) { r.PaidAmount, r.PaidAt = -1, &due }, []string{"paidAmount:negative_amount"}},
+		{"paid amount too large", func(r *FinancialRecord) { r.Status, r.PaidAmount, r.PaidAt = StatusPaid, 11, &due },
+			[]string{"paidAmount:not_fully_paid", "paidAmount:exceeds_amount"}},
+		{"missing payment date", func(r *FinancialRecord) { r.Status, r.PaidAmount = StatusPaid, 10 }, []string{"paidAt:required"}},
+		{"payment date too late", func(r *FinancialRecord) { r.PaidAmount, r.PaidAt = 4, &MaxDueDate }, []string{"paidAt:out_of_range"}},
+		{"details", func(r *FinancialRecord) {
+			r.Description, r.CounterpartyName, r.CounterpartyDocument, r.Reference = "Office rent", "Acme Ltda", "12.345.678/0001-90", "INV-2024-001"
+			r.Metadata = json.RawMessage(`{"costCenter": "sales", "lines": [1, 2]}`)
+		}, nil},
+		{"longest description", func(r *FinancialRecord) { r.Description = strings.Repeat("é", MaxDescriptionLength) }, nil},
+		{"description too long", func(r *FinancialRecord) { r.Description = strings.Repeat("x", MaxDescriptionLength+1) }, []string{"description:too_long"}},
+		{"counterparty name too long", func(r *FinancialRecord) { r.CounterpartyName = strings.Repeat("x", MaxCounterpartyNameLength+1) },
+			[]string{"counterpartyName:too_long"}},
+		{"document too long", func(r *FinancialRecord) { r.CounterpartyDocument = strings.Repeat("1", MaxDocumentLength+1) },
+			[]string{"counterpartyDocument:too_long"}},
+		{"reference too long", func(r *FinancialRecord) { r.Reference = strings.Repeat("x", MaxReferenceLength+1) }, []string{"reference:too_long"}},
+		{"metadata array", func(r *FinancialRecord) { r.Metadata = json.RawMessage(`[1]`) }, []string{"metadata:invalid_metadata"}},
+		{"metadata too large", func(r *FinancialRecord) {
+			r.Metadata = json.RawMessage(`{"notes": "` + strings.Repeat("x", MaxMetadataSize) + `"}`)
+		}, []string{"metadata:metadata_too_large"}},
+		{"every violation", func(r *FinancialRecord) { *r = FinancialRecord{Amount: -1} },
+			[]string{"direction:invalid_direction", "amount:negative_amount", "dueDate:required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			tt.modify(&r)
+			assert.Equal(t, tt.want, violationCodes(t, r.Validate()))
+		})
+	}
+}
+
+func TestValidateFinancialRecords(t *testing.T) {
+	due := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	records := []FinancialRecord{
+		{Direction: DirectionIn, Amount: 10, DueDate: due},
+		{Direction: "UP", Amount: 10, DueDate: due},
+		{Direction: DirectionOut, Amount: -5, DueDate: due},
+	}
+	assert.Equal(t, []string{"[1].direction:invalid_direction", "[2].amount:negative_amount"},
+		violationCodes(t, ValidateFinancialRecords(records)))
+
+	assert.NoError(t, ValidateFinancialRecords(records[:1]))
+}
+
+func TestSettlementValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		settlement Settlement
+		want       []string
+	}{
+		{"full balance", Settlement{}, nil},
+		{"partial", Settlement{Amount: 5, PaidAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}, nil},
+		{"negative amount", Settlement{Amount: -1}, []string{"amount:negative_amount"}},
+		{"amount too large", Settlement{Amount: MaxAmount + 1}, []string{"amount:amount_too_large"}},
+		{"payment date too early", Settlement{PaidAt: MinDueDate.Add(-time.Second)}, []string{"paidAt:out_of_range"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, violationCodes(t, tt.settlement.Validate()))
+		})
+	}
+}
+
+func TestFinancialRecordSetDefaults(t *testing.T) {
+	r := FinancialRecord{Metadata: json.RawMessage(" null")}
+	r.SetDefaults()
+	assert.Equal(t, StatusPending, r.Status)
+	assert.Nil(t, r.Metadata)
+
+	r = FinancialRecord{Status: StatusPaid, Metadata: json.RawMessage(`{}`)}
+	r.SetDefaults()
+	assert.Equal(t, StatusPaid, r.Status)
+	assert.JSONEq(t, `{}`, string(r.Metadata))
+}
@@ -0,0 +1,178 @@
+package httpapi
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+)
+
+// Limiter is a concurrency limiter for one route class. At most Limit
+// requests run at a time; up to Queue more wait for a slot for at most
+// MaxWait. Anything beyond that is shed with 503 and a Retry-After header,
+// so that overload fails fast instead of piling up in the database pool.
+type Limiter struct {
+	name    string
+	slots   chan struct{}
+	queue   int64
+	maxWait time.Duration
+
+	waiting  atomic.Int64
+	inFlight atomic.Int64
+
+	admitted       atomic.Int64
+	rejectedFull   atomic.Int64
+	rejectedWait   atomic.Int64
+	totalWaitNanos atomic.Int64
+}
+
+// NewLimiter returns a limiter sized by cfg, or nil when cfg.Limit is zero.
+// A nil *Limiter admits every request.
+func NewLimiter(name string, cfg config.AdmissionConfig) *Limiter {
+	if cfg.Limit <= 0 {
+		return nil
+	}
+	return &Limiter{
+		name:    name,
+		slots:   make(chan struct{}, cfg.Limit),
+		queue:   int64(max(cfg.Queue, 0)),
+		maxWait: cfg.MaxWait,
+	}
+}
+
+// LimiterStats is the snapshot exported through expvar.
+type LimiterStats struct {
+	Limit          int     `json:"limit"`
+	QueueSize      int64   `json:"queue_size"`
+	MaxWaitMs      int64   `json:"max_wait_ms"`
+	InFlight       int64   `json:"in_flight"`
+	Waiting        int64   `json:"waiting"`
+	Admitted       int64   `json:"admitted"`
+	RejectedFull   int64   `json:"rejected_queue_full"`
+	RejectedWait   int64   `json:"rejected_max_wait"`
+	AvgQueueWaitMs float64 `json:"avg_queue_wait_ms"`
+}
+
+// Stats returns the current state of the limiter.
+func (l *Limiter) Stats() LimiterStats {
+	s := LimiterStats{
+		Limit:        cap(l.slots),
+		QueueSize:    l.queue,
+		MaxWaitMs:    l.maxWait.Milliseconds(),
+		InFlight:     l.inFlight.Load(),
+		Waiting:      l.waiting.Load(),
+		Admitted:     l.admitted.Load(),
+		RejectedFull: l.rejectedFull.Load(),
+		RejectedWait: l.rejectedWait.Load(),
+	}
+	if s.Admitted > 0 {
+		s.AvgQueueWaitMs = float64(l.totalWaitNanos.Load()) / float64(s.Admitted) / float64(time.Millisecond)
+	}
+	return s
+}
+
+// Middleware admits the request or sheds it with 503.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		if !l.acquire(c) {
+			return
+		}
+		defer l.release()
+		c.Next()
+	}
+}
+
+func (l *Limiter) acquire(c *gin.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		l.admit(0)
+		return true
+	default:
+	}
+
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		l.rejectedFull.Add(1)
+		l.reject(c)
+		return false
+	}
+	defer l.waiting.Add(-1)
+
+	start := time.Now()
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.admit(time.Since(start))
+		return true
+	case <-timer.C:
+		l.rejectedWait.Add(1)
+		l.reject(c)
+		return false
+	case <-c.Request.Context().Done():
+		c.Error(c.Request.Context().Err())
+		c.Abort()
+		return false
+	}
+}
+
+func (l *Limiter) admit(wait time.Duration) {
+	l.inFlight.Add(1)
+	l.admitted.Add(1)
+	l.totalWaitNanos.Add(int64(wait))
+}
+
+func (l *Limiter) release() {
+	l.inFlight.Add(-1)
+	<-l.slots
+}
+
+func (l *Limiter) reject(c *gin.Context) {
+	retryAfter := int(math.Ceil(l.maxWait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	abortWithProblem(c, NewProblem(http.StatusServiceUnavailable, CodeOverloaded, "The server is overloaded, retry later"))
+}
+
+// Admission holds the limiters for every route class.
+type Admission struct {
+	Reads   *Limiter
+	Writes  *Limiter
+	Reports *Limiter
+}
+
+// NewAdmission builds the limiters from cfg.
+func NewAdmission(cfg config.Config) *Admission {
+	return &Admission{
+		Reads:   NewLimiter("reads", cfg.ReadAdmission),
+		Writes:  NewLimiter("writes", cfg.WriteAdmission),
+		Reports: NewLimiter("reports", cfg.ReportAdmission),
+	}
+}
+
+// Publish exports the state of every enabled limiter under the "admission"
+// expvar, served at /debug/vars.
+func (a *Admission) Publish() {
+	expvar.Publish("admission", expvar.Func(func() any {
+		stats := map[string]LimiterStats{}
+		for _, l := range []*Limiter{a.Reads, a.Writes, a.Reports} {
+			if l != nil {
+				stats[l.name] = l.Stats()
+			}
+		}
+		return stats
+	}))
+}
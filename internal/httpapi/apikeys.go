@@ -0,0 +1,235 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// apiKeyPrefix starts every API key secret, so that leaked keys are easy to
+// recognize, for instance by secret scanners.
+const apiKeyPrefix = "frk_"
+
+// apiKeyDisplayLength is the length of the secret prefix stored and shown
+// to tell keys apart.
+const apiKeyDisplayLength = len(apiKeyPrefix) + 8
+
+// newAPIKeySecret returns a random API key secret.
+func newAPIKeySecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the hash under which a secret is stored. Secrets are
+// long and random, so a fast unsalted hash is enough to make a leaked
+// database useless for authentication.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyAuthenticator authenticates the admin key and the API keys issued
+// through the API. It handles every token, so it comes last.
+//
+// Keys found are remembered in process memory for a TTL, so a key revoked
+// through another server instance keeps working there for at most that
+// long.
+type APIKeyAuthenticator struct {
+	keys      store.APIKeyStore
+	adminHash string
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	found map[string]cachedAPIKey // hash -> key
+}
+
+type cachedAPIKey struct {
+	key     *domain.APIKey
+	expires time.Time
+}
+
+// NewAPIKeyAuthenticator returns an authenticator looking keys up in keys
+// and remembering them for cacheTTL. adminKey is the secret of the admin
+// key; an empty adminKey disables it.
+func NewAPIKeyAuthenticator(keys store.APIKeyStore, adminKey string, cacheTTL time.Duration) *APIKeyAuthenticator {
+	a := &APIKeyAuthenticator{keys: keys, ttl: cacheTTL, found: map[string]cachedAPIKey{}}
+	if adminKey != "" {
+		a.adminHash = hashAPIKey(adminKey)
+	}
+	return a
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, secret string) (*Principal, error) {
+	hash := hashAPIKey(secret)
+	if a.adminHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.adminHash)) == 1 {
+		return &Principal{Subject: "admin", Admin: true}, nil
+	}
+
+	a.mu.Lock()
+	cached, ok := a.found[hash]
+	a.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return apiKeyPrincipal(cached.key), nil
+	}
+
+	key, err := a.keys.FindAPIKeyByHash(ctx, hash)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.ttl > 0 {
+		a.mu.Lock()
+		a.found[hash] = cachedAPIKey{key: key, expires: time.Now().Add(a.ttl)}
+		a.mu.Unlock()
+	}
+	return apiKeyPrincipal(key), nil
+}
+
+func apiKeyPrincipal(key *domain.APIKey) *Principal {
+	return &Principal{Subject: "api-key:" + strconv.FormatUint(uint64(key.ID), 10), Grants: key.Grants}
+}
+
+// forget drops the key from the cache once it is revoked.
+func (a *APIKeyAuthenticator) forget(id uint) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for hash, cached := range a.found {
+		if cached.key.ID == id {
+			delete(a.found, hash)
+		}
+	}
+}
+
+// forgetAPIKey drops a revoked key from the caches of the authenticators.
+func (a *Auth) forgetAPIKey(id uint) {
+	if a == nil {
+		return
+	}
+	for _, authenticator := range a.authenticators {
+		if keys, ok := authenticator.(*APIKeyAuthenticator); ok {
+			keys.forget(id)
+		}
+	}
+}
+
+// apiKeyInput is the body of the issue request.
+type apiKeyInput struct {
+	Name   string `json:"name"`
+	Grants []struct {
+		OrganizationID uint   `json:"organizationId"`
+		Scope          string `json:"scope"`
+	} `json:"grants"`
+}
+
+// issuedAPIKey is the response to the issue request, the only one carrying
+// the secret.
+type issuedAPIKey struct {
+	domain.APIKey
+	Secret string `json:"secret"`
+}
+
+func issueAPIKey(keys store.APIKeyStore, orgs store.OrganizationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in apiKeyInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.Error(bindProblem(err))
+			return
+		}
+		key := domain.APIKey{Name: strings.TrimSpace(in.Name)}
+		for _, g := range in.Grants {
+			key.Grants = append(key.Grants, domain.APIKeyGrant{OrganizationID: g.OrganizationID, Scope: g.Scope})
+		}
+
+		var vs domain.Violations
+		if err := vs.Merge("", key.Validate()); err != nil {
+			c.Error(err)
+			return
+		}
+		// Grants on organizations that do not exist are reported with the
+		// other violations, rather than as a foreign key error.
+		for i, g := range key.Grants {
+			_, err := orgs.GetOrganization(c.Request.Context(), g.OrganizationID)
+			if errors.Is(err, store.ErrNotFound) {
+				vs.Add(fmt.Sprintf("grants[%d].organizationId", i), "unknown_organization", "The organization does not exist")
+				continue
+			}
+			if err != nil {
+				c.Error(err)
+				return
+			}
+		}
+		if err := vs.Err(); err != nil {
+			c.Error(err)
+			return
+		}
+
+		secret, err := newAPIKeySecret()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		key.Prefix = secret[:apiKeyDisplayLength]
+		key.Hash = hashAPIKey(secret)
+		if err := keys.CreateAPIKey(c.Request.Context(), &key); err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, issuedAPIKey{APIKey: key, Secret: secret})
+	}
+}
+
+func listAPIKeys(keys store.APIKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page := pagination(c)
+
+		list, total, err := keys.ListAPIKeys(c.Request.Context(), page)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, paginated(list, page, total))
+	}
+}
+
+func revokeAPIKey(keys store.APIKeyStore, auth *Auth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("keyId"), 10, 32)
+		if err != nil {
+			c.Error(NewProblem(http.StatusBadRequest, CodeInvalidAPIKeyID, "The API key ID must be a positive integer"))
+			return
+		}
+
+		err = keys.RevokeAPIKey(c.Request.Context(), uint(id))
+		if errors.Is(err, store.ErrNotFound) {
+			err = NewProblem(http.StatusNotFound, CodeAPIKeyNotFound, "The API key does not exist or is already revoked")
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		auth.forgetAPIKey(uint(id))
+
+		noContent(c)
+	}
+}
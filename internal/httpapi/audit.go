@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// auditEventFilter parses the filters of the audit event listing, responding
+// with 400 when one is invalid.
+func auditEventFilter(c *gin.Context) (store.AuditEventFilter, bool) {
+	filter := store.AuditEventFilter{
+		EntityType: c.Query("entity_type"),
+		Action:     c.Query("action"),
+		Actor:      c.Query("actor"),
+	}
+	invalid := func(detail string) (store.AuditEventFilter, bool) {
+		c.Error(NewProblem(http.StatusBadRequest, CodeInvalidQuery, detail))
+		return store.AuditEventFilter{}, false
+	}
+
+	switch filter.EntityType {
+	case "", domain.AuditEntityTag, domain.AuditEntityFinancialRecord, domain.AuditEntityRecurrence:
+	default:
+		return invalid("entity_type must be tag, financial_record or recurrence")
+	}
+	switch filter.Action {
+	case "", domain.AuditCreate, domain.AuditUpdate, domain.AuditDelete, domain.AuditRestore:
+	default:
+		return invalid("action must be create, update, delete or restore")
+	}
+	if s := c.Query("entity_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || id == 0 {
+			return invalid("entity_id must be a positive integer")
+		}
+		filter.EntityID = uint(id)
+	}
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		s := c.Query(bound.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return invalid(bound.name + " must be an RFC 3339 timestamp")
+		}
+		*bound.dst = t
+	}
+	return filter, true
+}
+
+func listAuditEvents(auditStore store.AuditEventStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		filter, ok := auditEventFilter(c)
+		if !ok {
+			return
+		}
+		page := pagination(c)
+
+		events, total, err := auditStore.ListAuditEvents(c.Request.Context(), orgID, filter, page)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, paginated(events, page, total))
+	}
+}
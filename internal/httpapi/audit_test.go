@@ -0,0 +1,127 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+)
+
+// decodeFields decodes the fields of an audit event snapshot.
+func decodeFields(t *testing.T, raw json.RawMessage) map[string]json.RawMessage {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(raw, &fields))
+	return fields
+}
+
+type auditEventList struct {
+	Data       []domain.AuditEvent `json:"data"`
+	Pagination struct {
+		TotalItems int64 `json:"total_items"`
+	} `json:"pagination"`
+}
+
+func TestAuditEventsRecordChanges(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+	key := issue(t, r, grant{1, domain.ScopeWrite}, grant{2, domain.ScopeWrite})
+	actor := "api-key:" + strconv.FormatUint(uint64(key.ID), 10)
+
+	w := serveAs(r, key.Secret, "", "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	tag := decode[domain.Tag](t, w)
+	tagRequestID := w.Header().Get(RequestIDHeader)
+
+	due := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	bulk := []map[string]any{
+		{"direction": "OUT", "amount": 1200, "dueDate": due, "tags": []map[string]any{{"ID": tag.ID}}},
+		{"direction": "IN", "amount": 5000, "dueDate": due},
+	}
+	w = serveAs(r, key.Secret, "", "POST", "/api/v1/organizations/1/financial-records/bulk", bulk)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	records := decode[[]domain.FinancialRecord](t, w)
+
+	// Organizations only see their own events.
+	w = serveAs(r, key.Secret, "", "POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Payroll"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1/audit-events", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	list := decode[auditEventList](t, w)
+	require.Len(t, list.Data, 3)
+	assert.EqualValues(t, 3, list.Pagination.TotalItems)
+
+	// Newest first: the records of the bulk request, then the tag.
+	created := list.Data[2]
+	assert.Equal(t, actor, created.Actor)
+	assert.Equal(t, domain.AuditCreate, created.Action)
+	assert.Equal(t, domain.AuditEntityTag, created.EntityType)
+	assert.Equal(t, tag.ID, created.EntityID)
+	assert.Equal(t, tagRequestID, created.RequestID)
+	assert.JSONEq(t, `null`, string(created.Before))
+	assert.JSONEq(t, `"Rent"`, string(decodeFields(t, created.After)["name"]))
+
+	assert.Equal(t, records[1].ID, list.Data[0].EntityID)
+	assert.Equal(t, records[0].ID, list.Data[1].EntityID)
+	assert.Equal(t, domain.AuditEntityFinancialRecord, list.Data[1].EntityType)
+	assert.JSONEq(t, `[`+strconv.FormatUint(uint64(tag.ID), 10)+`]`, string(decodeFields(t, list.Data[1].After)["tags"]))
+	assert.Equal(t, list.Data[0].RequestID, list.Data[1].RequestID)
+
+	for query, want := range map[string]int{
+		"entity_type=tag": 1,
+		"entity_type=financial_record&entity_id=" + strconv.FormatUint(uint64(records[0].ID), 10): 1,
+		"action=update":              0,
+		"actor=" + actor:             3,
+		"actor=admin":                0,
+		"since=2000-01-01T00:00:00Z": 3,
+		"until=2000-01-01T00:00:00Z": 0,
+		"page=2&page_size=2":         1,
+	} {
+		w = serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1/audit-events?"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, "%s: %s", query, w.Body.String())
+		assert.Len(t, decode[auditEventList](t, w).Data, want, query)
+	}
+
+	for _, query := range []string{"entity_type=organization", "entity_id=x", "entity_id=0", "action=rename", "since=yesterday", "until=2024-01-01"} {
+		w = serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1/audit-events?"+query, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code, query)
+		assert.Equal(t, CodeInvalidQuery, decode[Problem](t, w).Code, query)
+	}
+}
+
+func TestAuditEventsRecordLinkedTags(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+	rent := domain.Tag{Name: "Rent", OrganizationID: 1}
+	gone := domain.Tag{Name: "Gone", OrganizationID: 1}
+	foreign := domain.Tag{Name: "Foreign", OrganizationID: 2}
+	for _, tag := range []*domain.Tag{&rent, &gone, &foreign} {
+		require.NoError(t, mem.CreateTag(ctx, tag, nil))
+	}
+	require.NoError(t, mem.DeleteTag(ctx, 1, gone.ID))
+
+	// The response and the event show the tags that were linked, not the
+	// ones that were sent.
+	tags := []map[string]any{{"ID": rent.ID}, {"ID": gone.ID}, {"ID": foreign.ID}, {"ID": 999}, {"ID": rent.ID}}
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records", map[string]any{
+		"direction": "OUT", "amount": 1200, "dueDate": time.Now(), "tags": tags,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	record := decode[domain.FinancialRecord](t, w)
+	require.Len(t, record.Tags, 1)
+	assert.Equal(t, rent.ID, record.Tags[0].ID)
+	assert.Equal(t, "Rent", record.Tags[0].Name)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/audit-events?entity_type=financial_record", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	list := decode[auditEventList](t, w)
+	require.Len(t, list.Data, 1)
+	assert.JSONEq(t, `[`+strconv.FormatUint(uint64(rent.ID), 10)+`]`, string(decodeFields(t, list.Data[0].After)["tags"]))
+}
@@ -0,0 +1,165 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+var (
+	// ErrUnrecognizedToken is returned by an Authenticator for a token it
+	// does not handle, so that the next one can try.
+	ErrUnrecognizedToken = errors.New("unrecognized token")
+	// ErrInvalidToken is returned by an Authenticator for a token it
+	// handles but rejects: unknown, revoked, expired or badly signed.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller, for instance in logs: "admin",
+	// "api-key:<id>" or "user:<sub>" for a JWT.
+	Subject string
+	// Admin is allowed every route.
+	Admin bool
+	// Grants lists the organizations the caller may act on.
+	Grants domain.Grants
+}
+
+// Allows reports whether the caller may act on the organization with scope.
+func (p *Principal) Allows(orgID uint, scope string) bool {
+	return p.Admin || p.Grants.Allow(orgID, scope)
+}
+
+// An Authenticator identifies the caller presenting a bearer token.
+type Authenticator interface {
+	// Authenticate returns the caller presenting token, ErrUnrecognizedToken
+	// when the token is not of the kind it handles, and ErrInvalidToken
+	// when it rejects the token.
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller authenticated for the request
+// of ctx, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// callerID returns the subject of the authenticated caller of the request,
+// or "" when authentication is disabled.
+func callerID(c *gin.Context) string {
+	if p, ok := PrincipalFromContext(c.Request.Context()); ok {
+		return p.Subject
+	}
+	return ""
+}
+
+// Auth authenticates API requests with the bearer token of their
+// Authorization header, and authorizes them by route:
+//
+//   - routes under /organizations/:organizationId need a grant on that
+//     organization, read for GET and write for other methods;
+//   - every other API route needs the admin key.
+//
+// A nil *Auth lets every request through.
+type Auth struct {
+	authenticators []Authenticator
+}
+
+// NewAuth returns an Auth asking each authenticator in turn to identify the
+// caller, until one recognizes the token.
+func NewAuth(authenticators ...Authenticator) *Auth {
+	return &Auth{authenticators: authenticators}
+}
+
+func adminRequired() *Problem {
+	return NewProblem(http.StatusForbidden, CodeForbidden, "Only the admin key can use this route")
+}
+
+// adminOnly restricts a route under an organization to the admin key. It
+// lets every request through when authentication is disabled.
+func adminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p, ok := PrincipalFromContext(c.Request.Context()); ok && !p.Admin {
+			abortWithProblem(c, adminRequired())
+			return
+		}
+		c.Next()
+	}
+}
+
+// Middleware rejects requests without a valid token with 401, and requests
+// the caller is not granted with 403. It stores the caller in the request
+// context, where PrincipalFromContext finds it, and names its subject as
+// the actor of the audit events of the request.
+func (a *Auth) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Next()
+			return
+		}
+
+		p, err := a.authenticate(c.Request.Context(), c.GetHeader("Authorization"))
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrUnrecognizedToken) {
+			// The reason is only written to the access log.
+			c.Error(err)
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			abortWithProblem(c, NewProblem(http.StatusUnauthorized, CodeUnauthenticated, "A valid API key or token is required"))
+			return
+		}
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		if c.Param("organizationId") == "" {
+			if !p.Admin {
+				abortWithProblem(c, adminRequired())
+				return
+			}
+		} else {
+			orgID, ok := organizationID(c)
+			if !ok {
+				c.Abort()
+				return
+			}
+			scope := domain.ScopeWrite
+			if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+				scope = domain.ScopeRead
+			}
+			if !p.Allows(orgID, scope) {
+				abortWithProblem(c, NewProblem(http.StatusForbidden, CodeForbidden, "The caller is not granted "+scope+" access to this organization"))
+				return
+			}
+		}
+		ctx := store.WithActor(context.WithValue(c.Request.Context(), principalKey{}, p), p.Subject)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// authenticate returns the caller identified by the Authorization header.
+func (a *Auth) authenticate(ctx context.Context, header string) (*Principal, error) {
+	scheme, token, _ := strings.Cut(header, " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrInvalidToken
+	}
+	for _, authenticator := range a.authenticators {
+		p, err := authenticator.Authenticate(ctx, token)
+		if !errors.Is(err, ErrUnrecognizedToken) {
+			return p, err
+		}
+	}
+	return nil, ErrUnrecognizedToken
+}
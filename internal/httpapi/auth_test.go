@@ -0,0 +1,308 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+const testAdminKey = "test-admin-key"
+
+// newAuthTestRouter mounts the API on an in-memory store, requiring API
+// keys. wrap, if not nil, can intercept the API key store.
+func newAuthTestRouter(wrap func(store.APIKeyStore) store.APIKeyStore) (*gin.Engine, *store.MemoryStore) {
+	gin.SetMode(gin.TestMode)
+	mem := newMemoryStore()
+	var keys store.APIKeyStore = mem
+	if wrap != nil {
+		keys = wrap(mem)
+	}
+	r := NewRouter(Deps{
+		Stores:      store.Stores{Organizations: mem, APIKeys: keys, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger:      slog.New(slog.DiscardHandler),
+		Idempotency: NewIdempotency(time.Hour),
+		Auth:        NewAuth(NewAPIKeyAuthenticator(keys, testAdminKey, time.Hour)),
+	})
+	return r, mem
+}
+
+// serveAs sends a request authenticated with the API key secret, and with
+// the Idempotency-Key header when idempotencyKey is not empty.
+func serveAs(r http.Handler, secret, idempotencyKey, method, path string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+type grant struct {
+	OrganizationID uint   `json:"organizationId"`
+	Scope          string `json:"scope"`
+}
+
+// issue creates an API key with the admin key and returns its secret.
+func issue(t *testing.T, r http.Handler, grants ...grant) issuedAPIKey {
+	t.Helper()
+	w := serveAs(r, testAdminKey, "", "POST", "/api/v1/api-keys", map[string]any{"name": "test", "grants": grants})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	return decode[issuedAPIKey](t, w)
+}
+
+func TestAuthentication(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+
+	for _, header := range []string{"", "Bearer", "Bearer ", "Basic " + testAdminKey, "Bearer frk_unknown"} {
+		req := httptest.NewRequest("GET", "/api/v1/organizations/1/tags", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "Authorization: %q", header)
+		assert.Equal(t, CodeUnauthenticated, decode[Problem](t, w).Code, "Authorization: %q", header)
+		assert.True(t, strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer"), "Authorization: %q", header)
+	}
+
+	w := serveAs(r, testAdminKey, "", "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The scheme is case-insensitive.
+	req := httptest.NewRequest("GET", "/api/v1/organizations", nil)
+	req.Header.Set("Authorization", "bearer "+testAdminKey)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The document stays open, the metrics need the admin key.
+	w = serveAs(r, "", "", "GET", "/openapi.json", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serveAs(r, "", "", "GET", "/debug/vars", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveAs(r, testAdminKey, "", "GET", "/debug/vars", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIKeyGrants(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+	key := issue(t, r, grant{1, domain.ScopeWrite}, grant{2, domain.ScopeRead})
+
+	for _, tc := range []struct {
+		method, path string
+		body         any
+		want         int
+	}{
+		{"GET", "/api/v1/organizations/1/tags", nil, http.StatusOK},
+		{"POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, http.StatusCreated},
+		{"GET", "/api/v1/organizations/1", nil, http.StatusOK},
+		{"PUT", "/api/v1/organizations/1", map[string]any{"name": "Acme"}, http.StatusOK},
+		{"GET", "/api/v1/organizations/2/financial-records", nil, http.StatusOK},
+		{"GET", "/api/v1/organizations/2/financial-records/reports/cash-flow", nil, http.StatusOK},
+		{"POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Rent"}, http.StatusForbidden},
+		{"GET", "/api/v1/organizations/3/tags", nil, http.StatusForbidden},
+		{"GET", "/api/v1/organizations/99/tags", nil, http.StatusForbidden},
+		{"DELETE", "/api/v1/organizations/1", nil, http.StatusForbidden},
+		{"GET", "/api/v1/organizations", nil, http.StatusForbidden},
+		{"POST", "/api/v1/organizations", map[string]any{"name": "Initech"}, http.StatusForbidden},
+		{"GET", "/api/v1/api-keys", nil, http.StatusForbidden},
+		{"POST", "/api/v1/api-keys", map[string]any{"name": "escalated", "grants": []grant{{3, domain.ScopeWrite}}}, http.StatusForbidden},
+		{"GET", "/api/v1/organizations/abc/tags", nil, http.StatusBadRequest},
+		{"GET", "/debug/vars", nil, http.StatusForbidden},
+	} {
+		w := serveAs(r, key.Secret, "", tc.method, tc.path, tc.body)
+		assert.Equal(t, tc.want, w.Code, "%s %s: %s", tc.method, tc.path, w.Body.String())
+		if tc.want == http.StatusForbidden {
+			assert.Equal(t, CodeForbidden, decode[Problem](t, w).Code, "%s %s", tc.method, tc.path)
+		}
+	}
+
+	// The admin key is allowed everywhere.
+	w := serveAs(r, testAdminKey, "", "DELETE", "/api/v1/organizations/3", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestOnlyTheAdminKeyChangesOrganizationLimits(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+	key := issue(t, r, grant{1, domain.ScopeWrite})
+	w := serveAs(r, testAdminKey, "", "PUT", "/api/v1/organizations/1", map[string]any{"name": "Acme", "maxFinancialRecords": 10})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A write key cannot lift or change the limits, nor clear them to take
+	// the server-wide quota.
+	for _, body := range []map[string]any{
+		{"name": "Acme", "maxFinancialRecords": 0},
+		{"name": "Acme", "maxFinancialRecords": 11},
+		{"name": "Acme", "maxTags": 5},
+	} {
+		w = serveAs(r, key.Secret, "", "PUT", "/api/v1/organizations/1", body)
+		require.Equal(t, http.StatusForbidden, w.Code, "%v", body)
+		assert.Equal(t, CodeForbidden, decode[Problem](t, w).Code, "%v", body)
+	}
+
+	// It keeps them by leaving them out or repeating them.
+	for _, body := range []map[string]any{
+		{"name": "Initech"},
+		{"name": "Initech", "maxFinancialRecords": 10},
+	} {
+		w = serveAs(r, key.Secret, "", "PUT", "/api/v1/organizations/1", body)
+		require.Equal(t, http.StatusOK, w.Code, "%v: %s", body, w.Body.String())
+	}
+	w = serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1", nil)
+	org := decode[domain.Organization](t, w)
+	assert.Equal(t, "Initech", org.Name)
+	require.NotNil(t, org.MaxFinancialRecords)
+	assert.Equal(t, 10, *org.MaxFinancialRecords)
+	assert.Nil(t, org.MaxTags)
+
+	// The admin key lifts them.
+	w = serveAs(r, testAdminKey, "", "PUT", "/api/v1/organizations/1", map[string]any{"name": "Acme", "maxFinancialRecords": 0})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, decode[domain.Organization](t, w).MaxFinancialRecords)
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+
+	key := issue(t, r, grant{1, domain.ScopeRead})
+	assert.Equal(t, "test", key.Name)
+	assert.True(t, strings.HasPrefix(key.Secret, apiKeyPrefix))
+	assert.Equal(t, key.Secret[:apiKeyDisplayLength], key.Prefix)
+	assert.Equal(t, []domain.APIKeyGrant{{OrganizationID: 1, Scope: domain.ScopeRead}}, key.Grants)
+	other := issue(t, r, grant{2, domain.ScopeRead})
+	assert.NotEqual(t, key.Secret, other.Secret)
+
+	w := serveAs(r, testAdminKey, "", "GET", "/api/v1/api-keys?page_size=1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), key.Secret)
+	assert.NotContains(t, w.Body.String(), hashAPIKey(key.Secret))
+	list := decode[listResponse[domain.APIKey]](t, w)
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, key.ID, list.Data[0].ID)
+	assert.Equal(t, key.Grants, list.Data[0].Grants)
+	assert.Equal(t, int64(2), list.Pagination.TotalItems)
+
+	w = serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	path := "/api/v1/api-keys/" + strconv.FormatUint(uint64(key.ID), 10)
+	w = serveAs(r, testAdminKey, "", "DELETE", path, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// Revoking drops the key from the cache at once.
+	w = serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveAs(r, other.Secret, "", "GET", "/api/v1/organizations/2/tags", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serveAs(r, testAdminKey, "", "DELETE", path, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeAPIKeyNotFound, decode[Problem](t, w).Code)
+	w = serveAs(r, testAdminKey, "", "DELETE", "/api/v1/api-keys/abc", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidAPIKeyID, decode[Problem](t, w).Code)
+
+	w = serveAs(r, testAdminKey, "", "GET", "/api/v1/api-keys", nil)
+	assert.Equal(t, int64(1), decode[listResponse[domain.APIKey]](t, w).Pagination.TotalItems)
+}
+
+func TestIssueAPIKeyValidation(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+
+	w := serveAs(r, testAdminKey, "", "POST", "/api/v1/api-keys", map[string]any{
+		"name":   " ",
+		"grants": []grant{{1, "admin"}, {99, domain.ScopeRead}, {1, domain.ScopeRead}},
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	problem := decode[Problem](t, w)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	var fields []string
+	for _, e := range problem.Errors {
+		fields = append(fields, e.Field+":"+e.Code)
+	}
+	assert.Equal(t, []string{
+		"name:required",
+		"grants[0].scope:invalid_scope",
+		"grants[2].organizationId:duplicate_grant",
+		"grants[1].organizationId:unknown_organization",
+	}, fields)
+
+	w = serveAs(r, testAdminKey, "", "POST", "/api/v1/api-keys", map[string]any{"name": "empty"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "grants", decode[Problem](t, w).Errors[0].Field)
+
+	w = serveAs(r, testAdminKey, "", "GET", "/api/v1/api-keys", nil)
+	assert.Zero(t, decode[listResponse[domain.APIKey]](t, w).Pagination.TotalItems)
+}
+
+func TestIdempotencyKeysAreScopedToTheCaller(t *testing.T) {
+	r, _ := newAuthTestRouter(nil)
+	key := issue(t, r, grant{1, domain.ScopeWrite})
+
+	body := map[string]any{"name": "Rent"}
+	w := serveAs(r, testAdminKey, "tag-1", "POST", "/api/v1/organizations/1/tags", body)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// The same key sent by another caller is not replayed.
+	w = serveAs(r, key.Secret, "tag-1", "POST", "/api/v1/organizations/1/tags", body)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+}
+
+// countingAPIKeyStore counts the API keys looked up.
+type countingAPIKeyStore struct {
+	store.APIKeyStore
+	lookups int
+}
+
+func (s *countingAPIKeyStore) FindAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	s.lookups++
+	return s.APIKeyStore.FindAPIKeyByHash(ctx, hash)
+}
+
+func TestAPIKeysAreCached(t *testing.T) {
+	var keys *countingAPIKeyStore
+	r, _ := newAuthTestRouter(func(s store.APIKeyStore) store.APIKeyStore {
+		keys = &countingAPIKeyStore{APIKeyStore: s}
+		return keys
+	})
+	key := issue(t, r, grant{1, domain.ScopeRead})
+
+	for range 3 {
+		w := serveAs(r, key.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 1, keys.lookups)
+
+	// Unknown keys are looked up every time, and the admin key never is.
+	for range 2 {
+		w := serveAs(r, "frk_unknown", "", "GET", "/api/v1/organizations/1/tags", nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		w = serveAs(r, testAdminKey, "", "GET", "/api/v1/organizations/1/tags", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 3, keys.lookups)
+}
@@ -0,0 +1,541 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// organizationID parses the :organizationId path parameter, responding with
+// 400 when it is not a valid ID.
+func organizationID(c *gin.Context) (uint, bool) {
+	orgID, err := strconv.ParseUint(c.Param("organizationId"), 10, 32)
+	if err != nil {
+		c.Error(NewProblem(http.StatusBadRequest, CodeInvalidOrganizationID, "The organization ID must be a positive integer"))
+		return 0, false
+	}
+	return uint(orgID), true
+}
+
+// pathID parses the ID in the path parameter name, responding with 400 and
+// code when it is not a valid ID.
+func pathID(c *gin.Context, name, code, what string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		c.Error(NewProblem(http.StatusBadRequest, code, "The "+what+" ID must be a positive integer"))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// boolQuery parses the boolean query parameter name, false when absent,
+// responding with 400 when it is not a boolean.
+func boolQuery(c *gin.Context, name string) (bool, bool) {
+	v, err := strconv.ParseBool(c.DefaultQuery(name, "false"))
+	if err != nil {
+		c.Error(NewProblem(http.StatusBadRequest, CodeInvalidQuery, name+" must be true or false"))
+		return false, false
+	}
+	return v, true
+}
+
+// pagination parses the page and page_size query parameters, falling back to
+// the first page of 20 items.
+func pagination(c *gin.Context) store.Page {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	// Ensure page and pageSize are positive
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	return store.Page{Number: page, Size: pageSize}
+}
+
+// paginated wraps one page of results in the listing envelope.
+func paginated(data any, page store.Page, total int64) gin.H {
+	// Calculate total pages
+	totalPages := (total + int64(page.Size) - 1) / int64(page.Size)
+
+	return gin.H{
+		"data": data,
+		"pagination": gin.H{
+			"current_page": page.Number,
+			"page_size":    page.Size,
+			"total_items":  total,
+			"total_pages":  totalPages,
+		},
+	}
+}
+
+// noContent responds with 204 No Content.
+func noContent(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+	// Write the header now, so that the idempotency cache sees a complete
+	// response.
+	c.Writer.WriteHeaderNow()
+}
+
+// Quota headers, set on the responses of create requests when the
+// organization has a quota.
+const (
+	QuotaLimitHeader     = "X-Quota-Limit"
+	QuotaRemainingHeader = "X-Quota-Remaining"
+)
+
+// newQuota returns the quota of limit items to give the store writes, nil
+// when limit is zero and the organization may hold any number of them.
+func newQuota(limit int) *store.Quota {
+	if limit <= 0 {
+		return nil
+	}
+	return &store.Quota{Limit: limit}
+}
+
+// quotaProblem turns the *store.QuotaError of a write refused by the quota
+// into a 403 naming what the organization may hold, with the quota
+// headers. Other errors are returned as they are.
+func quotaProblem(c *gin.Context, what string, err error) error {
+	var exceeded *store.QuotaError
+	if !errors.As(err, &exceeded) {
+		return err
+	}
+	c.Header(QuotaLimitHeader, strconv.Itoa(exceeded.Limit))
+	c.Header(QuotaRemainingHeader, strconv.FormatInt(exceeded.Left, 10))
+	return NewProblem(http.StatusForbidden, CodeQuotaExceeded,
+		fmt.Sprintf("The organization may hold at most %d %s, and can create %d more", exceeded.Limit, what, exceeded.Left))
+}
+
+// setQuotaRemaining reports how many items the organization may still
+// create after the write given quota.
+func setQuotaRemaining(c *gin.Context, quota *store.Quota) {
+	if quota != nil {
+		c.Header(QuotaLimitHeader, strconv.Itoa(quota.Limit))
+		c.Header(QuotaRemainingHeader, strconv.FormatInt(quota.Left, 10))
+	}
+}
+
+func createTag(tagStore store.TagStore, quota int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upsert, ok := boolQuery(c, "upsert")
+		if !ok {
+			return
+		}
+
+		var tag domain.Tag
+		if err := c.ShouldBindJSON(&tag); err != nil {
+			c.Error(bindProblem(err))
+			return
+		}
+
+		// Get organizationId from path
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		tag.OrganizationID = orgID
+		tag.Name = strings.TrimSpace(tag.Name)
+
+		if err := tag.Validate(); err != nil {
+			c.Error(err)
+			return
+		}
+		q := newQuota(requestOrganization(c).TagLimit(quota))
+		err := quotaProblem(c, "tags", tagStore.CreateTag(c.Request.Context(), &tag, q))
+		if errors.Is(err, store.ErrDuplicate) {
+			// Names are unique per organization; answer with the tag that
+			// holds the name.
+			existing, findErr := tagStore.FindTagByName(c.Request.Context(), orgID, tag.Name)
+			switch {
+			case findErr == nil && upsert:
+				c.JSON(http.StatusOK, existing)
+			case findErr == nil:
+				p := NewProblem(http.StatusConflict, CodeTagExists, "The organization already has a tag with this name")
+				p.Existing = existing
+				c.Error(p)
+			case errors.Is(findErr, store.ErrNotFound):
+				// The clashing tag was deleted in the meantime.
+				c.Error(NewProblem(http.StatusConflict, CodeTagExists, "The organization already has a tag with this name"))
+			default:
+				c.Error(findErr)
+			}
+			return
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		setQuotaRemaining(c, q)
+		c.JSON(http.StatusCreated, tag)
+	}
+}
+
+func createFinancialRecord(recordStore store.FinancialRecordStore, quota int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var record domain.FinancialRecord
+		if err := c.ShouldBindJSON(&record); err != nil {
+			c.Error(bindProblem(err))
+			return
+		}
+
+		// Get organizationId from path
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		record.OrganizationID = orgID
+		record.SetDefaults()
+
+		if err := record.Validate(); err != nil {
+			c.Error(err)
+			return
+		}
+		q := newQuota(requestOrganization(c).FinancialRecordLimit(quota))
+		if err := recordStore.CreateFinancialRecord(c.Request.Context(), &record, q); err != nil {
+			c.Error(quotaProblem(c, "financial records", err))
+			return
+		}
+
+		setQuotaRemaining(c, q)
+		record.MarkOverdue(time.Now())
+		c.JSON(http.StatusCreated, record)
+	}
+}
+
+func createFinancialRecordsBulk(recordStore store.FinancialRecordStore, quota int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var records []domain.FinancialRecord
+		if err := c.ShouldBindJSON(&records); err != nil {
+			c.Error(bindProblem(err))
+			return
+		}
+
+		// Get organizationId from path
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+
+		// Set organization ID for all records and validate them, reporting
+		// the violations of every record at once
+		for i := range records {
+			records[i].OrganizationID = orgID
+			records[i].SetDefaults()
+		}
+		if err := domain.ValidateFinancialRecords(records); err != nil {
+			c.Error(err)
+			return
+		}
+		// The batch is created whole or not at all.
+		q := newQuota(requestOrganization(c).FinancialRecordLimit(quota))
+		if err := recordStore.CreateFinancialRecords(c.Request.Context(), records, q); err != nil {
+			c.Error(quotaProblem(c, "financial records", err))
+			return
+		}
+
+		setQuotaRemaining(c, q)
+		markOverdue(records)
+		c.JSON(http.StatusCreated, records)
+	}
+}
+
+// maxSearchLength is the longest full-text search accepted, in characters.
+const maxSearchLength = 200
+
+func listFinancialRecords(recordStore store.FinancialRecordStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		page := pagination(c)
+
+		// Handle tag filtering
+		var filter store.FinancialRecordFilter
+		if filter.IncludeDeleted, ok = boolQuery(c, "include_deleted"); !ok {
+			return
+		}
+		if tagIDs := c.Query("tags"); tagIDs != "" {
+			for _, s := range strings.Split(tagIDs, ",") {
+				id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+				if err != nil {
+					c.Error(NewProblem(http.StatusBadRequest, CodeInvalidTagID, "Tag IDs must be a comma-separated list of integers"))
+					return
+				}
+				filter.TagIDs = append(filter.TagIDs, uint(id))
+			}
+		}
+		filter.CounterpartyName = c.Query("counterparty")
+		filter.CounterpartyDocument = c.Query("counterparty_document")
+		filter.Reference = c.Query("reference")
+		if metadata := c.Query("metadata"); metadata != "" {
+			if !domain.IsJSONObject([]byte(metadata)) {
+				c.Error(NewProblem(http.StatusBadRequest, CodeInvalidQuery, "metadata must be a JSON object"))
+				return
+			}
+			filter.Metadata = json.RawMessage(metadata)
+		}
+		filter.Query = strings.TrimSpace(c.Query("q"))
+		if utf8.RuneCountInString(filter.Query) > maxSearchLength {
+			c.Error(NewProblem(http.StatusBadRequest, CodeInvalidQuery, fmt.Sprintf("q must be at most %d characters long", maxSearchLength)))
+			return
+		}
+
+		records, total, err := recordStore.ListFinancialRecords(c.Request.Context(), orgID, filter, page)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		markOverdue(records)
+
+		c.JSON(http.StatusOK, paginated(records, page, total))
+	}
+}
+
+func getCashFlowReport(recordStore store.FinancialRecordStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		basis := c.DefaultQuery("basis", domain.BasisDue)
+		if basis != domain.BasisDue && basis != domain.BasisPaid {
+			c.Error(NewProblem(http.StatusBadRequest, CodeInvalidQuery, "basis must be due or paid"))
+			return
+		}
+
+		// Calculate date range (last 2 years)
+		now := time.Now()
+		twoYearsAgo := now.AddDate(-2, 0, 0)
+
+		loc := requestOrganization(c).Location()
+		monthlyData, err := recordStore.CashFlowReport(c.Request.Context(), orgID, basis, twoYearsAgo, loc)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		// Map the database results to our response structure
+		report := domain.CashFlowReport{
+			Basis:       basis,
+			MonthlyData: monthlyData,
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+func listTags(tagStore store.TagStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		page := pagination(c)
+
+		var filter store.TagFilter
+		if filter.IncludeDeleted, ok = boolQuery(c, "include_deleted"); !ok {
+			return
+		}
+
+		tags, total, err := tagStore.ListTags(c.Request.Context(), orgID, filter, page)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, paginated(tags, page, total))
+	}
+}
+
+func tagNotFound() *Problem {
+	return NewProblem(http.StatusNotFound, CodeTagNotFound, "The tag does not exist in this organization")
+}
+
+func recordNotFound() *Problem {
+	return NewProblem(http.StatusNotFound, CodeRecordNotFound, "The financial record does not exist in this organization")
+}
+
+func deleteTag(tagStore store.TagStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "tagId", CodeInvalidTagID, "tag")
+		if !ok {
+			return
+		}
+
+		err := tagStore.DeleteTag(c.Request.Context(), orgID, id)
+		if errors.Is(err, store.ErrNotFound) {
+			err = tagNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		noContent(c)
+	}
+}
+
+func restoreTag(tagStore store.TagStore, quota int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "tagId", CodeInvalidTagID, "tag")
+		if !ok {
+			return
+		}
+		q := newQuota(requestOrganization(c).TagLimit(quota))
+		tag, err := tagStore.RestoreTag(c.Request.Context(), orgID, id, q)
+		err = quotaProblem(c, "tags", err)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			err = tagNotFound()
+		case errors.Is(err, store.ErrDuplicate):
+			err = NewProblem(http.StatusConflict, CodeTagExists, "Another tag of the organization took this tag's name")
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		setQuotaRemaining(c, q)
+		c.JSON(http.StatusOK, tag)
+	}
+}
+
+func deleteFinancialRecord(recordStore store.FinancialRecordStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "recordId", CodeInvalidRecordID, "financial record")
+		if !ok {
+			return
+		}
+
+		err := recordStore.DeleteFinancialRecord(c.Request.Context(), orgID, id)
+		if errors.Is(err, store.ErrNotFound) {
+			err = recordNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		noContent(c)
+	}
+}
+
+func restoreFinancialRecord(recordStore store.FinancialRecordStore, quota int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "recordId", CodeInvalidRecordID, "financial record")
+		if !ok {
+			return
+		}
+		q := newQuota(requestOrganization(c).FinancialRecordLimit(quota))
+		record, err := recordStore.RestoreFinancialRecord(c.Request.Context(), orgID, id, q)
+		err = quotaProblem(c, "financial records", err)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			err = recordNotFound()
+		case errors.Is(err, store.ErrDuplicate):
+			err = NewProblem(http.StatusConflict, CodeOccurrenceExists, "The recurrence created this occurrence again since the record was deleted")
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		setQuotaRemaining(c, q)
+		record.MarkOverdue(time.Now())
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// markOverdue reports the pending records past their due date as overdue.
+func markOverdue(records []domain.FinancialRecord) {
+	now := time.Now()
+	for i := range records {
+		records[i].MarkOverdue(now)
+	}
+}
+
+// updateFinancialRecord applies change to the record of the :recordId path
+// parameter and responds with the record, or with 404 or 409 when the
+// record cannot be changed.
+func updateFinancialRecord(c *gin.Context, recordStore store.FinancialRecordStore, change func(*domain.FinancialRecord) error) {
+	orgID, ok := organizationID(c)
+	if !ok {
+		return
+	}
+	id, ok := pathID(c, "recordId", CodeInvalidRecordID, "financial record")
+	if !ok {
+		return
+	}
+
+	record, err := recordStore.UpdateFinancialRecord(c.Request.Context(), orgID, id, change)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		err = recordNotFound()
+	case errors.Is(err, domain.ErrRecordPaid):
+		err = NewProblem(http.StatusConflict, CodeRecordPaid, "The financial record is already paid")
+	case errors.Is(err, domain.ErrRecordCancelled):
+		err = NewProblem(http.StatusConflict, CodeRecordCancelled, "The financial record is cancelled")
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	record.MarkOverdue(time.Now())
+	c.JSON(http.StatusOK, record)
+}
+
+func settleFinancialRecord(recordStore store.FinancialRecordStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Without a body, the whole balance is paid now.
+		var settlement domain.Settlement
+		if err := c.ShouldBindJSON(&settlement); err != nil && !errors.Is(err, io.EOF) {
+			c.Error(bindProblem(err))
+			return
+		}
+		if err := settlement.Validate(); err != nil {
+			c.Error(err)
+			return
+		}
+
+		updateFinancialRecord(c, recordStore, func(r *domain.FinancialRecord) error {
+			return r.Settle(settlement)
+		})
+	}
+}
+
+func cancelFinancialRecord(recordStore store.FinancialRecordStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateFinancialRecord(c, recordStore, (*domain.FinancialRecord).Cancel)
+	}
+}
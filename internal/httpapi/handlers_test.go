@@ -0,0 +1,1015 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// testOrganizations is the number of organizations newMemoryStore creates,
+// with IDs 1 to testOrganizations.
+const testOrganizations = 3
+
+// newMemoryStore returns an in-memory store holding testOrganizations
+// organizations.
+func newMemoryStore() *store.MemoryStore {
+	mem := store.NewMemoryStore()
+	for i := 1; i <= testOrganizations; i++ {
+		org := domain.Organization{Name: "Organization " + strconv.Itoa(i), TimeZone: domain.DefaultTimeZone, Currency: domain.DefaultCurrency}
+		if err := mem.CreateOrganization(context.Background(), &org); err != nil {
+			panic(err)
+		}
+	}
+	return mem
+}
+
+// newTestRouter mounts the API on an in-memory store.
+func newTestRouter() (*gin.Engine, *store.MemoryStore) {
+	gin.SetMode(gin.TestMode)
+	mem := newMemoryStore()
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, Recurrences: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+	})
+	return r, mem
+}
+
+// serve sends a request with an optional JSON body and returns the recorded
+// response.
+func serve(r http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+type listResponse[T any] struct {
+	Data       []T `json:"data"`
+	Pagination struct {
+		CurrentPage int   `json:"current_page"`
+		PageSize    int   `json:"page_size"`
+		TotalItems  int64 `json:"total_items"`
+		TotalPages  int64 `json:"total_pages"`
+	} `json:"pagination"`
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &v))
+	return v
+}
+
+func TestCreateAndListTagsInMemory(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	created := decode[domain.Tag](t, w)
+	assert.Equal(t, "Rent", created.Name)
+	assert.Equal(t, uint(1), created.OrganizationID)
+	assert.NotZero(t, created.ID)
+
+	serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Payroll"})
+	serve(r, "POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Other org"})
+
+	w = serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	list := decode[listResponse[domain.Tag]](t, w)
+	require.Len(t, list.Data, 2)
+	assert.Equal(t, "Rent", list.Data[0].Name)
+	assert.Equal(t, "Payroll", list.Data[1].Name)
+	assert.Equal(t, int64(2), list.Pagination.TotalItems)
+}
+
+func TestListTagsPagination(t *testing.T) {
+	r, mem := newTestRouter()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, mem.CreateTag(context.Background(), &domain.Tag{Name: name, OrganizationID: 1}, nil))
+	}
+
+	w := serve(r, "GET", "/api/v1/organizations/1/tags?page=2&page_size=2", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	list := decode[listResponse[domain.Tag]](t, w)
+	require.Len(t, list.Data, 2)
+	assert.Equal(t, "c", list.Data[0].Name)
+	assert.Equal(t, "d", list.Data[1].Name)
+	assert.Equal(t, 2, list.Pagination.CurrentPage)
+	assert.Equal(t, 2, list.Pagination.PageSize)
+	assert.Equal(t, int64(5), list.Pagination.TotalItems)
+	assert.Equal(t, int64(3), list.Pagination.TotalPages)
+
+	// Out-of-range pages are empty rather than an error
+	w = serve(r, "GET", "/api/v1/organizations/1/tags?page=9&page_size=2", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decode[listResponse[domain.Tag]](t, w).Data)
+}
+
+func TestInvalidOrganizationID(t *testing.T) {
+	r, _ := newTestRouter()
+
+	for _, path := range []string{
+		"/api/v1/organizations/abc/tags",
+		"/api/v1/organizations/-1/financial-records",
+		"/api/v1/organizations/x/financial-records/reports/cash-flow",
+	} {
+		w := serve(r, "GET", path, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
+
+func TestCreateFinancialRecordValidation(t *testing.T) {
+	r, _ := newTestRouter()
+	due := time.Now().Format(time.RFC3339)
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+	}{
+		{"valid", map[string]any{"direction": "IN", "amount": 10.0, "dueDate": due}, http.StatusCreated},
+		{"zero amount", map[string]any{"direction": "OUT", "amount": 0.0, "dueDate": due}, http.StatusCreated},
+		{"invalid direction", map[string]any{"direction": "SIDEWAYS", "amount": 10.0, "dueDate": due}, http.StatusBadRequest},
+		{"negative amount", map[string]any{"direction": "IN", "amount": -1.0, "dueDate": due}, http.StatusBadRequest},
+		{"amount too large", map[string]any{"direction": "IN", "amount": 2e12, "dueDate": due}, http.StatusBadRequest},
+		{"missing due date", map[string]any{"direction": "IN", "amount": 10.0}, http.StatusBadRequest},
+		{"due date out of range", map[string]any{"direction": "IN", "amount": 10.0, "dueDate": "2300-01-01T00:00:00Z"}, http.StatusBadRequest},
+		{"malformed JSON", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body any
+			if tt.body != nil {
+				body = tt.body
+			}
+			w := serve(r, "POST", "/api/v1/organizations/1/financial-records", body)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestCreateFinancialRecordReportsEveryViolation(t *testing.T) {
+	r, _ := newTestRouter()
+
+	tags := make([]map[string]any, domain.MaxRecordTags+1)
+	for i := range tags {
+		tags[i] = map[string]any{"ID": i + 1}
+	}
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records", map[string]any{
+		"direction": "SIDEWAYS",
+		"amount":    -1,
+		"tags":      tags,
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var codes []string
+	for _, e := range decode[Problem](t, w).Errors {
+		codes = append(codes, e.Field+":"+e.Code)
+	}
+	assert.Equal(t, []string{"direction:invalid_direction", "amount:negative_amount", "dueDate:required", "tags:too_many_tags"}, codes)
+}
+
+func TestCreateTagValidation(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	tests := []struct {
+		name string
+		body map[string]any
+		code string
+	}{
+		{"missing name", map[string]any{}, "required"},
+		{"blank name", map[string]any{"name": "   "}, "required"},
+		{"name too long", map[string]any{"name": strings.Repeat("x", domain.MaxTagNameLength+1)}, "too_long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, "POST", "/api/v1/organizations/1/tags", tt.body)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			problem := decode[Problem](t, w)
+			require.Len(t, problem.Errors, 1)
+			assert.Equal(t, "name", problem.Errors[0].Field)
+			assert.Equal(t, tt.code, problem.Errors[0].Code)
+		})
+	}
+
+}
+
+func TestCreateTagWithTakenName(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "  Red Cat 12 "})
+	require.Equal(t, http.StatusCreated, w.Code)
+	created := decode[domain.Tag](t, w)
+	assert.Equal(t, "Red Cat 12", created.Name)
+
+	// Names are compared ignoring case and whitespace.
+	w = serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "red  cat\t12"})
+	require.Equal(t, http.StatusConflict, w.Code)
+	type tagConflict struct {
+		Code     string     `json:"code"`
+		Existing domain.Tag `json:"existing"`
+	}
+	conflict := decode[tagConflict](t, w)
+	assert.Equal(t, CodeTagExists, conflict.Code)
+	assert.Equal(t, created.ID, conflict.Existing.ID)
+	assert.Equal(t, "Red Cat 12", conflict.Existing.Name)
+
+	w = serve(r, "POST", "/api/v1/organizations/1/tags?upsert=true", map[string]any{"name": "RED CAT 12"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, created.ID, decode[domain.Tag](t, w).ID)
+
+	// A new name is still created with upsert.
+	w = serve(r, "POST", "/api/v1/organizations/1/tags?upsert=true", map[string]any{"name": "Blue Dog 3"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Names only need to be unique within an organization.
+	w = serve(r, "POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Red Cat 12"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(r, "POST", "/api/v1/organizations/1/tags?upsert=maybe", map[string]any{"name": "Red Cat 12"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, int64(2), decode[listResponse[domain.Tag]](t, w).Pagination.TotalItems)
+}
+
+func TestCreateFinancialRecordsBulkIsAtomic(t *testing.T) {
+	r, mem := newTestRouter()
+	due := time.Now().Format(time.RFC3339)
+
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records/bulk", []map[string]any{
+		{"direction": "IN", "amount": 10.0, "dueDate": due},
+		{"direction": "OUT", "amount": -5.0, "dueDate": due},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	_, total, err := mem.ListFinancialRecords(context.Background(), 1, store.FinancialRecordFilter{}, store.Page{Number: 1, Size: 20})
+	require.NoError(t, err)
+	assert.Zero(t, total, "no record of a rejected batch is stored")
+
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/bulk", []map[string]any{
+		{"direction": "IN", "amount": 10.0, "dueDate": due},
+		{"direction": "OUT", "amount": 5.0, "dueDate": due},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	created := decode[[]domain.FinancialRecord](t, w)
+	require.Len(t, created, 2)
+	assert.NotEqual(t, created[0].ID, created[1].ID)
+	assert.Equal(t, uint(1), created[1].OrganizationID)
+}
+
+func TestListFinancialRecordsFiltersByTag(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+
+	rent := domain.Tag{Name: "Rent", OrganizationID: 1}
+	food := domain.Tag{Name: "Food", OrganizationID: 1}
+	foreign := domain.Tag{Name: "Foreign", OrganizationID: 2}
+	require.NoError(t, mem.CreateTag(ctx, &rent, nil))
+	require.NoError(t, mem.CreateTag(ctx, &food, nil))
+	require.NoError(t, mem.CreateTag(ctx, &foreign, nil))
+
+	require.NoError(t, mem.CreateFinancialRecords(ctx, []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "OUT", Amount: 1000, DueDate: time.Now(), Tags: []domain.Tag{{Model: rent.Model}}},
+		{OrganizationID: 1, Direction: "OUT", Amount: 50, DueDate: time.Now(), Tags: []domain.Tag{{Model: food.Model}, {Model: rent.Model}}},
+		// Tags of another organization are never linked
+		{OrganizationID: 1, Direction: "IN", Amount: 10, DueDate: time.Now(), Tags: []domain.Tag{{Model: foreign.Model}}},
+		{OrganizationID: 2, Direction: "IN", Amount: 99, DueDate: time.Now(), Tags: []domain.Tag{{Model: foreign.Model}}},
+	}, nil))
+
+	w := serve(r, "GET", "/api/v1/organizations/1/financial-records", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	all := decode[listResponse[domain.FinancialRecord]](t, w)
+	assert.Equal(t, int64(3), all.Pagination.TotalItems)
+	assert.Len(t, all.Data[1].Tags, 2)
+	assert.Empty(t, all.Data[2].Tags)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?tags="+itoa(food.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	filtered := decode[listResponse[domain.FinancialRecord]](t, w)
+	require.Len(t, filtered.Data, 1)
+	assert.Equal(t, 50.0, filtered.Data[0].Amount)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?tags="+itoa(food.ID)+","+itoa(rent.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(2), decode[listResponse[domain.FinancialRecord]](t, w).Pagination.TotalItems)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?tags=1,oops", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListFinancialRecordsFiltersByDetails(t *testing.T) {
+	r, _ := newTestRouter()
+	now := time.Now().UTC()
+
+	for _, record := range []map[string]any{
+		{"direction": "OUT", "amount": 1000, "dueDate": now, "counterpartyName": "ACME Imóveis", "counterpartyDocument": "12.345.678/0001-90",
+			"reference": "NF-1", "metadata": map[string]any{"costCenter": "operations", "labels": []string{"rent", "fixed"}}},
+		{"direction": "OUT", "amount": 50, "dueDate": now, "counterpartyName": "acme imóveis", "reference": "NF-2",
+			"metadata": map[string]any{"costCenter": "marketing"}},
+		{"direction": "IN", "amount": 10, "dueDate": now, "description": "No details"},
+	} {
+		w := serve(r, "POST", "/api/v1/organizations/1/financial-records", record)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	list := func(query string) []domain.FinancialRecord {
+		t.Helper()
+		w := serve(r, "GET", "/api/v1/organizations/1/financial-records?"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return decode[listResponse[domain.FinancialRecord]](t, w).Data
+	}
+	assert.Len(t, list("counterparty=ACME+IM%C3%93VEIS"), 2)
+	assert.Len(t, list("counterparty_document=12.345.678%2F0001-90"), 1)
+	assert.Len(t, list("reference=NF-2"), 1)
+	assert.Empty(t, list("reference=nf-2"))
+	assert.Len(t, list("counterparty=acme+im%C3%B3veis&reference=NF-1"), 1)
+
+	matched := list("metadata=" + url.QueryEscape(`{"costCenter":"operations"}`))
+	require.Len(t, matched, 1)
+	assert.JSONEq(t, `{"costCenter":"operations","labels":["rent","fixed"]}`, string(matched[0].Metadata))
+	assert.Len(t, list("metadata="+url.QueryEscape(`{"labels":["fixed"]}`)), 1)
+	assert.Empty(t, list("metadata="+url.QueryEscape(`{"costCenter":"sales"}`)))
+	assert.Len(t, list("metadata=%7B%7D"), 2)
+
+	w := serve(r, "GET", "/api/v1/organizations/1/financial-records?metadata=%5B1%5D", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidQuery, decode[Problem](t, w).Code)
+
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records", map[string]any{
+		"direction": "OUT", "amount": 1, "dueDate": now, "reference": strings.Repeat("x", domain.MaxReferenceLength+1), "metadata": "oops",
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	p := decode[Problem](t, w)
+	require.Len(t, p.Errors, 2)
+	assert.Equal(t, "too_long", p.Errors[0].Code)
+	assert.Equal(t, "invalid_metadata", p.Errors[1].Code)
+}
+
+func TestSearchFinancialRecords(t *testing.T) {
+	r, _ := newTestRouter()
+	now := time.Now().UTC()
+
+	for _, record := range []map[string]any{
+		{"direction": "OUT", "amount": 1000, "dueDate": now, "description": "Aluguel do escritório", "counterpartyName": "Imobiliária Central"},
+		{"direction": "OUT", "amount": 50, "dueDate": now, "description": "Taxa de condomínio", "counterpartyName": "Condomínio Aluguel Center"},
+		{"direction": "IN", "amount": 10, "dueDate": now, "description": "Consulting", "reference": "NF-100"},
+	} {
+		w := serve(r, "POST", "/api/v1/organizations/1/financial-records", record)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	search := func(q string) []float64 {
+		t.Helper()
+		w := serve(r, "GET", "/api/v1/organizations/1/financial-records?q="+url.QueryEscape(q), nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var amounts []float64
+		for _, record := range decode[listResponse[domain.FinancialRecord]](t, w).Data {
+			amounts = append(amounts, record.Amount)
+		}
+		return amounts
+	}
+	// A match in the counterparty name ranks above one in the description.
+	assert.Equal(t, []float64{50, 1000}, search("aluguel"))
+	assert.Equal(t, []float64{1000}, search("  ALUGUEL escritório "))
+	assert.Equal(t, []float64{10}, search("nf-100"))
+	assert.Empty(t, search("payroll"))
+
+	w := serve(r, "GET", "/api/v1/organizations/1/financial-records?q="+strings.Repeat("a", maxSearchLength+1), nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidQuery, decode[Problem](t, w).Code)
+}
+
+func TestCashFlowReportAggregatesByMonth(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 12, 0, 0, 0, time.UTC)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	require.NoError(t, mem.CreateFinancialRecords(ctx, []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "IN", Amount: 2000, DueDate: thisMonth},
+		{OrganizationID: 1, Direction: "OUT", Amount: 1000, DueDate: thisMonth},
+		{OrganizationID: 1, Direction: "IN", Amount: 1500, DueDate: lastMonth},
+		{OrganizationID: 1, Direction: "OUT", Amount: 800, DueDate: lastMonth},
+		{OrganizationID: 1, Direction: "OUT", Amount: 300, DueDate: lastMonth},
+		// Outside the two-year window and in another organization
+		{OrganizationID: 1, Direction: "IN", Amount: 7, DueDate: now.AddDate(-3, 0, 0)},
+		{OrganizationID: 2, Direction: "IN", Amount: 7, DueDate: thisMonth},
+	}, nil))
+
+	w := serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	report := decode[domain.CashFlowReport](t, w)
+
+	assert.Equal(t, []domain.MonthlyCashFlow{
+		{Year: lastMonth.Year(), Month: int(lastMonth.Month()), In: 1500, Out: 1100},
+		{Year: thisMonth.Year(), Month: int(thisMonth.Month()), In: 2000, Out: 1000},
+	}, report.MonthlyData)
+
+	// Months are those of the organization's time zone: at 2 a.m. UTC on
+	// the first, it is still the previous month in São Paulo.
+	w = serve(r, "PUT", "/api/v1/organizations/1", map[string]any{"name": "Organization 1", "timeZone": "America/Sao_Paulo"})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, mem.CreateFinancialRecords(ctx, []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "IN", Amount: 5, DueDate: thisMonth.Add(-10 * time.Hour)},
+	}, nil))
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil)
+	assert.Equal(t, []domain.MonthlyCashFlow{
+		{Year: lastMonth.Year(), Month: int(lastMonth.Month()), In: 1505, Out: 1100},
+		{Year: thisMonth.Year(), Month: int(thisMonth.Month()), In: 2000, Out: 1000},
+	}, decode[domain.CashFlowReport](t, w).MonthlyData)
+}
+
+func TestDeleteAndRestoreTag(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+
+	rent := domain.Tag{Name: "Rent", OrganizationID: 1}
+	require.NoError(t, mem.CreateTag(ctx, &rent, nil))
+	require.NoError(t, mem.CreateFinancialRecord(ctx, &domain.FinancialRecord{
+		OrganizationID: 1, Direction: "OUT", Amount: 1000, DueDate: time.Now(), Tags: []domain.Tag{{Model: rent.Model}},
+	}, nil))
+	path := "/api/v1/organizations/1/tags/" + itoa(rent.ID)
+
+	w := serve(r, "DELETE", path, nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	// The tag is hidden from listings and from its records.
+	w = serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Empty(t, decode[listResponse[domain.Tag]](t, w).Data)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records", nil)
+	assert.Empty(t, decode[listResponse[domain.FinancialRecord]](t, w).Data[0].Tags)
+	w = serve(r, "GET", "/api/v1/organizations/1/tags?include_deleted=true", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	list := decode[listResponse[domain.Tag]](t, w)
+	require.Len(t, list.Data, 1)
+	assert.True(t, list.Data[0].DeletedAt.Valid)
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"DELETE", path, http.StatusNotFound, CodeTagNotFound},
+		{"DELETE", "/api/v1/organizations/2/tags/" + itoa(rent.ID), http.StatusNotFound, CodeTagNotFound},
+		{"POST", "/api/v1/organizations/2/tags/" + itoa(rent.ID) + "/restore", http.StatusNotFound, CodeTagNotFound},
+		{"POST", "/api/v1/organizations/1/tags/99/restore", http.StatusNotFound, CodeTagNotFound},
+		{"DELETE", "/api/v1/organizations/1/tags/x", http.StatusBadRequest, CodeInvalidTagID},
+		{"GET", "/api/v1/organizations/1/tags?include_deleted=maybe", http.StatusBadRequest, CodeInvalidQuery},
+	} {
+		w = serve(r, tc.method, tc.path, nil)
+		require.Equal(t, tc.status, w.Code, "%s %s", tc.method, tc.path)
+		assert.Equal(t, tc.code, decode[Problem](t, w).Code, "%s %s", tc.method, tc.path)
+	}
+
+	// The name was taken in the meantime.
+	w = serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "rent"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	taken := decode[domain.Tag](t, w)
+	w = serve(r, "POST", path+"/restore", nil)
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeTagExists, decode[Problem](t, w).Code)
+	w = serve(r, "DELETE", "/api/v1/organizations/1/tags/"+itoa(taken.ID), nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve(r, "POST", path+"/restore", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	restored := decode[domain.Tag](t, w)
+	assert.Equal(t, rent.ID, restored.ID)
+	assert.False(t, restored.DeletedAt.Valid)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records", nil)
+	assert.Len(t, decode[listResponse[domain.FinancialRecord]](t, w).Data[0].Tags, 1)
+
+	// Restoring again changes nothing.
+	w = serve(r, "POST", path+"/restore", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	events, _, err := mem.ListAuditEvents(ctx, 1, store.AuditEventFilter{EntityID: rent.ID, EntityType: domain.AuditEntityTag}, store.Page{Number: 1, Size: 10})
+	require.NoError(t, err)
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{domain.AuditRestore, domain.AuditDelete, domain.AuditCreate}, actions)
+}
+
+func TestDeleteAndRestoreFinancialRecord(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	records := []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "IN", Amount: 2000, DueDate: now},
+		{OrganizationID: 1, Direction: "OUT", Amount: 500, DueDate: now},
+	}
+	require.NoError(t, mem.CreateFinancialRecords(ctx, records, nil))
+	path := "/api/v1/organizations/1/financial-records/" + itoa(records[1].ID)
+
+	w := serve(r, "DELETE", path, nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = serve(r, "DELETE", path, nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeRecordNotFound, decode[Problem](t, w).Code)
+	w = serve(r, "DELETE", "/api/v1/organizations/1/financial-records/x", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidRecordID, decode[Problem](t, w).Code)
+
+	// Deleted records are left out of listings and reports.
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records", nil)
+	assert.Equal(t, int64(1), decode[listResponse[domain.FinancialRecord]](t, w).Pagination.TotalItems)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?include_deleted=true", nil)
+	assert.Equal(t, int64(2), decode[listResponse[domain.FinancialRecord]](t, w).Pagination.TotalItems)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil)
+	assert.Equal(t, []domain.MonthlyCashFlow{
+		{Year: now.Year(), Month: int(now.Month()), In: 2000},
+	}, decode[domain.CashFlowReport](t, w).MonthlyData)
+
+	w = serve(r, "POST", "/api/v1/organizations/2/financial-records/"+itoa(records[1].ID)+"/restore", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, "POST", path+"/restore", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	restored := decode[domain.FinancialRecord](t, w)
+	assert.Equal(t, 500.0, restored.Amount)
+	assert.False(t, restored.DeletedAt.Valid)
+	assert.NotNil(t, restored.Tags)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil)
+	assert.Equal(t, []domain.MonthlyCashFlow{
+		{Year: now.Year(), Month: int(now.Month()), In: 2000, Out: 500},
+	}, decode[domain.CashFlowReport](t, w).MonthlyData)
+}
+
+func TestSettleAndCancelFinancialRecord(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	lastMonth := now.AddDate(0, -1, 0)
+	records := []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "IN", Amount: 1000, DueDate: lastMonth, Status: domain.StatusPending},
+		{OrganizationID: 1, Direction: "OUT", Amount: 400, DueDate: now.AddDate(0, 0, 1), Status: domain.StatusPending},
+	}
+	require.NoError(t, mem.CreateFinancialRecords(ctx, records, nil))
+	path := "/api/v1/organizations/1/financial-records/" + itoa(records[0].ID)
+
+	// Pending records past their due date are reported overdue.
+	w := serve(r, "GET", "/api/v1/organizations/1/financial-records", nil)
+	list := decode[listResponse[domain.FinancialRecord]](t, w)
+	assert.Equal(t, domain.StatusOverdue, list.Data[0].Status)
+	assert.Equal(t, domain.StatusPending, list.Data[1].Status)
+
+	w = serve(r, "POST", path+"/settle", map[string]any{"amount": 250, "paidAt": lastMonth})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	record := decode[domain.FinancialRecord](t, w)
+	assert.Equal(t, domain.StatusOverdue, record.Status)
+	assert.Equal(t, 250.0, record.PaidAmount)
+	require.NotNil(t, record.PaidAt)
+	assert.True(t, lastMonth.Equal(*record.PaidAt))
+
+	w = serve(r, "POST", path+"/settle", map[string]any{"amount": 800})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	p := decode[Problem](t, w)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, "exceeds_balance", p.Errors[0].Code)
+
+	// Without a body, the balance is paid now.
+	w = serve(r, "POST", path+"/settle", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	record = decode[domain.FinancialRecord](t, w)
+	assert.Equal(t, domain.StatusPaid, record.Status)
+	assert.Equal(t, 1000.0, record.PaidAmount)
+
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(records[1].ID)+"/cancel", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, domain.StatusCancelled, decode[domain.FinancialRecord](t, w).Status)
+
+	for _, tc := range []struct {
+		path   string
+		body   any
+		status int
+		code   string
+	}{
+		{path + "/settle", nil, http.StatusConflict, CodeRecordPaid},
+		{path + "/cancel", nil, http.StatusConflict, CodeRecordPaid},
+		{"/api/v1/organizations/1/financial-records/" + itoa(records[1].ID) + "/settle", nil, http.StatusConflict, CodeRecordCancelled},
+		{"/api/v1/organizations/2/financial-records/" + itoa(records[1].ID) + "/cancel", nil, http.StatusNotFound, CodeRecordNotFound},
+		{"/api/v1/organizations/1/financial-records/x/settle", nil, http.StatusBadRequest, CodeInvalidRecordID},
+		{path + "/settle", map[string]any{"amount": -1}, http.StatusBadRequest, CodeValidationFailed},
+		{path + "/settle", "not an object", http.StatusBadRequest, CodeInvalidBody},
+	} {
+		w = serve(r, "POST", tc.path, tc.body)
+		require.Equal(t, tc.status, w.Code, "%s %v", tc.path, tc.body)
+		assert.Equal(t, tc.code, decode[Problem](t, w).Code, "%s %v", tc.path, tc.body)
+	}
+
+	// The projected cash flow leaves the cancelled record out; the realized
+	// one counts each payment in the month it was made.
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil)
+	report := decode[domain.CashFlowReport](t, w)
+	assert.Equal(t, domain.BasisDue, report.Basis)
+	assert.Equal(t, []domain.MonthlyCashFlow{{Year: lastMonth.Year(), Month: int(lastMonth.Month()), In: 1000}}, report.MonthlyData)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow?basis=paid", nil)
+	report = decode[domain.CashFlowReport](t, w)
+	assert.Equal(t, domain.BasisPaid, report.Basis)
+	assert.Equal(t, []domain.MonthlyCashFlow{
+		{Year: lastMonth.Year(), Month: int(lastMonth.Month()), In: 250},
+		{Year: now.Year(), Month: int(now.Month()), In: 750},
+	}, report.MonthlyData)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records/reports/cash-flow?basis=cash", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidQuery, decode[Problem](t, w).Code)
+
+	events, _, err := mem.ListAuditEvents(ctx, 1, store.AuditEventFilter{Action: domain.AuditUpdate}, store.Page{Number: 1, Size: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, records[1].ID, events[0].EntityID)
+	assert.Contains(t, string(events[0].After), `"status":"cancelled"`)
+	assert.Contains(t, string(events[1].Before), `"paidAmount":250`)
+	assert.Contains(t, string(events[1].After), `"status":"paid"`)
+}
+
+func TestCreateFinancialRecordWithPayment(t *testing.T) {
+	r, _ := newTestRouter()
+	now := time.Now().UTC()
+
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records", map[string]any{
+		"direction": "OUT", "amount": 100, "dueDate": now, "status": "paid", "paidAmount": 100, "paidAt": now,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	record := decode[domain.FinancialRecord](t, w)
+	assert.Equal(t, domain.StatusPaid, record.Status)
+	assert.Equal(t, 100.0, record.PaidAmount)
+
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records", map[string]any{
+		"direction": "OUT", "amount": 100, "dueDate": now, "status": "overdue",
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	p := decode[Problem](t, w)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, FieldError{Field: "status", Code: "invalid_status", Message: "Status must be 'pending', 'paid' or 'cancelled'"}, p.Errors[0])
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func TestOrganizationQuotas(t *testing.T) {
+	mem := newMemoryStore()
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+		Config: config.Config{MaxTagsPerOrganization: 2, MaxFinancialRecordsPerOrganization: 3},
+	})
+	record := func() map[string]any {
+		return map[string]any{"direction": "IN", "amount": 1, "dueDate": time.Now()}
+	}
+
+	for i, name := range []string{"Rent", "Payroll"} {
+		w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "2", w.Header().Get(QuotaLimitHeader))
+		assert.Equal(t, strconv.Itoa(1-i), w.Header().Get(QuotaRemainingHeader))
+	}
+	w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Taxes"})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeQuotaExceeded, decode[Problem](t, w).Code)
+	assert.Equal(t, "0", w.Header().Get(QuotaRemainingHeader))
+
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/bulk", []map[string]any{record(), record()})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Header().Get(QuotaRemainingHeader))
+
+	// A batch that does not fit is rejected whole.
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/bulk", []map[string]any{record(), record()})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeQuotaExceeded, decode[Problem](t, w).Code)
+	assert.Equal(t, "3", w.Header().Get(QuotaLimitHeader))
+	assert.Equal(t, "1", w.Header().Get(QuotaRemainingHeader))
+	n, err := mem.CountFinancialRecords(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records", record())
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "0", w.Header().Get(QuotaRemainingHeader))
+	last := decode[domain.FinancialRecord](t, w)
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records", record())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Restoring what is not deleted takes nothing from a full quota.
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(last.ID)+"/restore", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "0", w.Header().Get(QuotaRemainingHeader))
+	tags, _, err := mem.ListTags(context.Background(), 1, store.TagFilter{}, store.Page{Number: 1, Size: 10})
+	require.NoError(t, err)
+	w = serve(r, "POST", "/api/v1/organizations/1/tags/"+itoa(tags[0].ID)+"/restore", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "0", w.Header().Get(QuotaRemainingHeader))
+
+	// Quotas are per organization.
+	w = serve(r, "POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Taxes"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(r, "POST", "/api/v1/organizations/2/financial-records", record())
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// An organization's own limits replace the server-wide quotas, and zero
+	// lifts them.
+	w = serve(r, "PUT", "/api/v1/organizations/2", map[string]any{"name": "Organization 2", "maxTags": 1, "maxFinancialRecords": 0})
+	require.Equal(t, http.StatusOK, w.Code)
+	org := decode[domain.Organization](t, w)
+	require.NotNil(t, org.MaxTags)
+	assert.Equal(t, 1, *org.MaxTags)
+	w = serve(r, "POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Payroll"})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "1", w.Header().Get(QuotaLimitHeader))
+	w = serve(r, "POST", "/api/v1/organizations/2/financial-records/bulk", []map[string]any{record(), record(), record(), record()})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(QuotaLimitHeader))
+	w = serve(r, "PUT", "/api/v1/organizations/2", map[string]any{"name": "Organization 2", "maxTags": -1})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "negative_limit", decode[Problem](t, w).Errors[0].Code)
+}
+
+func TestRecurringFinancialRecords(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month()-2, 1, 12, 0, 0, 0, time.UTC)
+	records := []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "OUT", Amount: 100, DueDate: start, Status: domain.StatusPending, Description: "Rent", Reference: "rent"},
+		{OrganizationID: 1, Direction: "IN", Amount: 10, DueDate: start, Status: domain.StatusPending},
+	}
+	require.NoError(t, mem.CreateFinancialRecords(ctx, records, nil))
+	path := "/api/v1/organizations/1/financial-records/" + itoa(records[0].ID)
+
+	// The occurrences due by now are created with the recurrence.
+	w := serve(r, "POST", path+"/recurrence", map[string]any{"frequency": "monthly", "count": 6})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	rec := decode[domain.Recurrence](t, w)
+	assert.Equal(t, 1, rec.Interval)
+	assert.Equal(t, 3, rec.Generated)
+	require.NotNil(t, rec.NextDate)
+	assert.True(t, start.AddDate(0, 3, 0).Equal(*rec.NextDate))
+
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?reference=rent", nil)
+	occurrences := decode[listResponse[domain.FinancialRecord]](t, w).Data
+	require.Len(t, occurrences, 3)
+	for i, o := range occurrences {
+		require.NotNil(t, o.RecurrenceID)
+		assert.Equal(t, rec.ID, *o.RecurrenceID)
+		assert.Equal(t, i, o.Occurrence)
+		assert.True(t, start.AddDate(0, i, 0).Equal(o.DueDate))
+		assert.Equal(t, "Rent", o.Description)
+	}
+
+	w = serve(r, "GET", "/api/v1/organizations/1/recurrences", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1), decode[listResponse[domain.Recurrence]](t, w).Pagination.TotalItems)
+	w = serve(r, "GET", "/api/v1/organizations/1/recurrences/"+itoa(rec.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 100.0, decode[domain.Recurrence](t, w).Amount)
+
+	// The occurrence alone moves; the series keeps its template.
+	second := "/api/v1/organizations/1/financial-records/" + itoa(occurrences[1].ID)
+	w = serve(r, "PATCH", second, map[string]any{"amount": 50})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 50.0, decode[domain.FinancialRecord](t, w).Amount)
+	w = serve(r, "GET", "/api/v1/organizations/1/recurrences/"+itoa(rec.ID), nil)
+	assert.Equal(t, 100.0, decode[domain.Recurrence](t, w).Amount)
+
+	// The occurrence and the later ones move, and so does the template.
+	w = serve(r, "PATCH", second+"?scope=future", map[string]any{"amount": 75, "recurrence": map[string]any{"frequency": "monthly", "count": 5}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 75.0, decode[domain.FinancialRecord](t, w).Amount)
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?reference=rent", nil)
+	occurrences = decode[listResponse[domain.FinancialRecord]](t, w).Data
+	require.Len(t, occurrences, 3)
+	assert.Equal(t, []float64{100, 75, 75}, []float64{occurrences[0].Amount, occurrences[1].Amount, occurrences[2].Amount})
+	w = serve(r, "GET", "/api/v1/organizations/1/recurrences/"+itoa(rec.ID), nil)
+	rec = decode[domain.Recurrence](t, w)
+	assert.Equal(t, 75.0, rec.Amount)
+	assert.Equal(t, 5, rec.Count)
+
+	for _, tc := range []struct {
+		method, path string
+		body         any
+		status       int
+		code         string
+	}{
+		{"POST", path + "/recurrence", map[string]any{"frequency": "monthly"}, http.StatusConflict, CodeRecordRecurring},
+		{"POST", "/api/v1/organizations/1/financial-records/99/recurrence", map[string]any{"frequency": "monthly"}, http.StatusNotFound, CodeRecordNotFound},
+		{"POST", "/api/v1/organizations/1/financial-records/" + itoa(records[1].ID) + "/recurrence", map[string]any{"frequency": "daily"}, http.StatusBadRequest, CodeValidationFailed},
+		{"PATCH", path + "?scope=all", map[string]any{"amount": 1}, http.StatusBadRequest, CodeInvalidQuery},
+		{"PATCH", path, map[string]any{"recurrence": map[string]any{"frequency": "weekly"}}, http.StatusBadRequest, CodeValidationFailed},
+		{"PATCH", path, map[string]any{"amount": -1}, http.StatusBadRequest, CodeValidationFailed},
+		{"PATCH", "/api/v1/organizations/1/financial-records/" + itoa(records[1].ID) + "?scope=future", map[string]any{"amount": 1}, http.StatusConflict, CodeRecordNotRecurring},
+		{"PATCH", "/api/v1/organizations/2/financial-records/" + itoa(records[0].ID) + "?scope=future", map[string]any{"amount": 1}, http.StatusNotFound, CodeRecordNotFound},
+		{"GET", "/api/v1/organizations/2/recurrences/" + itoa(rec.ID), nil, http.StatusNotFound, CodeRecurrenceNotFound},
+		{"GET", "/api/v1/organizations/1/recurrences/x", nil, http.StatusBadRequest, CodeInvalidRecurrenceID},
+	} {
+		w = serve(r, tc.method, tc.path, tc.body)
+		require.Equal(t, tc.status, w.Code, "%s %s", tc.method, tc.path)
+		assert.Equal(t, tc.code, decode[Problem](t, w).Code, "%s %s", tc.method, tc.path)
+	}
+
+	// The scheduler creates the rest of the series once, and stops there.
+	scheduler := store.WithActor(ctx, store.SchedulerActor)
+	n, err := mem.MaterializeRecurrences(scheduler, now.AddDate(1, 0, 0), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = mem.MaterializeRecurrences(scheduler, now.AddDate(1, 0, 0), 0)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	count, err := mem.CountFinancialRecords(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+
+	w = serve(r, "GET", "/api/v1/organizations/1/audit-events?entity_type=recurrence", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	events := decode[listResponse[domain.AuditEvent]](t, w).Data
+	require.Len(t, events, 2)
+	assert.Equal(t, domain.AuditUpdate, events[0].Action)
+	assert.Equal(t, domain.AuditCreate, events[1].Action)
+	w = serve(r, "GET", "/api/v1/organizations/1/audit-events?actor="+store.SchedulerActor, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(2), decode[listResponse[domain.AuditEvent]](t, w).Pagination.TotalItems)
+}
+
+func TestDeletingARecurrenceEndsTheSeries(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	records := []domain.FinancialRecord{{OrganizationID: 1, Direction: "OUT", Amount: 100, DueDate: now, Status: domain.StatusPending}}
+	require.NoError(t, mem.CreateFinancialRecords(ctx, records, nil))
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(records[0].ID)+"/recurrence", map[string]any{"frequency": "weekly"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	rec := decode[domain.Recurrence](t, w)
+	path := "/api/v1/organizations/1/recurrences/" + itoa(rec.ID)
+
+	// Deleting the first occurrence leaves the series running.
+	w = serve(r, "DELETE", "/api/v1/organizations/1/financial-records/"+itoa(records[0].ID), nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(r, "GET", path, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(records[0].ID)+"/restore", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, "DELETE", "/api/v1/organizations/2/recurrences/"+itoa(rec.ID), nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, "DELETE", path, nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	// The series is gone and creates nothing more; its occurrences stay
+	// but no longer edit it.
+	w = serve(r, "GET", path, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(r, "DELETE", path, nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeRecurrenceNotFound, decode[Problem](t, w).Code)
+	n, err := mem.MaterializeRecurrences(store.WithActor(ctx, store.SchedulerActor), now.AddDate(0, 2, 0), 0)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	w = serve(r, "PATCH", "/api/v1/organizations/1/financial-records/"+itoa(records[0].ID)+"?scope=future", map[string]any{"amount": 1})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeRecordNotRecurring, decode[Problem](t, w).Code)
+	w = serve(r, "GET", "/api/v1/organizations/1/audit-events?entity_type=recurrence", nil)
+	events := decode[listResponse[domain.AuditEvent]](t, w).Data
+	require.Len(t, events, 2)
+	assert.Equal(t, domain.AuditDelete, events[0].Action)
+}
+
+func TestRecurrencesOfDeletedOrganizationsStop(t *testing.T) {
+	r, mem := newTestRouter()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	records := []domain.FinancialRecord{{OrganizationID: 2, Direction: "OUT", Amount: 100, DueDate: now, Status: domain.StatusPending}}
+	require.NoError(t, mem.CreateFinancialRecords(ctx, records, nil))
+	w := serve(r, "POST", "/api/v1/organizations/2/financial-records/"+itoa(records[0].ID)+"/recurrence", map[string]any{"frequency": "weekly"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = serve(r, "DELETE", "/api/v1/organizations/2", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	scheduler := store.WithActor(ctx, store.SchedulerActor)
+	n, err := mem.MaterializeRecurrences(scheduler, now.AddDate(0, 2, 0), 0)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	count, err := mem.CountFinancialRecords(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestRecurringFinancialRecordsShortenedThenExtended(t *testing.T) {
+	r, mem := newTestRouter()
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month()-3, 1, 0, 0, 0, 0, time.UTC)
+	records := []domain.FinancialRecord{{OrganizationID: 1, Direction: "OUT", Amount: 100, DueDate: start, Status: domain.StatusPending, Reference: "rent"}}
+	require.NoError(t, mem.CreateFinancialRecords(context.Background(), records, nil))
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(records[0].ID)+"/recurrence", map[string]any{"frequency": "monthly", "count": 4})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?reference=rent", nil)
+	occurrences := decode[listResponse[domain.FinancialRecord]](t, w).Data
+	require.Len(t, occurrences, 4)
+	second := "/api/v1/organizations/1/financial-records/" + itoa(occurrences[1].ID)
+
+	// Shortening the series deletes its last two occurrences, and
+	// extending it again creates them anew.
+	w = serve(r, "PATCH", second+"?scope=future", map[string]any{"recurrence": map[string]any{"frequency": "monthly", "count": 2}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?reference=rent", nil)
+	assert.Len(t, decode[listResponse[domain.FinancialRecord]](t, w).Data, 2)
+	w = serve(r, "PATCH", second+"?scope=future", map[string]any{"recurrence": map[string]any{"frequency": "monthly", "count": 4}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(r, "GET", "/api/v1/organizations/1/financial-records?reference=rent", nil)
+	extended := decode[listResponse[domain.FinancialRecord]](t, w).Data
+	require.Len(t, extended, 4)
+	for i, o := range extended {
+		assert.Equal(t, i, o.Occurrence)
+		assert.True(t, start.AddDate(0, i, 0).Equal(o.DueDate))
+	}
+	assert.NotEqual(t, occurrences[3].ID, extended[3].ID)
+
+	// The deleted occurrences cannot come back next to their new copies.
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(occurrences[3].ID)+"/restore", nil)
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeOccurrenceExists, decode[Problem](t, w).Code)
+}
+
+func TestRecurringFinancialRecordsRespectQuota(t *testing.T) {
+	mem := newMemoryStore()
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, Recurrences: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+		Config: config.Config{MaxFinancialRecordsPerOrganization: 4},
+	})
+	ctx := context.Background()
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month()-5, 1, 0, 0, 0, 0, time.UTC)
+	records := []domain.FinancialRecord{
+		{OrganizationID: 1, Direction: "OUT", Amount: 100, DueDate: start, Status: domain.StatusPending, Reference: "rent"},
+		{OrganizationID: 1, Direction: "IN", Amount: 10, DueDate: start, Status: domain.StatusPending},
+	}
+	require.NoError(t, mem.CreateFinancialRecords(ctx, records, nil))
+
+	// Five occurrences are due, but only two fit in the quota; the others
+	// stay due.
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(records[0].ID)+"/recurrence", map[string]any{"frequency": "monthly"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "4", w.Header().Get(QuotaLimitHeader))
+	assert.Equal(t, "0", w.Header().Get(QuotaRemainingHeader))
+	rec := decode[domain.Recurrence](t, w)
+	assert.Equal(t, 3, rec.Generated)
+	require.NotNil(t, rec.NextDate)
+	assert.True(t, rec.NextDate.Before(now))
+
+	// A full organization cannot start a series.
+	w = serve(r, "POST", "/api/v1/organizations/1/financial-records/"+itoa(records[1].ID)+"/recurrence", map[string]any{"frequency": "monthly"})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeQuotaExceeded, decode[Problem](t, w).Code)
+
+	// The scheduler fills the room a deletion makes, and catches up once
+	// the organization lifts its limit.
+	scheduler := store.WithActor(ctx, store.SchedulerActor)
+	n, err := mem.MaterializeRecurrences(scheduler, now, 4)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	require.NoError(t, mem.DeleteFinancialRecord(ctx, 1, records[1].ID))
+	n, err = mem.MaterializeRecurrences(scheduler, now, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	org, err := mem.GetOrganization(ctx, 1)
+	require.NoError(t, err)
+	unlimited := 0
+	org.MaxFinancialRecords = &unlimited
+	require.NoError(t, mem.UpdateOrganization(ctx, org))
+	n, err = mem.MaterializeRecurrences(scheduler, now, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	count, err := mem.CountFinancialRecords(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+}
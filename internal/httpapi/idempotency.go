@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader lets a client retry a write without applying it twice.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader marks a response replayed from the cache.
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency replays the response of a successful write to retries that
+// carry the same Idempotency-Key header, for TTL after the first attempt.
+// Responses are kept in process memory, so replay only works when retries
+// reach the same server instance. A nil *Idempotency ignores the header.
+type Idempotency struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+
+	// Set before done is closed when the write succeeded.
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// NewIdempotency returns a response cache keeping successful writes for ttl,
+// or nil when ttl is zero.
+func NewIdempotency(ttl time.Duration) *Idempotency {
+	if ttl <= 0 {
+		return nil
+	}
+	return &Idempotency{ttl: ttl, entries: map[string]*idempotencyEntry{}}
+}
+
+// Middleware runs the handler once per idempotency key. A retry with the
+// same key and body gets the original response; a retry arriving while the
+// first attempt is still running waits for it. Reusing a key with a
+// different body is rejected with 422. Failed attempts are not cached, so
+// they can be retried.
+func (i *Idempotency) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if i == nil || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			abortWithProblem(c, NewProblem(http.StatusBadRequest, CodeIdempotencyKeyInvalid, "The idempotency key is longer than 255 characters"))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithProblem(c, NewProblem(http.StatusBadRequest, CodeInvalidBody, "The request body could not be read"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped to the caller, and to the route and organization
+		// they were sent to.
+		cacheKey := callerID(c) + " " + c.Request.Method + " " + c.Request.URL.Path + " " + key
+		fingerprint := sha256.Sum256(body)
+
+		for {
+			entry, owner := i.claim(cacheKey, fingerprint)
+			if entry.fingerprint != fingerprint {
+				abortWithProblem(c, NewProblem(http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "The idempotency key was already used with a different request"))
+				return
+			}
+			if owner {
+				i.run(c, cacheKey, entry)
+				return
+			}
+
+			select {
+			case <-entry.done:
+			case <-c.Request.Context().Done():
+				c.Error(c.Request.Context().Err())
+				c.Abort()
+				return
+			}
+			if entry.status != 0 {
+				c.Header(idempotentReplayedHeader, "true")
+				c.Data(entry.status, entry.contentType, entry.body)
+				c.Abort()
+				return
+			}
+			// The first attempt failed and released the key; try again.
+		}
+	}
+}
+
+// claim returns the live entry for key, creating it when there is none.
+// owner reports whether the caller created it and must run the request.
+func (i *Idempotency) claim(key string, fingerprint [sha256.Size]byte) (entry *idempotencyEntry, owner bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := i.entries[key]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e, false
+	}
+
+	// Drop expired entries from time to time while holding the lock anyway.
+	if now.Sub(i.lastSweep) > time.Minute {
+		for k, e := range i.entries {
+			if !e.expires.IsZero() && !now.Before(e.expires) {
+				delete(i.entries, k)
+			}
+		}
+		i.lastSweep = now
+	}
+
+	entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	i.entries[key] = entry
+	return entry, true
+}
+
+// run executes the request and caches its response if it succeeded.
+func (i *Idempotency) run(c *gin.Context, key string, entry *idempotencyEntry) {
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+
+	defer func() {
+		i.mu.Lock()
+		if status := w.Status(); w.Written() && status >= 200 && status < 300 {
+			entry.status = status
+			entry.contentType = w.Header().Get("Content-Type")
+			entry.body = w.body.Bytes()
+			entry.expires = time.Now().Add(i.ttl)
+		} else {
+			delete(i.entries, key)
+		}
+		i.mu.Unlock()
+		close(entry.done)
+	}()
+
+	c.Next()
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+func newIdempotentTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	mem := newMemoryStore()
+	return NewRouter(Deps{
+		Stores:      store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger:      slog.New(slog.DiscardHandler),
+		Idempotency: NewIdempotency(time.Hour),
+	})
+}
+
+func serveWithKey(r http.Handler, method, path, key string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(body)
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	r := newIdempotentTestRouter()
+
+	first := serveWithKey(r, "POST", "/api/v1/organizations/1/tags", "key-1", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(idempotentReplayedHeader))
+
+	second := serveWithKey(r, "POST", "/api/v1/organizations/1/tags", "key-1", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, "true", second.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	// The same key sent to another organization is a different request.
+	other := serveWithKey(r, "POST", "/api/v1/organizations/2/tags", "key-1", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, other.Code)
+	assert.Empty(t, other.Header().Get(idempotentReplayedHeader))
+
+	w := serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Len(t, decode[listResponse[domain.Tag]](t, w).Data, 1)
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	r := newIdempotentTestRouter()
+
+	w := serveWithKey(r, "POST", "/api/v1/organizations/1/tags", "key-1", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = serveWithKey(r, "POST", "/api/v1/organizations/1/tags", "key-1", map[string]any{"name": "Payroll"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestIdempotencyKeyFailedAttemptCanBeRetried(t *testing.T) {
+	r := newIdempotentTestRouter()
+
+	invalid := map[string]any{"direction": "SIDEWAYS", "amount": 10, "dueDate": time.Now()}
+	w := serveWithKey(r, "POST", "/api/v1/organizations/1/financial-records", "key-1", invalid)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveWithKey(r, "POST", "/api/v1/organizations/1/financial-records", "key-1", invalid)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get(idempotentReplayedHeader))
+}
@@ -0,0 +1,1345 @@
+//go:build integration
+
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+var testDB *gorm.DB
+var testDSN string
+var router *gin.Engine
+
+func TestMain(m *testing.M) {
+	// Setup test environment
+	gin.SetMode(gin.TestMode)
+
+	// Use a test database
+	testDSN = os.Getenv("TEST_DATABASE_URL")
+	if testDSN == "" {
+		testDSN = "host=localhost user=postgres password=postgres dbname=financial_test_db port=5432 sslmode=disable"
+	}
+
+	var err error
+	testDB, err = gorm.Open(postgres.Open(testDSN), &gorm.Config{})
+	if err != nil {
+		fmt.Printf("Failed to connect to test database: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Migrate the schema and apply database indexes
+	if err := store.Migrate(testDB); err != nil {
+		fmt.Printf("Failed to migrate test database: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Setup router with routes
+	gormStore := store.NewGormStore(testDB)
+	router = NewRouter(Deps{Stores: store.Stores{Organizations: gormStore, APIKeys: gormStore, Tags: gormStore, FinancialRecords: gormStore, AuditEvents: gormStore}})
+
+	// Run tests
+	exitCode := m.Run()
+
+	// Clean up database after tests
+	cleanupTestDB()
+
+	os.Exit(exitCode)
+}
+
+func cleanupTestDB() {
+	// Drop all tables
+	testDB.Exec("DROP TABLE IF EXISTS audit_events CASCADE")
+	testDB.Exec("DROP FUNCTION IF EXISTS audit_events_append_only")
+	testDB.Exec("DROP TABLE IF EXISTS financial_record_tags CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS payments CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS financial_records CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS recurrences CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS tags CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS api_key_grants CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS api_keys CASCADE")
+	testDB.Exec("DROP TABLE IF EXISTS organizations CASCADE")
+	testDB.Exec("DROP ROLE IF EXISTS " + testTenantRole)
+}
+
+func clearTables() {
+	testDB.Exec("DELETE FROM financial_record_tags")
+	testDB.Exec("DELETE FROM payments")
+	testDB.Exec("DELETE FROM financial_records")
+	testDB.Exec("DELETE FROM recurrences")
+	testDB.Exec("DELETE FROM tags")
+	// Audit events reject deletes; truncating bypasses the trigger.
+	testDB.Exec("TRUNCATE audit_events RESTART IDENTITY")
+	testDB.Exec("TRUNCATE api_keys RESTART IDENTITY CASCADE")
+	testDB.Exec("TRUNCATE organizations RESTART IDENTITY CASCADE")
+
+	// Every test writes to organization 1
+	testDB.Create(&domain.Organization{Name: "Test Organization", TimeZone: "UTC", Currency: "USD"})
+}
+
+func TestOrganizationLifecycleInPostgres(t *testing.T) {
+	clearTables()
+
+	// Create an organization
+	jsonData, _ := json.Marshal(map[string]interface{}{"name": "Initech", "timeZone": "America/Chicago"})
+	req := httptest.NewRequest("POST", "/api/v1/organizations", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created domain.Organization
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), created.ID)
+	assert.Equal(t, "America/Chicago", created.TimeZone)
+	assert.Equal(t, "USD", created.Currency)
+	path := fmt.Sprintf("/api/v1/organizations/%d", created.ID)
+
+	// Update it
+	jsonData, _ = json.Marshal(map[string]interface{}{"name": "Initrode", "currency": "EUR"})
+	req = httptest.NewRequest("PUT", path, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated domain.Organization
+	err = json.Unmarshal(w.Body.Bytes(), &updated)
+	assert.Nil(t, err)
+	assert.Equal(t, "Initrode", updated.Name)
+	assert.Equal(t, "UTC", updated.TimeZone)
+	assert.Equal(t, "EUR", updated.Currency)
+	assert.Equal(t, created.CreatedAt.Unix(), updated.CreatedAt.Unix())
+
+	// Delete it; its routes are gone afterwards
+	req = httptest.NewRequest("DELETE", path, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	for _, p := range []string{path, path + "/tags"} {
+		req = httptest.NewRequest("GET", p, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func TestAPIKeysInPostgres(t *testing.T) {
+	clearTables()
+	gormStore := store.NewGormStore(testDB)
+	authRouter := NewRouter(Deps{
+		Stores: store.Stores{Organizations: gormStore, APIKeys: gormStore, Tags: gormStore, FinancialRecords: gormStore, AuditEvents: gormStore},
+		Auth:   NewAuth(NewAPIKeyAuthenticator(gormStore, "test-admin-key", 0)),
+	})
+	send := func(secret, method, path string, body any) *httptest.ResponseRecorder {
+		jsonData, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		authRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	// Issue a key reading organization 1
+	w := send("test-admin-key", "POST", "/api/v1/api-keys", map[string]interface{}{
+		"name":   "reader",
+		"grants": []map[string]interface{}{{"organizationId": 1, "scope": "read"}},
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var issued issuedAPIKey
+	err := json.Unmarshal(w.Body.Bytes(), &issued)
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.APIKeyGrant{{OrganizationID: 1, Scope: "read"}}, issued.Grants)
+
+	// Only its hash is stored
+	var stored domain.APIKey
+	assert.Nil(t, testDB.First(&stored, issued.ID).Error)
+	assert.Equal(t, hashAPIKey(issued.Secret), stored.Hash)
+
+	// It reads organization 1 and nothing else
+	assert.Equal(t, http.StatusOK, send(issued.Secret, "GET", "/api/v1/organizations/1/tags", nil).Code)
+	assert.Equal(t, http.StatusForbidden, send(issued.Secret, "POST", "/api/v1/organizations/1/tags", map[string]interface{}{"name": "Rent"}).Code)
+	assert.Equal(t, http.StatusForbidden, send(issued.Secret, "GET", "/api/v1/api-keys", nil).Code)
+
+	// Grants on unknown organizations are rejected
+	w = send("test-admin-key", "POST", "/api/v1/api-keys", map[string]interface{}{
+		"name":   "stray",
+		"grants": []map[string]interface{}{{"organizationId": 99, "scope": "read"}},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Revoked keys are rejected
+	path := fmt.Sprintf("/api/v1/api-keys/%d", issued.ID)
+	assert.Equal(t, http.StatusNoContent, send("test-admin-key", "DELETE", path, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, send(issued.Secret, "GET", "/api/v1/organizations/1/tags", nil).Code)
+	assert.Equal(t, http.StatusNotFound, send("test-admin-key", "DELETE", path, nil).Code)
+}
+
+func TestCreateTag(t *testing.T) {
+	clearTables()
+
+	// Create test data
+	tag := map[string]interface{}{
+		"name": "Test Tag",
+	}
+	jsonData, _ := json.Marshal(tag)
+
+	// Create request
+	req := httptest.NewRequest("POST", "/api/v1/organizations/1/tags", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create response recorder
+	w := httptest.NewRecorder()
+
+	// Serve the request
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Parse response
+	var response domain.Tag
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+
+	// Validate response
+	assert.Equal(t, "Test Tag", response.Name)
+	assert.Equal(t, uint(1), response.OrganizationID)
+	assert.NotZero(t, response.ID)
+}
+
+func TestCreateTagWithTakenNameInPostgres(t *testing.T) {
+	clearTables()
+
+	existing := domain.Tag{Name: "Red Cat 12", OrganizationID: 1}
+	testDB.Create(&existing)
+
+	for _, query := range []string{"", "?upsert=true"} {
+		jsonData, _ := json.Marshal(map[string]interface{}{"name": " red  CAT 12"})
+
+		// Create request
+		req := httptest.NewRequest("POST", "/api/v1/organizations/1/tags"+query, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		// Create response recorder
+		w := httptest.NewRecorder()
+
+		// Serve the request
+		router.ServeHTTP(w, req)
+
+		// Parse response, the tag being either the body or its "existing" member
+		var response struct {
+			domain.Tag
+			Code     string     `json:"code"`
+			Existing domain.Tag `json:"existing"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Nil(t, err)
+
+		// Validate response
+		if query == "" {
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Equal(t, CodeTagExists, response.Code)
+			assert.Equal(t, existing.ID, response.Existing.ID)
+		} else {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, existing.ID, response.ID)
+		}
+	}
+}
+
+func TestListTags(t *testing.T) {
+	clearTables()
+
+	// Create test data
+	testDB.Create(&domain.Tag{Name: "Tag 1", OrganizationID: 1})
+	testDB.Create(&domain.Tag{Name: "Tag 2", OrganizationID: 1})
+
+	// Create request
+	req := httptest.NewRequest("GET", "/api/v1/organizations/1/tags", nil)
+
+	// Create response recorder
+	w := httptest.NewRecorder()
+
+	// Serve the request
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Parse response
+	var response struct {
+		Data       []domain.Tag `json:"data"`
+		Pagination struct {
+			CurrentPage int   `json:"current_page"`
+			PageSize    int   `json:"page_size"`
+			TotalItems  int64 `json:"total_items"`
+			TotalPages  int64 `json:"total_pages"`
+		} `json:"pagination"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+
+	// Validate response
+	assert.Len(t, response.Data, 2)
+	assert.Equal(t, "Tag 1", response.Data[0].Name)
+	assert.Equal(t, "Tag 2", response.Data[1].Name)
+
+	// Validate pagination
+	assert.Equal(t, 1, response.Pagination.CurrentPage)
+	assert.Equal(t, 20, response.Pagination.PageSize)
+	assert.Equal(t, int64(2), response.Pagination.TotalItems)
+	assert.Equal(t, int64(1), response.Pagination.TotalPages)
+}
+
+func TestCreateFinancialRecord(t *testing.T) {
+	clearTables()
+
+	// Create test data - first create a tag
+	tag := domain.Tag{Name: "Expense Tag", OrganizationID: 1}
+	testDB.Create(&tag)
+
+	// Create financial record with tag
+	record := map[string]interface{}{
+		"direction": "OUT",
+		"amount":    100.50,
+		"dueDate":   time.Now().Format(time.RFC3339),
+		"tags":      []map[string]interface{}{{"id": tag.ID}},
+	}
+	jsonData, _ := json.Marshal(record)
+
+	// Create request
+	req := httptest.NewRequest("POST", "/api/v1/organizations/1/financial-records", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create response recorder
+	w := httptest.NewRecorder()
+
+	// Serve the request
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Parse response
+	var response domain.FinancialRecord
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+
+	// Validate response
+	assert.Equal(t, "OUT", response.Direction)
+	assert.Equal(t, 100.50, response.Amount)
+	assert.Equal(t, uint(1), response.OrganizationID)
+}
+
+func TestCreateFinancialRecordsBulk(t *testing.T) {
+	clearTables()
+
+	// Create test data - first create tags
+	tag1 := domain.Tag{Name: "Income Tag", OrganizationID: 1}
+	tag2 := domain.Tag{Name: "Expense Tag", OrganizationID: 1}
+	testDB.Create(&tag1)
+	testDB.Create(&tag2)
+
+	// Create financial records with tags
+	records := []map[string]interface{}{
+		{
+			"direction": "IN",
+			"amount":    1000.0,
+			"dueDate":   time.Now().Format(time.RFC3339),
+			"tags":      []map[string]interface{}{{"id": tag1.ID}},
+		},
+		{
+			"direction": "OUT",
+			"amount":    500.0,
+			"dueDate":   time.Now().Format(time.RFC3339),
+			"tags":      []map[string]interface{}{{"id": tag2.ID}},
+		},
+	}
+	jsonData, _ := json.Marshal(records)
+
+	// Create request
+	req := httptest.NewRequest("POST", "/api/v1/organizations/1/financial-records/bulk", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create response recorder
+	w := httptest.NewRecorder()
+
+	// Serve the request
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Parse response
+	var response []domain.FinancialRecord
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+
+	// Validate response
+	assert.Len(t, response, 2)
+	assert.Equal(t, "IN", response[0].Direction)
+	assert.Equal(t, "OUT", response[1].Direction)
+}
+
+func TestListFinancialRecords(t *testing.T) {
+	clearTables()
+
+	// Create test data
+	tag := domain.Tag{Name: "Test Tag", OrganizationID: 1}
+	testDB.Create(&tag)
+
+	record1 := domain.FinancialRecord{
+		Direction:      "IN",
+		Amount:         1000.0,
+		DueDate:        time.Now(),
+		OrganizationID: 1,
+	}
+	testDB.Create(&record1)
+	testDB.Exec("INSERT INTO financial_record_tags (financial_record_id, tag_id) VALUES (?, ?)", record1.ID, tag.ID)
+
+	record2 := domain.FinancialRecord{
+		Direction:      "OUT",
+		Amount:         500.0,
+		DueDate:        time.Now(),
+		OrganizationID: 1,
+	}
+	testDB.Create(&record2)
+
+	// Create request
+	req := httptest.NewRequest("GET", "/api/v1/organizations/1/financial-records?page=1&page_size=10", nil)
+
+	// Create response recorder
+	w := httptest.NewRecorder()
+
+	// Serve the request
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Parse response
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+
+	// Validate response
+	records, ok := response["data"].([]interface{})
+	assert.True(t, ok)
+	assert.Len(t, records, 2)
+
+	pagination, ok := response["pagination"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), pagination["current_page"])
+	assert.Equal(t, float64(2), pagination["total_items"])
+}
+
+func TestListFinancialRecordsByTagsInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+			rent := domain.Tag{OrganizationID: 1, Name: "Rent"}
+			office := domain.Tag{OrganizationID: 1, Name: "Office"}
+			other := domain.Tag{OrganizationID: 1, Name: "Other"}
+			for _, tag := range []*domain.Tag{&rent, &office, &other} {
+				require.NoError(t, s.CreateTag(ctx, tag, nil))
+			}
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 10, DueDate: time.Now(), Status: domain.StatusPending, Tags: []domain.Tag{rent, office}},
+				{OrganizationID: 1, Direction: "OUT", Amount: 20, DueDate: time.Now(), Status: domain.StatusPending, Tags: []domain.Tag{office}},
+				{OrganizationID: 1, Direction: "OUT", Amount: 30, DueDate: time.Now(), Status: domain.StatusPending, Tags: []domain.Tag{other}},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			// The record carrying both tags is listed and counted once.
+			filter := store.FinancialRecordFilter{TagIDs: []uint{rent.ID, office.ID}}
+			found, total, err := s.ListFinancialRecords(ctx, 1, filter, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), total)
+			require.Len(t, found, 2)
+			assert.Equal(t, []uint{records[0].ID, records[1].ID}, []uint{found[0].ID, found[1].ID})
+			assert.Len(t, found[0].Tags, 2)
+		})
+	}
+}
+
+func TestCashFlowReport(t *testing.T) {
+	clearTables()
+
+	// Create test data
+	now := time.Now()
+	lastMonth := now.AddDate(0, -1, 0)
+
+	// Create records for current month
+	testDB.Create(&domain.FinancialRecord{
+		Direction:      "IN",
+		Amount:         2000.0,
+		DueDate:        now,
+		OrganizationID: 1,
+	})
+	testDB.Create(&domain.FinancialRecord{
+		Direction:      "OUT",
+		Amount:         1000.0,
+		DueDate:        now,
+		OrganizationID: 1,
+	})
+
+	// Create records for last month
+	testDB.Create(&domain.FinancialRecord{
+		Direction:      "IN",
+		Amount:         1500.0,
+		DueDate:        lastMonth,
+		OrganizationID: 1,
+	})
+	testDB.Create(&domain.FinancialRecord{
+		Direction:      "OUT",
+		Amount:         800.0,
+		DueDate:        lastMonth,
+		OrganizationID: 1,
+	})
+
+	// Create request
+	req := httptest.NewRequest("GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil)
+
+	// Create response recorder
+	w := httptest.NewRecorder()
+
+	// Serve the request
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Parse response
+	var response domain.CashFlowReport
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+
+	// Validate response has monthly data
+	assert.GreaterOrEqual(t, len(response.MonthlyData), 2)
+
+	// Find current month and last month data
+	currentYearMonth := fmt.Sprintf("%d-%d", now.Year(), int(now.Month()))
+	lastYearMonth := fmt.Sprintf("%d-%d", lastMonth.Year(), int(lastMonth.Month()))
+
+	foundCurrent := false
+	foundLast := false
+
+	for _, data := range response.MonthlyData {
+		yearMonth := fmt.Sprintf("%d-%d", data.Year, data.Month)
+		if yearMonth == currentYearMonth {
+			foundCurrent = true
+			assert.InDelta(t, 2000.0, data.In, 0.01)
+			assert.InDelta(t, 1000.0, data.Out, 0.01)
+		} else if yearMonth == lastYearMonth {
+			foundLast = true
+			assert.InDelta(t, 1500.0, data.In, 0.01)
+			assert.InDelta(t, 800.0, data.Out, 0.01)
+		}
+	}
+
+	assert.True(t, foundCurrent || foundLast, "Should find data for current month or last month")
+}
+
+// testTenantRole is the role of the stores with row-level security.
+const testTenantRole = "financial_tenant_test"
+
+// tenantStore is what TestRowLevelSecurityInPostgres and
+// TestAuditEventsInPostgres need of a store.
+type tenantStore interface {
+	store.TagStore
+	store.FinancialRecordStore
+	store.RecurrenceStore
+	store.AuditEventStore
+}
+
+func TestRowLevelSecurityInPostgres(t *testing.T) {
+	clearTables()
+	require.NoError(t, store.EnableRowLevelSecurity(testDB, testTenantRole))
+	// Enabling is idempotent.
+	require.NoError(t, store.EnableRowLevelSecurity(testDB, testTenantRole))
+
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB).WithRowLevelSecurity(testTenantRole),
+		"pgx":  store.NewPgxStore(pool).WithRowLevelSecurity(testTenantRole),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			// The owner writes the data of two organizations.
+			other := domain.Organization{Name: "Other", TimeZone: "UTC", Currency: "USD"}
+			require.NoError(t, testDB.Create(&other).Error)
+			own := domain.Tag{OrganizationID: 1, Name: "Own"}
+			secret := domain.Tag{OrganizationID: other.ID, Name: "Secret"}
+			require.NoError(t, testDB.Create(&own).Error)
+			require.NoError(t, testDB.Create(&secret).Error)
+			require.NoError(t, testDB.Create(&domain.FinancialRecord{
+				OrganizationID: other.ID, Direction: "IN", Amount: 100, DueDate: time.Now(), Tags: []domain.Tag{secret},
+			}).Error)
+
+			ctx := store.WithOrganization(context.Background(), 1)
+			page := store.Page{Number: 1, Size: 10}
+
+			// A query for another organization finds nothing.
+			tags, total, err := s.ListTags(ctx, other.ID, store.TagFilter{}, page)
+			require.NoError(t, err)
+			assert.Empty(t, tags)
+			assert.Zero(t, total)
+			_, err = s.FindTagByName(ctx, other.ID, "Secret")
+			assert.ErrorIs(t, err, store.ErrNotFound)
+			records, total, err := s.ListFinancialRecords(ctx, other.ID, store.FinancialRecordFilter{}, page)
+			require.NoError(t, err)
+			assert.Empty(t, records)
+			assert.Zero(t, total)
+			report, err := s.CashFlowReport(ctx, other.ID, domain.BasisDue, time.Now().AddDate(-1, 0, 0), time.UTC)
+			require.NoError(t, err)
+			assert.Empty(t, report)
+
+			// Nor can it write there.
+			assert.Error(t, s.CreateTag(ctx, &domain.Tag{OrganizationID: other.ID, Name: "Planted"}, nil))
+			assert.Error(t, s.CreateFinancialRecords(ctx, []domain.FinancialRecord{
+				{OrganizationID: other.ID, Direction: "OUT", Amount: 1, DueDate: time.Now()},
+			}, nil))
+
+			// A record of the organization cannot be linked to another
+			// organization's tag: either the write fails or the link is
+			// dropped.
+			_ = s.CreateFinancialRecord(ctx, &domain.FinancialRecord{
+				OrganizationID: 1, Direction: "OUT", Amount: 1, DueDate: time.Now(), Tags: []domain.Tag{secret},
+			}, nil)
+			var links int64
+			require.NoError(t, testDB.Table("financial_record_tags").Where("tag_id = ?", secret.ID).Count(&links).Error)
+			assert.Equal(t, int64(1), links)
+
+			// Without an organization in the context nothing is visible.
+			tags, _, err = s.ListTags(context.Background(), 1, store.TagFilter{}, page)
+			require.NoError(t, err)
+			assert.Empty(t, tags)
+
+			// Its audit events are hidden too.
+			events, _, err := s.ListAuditEvents(ctx, other.ID, store.AuditEventFilter{}, page)
+			require.NoError(t, err)
+			assert.Empty(t, events)
+
+			// The organization of the context is served as usual.
+			tags, total, err = s.ListTags(ctx, 1, store.TagFilter{}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			if assert.Len(t, tags, 1) {
+				assert.Equal(t, own.ID, tags[0].ID)
+			}
+
+			// Quota checks may lock the organization of the context.
+			quota := &store.Quota{Limit: 5}
+			require.NoError(t, s.CreateTag(ctx, &domain.Tag{OrganizationID: 1, Name: "Limited"}, quota))
+			assert.Equal(t, int64(3), quota.Left)
+		})
+	}
+}
+
+func TestAuditEventsInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := store.WithActor(context.Background(), "api-key:7")
+			page := store.Page{Number: 1, Size: 10}
+
+			tag := domain.Tag{OrganizationID: 1, Name: "Rent"}
+			require.NoError(t, s.CreateTag(ctx, &tag, nil))
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 1200, DueDate: time.Now(), Tags: []domain.Tag{tag}},
+				{OrganizationID: 1, Direction: "IN", Amount: 5000, DueDate: time.Now()},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			events, total, err := s.ListAuditEvents(ctx, 1, store.AuditEventFilter{}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), total)
+			require.Len(t, events, 3)
+			assert.Equal(t, records[1].ID, events[0].EntityID)
+			assert.Equal(t, records[0].ID, events[1].EntityID)
+			var linked struct{ Tags []uint }
+			require.NoError(t, json.Unmarshal(events[1].After, &linked))
+			assert.Equal(t, []uint{tag.ID}, linked.Tags)
+			assert.Equal(t, tag.ID, events[2].EntityID)
+			for _, e := range events {
+				assert.Equal(t, "api-key:7", e.Actor)
+				assert.Equal(t, domain.AuditCreate, e.Action)
+				assert.Nil(t, e.Before)
+			}
+
+			events, total, err = s.ListAuditEvents(ctx, 1, store.AuditEventFilter{
+				EntityType: domain.AuditEntityTag, EntityID: tag.ID, Since: time.Now().Add(-time.Minute),
+			}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			require.Len(t, events, 1)
+			assert.Equal(t, tag.ID, events[0].EntityID)
+
+			// A failed write records nothing.
+			assert.Error(t, s.CreateTag(ctx, &domain.Tag{OrganizationID: 1, Name: "rent"}, nil))
+			_, total, err = s.ListAuditEvents(ctx, 1, store.AuditEventFilter{}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), total)
+
+			// Events cannot be rewritten, even by the table owner.
+			assert.Error(t, testDB.Exec("UPDATE audit_events SET actor = 'someone else'").Error)
+			assert.Error(t, testDB.Exec("DELETE FROM audit_events").Error)
+		})
+	}
+}
+
+func TestConcurrentCreatesKeepToQuotaInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+
+			// The creates check the quota one after the other, so exactly
+			// as many succeed as it allows.
+			const limit, attempts = 3, 12
+			results := make(chan error, attempts)
+			for i := 0; i < attempts; i++ {
+				go func() {
+					results <- s.CreateFinancialRecord(ctx, &domain.FinancialRecord{
+						OrganizationID: 1, Direction: "IN", Amount: 1, DueDate: time.Now(), Status: domain.StatusPending,
+					}, &store.Quota{Limit: limit})
+				}()
+			}
+			var created, refused int
+			for i := 0; i < attempts; i++ {
+				err := <-results
+				var exceeded *store.QuotaError
+				switch {
+				case err == nil:
+					created++
+				case errors.As(err, &exceeded):
+					refused++
+					assert.Zero(t, exceeded.Left)
+				default:
+					t.Error(err)
+				}
+			}
+			assert.Equal(t, limit, created)
+			assert.Equal(t, attempts-limit, refused)
+			count, err := s.CountFinancialRecords(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, int64(limit), count)
+		})
+	}
+}
+
+func TestDeleteRestoreAndPurgeInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+			page := store.Page{Number: 1, Size: 10}
+
+			tag := domain.Tag{OrganizationID: 1, Name: "Rent"}
+			require.NoError(t, s.CreateTag(ctx, &tag, nil))
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 1200, DueDate: time.Now(), Tags: []domain.Tag{tag}},
+				{OrganizationID: 1, Direction: "IN", Amount: 5000, DueDate: time.Now()},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			require.NoError(t, s.DeleteTag(ctx, 1, tag.ID))
+			assert.ErrorIs(t, s.DeleteTag(ctx, 1, tag.ID), store.ErrNotFound)
+			assert.ErrorIs(t, s.DeleteTag(ctx, 2, tag.ID), store.ErrNotFound)
+			require.NoError(t, s.DeleteFinancialRecord(ctx, 1, records[1].ID))
+			assert.ErrorIs(t, s.DeleteFinancialRecord(ctx, 1, records[1].ID), store.ErrNotFound)
+
+			// Deleted rows are hidden unless asked for.
+			tags, _, err := s.ListTags(ctx, 1, store.TagFilter{}, page)
+			require.NoError(t, err)
+			assert.Empty(t, tags)
+			tags, _, err = s.ListTags(ctx, 1, store.TagFilter{IncludeDeleted: true}, page)
+			require.NoError(t, err)
+			require.Len(t, tags, 1)
+			assert.True(t, tags[0].DeletedAt.Valid)
+			found, total, err := s.ListFinancialRecords(ctx, 1, store.FinancialRecordFilter{}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			require.Len(t, found, 1)
+			assert.Empty(t, found[0].Tags)
+			found, total, err = s.ListFinancialRecords(ctx, 1, store.FinancialRecordFilter{IncludeDeleted: true}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), total)
+			// Asking for deleted records does not bring back deleted tags.
+			require.Len(t, found, 2)
+			assert.Empty(t, found[0].Tags)
+			report, err := s.CashFlowReport(ctx, 1, domain.BasisDue, time.Now().AddDate(-1, 0, 0), time.UTC)
+			require.NoError(t, err)
+			require.Len(t, report, 1)
+			assert.Zero(t, report[0].In)
+
+			// A restored tag whose name was taken in the meantime conflicts.
+			taken := domain.Tag{OrganizationID: 1, Name: "rent"}
+			require.NoError(t, s.CreateTag(ctx, &taken, nil))
+			_, err = s.RestoreTag(ctx, 1, tag.ID, nil)
+			assert.ErrorIs(t, err, store.ErrDuplicate)
+			require.NoError(t, s.DeleteTag(ctx, 1, taken.ID))
+
+			restored, err := s.RestoreTag(ctx, 1, tag.ID, nil)
+			require.NoError(t, err)
+			assert.False(t, restored.DeletedAt.Valid)
+			record, err := s.RestoreFinancialRecord(ctx, 1, records[1].ID, nil)
+			require.NoError(t, err)
+			assert.False(t, record.DeletedAt.Valid)
+			_, err = s.RestoreFinancialRecord(ctx, 2, records[1].ID, nil)
+			assert.ErrorIs(t, err, store.ErrNotFound)
+			// Restoring a live row changes nothing.
+			_, err = s.RestoreTag(ctx, 1, tag.ID, nil)
+			require.NoError(t, err)
+
+			events, _, err := s.ListAuditEvents(ctx, 1, store.AuditEventFilter{EntityType: domain.AuditEntityTag, EntityID: tag.ID}, page)
+			require.NoError(t, err)
+			var actions []string
+			for _, e := range events {
+				actions = append(actions, e.Action)
+			}
+			assert.Equal(t, []string{domain.AuditRestore, domain.AuditDelete, domain.AuditCreate}, actions)
+
+			// Only the rows deleted before the cutoff are purged.
+			require.NoError(t, s.DeleteFinancialRecord(ctx, 1, records[0].ID))
+			require.NoError(t, s.DeleteTag(ctx, 1, tag.ID))
+			require.NoError(t, testDB.Exec("UPDATE financial_records SET deleted_at = now() - interval '2 days' WHERE id = ?", records[0].ID).Error)
+			require.NoError(t, testDB.Exec("UPDATE tags SET deleted_at = now() - interval '2 days' WHERE id = ?", tag.ID).Error)
+			result, err := store.PurgeDeleted(ctx, testDB, time.Now().Add(-24*time.Hour), 1)
+			require.NoError(t, err)
+			assert.Equal(t, store.PurgeResult{FinancialRecords: 1, Tags: 1, Links: 1}, result)
+
+			var remaining int64
+			require.NoError(t, testDB.Unscoped().Model(&domain.FinancialRecord{}).Count(&remaining).Error)
+			assert.Equal(t, int64(1), remaining)
+			require.NoError(t, testDB.Unscoped().Model(&domain.Tag{}).Count(&remaining).Error)
+			assert.Equal(t, int64(1), remaining, "the tag deleted just now is kept")
+			_, total, err = s.ListAuditEvents(ctx, 1, store.AuditEventFilter{EntityType: domain.AuditEntityTag, EntityID: tag.ID}, page)
+			require.NoError(t, err)
+			assert.Equal(t, int64(4), total)
+		})
+	}
+}
+
+func TestSettleAndCancelInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Microsecond)
+			lastMonth := now.AddDate(0, -1, 0)
+
+			tag := domain.Tag{OrganizationID: 1, Name: "Rent"}
+			require.NoError(t, s.CreateTag(ctx, &tag, nil))
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 1200, DueDate: lastMonth, Status: domain.StatusPending, Tags: []domain.Tag{tag}},
+				{OrganizationID: 1, Direction: "IN", Amount: 5000, DueDate: now, Status: domain.StatusPending},
+				{OrganizationID: 1, Direction: "IN", Amount: 300, DueDate: lastMonth, Status: domain.StatusPaid, PaidAmount: 300, PaidAt: &lastMonth},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			record, err := s.UpdateFinancialRecord(ctx, 1, records[0].ID, func(r *domain.FinancialRecord) error {
+				return r.Settle(domain.Settlement{Amount: 200, PaidAt: lastMonth})
+			})
+			require.NoError(t, err)
+			assert.Equal(t, domain.StatusPending, record.Status)
+			assert.Equal(t, 200.0, record.PaidAmount)
+			require.Len(t, record.Tags, 1)
+			record, err = s.UpdateFinancialRecord(ctx, 1, records[0].ID, func(r *domain.FinancialRecord) error {
+				return r.Settle(domain.Settlement{PaidAt: now})
+			})
+			require.NoError(t, err)
+			assert.Equal(t, domain.StatusPaid, record.Status)
+			assert.Equal(t, 1200.0, record.PaidAmount)
+			assert.True(t, now.Equal(*record.PaidAt))
+
+			// A failed update saves nothing.
+			_, err = s.UpdateFinancialRecord(ctx, 1, records[0].ID, func(r *domain.FinancialRecord) error {
+				return r.Settle(domain.Settlement{})
+			})
+			assert.ErrorIs(t, err, domain.ErrRecordPaid)
+			_, err = s.UpdateFinancialRecord(ctx, 2, records[1].ID, (*domain.FinancialRecord).Cancel)
+			assert.ErrorIs(t, err, store.ErrNotFound)
+
+			record, err = s.UpdateFinancialRecord(ctx, 1, records[1].ID, (*domain.FinancialRecord).Cancel)
+			require.NoError(t, err)
+			assert.Equal(t, domain.StatusCancelled, record.Status)
+			// Cancelling again changes nothing and records no event.
+			_, err = s.UpdateFinancialRecord(ctx, 1, records[1].ID, (*domain.FinancialRecord).Cancel)
+			require.NoError(t, err)
+
+			found, _, err := s.ListFinancialRecords(ctx, 1, store.FinancialRecordFilter{}, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			require.Len(t, found, 3)
+			assert.Equal(t, domain.StatusPaid, found[0].Status)
+			assert.Equal(t, domain.StatusCancelled, found[1].Status)
+			assert.Equal(t, 300.0, found[2].PaidAmount)
+
+			due, err := s.CashFlowReport(ctx, 1, domain.BasisDue, now.AddDate(-1, 0, 0), time.UTC)
+			require.NoError(t, err)
+			assert.Equal(t, []domain.MonthlyCashFlow{
+				{Year: lastMonth.Year(), Month: int(lastMonth.Month()), In: 300, Out: 1200},
+			}, due)
+			// Each part of the split payment counts in the month it was made.
+			paid, err := s.CashFlowReport(ctx, 1, domain.BasisPaid, now.AddDate(-1, 0, 0), time.UTC)
+			require.NoError(t, err)
+			assert.Equal(t, []domain.MonthlyCashFlow{
+				{Year: lastMonth.Year(), Month: int(lastMonth.Month()), In: 300, Out: 200},
+				{Year: now.Year(), Month: int(now.Month()), Out: 1000},
+			}, paid)
+			var payments []domain.Payment
+			require.NoError(t, testDB.Order("id").Find(&payments, "financial_record_id = ?", records[0].ID).Error)
+			require.Len(t, payments, 2)
+			assert.Equal(t, 200.0, payments[0].Amount)
+			assert.True(t, lastMonth.Equal(payments[0].PaidAt))
+			assert.Equal(t, 1000.0, payments[1].Amount)
+			assert.True(t, now.Equal(payments[1].PaidAt))
+
+			events, total, err := s.ListAuditEvents(ctx, 1, store.AuditEventFilter{Action: domain.AuditUpdate}, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), total)
+			require.Len(t, events, 3)
+			var change struct{ Status string }
+			require.NoError(t, json.Unmarshal(events[0].After, &change))
+			assert.Equal(t, domain.StatusCancelled, change.Status)
+
+			// Months are those of the time zone: at 2 a.m. UTC on the first,
+			// it is still the previous month in São Paulo.
+			first := time.Date(now.Year(), now.Month(), 1, 2, 0, 0, 0, time.UTC)
+			require.NoError(t, s.CreateFinancialRecords(ctx, []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "IN", Amount: 50, DueDate: first, Status: domain.StatusPending},
+			}, nil))
+			saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+			require.NoError(t, err)
+			local, err := s.CashFlowReport(ctx, 1, domain.BasisDue, first.Add(-time.Hour), saoPaulo)
+			require.NoError(t, err)
+			previous := first.AddDate(0, -1, 0)
+			assert.Equal(t, []domain.MonthlyCashFlow{{Year: previous.Year(), Month: int(previous.Month()), In: 50}}, local)
+		})
+	}
+}
+
+func TestFinancialRecordDetailsInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 1000, DueDate: now, Status: domain.StatusPending, Description: "Rent",
+					CounterpartyName: "ACME Imóveis", CounterpartyDocument: "12.345.678/0001-90", Reference: "NF-1",
+					Metadata: json.RawMessage(`{"costCenter": "operations", "labels": ["rent", "fixed"]}`)},
+				{OrganizationID: 1, Direction: "OUT", Amount: 50, DueDate: now, Status: domain.StatusPending,
+					CounterpartyName: "acme imóveis", Reference: "NF-2", Metadata: json.RawMessage(`{"costCenter": "marketing"}`)},
+				{OrganizationID: 1, Direction: "IN", Amount: 10, DueDate: now, Status: domain.StatusPending},
+				{OrganizationID: 2, Direction: "IN", Amount: 99, DueDate: now, Status: domain.StatusPending,
+					CounterpartyName: "ACME Imóveis", Reference: "NF-1", Metadata: json.RawMessage(`{"costCenter": "operations"}`)},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			list := func(filter store.FinancialRecordFilter) []domain.FinancialRecord {
+				t.Helper()
+				found, _, err := s.ListFinancialRecords(ctx, 1, filter, store.Page{Number: 1, Size: 10})
+				require.NoError(t, err)
+				return found
+			}
+			all := list(store.FinancialRecordFilter{})
+			require.Len(t, all, 3)
+			assert.Equal(t, "Rent", all[0].Description)
+			assert.Equal(t, "12.345.678/0001-90", all[0].CounterpartyDocument)
+			assert.JSONEq(t, `{"costCenter": "operations", "labels": ["rent", "fixed"]}`, string(all[0].Metadata))
+			assert.Nil(t, all[2].Metadata)
+
+			assert.Len(t, list(store.FinancialRecordFilter{CounterpartyName: "ACME IMÓVEIS"}), 2)
+			assert.Len(t, list(store.FinancialRecordFilter{CounterpartyDocument: "12.345.678/0001-90"}), 1)
+			assert.Len(t, list(store.FinancialRecordFilter{Reference: "NF-1"}), 1)
+			assert.Len(t, list(store.FinancialRecordFilter{Metadata: json.RawMessage(`{"labels": ["fixed"]}`)}), 1)
+			assert.Empty(t, list(store.FinancialRecordFilter{Metadata: json.RawMessage(`{"costCenter": "sales"}`)}))
+
+			record, err := s.UpdateFinancialRecord(ctx, 1, records[1].ID, func(r *domain.FinancialRecord) error {
+				r.Reference = "NF-3"
+				r.Metadata = json.RawMessage(`{"costCenter": "sales"}`)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "NF-3", record.Reference)
+			found := list(store.FinancialRecordFilter{Metadata: json.RawMessage(`{"costCenter": "sales"}`)})
+			require.Len(t, found, 1)
+			assert.Equal(t, "NF-3", found[0].Reference)
+		})
+	}
+}
+
+func TestSearchFinancialRecordsInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 1000, DueDate: now, Status: domain.StatusPending,
+					Description: "Pagamento do aluguel do escritório", CounterpartyName: "Imobiliária Central", Reference: "NF-100"},
+				{OrganizationID: 1, Direction: "IN", Amount: 300, DueDate: now, Status: domain.StatusPending,
+					Description: "Consulting invoices for March", CounterpartyName: "Distribuidora Santos"},
+				{OrganizationID: 1, Direction: "OUT", Amount: 50, DueDate: now, Status: domain.StatusPending,
+					Description: "Mensalidade", CounterpartyName: "Pagamentos Rápidos"},
+				{OrganizationID: 1, Direction: "IN", Amount: 10, DueDate: now, Status: domain.StatusPending},
+				{OrganizationID: 2, Direction: "OUT", Amount: 99, DueDate: now, Status: domain.StatusPending,
+					Description: "Pagamento do aluguel do escritório"},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			search := func(q string) []uint {
+				t.Helper()
+				found, total, err := s.ListFinancialRecords(ctx, 1, store.FinancialRecordFilter{Query: q}, store.Page{Number: 1, Size: 10})
+				require.NoError(t, err)
+				ids := []uint{}
+				for _, r := range found {
+					ids = append(ids, r.ID)
+				}
+				assert.Equal(t, int64(len(ids)), total)
+				return ids
+			}
+			// Portuguese stemming, with the counterparty name ranking first.
+			assert.Equal(t, []uint{records[2].ID, records[0].ID}, search("pagamentos"))
+			assert.Equal(t, []uint{records[0].ID}, search("aluguel escritório"))
+			// English stemming.
+			assert.Equal(t, []uint{records[1].ID}, search("invoice"))
+			assert.Equal(t, []uint{records[0].ID}, search("NF-100"))
+			// A misspelled counterparty name matches on trigrams.
+			assert.Equal(t, []uint{records[1].ID}, search("distribuidora sanots"))
+			assert.Empty(t, search("payroll"))
+
+			// The search vector follows updates.
+			_, err := s.UpdateFinancialRecord(ctx, 1, records[3].ID, func(r *domain.FinancialRecord) error {
+				r.Description = "Payroll for March"
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{records[3].ID}, search("payroll"))
+		})
+	}
+}
+
+func TestRecurrencesInPostgres(t *testing.T) {
+	clearTables()
+	require.NoError(t, store.EnableRowLevelSecurity(testDB, testTenantRole))
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB).WithRowLevelSecurity(testTenantRole),
+		"pgx":  store.NewPgxStore(pool).WithRowLevelSecurity(testTenantRole),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := store.WithOrganization(context.Background(), 1)
+			start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+
+			tag := domain.Tag{OrganizationID: 1, Name: "Rent"}
+			require.NoError(t, s.CreateTag(ctx, &tag, nil))
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "OUT", Amount: 1000, DueDate: start, Status: domain.StatusPending, Tags: []domain.Tag{tag}, Reference: "lease"},
+				{OrganizationID: 1, Direction: "IN", Amount: 10, DueDate: start, Status: domain.StatusPending},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+
+			rec := domain.Recurrence{RecurrenceRule: domain.RecurrenceRule{Frequency: domain.FrequencyMonthly, Count: 6}}
+			require.NoError(t, s.CreateRecurrence(ctx, 1, records[0].ID, &rec, time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), nil))
+			assert.Equal(t, 3, rec.Generated)
+			err := s.CreateRecurrence(ctx, 1, records[0].ID, &domain.Recurrence{RecurrenceRule: domain.RecurrenceRule{Frequency: domain.FrequencyWeekly}}, start, nil)
+			assert.ErrorIs(t, err, domain.ErrRecordRecurring)
+			err = s.CreateRecurrence(ctx, 2, records[1].ID, &domain.Recurrence{RecurrenceRule: domain.RecurrenceRule{Frequency: domain.FrequencyWeekly}}, start, nil)
+			assert.ErrorIs(t, err, store.ErrNotFound)
+
+			got, err := s.GetRecurrence(ctx, 1, rec.ID)
+			require.NoError(t, err)
+			assert.Equal(t, []uint{tag.ID}, got.TagIDs)
+			assert.True(t, time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC).Equal(*got.NextDate))
+			list, total, err := s.ListRecurrences(ctx, 1, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			require.Len(t, list, 1)
+
+			// The scheduler leaves the series of a deleted organization
+			// alone.
+			scheduler := store.WithActor(context.Background(), store.SchedulerActor)
+			require.NoError(t, testDB.Delete(&domain.Organization{}, 1).Error)
+			n, err := s.MaterializeRecurrences(scheduler, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 0)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			require.NoError(t, testDB.Unscoped().Model(&domain.Organization{}).Where("id = ?", 1).Update("deleted_at", nil).Error)
+
+			// The scheduler creates each occurrence once, within the quota.
+			n, err = s.MaterializeRecurrences(scheduler, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 4)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			n, err = s.MaterializeRecurrences(scheduler, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 0)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			n, err = s.MaterializeRecurrences(scheduler, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 0)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+
+			filter := store.FinancialRecordFilter{Reference: "lease"}
+			occurrences, _, err := s.ListFinancialRecords(ctx, 1, filter, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			require.Len(t, occurrences, 4)
+			for i, o := range occurrences {
+				assert.Equal(t, i, o.Occurrence)
+				require.Len(t, o.Tags, 1, "occurrence %d", i)
+				assert.Equal(t, tag.ID, o.Tags[0].ID)
+			}
+			assert.True(t, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC).Equal(occurrences[1].DueDate))
+
+			// Paying the third occurrence keeps it out of later edits.
+			_, err = s.UpdateFinancialRecord(ctx, 1, occurrences[2].ID, func(r *domain.FinancialRecord) error {
+				return r.Settle(domain.Settlement{PaidAt: start})
+			})
+			require.NoError(t, err)
+
+			// From the second occurrence on, the rent is 1100, due on the
+			// 15th, and the series ends after five occurrences.
+			amount := 1100.0
+			due := time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)
+			rule := domain.RecurrenceRule{Frequency: domain.FrequencyMonthly, Count: 5}
+			change := domain.RecordChange{Amount: &amount, DueDate: &due, Recurrence: &rule}
+			record, err := s.EditRecurrence(ctx, 1, occurrences[1].ID, func(r *domain.Recurrence, o []domain.FinancialRecord) error {
+				return r.EditFuture(o, change)
+			}, time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), 0)
+			require.NoError(t, err)
+			assert.Equal(t, 1100.0, record.Amount)
+			assert.True(t, due.Equal(record.DueDate))
+
+			occurrences, _, err = s.ListFinancialRecords(ctx, 1, filter, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			require.Len(t, occurrences, 5)
+			var amounts []float64
+			for _, o := range occurrences {
+				amounts = append(amounts, o.Amount)
+			}
+			assert.Equal(t, []float64{1000, 1100, 1000, 1100, 1100}, amounts)
+			assert.True(t, time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC).Equal(occurrences[4].DueDate))
+			got, err = s.GetRecurrence(ctx, 1, rec.ID)
+			require.NoError(t, err)
+			assert.Equal(t, 5, got.Generated)
+			assert.Nil(t, got.NextDate)
+
+			// A failed edit saves nothing.
+			_, err = s.EditRecurrence(ctx, 1, occurrences[1].ID, func(r *domain.Recurrence, o []domain.FinancialRecord) error {
+				return r.EditFuture(o, domain.RecordChange{Recurrence: &domain.RecurrenceRule{Frequency: "daily"}})
+			}, start, 0)
+			var verr *domain.ValidationError
+			assert.ErrorAs(t, err, &verr)
+			_, err = s.EditRecurrence(ctx, 1, records[1].ID, func(r *domain.Recurrence, o []domain.FinancialRecord) error {
+				return nil
+			}, start, 0)
+			assert.ErrorIs(t, err, domain.ErrRecordNotRecurring)
+			got, err = s.GetRecurrence(ctx, 1, rec.ID)
+			require.NoError(t, err)
+			assert.Equal(t, 5, got.Count)
+
+			// Shortening the series deletes its last two occurrences, and
+			// extending it again creates them anew, while the deleted ones
+			// cannot be restored next to them.
+			for _, count := range []int{2, 5} {
+				rule := domain.RecurrenceRule{Frequency: domain.FrequencyMonthly, Count: count}
+				_, err = s.EditRecurrence(ctx, 1, occurrences[1].ID, func(r *domain.Recurrence, o []domain.FinancialRecord) error {
+					return r.EditFuture(o, domain.RecordChange{Recurrence: &rule})
+				}, time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), 0)
+				require.NoError(t, err)
+			}
+			extended, _, err := s.ListFinancialRecords(ctx, 1, filter, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			require.Len(t, extended, 5)
+			assert.Equal(t, 4, extended[4].Occurrence)
+			assert.NotEqual(t, occurrences[4].ID, extended[4].ID)
+			assert.True(t, occurrences[4].DueDate.Equal(extended[4].DueDate))
+			_, err = s.RestoreFinancialRecord(ctx, 1, occurrences[4].ID, nil)
+			assert.ErrorIs(t, err, store.ErrDuplicate)
+
+			events, total, err := s.ListAuditEvents(ctx, 1, store.AuditEventFilter{Actor: store.SchedulerActor}, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			require.Len(t, events, 1)
+			assert.Equal(t, domain.AuditEntityFinancialRecord, events[0].EntityType)
+			_, total, err = s.ListAuditEvents(ctx, 1, store.AuditEventFilter{EntityType: domain.AuditEntityRecurrence}, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			assert.Equal(t, int64(4), total)
+
+			// Deleting the recurrence ends the series; its occurrences stay.
+			require.NoError(t, s.DeleteRecurrence(ctx, 1, rec.ID))
+			assert.ErrorIs(t, s.DeleteRecurrence(ctx, 1, rec.ID), store.ErrNotFound)
+			_, err = s.GetRecurrence(ctx, 1, rec.ID)
+			assert.ErrorIs(t, err, store.ErrNotFound)
+			_, err = s.EditRecurrence(ctx, 1, extended[1].ID, func(r *domain.Recurrence, o []domain.FinancialRecord) error {
+				return r.EditFuture(o, domain.RecordChange{Recurrence: &domain.RecurrenceRule{Frequency: domain.FrequencyMonthly}})
+			}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+			assert.ErrorIs(t, err, domain.ErrRecordNotRecurring)
+			n, err = s.MaterializeRecurrences(scheduler, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			count, err := s.CountFinancialRecords(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, int64(6), count)
+		})
+	}
+}
+
+func TestRecordTagsStayInTheirOrganizationInPostgres(t *testing.T) {
+	pool, err := store.NewPgxPool(context.Background(), testDSN, nil)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	stores := map[string]tenantStore{
+		"gorm": store.NewGormStore(testDB),
+		"pgx":  store.NewPgxStore(pool),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			clearTables()
+			ctx := context.Background()
+			other := domain.Organization{Name: "Other", TimeZone: "UTC", Currency: "USD"}
+			require.NoError(t, testDB.Create(&other).Error)
+			own := domain.Tag{OrganizationID: 1, Name: "Own"}
+			gone := domain.Tag{OrganizationID: 1, Name: "Gone"}
+			secret := domain.Tag{OrganizationID: other.ID, Name: "Secret"}
+			for _, tag := range []*domain.Tag{&own, &gone, &secret} {
+				require.NoError(t, s.CreateTag(ctx, tag, nil))
+			}
+			require.NoError(t, s.DeleteTag(ctx, 1, gone.ID))
+
+			// Only the live tags of the record's organization are linked:
+			// not another organization's, not deleted or unknown ones, and
+			// no tag is created on the way.
+			tags := []domain.Tag{
+				{Model: gorm.Model{ID: own.ID}},
+				{Model: gorm.Model{ID: secret.ID}},
+				{Model: gorm.Model{ID: gone.ID}},
+				{Model: gorm.Model{ID: 999999}},
+				{Model: gorm.Model{ID: 999998}, OrganizationID: other.ID, Name: "Planted"},
+			}
+			record := domain.FinancialRecord{OrganizationID: 1, Direction: "OUT", Amount: 10, DueDate: time.Now(), Status: domain.StatusPending, Tags: tags}
+			require.NoError(t, s.CreateFinancialRecord(ctx, &record, nil))
+			records := []domain.FinancialRecord{
+				{OrganizationID: 1, Direction: "IN", Amount: 20, DueDate: time.Now(), Status: domain.StatusPending, Tags: tags},
+			}
+			require.NoError(t, s.CreateFinancialRecords(ctx, records, nil))
+			// The caller and the audit events see the linked tags only.
+			for _, r := range []domain.FinancialRecord{record, records[0]} {
+				require.Len(t, r.Tags, 1)
+				assert.Equal(t, own.ID, r.Tags[0].ID)
+				assert.Equal(t, "Own", r.Tags[0].Name)
+			}
+			events, _, err := s.ListAuditEvents(ctx, 1, store.AuditEventFilter{EntityType: domain.AuditEntityFinancialRecord}, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			require.Len(t, events, 2)
+			for _, event := range events {
+				var after struct{ Tags []uint }
+				require.NoError(t, json.Unmarshal(event.After, &after))
+				assert.Equal(t, []uint{own.ID}, after.Tags)
+			}
+
+			var planted int64
+			require.NoError(t, testDB.Unscoped().Model(&domain.Tag{}).Where("name = ? OR id IN ?", "Planted", []uint{999998, 999999}).Count(&planted).Error)
+			assert.Zero(t, planted)
+			found, _, err := s.ListFinancialRecords(ctx, 1, store.FinancialRecordFilter{}, store.Page{Number: 1, Size: 10})
+			require.NoError(t, err)
+			require.Len(t, found, 2)
+			for _, r := range found {
+				require.Len(t, r.Tags, 1)
+				assert.Equal(t, own.ID, r.Tags[0].ID)
+			}
+			var links int64
+			require.NoError(t, testDB.Table("financial_record_tags").Count(&links).Error)
+			assert.Equal(t, int64(2), links)
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+)
+
+// jwtLeeway absorbs the clock skew between the identity provider and the
+// server when checking the "exp" and "nbf" claims.
+const jwtLeeway = time.Minute
+
+// minRSAKeyBits is the smallest RSA key accepted to verify tokens.
+const minRSAKeyBits = 2048
+
+// JWTAuthenticator authenticates the JWTs of the identity provider, signed
+// with HS256 or RS256 by one of the configured keys. The "sub" claim names
+// the user, and the "org_ids" claim lists the organizations the user may
+// read and write. Tokens must carry an "exp" claim.
+type JWTAuthenticator struct {
+	keys     []jwtKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// jwtKey verifies the signatures of one algorithm. An empty id matches
+// tokens without a "kid" header, and tokens naming any key.
+type jwtKey struct {
+	id     string
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+}
+
+// NewJWTAuthenticator loads the keys of cfg.
+func NewJWTAuthenticator(cfg config.JWTConfig) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{issuer: cfg.Issuer, audience: cfg.Audience, now: time.Now}
+	if cfg.HMACSecret != "" {
+		a.keys = append(a.keys, jwtKey{alg: "HS256", secret: []byte(cfg.HMACSecret)})
+	}
+	if cfg.RSAPublicKeyFile != "" {
+		key, err := loadRSAPublicKey(cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, jwtKey{alg: "RS256", public: key})
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, keys...)
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("jwt: no verification key configured")
+	}
+	return a, nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: %s holds no PEM block", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parse public key %s: %w", path, err)
+	}
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt: %s is not an RSA public key", path)
+	}
+	if err := checkRSAKeySize(public); err != nil {
+		return nil, fmt.Errorf("jwt: %s: %w", path, err)
+	}
+	return public, nil
+}
+
+func checkRSAKeySize(key *rsa.PublicKey) error {
+	if key.N.BitLen() < minRSAKeyBits {
+		return fmt.Errorf("RSA keys must have at least %d bits", minRSAKeyBits)
+	}
+	return nil
+}
+
+// loadJWKS reads the RSA ("kty": "RSA") and symmetric ("kty": "oct") keys
+// of a JSON Web Key Set. Keys meant for encryption are skipped.
+func loadJWKS(path string) ([]jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: read JWKS: %w", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwt: parse JWKS %s: %w", path, err)
+	}
+
+	var keys []jwtKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			if k.Alg != "" && k.Alg != "RS256" {
+				continue
+			}
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("jwt: JWKS %s: key %d has an invalid modulus or exponent", path, i)
+			}
+			public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if err := checkRSAKeySize(public); err != nil {
+				return nil, fmt.Errorf("jwt: JWKS %s: key %d: %w", path, i, err)
+			}
+			keys = append(keys, jwtKey{id: k.Kid, alg: "RS256", public: public})
+		case "oct":
+			if k.Alg != "" && k.Alg != "HS256" {
+				continue
+			}
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("jwt: JWKS %s: key %d has an invalid secret", path, i)
+			}
+			keys = append(keys, jwtKey{id: k.Kid, alg: "HS256", secret: secret})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwt: JWKS %s holds no HS256 or RS256 signing key", path)
+	}
+	return keys, nil
+}
+
+// jwtClaims are the claims read from a token.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+	OrgIDs    jwtOrgIDs   `json:"org_ids"`
+}
+
+// jwtAudience is the "aud" claim, either a string or an array of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*a = jwtAudience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// jwtOrgIDs is the "org_ids" claim, an array of organization IDs given as
+// numbers or as strings.
+type jwtOrgIDs []uint
+
+func (ids *jwtOrgIDs) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*ids = make(jwtOrgIDs, len(raw))
+	for i, r := range raw {
+		s := strings.Trim(string(r), `"`)
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return fmt.Errorf("org_ids[%d] is not an organization ID", i)
+		}
+		(*ids)[i] = uint(id)
+	}
+	return nil
+}
+
+func (a *JWTAuthenticator) Authenticate(_ context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnrecognizedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	if !a.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], signature) {
+		return nil, fmt.Errorf("%w: no %s key with id %q verifies the signature", ErrInvalidToken, header.Alg, header.Kid)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := a.validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	p := &Principal{Subject: "user:" + claims.Subject}
+	for _, id := range claims.OrgIDs {
+		p.Grants = append(p.Grants, domain.APIKeyGrant{OrganizationID: id, Scope: domain.ScopeWrite})
+	}
+	return p, nil
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verify reports whether a key of alg, named kid, signed the input. Only
+// HS256 and RS256 are accepted, which rules out unsigned tokens.
+func (a *JWTAuthenticator) verify(alg, kid, input string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(input))
+	for _, k := range a.keys {
+		if k.alg != alg || (kid != "" && k.id != "" && k.id != kid) {
+			continue
+		}
+		switch alg {
+		case "HS256":
+			mac := hmac.New(sha256.New, k.secret)
+			mac.Write([]byte(input))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		case "RS256":
+			if rsa.VerifyPKCS1v15(k.public, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *JWTAuthenticator) validate(claims jwtClaims) error {
+	now := a.now()
+	switch {
+	case claims.Subject == "":
+		return errors.New("missing sub claim")
+	case claims.ExpiresAt == nil:
+		return errors.New("missing exp claim")
+	case now.After(numericDate(*claims.ExpiresAt).Add(jwtLeeway)):
+		return errors.New("token expired")
+	case claims.NotBefore != nil && now.Add(jwtLeeway).Before(numericDate(*claims.NotBefore)):
+		return errors.New("token not valid yet")
+	case a.issuer != "" && claims.Issuer != a.issuer:
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case a.audience != "" && !slices.Contains(claims.Audience, a.audience):
+		return errors.New("token not meant for this audience")
+	}
+	return nil
+}
+
+// numericDate converts a JWT NumericDate, in seconds since the epoch.
+func numericDate(seconds float64) time.Time {
+	return time.UnixMilli(int64(seconds * 1000))
+}
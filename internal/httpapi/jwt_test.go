@@ -0,0 +1,351 @@
+package httpapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+const testJWTSecret = "test-jwt-secret"
+
+// signJWT encodes claims into a token signed with key: a []byte for HS256
+// or an *rsa.PrivateKey for RS256.
+func signJWT(t *testing.T, header, claims map[string]any, key any) string {
+	t.Helper()
+	segment := func(v any) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := segment(header) + "." + segment(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(input))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func hs256(kid string) map[string]any {
+	h := map[string]any{"alg": "HS256", "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	return h
+}
+
+func rs256(kid string) map[string]any {
+	h := hs256(kid)
+	h["alg"] = "RS256"
+	return h
+}
+
+// userClaims are valid claims for a user of the organizations.
+func userClaims(orgIDs ...any) map[string]any {
+	return map[string]any{
+		"sub":     "user-42",
+		"iss":     "https://id.example.com",
+		"aud":     "financial-records",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"org_ids": orgIDs,
+	}
+}
+
+func with(claims map[string]any, name string, value any) map[string]any {
+	out := map[string]any{}
+	for k, v := range claims {
+		out[k] = v
+	}
+	if value == nil {
+		delete(out, name)
+	} else {
+		out[name] = value
+	}
+	return out
+}
+
+// writeKeyFiles writes the public key of key as PEM and as a one-key JWKS
+// with id kid, and returns their paths.
+func writeKeyFiles(t *testing.T, key *rsa.PrivateKey, kid string) (pemPath, jwksPath string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pemPath = filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]any{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	require.NoError(t, err)
+	jwksPath = filepath.Join(dir, "jwks.json")
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0o600))
+	return pemPath, jwksPath
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pemPath, jwksPath := writeKeyFiles(t, key, "key-1")
+	_, otherJWKSPath := writeKeyFiles(t, other, "key-2")
+
+	secret := []byte(testJWTSecret)
+	tests := []struct {
+		name  string
+		cfg   config.JWTConfig
+		token func(t *testing.T) string
+		want  []uint // organizations granted, nil when the token is rejected
+	}{
+		{
+			"HS256",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string { return signJWT(t, hs256(""), userClaims(1, 2), secret) },
+			[]uint{1, 2},
+		},
+		{
+			"RS256 with a PEM key",
+			config.JWTConfig{RSAPublicKeyFile: pemPath},
+			func(t *testing.T) string { return signJWT(t, rs256(""), userClaims(3), key) },
+			[]uint{3},
+		},
+		{
+			"RS256 with a JWKS key selected by kid",
+			config.JWTConfig{JWKSFile: jwksPath, Issuer: "https://id.example.com", Audience: "financial-records"},
+			func(t *testing.T) string { return signJWT(t, rs256("key-1"), userClaims(1), key) },
+			[]uint{1},
+		},
+		{
+			"organization IDs as strings and audience as an array",
+			config.JWTConfig{HMACSecret: testJWTSecret, Audience: "financial-records"},
+			func(t *testing.T) string {
+				claims := with(userClaims("1", "7"), "aud", []string{"other", "financial-records"})
+				return signJWT(t, hs256(""), claims, secret)
+			},
+			[]uint{1, 7},
+		},
+		{
+			"no organizations",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string { return signJWT(t, hs256(""), with(userClaims(), "org_ids", nil), secret) },
+			[]uint{},
+		},
+		{
+			"expired within the leeway",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string {
+				return signJWT(t, hs256(""), with(userClaims(1), "exp", time.Now().Add(-30*time.Second).Unix()), secret)
+			},
+			[]uint{1},
+		},
+		{
+			"expired",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string {
+				return signJWT(t, hs256(""), with(userClaims(1), "exp", time.Now().Add(-time.Hour).Unix()), secret)
+			},
+			nil,
+		},
+		{
+			"not valid yet",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string {
+				return signJWT(t, hs256(""), with(userClaims(1), "nbf", time.Now().Add(time.Hour).Unix()), secret)
+			},
+			nil,
+		},
+		{
+			"without exp",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string { return signJWT(t, hs256(""), with(userClaims(1), "exp", nil), secret) },
+			nil,
+		},
+		{
+			"without sub",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string { return signJWT(t, hs256(""), with(userClaims(1), "sub", nil), secret) },
+			nil,
+		},
+		{
+			"wrong issuer",
+			config.JWTConfig{HMACSecret: testJWTSecret, Issuer: "https://other.example.com"},
+			func(t *testing.T) string { return signJWT(t, hs256(""), userClaims(1), secret) },
+			nil,
+		},
+		{
+			"wrong audience",
+			config.JWTConfig{HMACSecret: testJWTSecret, Audience: "billing"},
+			func(t *testing.T) string { return signJWT(t, hs256(""), userClaims(1), secret) },
+			nil,
+		},
+		{
+			"wrong secret",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string { return signJWT(t, hs256(""), userClaims(1), []byte("guess")) },
+			nil,
+		},
+		{
+			"key of another JWKS",
+			config.JWTConfig{JWKSFile: otherJWKSPath},
+			func(t *testing.T) string { return signJWT(t, rs256("key-2"), userClaims(1), key) },
+			nil,
+		},
+		{
+			"unknown kid",
+			config.JWTConfig{JWKSFile: jwksPath},
+			func(t *testing.T) string { return signJWT(t, rs256("key-9"), userClaims(1), key) },
+			nil,
+		},
+		{
+			"HS256 signed with the RSA public key",
+			config.JWTConfig{RSAPublicKeyFile: pemPath},
+			func(t *testing.T) string {
+				public, err := os.ReadFile(pemPath)
+				require.NoError(t, err)
+				return signJWT(t, hs256(""), userClaims(1), public)
+			},
+			nil,
+		},
+		{
+			"unsigned",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string {
+				token := signJWT(t, map[string]any{"alg": "none"}, userClaims(1), secret)
+				return token[:strings.LastIndex(token, ".")+1]
+			},
+			nil,
+		},
+		{
+			"tampered claims",
+			config.JWTConfig{HMACSecret: testJWTSecret},
+			func(t *testing.T) string {
+				parts := strings.Split(signJWT(t, hs256(""), userClaims(1), secret), ".")
+				forged := strings.Split(signJWT(t, hs256(""), userClaims(1, 2, 3), []byte("guess")), ".")
+				return parts[0] + "." + forged[1] + "." + parts[2]
+			},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewJWTAuthenticator(tt.cfg)
+			require.NoError(t, err)
+
+			p, err := a.Authenticate(context.Background(), tt.token(t))
+			if tt.want == nil {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user:user-42", p.Subject)
+			assert.False(t, p.Admin)
+			orgIDs := []uint{}
+			for _, g := range p.Grants {
+				assert.Equal(t, domain.ScopeWrite, g.Scope)
+				orgIDs = append(orgIDs, g.OrganizationID)
+			}
+			assert.Equal(t, tt.want, orgIDs)
+		})
+	}
+}
+
+func TestJWTAuthenticatorConfig(t *testing.T) {
+	_, err := NewJWTAuthenticator(config.JWTConfig{})
+	assert.Error(t, err)
+
+	_, err = NewJWTAuthenticator(config.JWTConfig{RSAPublicKeyFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	pemPath, jwksPath := writeKeyFiles(t, weak, "weak")
+	_, err = NewJWTAuthenticator(config.JWTConfig{RSAPublicKeyFile: pemPath})
+	assert.ErrorContains(t, err, "at least 2048 bits")
+	_, err = NewJWTAuthenticator(config.JWTConfig{JWKSFile: jwksPath})
+	assert.ErrorContains(t, err, "at least 2048 bits")
+
+	a, err := NewJWTAuthenticator(config.JWTConfig{HMACSecret: testJWTSecret})
+	require.NoError(t, err)
+	_, err = a.Authenticate(context.Background(), "frk_not-a-jwt")
+	assert.ErrorIs(t, err, ErrUnrecognizedToken)
+}
+
+func TestJWTAuthorizesOrganizationRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mem := newMemoryStore()
+	jwtAuth, err := NewJWTAuthenticator(config.JWTConfig{HMACSecret: testJWTSecret})
+	require.NoError(t, err)
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+		Auth:   NewAuth(jwtAuth, NewAPIKeyAuthenticator(mem, testAdminKey, time.Hour)),
+	})
+	token := signJWT(t, hs256(""), userClaims(1), []byte(testJWTSecret))
+
+	w := serveAs(r, token, "", "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serveAs(r, token, "", "GET", "/api/v1/organizations/2/tags", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serveAs(r, token, "", "GET", "/api/v1/organizations", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// API keys keep working next to JWTs.
+	w = serveAs(r, testAdminKey, "", "GET", "/api/v1/organizations/2/tags", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serveAs(r, signJWT(t, hs256(""), userClaims(1), []byte("guess")), "", "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestPrincipalIsExposedToHandlers(t *testing.T) {
+	jwtAuth, err := NewJWTAuthenticator(config.JWTConfig{HMACSecret: testJWTSecret})
+	require.NoError(t, err)
+	r := gin.New()
+	r.Use(Problems())
+	r.GET("/organizations/:organizationId/whoami", NewAuth(jwtAuth).Middleware(), func(c *gin.Context) {
+		p, ok := PrincipalFromContext(c.Request.Context())
+		require.True(t, ok)
+		c.String(http.StatusOK, p.Subject)
+	})
+
+	w := serveAs(r, signJWT(t, hs256(""), userClaims(1), []byte(testJWTSecret)), "", "GET", "/organizations/1/whoami", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user:user-42", w.Body.String())
+}
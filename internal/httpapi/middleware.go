@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/telemetry"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestID reuses the caller's X-Request-ID header or generates a new one,
+// echoes it on the response and stores it in the request context so that
+// database logs can be correlated with the access log.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(c.GetHeader(RequestIDHeader))
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		c.Request = c.Request.WithContext(telemetry.WithRequestID(c.Request.Context(), id))
+
+		c.Next()
+	}
+}
+
+// AccessLog writes one structured line per request with its route,
+// organization, latency and the time spent in the database. It must be
+// registered after RequestID.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		ctx := c.Request.Context()
+
+		attrs := []slog.Attr{
+			slog.String("request_id", telemetry.RequestIDFromContext(ctx)),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", latency),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if orgID := c.Param("organizationId"); orgID != "" {
+			attrs = append(attrs, slog.String("organization_id", orgID))
+		}
+		if p, ok := PrincipalFromContext(ctx); ok {
+			attrs = append(attrs, slog.String("subject", p.Subject))
+		}
+		if dbTime, queries, ok := telemetry.DBTimeFromContext(ctx); ok {
+			attrs = append(attrs, slog.Duration("db_time", dbTime), slog.Int("db_queries", queries))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(ctx, level, "request completed", attrs...)
+	}
+}
@@ -0,0 +1,18 @@
+package httpapi
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPISpec is the OpenAPI 3 document describing every route of the
+// router. The tests validate real responses against it.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+func serveOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", OpenAPISpec)
+}
@@ -0,0 +1,303 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+func loadOpenAPISpec(t *testing.T) *openapi3.T {
+	t.Helper()
+	doc, err := openapi3.NewLoader().LoadFromData(OpenAPISpec)
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	return doc
+}
+
+// ginParam matches the ":name" path parameters of gin routes.
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+	r, _ := newTestRouter()
+
+	mounted := map[string]bool{}
+	for _, route := range r.Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		mounted[route.Method+" "+path] = true
+
+		item := doc.Paths.Value(path)
+		if assert.NotNil(t, item, "path %s is not in openapi.json", path) {
+			assert.NotNil(t, item.GetOperation(route.Method), "%s %s is not in openapi.json", route.Method, path)
+		}
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, mounted[method+" "+path], "%s %s is in openapi.json but not mounted", method, path)
+		}
+	}
+}
+
+// specValidator serves requests through the router and checks both the
+// request and the response against the OpenAPI document.
+type specValidator struct {
+	t      *testing.T
+	router *gin.Engine
+	spec   routers.Router
+}
+
+func newSpecValidator(t *testing.T) *specValidator {
+	doc := loadOpenAPISpec(t)
+	spec, err := gorillamux.NewRouter(doc)
+	require.NoError(t, err)
+
+	r, _ := newTestRouter()
+	return &specValidator{t: t, router: r, spec: spec}
+}
+
+func (v *specValidator) serve(method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	v.t.Helper()
+
+	var raw []byte
+	if body != nil {
+		var err error
+		raw, err = json.Marshal(body)
+		require.NoError(v.t, err)
+	}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	v.router.ServeHTTP(w, newRequest())
+
+	req := newRequest()
+	route, params, err := v.spec.FindRoute(req)
+	require.NoError(v.t, err, "%s %s is not in openapi.json", method, path)
+
+	ctx := context.Background()
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		// The router checks API keys; the document only declares them.
+		Options: &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	if w.Code < 400 {
+		// Rejected requests are expected not to match the document.
+		assert.NoError(v.t, openapi3filter.ValidateRequest(ctx, input), "request %s %s", method, path)
+	}
+
+	err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 w.Code,
+		Header:                 w.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(w.Body.Bytes())),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	assert.NoError(v.t, err, "response to %s %s (%d): %s", method, path, w.Code, w.Body.String())
+	return w
+}
+
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	v := newSpecValidator(t)
+	now := time.Now().UTC()
+
+	w := v.serve("POST", "/api/v1/organizations", map[string]any{"name": "Initech", "timeZone": "America/Chicago", "currency": "USD"}, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	v.serve("POST", "/api/v1/organizations", map[string]any{"name": "Initech", "currency": "usd"}, nil)
+	v.serve("GET", "/api/v1/organizations?page=1&page_size=2", nil, nil)
+	v.serve("GET", "/api/v1/organizations/4", nil, nil)
+	v.serve("PUT", "/api/v1/organizations/4", map[string]any{"name": "Initrode"}, nil)
+	w = v.serve("DELETE", "/api/v1/organizations/4", nil, nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	v.serve("GET", "/api/v1/organizations/4", nil, nil)
+	v.serve("GET", "/api/v1/organizations/4/tags", nil, nil)
+
+	w = v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	tag := decode[domain.Tag](t, w)
+
+	v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "rent"}, nil)
+	v.serve("POST", "/api/v1/organizations/1/tags?upsert=true", map[string]any{"name": "RENT"}, nil)
+	v.serve("POST", "/api/v1/organizations/1/tags", "not an object", nil)
+	v.serve("POST", "/api/v1/organizations/abc/tags", map[string]any{"name": "Rent"}, nil)
+	v.serve("GET", "/api/v1/organizations/1/tags?page=1&page_size=10", nil, nil)
+	v.serve("GET", "/api/v1/organizations/2/tags", nil, nil)
+
+	record := map[string]any{"direction": "OUT", "amount": 100, "dueDate": now, "tags": []map[string]any{{"ID": tag.ID}}}
+	w = v.serve("POST", "/api/v1/organizations/1/financial-records", record, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	created := decode[domain.FinancialRecord](t, w)
+	v.serve("POST", "/api/v1/organizations/1/financial-records", map[string]any{"direction": "SIDEWAYS", "amount": 1, "dueDate": now}, nil)
+
+	bulk := []map[string]any{
+		{"direction": "IN", "amount": 250.5, "dueDate": now, "description": "Consulting", "counterpartyName": "ACME",
+			"counterpartyDocument": "12.345.678/0001-90", "reference": "NF-1", "metadata": map[string]any{"project": "apollo"}},
+		{"direction": "OUT", "amount": 10, "dueDate": now, "tags": []map[string]any{{"ID": tag.ID}}},
+	}
+	key := http.Header{IdempotencyKeyHeader: {"bulk-1"}}
+	w = v.serve("POST", "/api/v1/organizations/1/financial-records/bulk", bulk, key)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	v.serve("GET", "/api/v1/organizations/1/financial-records", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records?tags=1&page=2&page_size=1", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records?tags=x", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records?counterparty=acme&reference=NF-1&metadata=%7B%22project%22%3A%22apollo%22%7D", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records?metadata=oops", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records?q=consulting", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records/reports/cash-flow", nil, nil)
+	v.serve("GET", "/api/v1/organizations/3/financial-records/reports/cash-flow", nil, nil)
+	recordPath := "/api/v1/organizations/1/financial-records/" + itoa(created.ID)
+	v.serve("DELETE", recordPath, nil, nil)
+	v.serve("DELETE", recordPath, nil, nil)
+	v.serve("DELETE", "/api/v1/organizations/1/financial-records/x", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records?include_deleted=true", nil, nil)
+	v.serve("POST", recordPath+"/restore", nil, nil)
+	v.serve("POST", "/api/v1/organizations/1/financial-records/99/restore", nil, nil)
+	v.serve("POST", recordPath+"/settle", map[string]any{"amount": 40, "paidAt": now}, nil)
+	v.serve("POST", recordPath+"/settle", map[string]any{"amount": 1000}, nil)
+	v.serve("POST", recordPath+"/settle", nil, nil)
+	v.serve("POST", recordPath+"/settle", nil, nil)
+	v.serve("POST", recordPath+"/cancel", nil, nil)
+	v.serve("POST", "/api/v1/organizations/1/financial-records/99/cancel", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records/reports/cash-flow?basis=paid", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/financial-records/reports/cash-flow?basis=cash", nil, nil)
+
+	w = v.serve("POST", "/api/v1/organizations/1/financial-records", map[string]any{"direction": "OUT", "amount": 900, "dueDate": now.AddDate(0, -1, 0)}, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	rentPath := "/api/v1/organizations/1/financial-records/" + itoa(decode[domain.FinancialRecord](t, w).ID)
+	w = v.serve("POST", rentPath+"/recurrence", map[string]any{"frequency": "monthly", "dayOfMonth": 5, "count": 12}, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	recurrencePath := "/api/v1/organizations/1/recurrences/" + itoa(decode[domain.Recurrence](t, w).ID)
+	v.serve("POST", rentPath+"/recurrence", map[string]any{"frequency": "monthly"}, nil)
+	v.serve("POST", recordPath+"/recurrence", map[string]any{"frequency": "hourly"}, nil)
+	v.serve("GET", "/api/v1/organizations/1/recurrences", nil, nil)
+	v.serve("GET", recurrencePath, nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/recurrences/99", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/recurrences/x", nil, nil)
+	v.serve("PATCH", rentPath, map[string]any{"description": "Rent", "metadata": map[string]any{"unit": "4B"}}, nil)
+	w = v.serve("PATCH", rentPath+"?scope=future", map[string]any{"amount": 950, "metadata": nil, "recurrence": map[string]any{"frequency": "monthly", "endDate": now.AddDate(1, 0, 0)}}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	v.serve("PATCH", rentPath+"?scope=always", map[string]any{"amount": 950}, nil)
+	v.serve("PATCH", rentPath, map[string]any{"recurrence": map[string]any{"frequency": "weekly"}}, nil)
+	v.serve("PATCH", recordPath+"?scope=future", map[string]any{"amount": 1}, nil)
+	v.serve("GET", "/api/v1/organizations/1/audit-events?entity_type=recurrence", nil, nil)
+
+	tagPath := "/api/v1/organizations/1/tags/" + itoa(tag.ID)
+	v.serve("DELETE", tagPath, nil, nil)
+	v.serve("DELETE", tagPath, nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/tags?include_deleted=true", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/tags?include_deleted=maybe", nil, nil)
+	w = v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	v.serve("POST", tagPath+"/restore", nil, nil)
+	v.serve("DELETE", "/api/v1/organizations/1/tags/"+itoa(decode[domain.Tag](t, w).ID), nil, nil)
+	v.serve("POST", tagPath+"/restore", nil, nil)
+
+	v.serve("GET", "/api/v1/organizations/1/audit-events", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/audit-events?entity_type=tag&action=create&since=2024-01-01T00:00:00Z&page_size=1", nil, nil)
+	v.serve("GET", "/api/v1/organizations/1/audit-events?action=rename", nil, nil)
+	v.serve("GET", "/api/v1/organizations/4/audit-events", nil, nil)
+
+	w = v.serve("GET", "/openapi.json", nil, nil)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+	assert.JSONEq(t, string(OpenAPISpec), w.Body.String())
+	v.serve("GET", "/debug/vars", nil, nil)
+}
+
+func TestIdempotencyErrorsMatchOpenAPI(t *testing.T) {
+	v := newSpecValidator(t)
+	v.router = newIdempotentTestRouter()
+
+	key := http.Header{IdempotencyKeyHeader: {"key-1"}}
+	w := v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, key)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, key)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Payroll"}, key)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestAuthErrorsMatchOpenAPI(t *testing.T) {
+	v := newSpecValidator(t)
+	v.router, _ = newAuthTestRouter(nil)
+	admin := http.Header{"Authorization": {"Bearer " + testAdminKey}}
+
+	w := v.serve("POST", "/api/v1/api-keys", map[string]any{"name": "reader", "grants": []grant{{1, domain.ScopeRead}}}, admin)
+	require.Equal(t, http.StatusCreated, w.Code)
+	key := decode[issuedAPIKey](t, w)
+	v.serve("POST", "/api/v1/api-keys", map[string]any{"name": "reader", "grants": []grant{{99, "admin"}}}, admin)
+	v.serve("GET", "/api/v1/api-keys", nil, admin)
+
+	w = v.serve("GET", "/api/v1/organizations/1/tags", nil, nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	reader := http.Header{"Authorization": {"Bearer " + key.Secret}}
+	v.serve("GET", "/api/v1/organizations/1/tags", nil, reader)
+	w = v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, reader)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	v.serve("GET", "/api/v1/api-keys", nil, reader)
+
+	path := "/api/v1/api-keys/" + strconv.FormatUint(uint64(key.ID), 10)
+	w = v.serve("DELETE", path, nil, admin)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	v.serve("DELETE", path, nil, admin)
+	v.serve("GET", "/openapi.json", nil, nil)
+	v.serve("GET", "/debug/vars", nil, nil)
+	v.serve("GET", "/debug/vars", nil, reader)
+	v.serve("GET", "/debug/vars", nil, admin)
+}
+
+func TestRateLimitAndQuotaErrorsMatchOpenAPI(t *testing.T) {
+	v := newSpecValidator(t)
+	mem := newMemoryStore()
+	v.router = NewRouter(Deps{
+		Stores:     store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger:     slog.New(slog.DiscardHandler),
+		Config:     config.Config{MaxTagsPerOrganization: 1, MaxFinancialRecordsPerOrganization: 1},
+		RateLimits: &RateLimits{Reads: ClassRateLimits{Organizations: NewRateLimiter(0.001, 1)}},
+	})
+
+	w := v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Rent"}, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = v.serve("POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Payroll"}, nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	v.serve("POST", "/api/v1/organizations/1/financial-records/bulk", []map[string]any{
+		{"direction": "IN", "amount": 1, "dueDate": "2024-01-15T00:00:00Z"},
+		{"direction": "OUT", "amount": 2, "dueDate": "2024-01-16T00:00:00Z"},
+	}, nil)
+
+	w = v.serve("GET", "/api/v1/organizations/1/tags", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = v.serve("GET", "/api/v1/organizations/1/tags", nil, nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+}
@@ -0,0 +1,266 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// organizationCache remembers the organizations that exist, so that routes
+// nested under an organization do not look it up on every request. Only
+// organizations that were found are remembered, for ttl: a new organization
+// is usable at once, and one deleted or updated through another server
+// instance is still served as it was for at most ttl. A zero ttl looks up
+// every request.
+type organizationCache struct {
+	orgs store.OrganizationStore
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	found map[uint]cachedOrganization
+}
+
+type cachedOrganization struct {
+	org     *domain.Organization
+	expires time.Time
+}
+
+func newOrganizationCache(orgs store.OrganizationStore, ttl time.Duration) *organizationCache {
+	return &organizationCache{orgs: orgs, ttl: ttl, found: map[uint]cachedOrganization{}}
+}
+
+// get returns the organization, store.ErrNotFound when it does not exist,
+// and the lookup error otherwise.
+func (oc *organizationCache) get(ctx context.Context, id uint) (*domain.Organization, error) {
+	oc.mu.Lock()
+	cached, ok := oc.found[id]
+	oc.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.org, nil
+	}
+
+	org, err := oc.orgs.GetOrganization(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if oc.ttl > 0 {
+		oc.mu.Lock()
+		oc.found[id] = cachedOrganization{org: org, expires: time.Now().Add(oc.ttl)}
+		oc.mu.Unlock()
+	}
+	return org, nil
+}
+
+// forget drops the organization from the cache once it is updated or
+// deleted.
+func (oc *organizationCache) forget(id uint) {
+	oc.mu.Lock()
+	delete(oc.found, id)
+	oc.mu.Unlock()
+}
+
+// Middleware responds with 404 unless the :organizationId of the request
+// names an existing organization, which it keeps for requestOrganization. It
+// names the organization in the request context for the stores, which
+// restrict the request to it when row-level security is enabled.
+func (oc *organizationCache) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		org, err := oc.get(c.Request.Context(), orgID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				err = organizationNotFound()
+			}
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Set(organizationKey, org)
+		c.Request = c.Request.WithContext(store.WithOrganization(c.Request.Context(), orgID))
+		c.Next()
+	}
+}
+
+// organizationKey holds the organization of the request in the gin context.
+const organizationKey = "organization"
+
+// requestOrganization returns the organization found by the organization
+// middleware, or one with the default settings on routes without it.
+func requestOrganization(c *gin.Context) *domain.Organization {
+	if org, ok := c.Get(organizationKey); ok {
+		return org.(*domain.Organization)
+	}
+	return &domain.Organization{TimeZone: domain.DefaultTimeZone, Currency: domain.DefaultCurrency}
+}
+
+func organizationNotFound() *Problem {
+	return NewProblem(http.StatusNotFound, CodeOrganizationNotFound, "The organization does not exist")
+}
+
+// organizationInput is the body of the create and update requests. Settings
+// left empty take their defaults.
+type organizationInput struct {
+	Name                string `json:"name"`
+	TimeZone            string `json:"timeZone"`
+	Currency            string `json:"currency"`
+	MaxTags             *int   `json:"maxTags"`
+	MaxFinancialRecords *int   `json:"maxFinancialRecords"`
+}
+
+// bindOrganization decodes and validates the request body into org.
+func bindOrganization(c *gin.Context, org *domain.Organization) bool {
+	var in organizationInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.Error(bindProblem(err))
+		return false
+	}
+	org.Name = strings.TrimSpace(in.Name)
+	org.TimeZone = in.TimeZone
+	org.Currency = in.Currency
+	org.MaxTags, org.MaxFinancialRecords = in.MaxTags, in.MaxFinancialRecords
+	org.SetDefaults()
+
+	if err := org.Validate(); err != nil {
+		c.Error(err)
+		return false
+	}
+	return true
+}
+
+func createOrganization(orgStore store.OrganizationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var org domain.Organization
+		if !bindOrganization(c, &org) {
+			return
+		}
+
+		if err := orgStore.CreateOrganization(c.Request.Context(), &org); err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, org)
+	}
+}
+
+func listOrganizations(orgStore store.OrganizationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page := pagination(c)
+
+		orgs, total, err := orgStore.ListOrganizations(c.Request.Context(), page)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, paginated(orgs, page, total))
+	}
+}
+
+func getOrganization(orgStore store.OrganizationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+
+		org, err := orgStore.GetOrganization(c.Request.Context(), orgID)
+		if errors.Is(err, store.ErrNotFound) {
+			err = organizationNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, org)
+	}
+}
+
+func updateOrganization(orgStore store.OrganizationStore, orgs *organizationCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		var org domain.Organization
+		org.ID = orgID
+		if !bindOrganization(c, &org) {
+			return
+		}
+		if p, ok := PrincipalFromContext(c.Request.Context()); ok && !p.Admin && !keepLimits(c, orgStore, &org) {
+			return
+		}
+
+		err := orgStore.UpdateOrganization(c.Request.Context(), &org)
+		if errors.Is(err, store.ErrNotFound) {
+			err = organizationNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		orgs.forget(orgID)
+
+		c.JSON(http.StatusOK, org)
+	}
+}
+
+// keepLimits gives org, updated by a caller other than the admin key, the
+// limits the organization has: the caller may leave them out or repeat
+// them, but changing them is rejected with 403.
+func keepLimits(c *gin.Context, orgStore store.OrganizationStore, org *domain.Organization) bool {
+	current, err := orgStore.GetOrganization(c.Request.Context(), org.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		err = organizationNotFound()
+	}
+	if err != nil {
+		c.Error(err)
+		return false
+	}
+	if !sameLimit(org.MaxTags, current.MaxTags) || !sameLimit(org.MaxFinancialRecords, current.MaxFinancialRecords) {
+		c.Error(NewProblem(http.StatusForbidden, CodeForbidden, "Only the admin key can change the organization's limits"))
+		return false
+	}
+	org.MaxTags, org.MaxFinancialRecords = current.MaxTags, current.MaxFinancialRecords
+	return true
+}
+
+// sameLimit reports whether the limit given in an update, nil when left
+// out, keeps the current one.
+func sameLimit(given, current *int) bool {
+	return given == nil || current != nil && *given == *current
+}
+
+func deleteOrganization(orgStore store.OrganizationStore, orgs *organizationCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+
+		err := orgStore.DeleteOrganization(c.Request.Context(), orgID)
+		if errors.Is(err, store.ErrNotFound) {
+			err = organizationNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		orgs.forget(orgID)
+
+		noContent(c)
+	}
+}
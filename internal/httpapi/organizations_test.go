@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+func TestOrganizationLifecycle(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations", map[string]any{"name": "  Initech ", "timeZone": "America/Chicago"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	org := decode[domain.Organization](t, w)
+	assert.Equal(t, uint(testOrganizations+1), org.ID)
+	assert.Equal(t, "Initech", org.Name)
+	assert.Equal(t, "America/Chicago", org.TimeZone)
+	assert.Equal(t, domain.DefaultCurrency, org.Currency)
+
+	path := "/api/v1/organizations/4"
+	w = serve(r, "GET", path, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, org.Name, decode[domain.Organization](t, w).Name)
+
+	w = serve(r, "PUT", path, map[string]any{"name": "Initrode", "currency": "EUR"})
+	require.Equal(t, http.StatusOK, w.Code)
+	updated := decode[domain.Organization](t, w)
+	assert.Equal(t, "Initrode", updated.Name)
+	assert.Equal(t, domain.DefaultTimeZone, updated.TimeZone, "settings left out revert to their defaults")
+	assert.Equal(t, "EUR", updated.Currency)
+
+	w = serve(r, "GET", "/api/v1/organizations?page=2&page_size=3", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	list := decode[listResponse[domain.Organization]](t, w)
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, "Initrode", list.Data[0].Name)
+	assert.Equal(t, int64(testOrganizations+1), list.Pagination.TotalItems)
+
+	w = serve(r, "POST", path+"/tags", map[string]any{"name": "Rent"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(r, "DELETE", path, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", path},
+		{"PUT", path},
+		{"DELETE", path},
+		{"GET", path + "/tags"},
+		{"POST", path + "/financial-records"},
+		{"GET", path + "/financial-records/reports/cash-flow"},
+	} {
+		w = serve(r, req.method, req.path, map[string]any{"name": "Initrode"})
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s %s", req.method, req.path)
+		assert.Equal(t, CodeOrganizationNotFound, decode[Problem](t, w).Code, "%s %s", req.method, req.path)
+	}
+
+	w = serve(r, "GET", "/api/v1/organizations", nil)
+	assert.Equal(t, int64(testOrganizations), decode[listResponse[domain.Organization]](t, w).Pagination.TotalItems)
+}
+
+func TestOrganizationValidation(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations", map[string]any{"name": " ", "timeZone": "Mars/Olympus", "currency": "usd"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	problem := decode[Problem](t, w)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	var fields []string
+	for _, e := range problem.Errors {
+		fields = append(fields, e.Field+":"+e.Code)
+	}
+	assert.Equal(t, []string{"name:required", "timeZone:invalid_time_zone", "currency:invalid_currency"}, fields)
+
+	w = serve(r, "PUT", "/api/v1/organizations/1", map[string]any{"name": "Acme", "currency": "dollars"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(r, "GET", "/api/v1/organizations/abc", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidOrganizationID, decode[Problem](t, w).Code)
+}
+
+func TestNestedRoutesRequireOrganization(t *testing.T) {
+	r, mem := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations/99/tags", map[string]any{"name": "Rent"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeOrganizationNotFound, decode[Problem](t, w).Code)
+
+	w = serve(r, "POST", "/api/v1/organizations/99/financial-records/bulk", []map[string]any{
+		{"direction": "IN", "amount": 1, "dueDate": time.Now()},
+	})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Nothing was written for the unknown organization.
+	records, total, err := mem.ListFinancialRecords(context.Background(), 99, store.FinancialRecordFilter{}, store.Page{Number: 1, Size: 10})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	assert.Zero(t, total)
+}
+
+// countingOrganizationStore counts the organizations looked up.
+type countingOrganizationStore struct {
+	store.OrganizationStore
+	lookups int
+}
+
+func (s *countingOrganizationStore) GetOrganization(ctx context.Context, id uint) (*domain.Organization, error) {
+	s.lookups++
+	return s.OrganizationStore.GetOrganization(ctx, id)
+}
+
+func TestOrganizationExistenceIsCached(t *testing.T) {
+	mem := newMemoryStore()
+	orgs := &countingOrganizationStore{OrganizationStore: mem}
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: orgs, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+		Config: config.Config{OrganizationCacheTTL: time.Hour},
+	})
+
+	for range 3 {
+		w := serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 1, orgs.lookups)
+
+	// Unknown organizations are not remembered, so they can be created.
+	for range 2 {
+		w := serve(r, "GET", "/api/v1/organizations/4/tags", nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	}
+	assert.Equal(t, 3, orgs.lookups)
+
+	// Deleting through the API drops the organization from the cache.
+	w := serve(r, "DELETE", "/api/v1/organizations/1", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// contextRecordingStore records the organization named in the context of
+// the last call.
+type contextRecordingStore struct {
+	*store.MemoryStore
+	org   uint
+	named bool
+}
+
+func (s *contextRecordingStore) ListTags(ctx context.Context, orgID uint, filter store.TagFilter, page store.Page) ([]domain.Tag, int64, error) {
+	s.org, s.named = store.OrganizationFromContext(ctx)
+	return s.MemoryStore.ListTags(ctx, orgID, filter, page)
+}
+
+func (s *contextRecordingStore) CreateFinancialRecords(ctx context.Context, records []domain.FinancialRecord, quota *store.Quota) error {
+	s.org, s.named = store.OrganizationFromContext(ctx)
+	return s.MemoryStore.CreateFinancialRecords(ctx, records, quota)
+}
+
+func TestNestedRoutesNameOrganizationInContext(t *testing.T) {
+	rec := &contextRecordingStore{MemoryStore: newMemoryStore()}
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: rec, Tags: rec, FinancialRecords: rec},
+		Logger: slog.New(slog.DiscardHandler),
+	})
+
+	w := serve(r, "GET", "/api/v1/organizations/2/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, rec.named)
+	assert.Equal(t, uint(2), rec.org)
+
+	w = serve(r, "POST", "/api/v1/organizations/3/financial-records/bulk", []map[string]any{
+		{"direction": "IN", "amount": 1, "dueDate": time.Now()},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.True(t, rec.named)
+	assert.Equal(t, uint(3), rec.org)
+}
@@ -0,0 +1,193 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+)
+
+// ProblemContentType is the media type of error responses (RFC 7807).
+const ProblemContentType = "application/problem+json"
+
+// Stable error codes carried in the "code" member of problem responses.
+// Clients branch on them, so they must never change meaning.
+const (
+	CodeInvalidBody           = "invalid_body"
+	CodeValidationFailed      = "validation_failed"
+	CodeInvalidOrganizationID = "invalid_organization_id"
+	CodeInvalidTagID          = "invalid_tag_id"
+	CodeInvalidRecordID       = "invalid_financial_record_id"
+	CodeInvalidAPIKeyID       = "invalid_api_key_id"
+	CodeInvalidRecurrenceID   = "invalid_recurrence_id"
+	CodeInvalidQuery          = "invalid_query"
+	CodeTagExists             = "tag_exists"
+	CodeRecordPaid            = "financial_record_paid"
+	CodeRecordCancelled       = "financial_record_cancelled"
+	CodeRecordRecurring       = "financial_record_recurring"
+	CodeRecordNotRecurring    = "financial_record_not_recurring"
+	CodeOccurrenceExists      = "occurrence_exists"
+	CodeIdempotencyKeyInvalid = "idempotency_key_invalid"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeNotFound              = "not_found"
+	CodeOrganizationNotFound  = "organization_not_found"
+	CodeAPIKeyNotFound        = "api_key_not_found"
+	CodeTagNotFound           = "tag_not_found"
+	CodeRecordNotFound        = "financial_record_not_found"
+	CodeRecurrenceNotFound    = "recurrence_not_found"
+	CodeUnauthenticated       = "unauthenticated"
+	CodeForbidden             = "forbidden"
+	CodeQuotaExceeded         = "quota_exceeded"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeRateLimited           = "rate_limited"
+	CodeOverloaded            = "overloaded"
+	CodeTimeout               = "timeout"
+	CodeClientClosedRequest   = "client_closed_request"
+	CodeInternal              = "internal_error"
+)
+
+// Problem is an error reported to the client as an RFC 7807 problem
+// details object. Handlers record it with c.Error and the Problems
+// middleware writes it.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	// Existing is the resource a conflicting write clashed with.
+	Existing any `json:"existing,omitempty"`
+}
+
+// FieldError is one invalid field of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewProblem returns a problem with the given status, code and detail.
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  statusTitle(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%d %s: %s", p.Status, p.Code, p.Detail)
+}
+
+func statusTitle(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// validationProblem reports the violations of one or more inputs.
+func validationProblem(violations []domain.Violation) *Problem {
+	p := NewProblem(http.StatusBadRequest, CodeValidationFailed, "The request body is invalid")
+	for _, v := range violations {
+		p.Errors = append(p.Errors, FieldError{Field: v.Field, Code: v.Code, Message: v.Message})
+	}
+	return p
+}
+
+// bindProblem reports a request body that could not be decoded.
+func bindProblem(err error) *Problem {
+	p := NewProblem(http.StatusBadRequest, CodeInvalidBody, "The request body is not valid JSON for this endpoint")
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		p.Errors = []FieldError{{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("Expected a value of type %s", typeErr.Type),
+		}}
+	}
+	return p
+}
+
+// problemFor converts any error recorded during a request into the problem
+// sent to the client. Errors that are not a *Problem are internal: the
+// client only learns whether the request was cancelled, timed out or
+// failed, never the underlying message.
+func problemFor(ctx context.Context, err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var validation *domain.ValidationError
+	if errors.As(err, &validation) {
+		return validationProblem(validation.Violations)
+	}
+
+	ctxErr := ctx.Err()
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(ctxErr, context.Canceled) || errors.Is(err, context.Canceled):
+		return NewProblem(StatusClientClosedRequest, CodeClientClosedRequest, "The client closed the request")
+	case errors.Is(ctxErr, context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &pgErr) && pgErr.Code == pgQueryCanceled:
+		return NewProblem(http.StatusGatewayTimeout, CodeTimeout, "The request timed out")
+	default:
+		return NewProblem(http.StatusInternalServerError, CodeInternal, "The request could not be completed")
+	}
+}
+
+// Problems writes the last error recorded with c.Error as a problem
+// response, unless a response was already written. The original error
+// stays in c.Errors, so the access log records internal errors that are
+// hidden from the client.
+func Problems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		writeProblem(c, problemFor(c.Request.Context(), c.Errors.Last().Err))
+	}
+}
+
+// writeProblem writes p, filling in the request-specific members.
+func writeProblem(c *gin.Context, p *Problem) {
+	body := *p
+	body.Instance = c.Request.URL.Path
+	body.RequestID = c.Writer.Header().Get(RequestIDHeader)
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(body.Status, ProblemContentType, b)
+}
+
+// abortWithProblem records p for the Problems middleware and stops the
+// handler chain.
+func abortWithProblem(c *gin.Context, p *Problem) {
+	c.Error(p)
+	c.Abort()
+}
+
+// recoverProblem answers a panicking request with a 500 problem, and
+// records the panic for the access log.
+func recoverProblem(c *gin.Context, recovered any) {
+	c.Error(fmt.Errorf("panic: %v", recovered))
+	writeProblem(c, NewProblem(http.StatusInternalServerError, CodeInternal, "The request could not be completed"))
+	c.Abort()
+}
@@ -0,0 +1,143 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// failingTagStore fails every listing with err, or panics when err is nil.
+type failingTagStore struct {
+	store.TagStore
+	err error
+}
+
+func (s failingTagStore) ListTags(context.Context, uint, store.TagFilter, store.Page) ([]domain.Tag, int64, error) {
+	if s.err == nil {
+		panic("store exploded")
+	}
+	return nil, 0, s.err
+}
+
+func newFailingRouter(err error) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	mem := newMemoryStore()
+	return NewRouter(Deps{
+		Stores: store.Stores{Organizations: mem, Tags: failingTagStore{TagStore: mem, err: err}, FinancialRecords: mem, AuditEvents: mem},
+		Logger: slog.New(slog.DiscardHandler),
+	})
+}
+
+func TestInternalErrorsAreNotLeaked(t *testing.T) {
+	r := newFailingRouter(errors.New(`pq: relation "secret_table" does not exist`))
+
+	w := serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "secret_table")
+
+	problem := decode[Problem](t, w)
+	assert.Equal(t, CodeInternal, problem.Code)
+	assert.Equal(t, http.StatusInternalServerError, problem.Status)
+	assert.Equal(t, "/api/v1/organizations/1/tags", problem.Instance)
+	assert.NotEmpty(t, problem.RequestID)
+	assert.Equal(t, w.Header().Get(RequestIDHeader), problem.RequestID)
+}
+
+func TestTimeoutProblem(t *testing.T) {
+	r := newFailingRouter(context.DeadlineExceeded)
+
+	w := serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, CodeTimeout, decode[Problem](t, w).Code)
+}
+
+func TestPanicProblem(t *testing.T) {
+	// Keep the stack trace logged by the recovery middleware out of the
+	// test output.
+	defaultErrorWriter := gin.DefaultErrorWriter
+	gin.DefaultErrorWriter = io.Discard
+	t.Cleanup(func() { gin.DefaultErrorWriter = defaultErrorWriter })
+	var logs bytes.Buffer
+	mem := newMemoryStore()
+	r := NewRouter(Deps{
+		Stores: store.Stores{Organizations: mem, Tags: failingTagStore{TagStore: mem}, FinancialRecords: mem, AuditEvents: mem},
+		Logger: slog.New(slog.NewJSONHandler(&logs, nil)),
+	})
+
+	w := serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "exploded")
+	problem := decode[Problem](t, w)
+	assert.Equal(t, CodeInternal, problem.Code)
+	assert.Equal(t, w.Header().Get(RequestIDHeader), problem.RequestID)
+
+	// The access log has the 500 and its cause.
+	var line struct {
+		Level     string `json:"level"`
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id"`
+		Errors    string `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line), logs.String())
+	assert.Equal(t, "ERROR", line.Level)
+	assert.Equal(t, http.StatusInternalServerError, line.Status)
+	assert.Equal(t, problem.RequestID, line.RequestID)
+	assert.Contains(t, line.Errors, "store exploded")
+}
+
+func TestNotFoundProblem(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "GET", "/api/v1/nothing-here", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeNotFound, decode[Problem](t, w).Code)
+
+	w = serve(r, "DELETE", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, CodeMethodNotAllowed, decode[Problem](t, w).Code)
+}
+
+func TestBulkValidationReportsEveryRecord(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records/bulk", []map[string]any{
+		{"direction": "IN", "amount": 10, "dueDate": time.Now()},
+		{"direction": "SIDEWAYS", "amount": -1, "dueDate": time.Now()},
+		{"direction": "UP", "amount": 10, "dueDate": time.Now()},
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	problem := decode[Problem](t, w)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	fields := make([]string, len(problem.Errors))
+	for i, e := range problem.Errors {
+		fields[i] = e.Field
+	}
+	assert.Equal(t, []string{"[1].direction", "[1].amount", "[2].direction"}, fields)
+}
+
+func TestInvalidBodyProblem(t *testing.T) {
+	r, _ := newTestRouter()
+
+	w := serve(r, "POST", "/api/v1/organizations/1/financial-records", map[string]any{"direction": "IN", "amount": "ten"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	problem := decode[Problem](t, w)
+	assert.Equal(t, CodeInvalidBody, problem.Code)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "amount", problem.Errors[0].Field)
+}
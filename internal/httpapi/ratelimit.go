@@ -0,0 +1,200 @@
+package httpapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+)
+
+// Rate limit headers, set on every response of a rate-limited route. They
+// describe the most depleted bucket the request drew from.
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// RateLimiter keeps one token bucket per key, such as an organization ID.
+// A bucket holds up to burst tokens and refills at rate tokens per second;
+// a request takes a token, or is rejected when the bucket is empty.
+//
+// Buckets live in process memory, so each server instance enforces the
+// limits on its own share of the traffic.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter refilling buckets of burst tokens at rate
+// tokens per second, or nil when rate is zero. A zero burst holds one
+// second's worth of tokens. A nil *RateLimiter allows every request.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(int(math.Ceil(rate)), 1)
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: map[string]*tokenBucket{}}
+}
+
+// rateDecision is the outcome of taking a token from a bucket.
+type rateDecision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// retryAfter is how long until a token is available, when rejected.
+	retryAfter time.Duration
+	// reset is how long until the bucket is full again.
+	reset time.Duration
+}
+
+// take takes a token from the bucket of key.
+func (l *RateLimiter) take(key string) rateDecision {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := rateDecision{limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = l.refillTime(1 - b.tokens)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = l.refillTime(l.burst - b.tokens)
+	return d
+}
+
+// refillTime returns how long the bucket takes to gain tokens.
+func (l *RateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that are full again, at most once a minute, so
+// that only the keys seen recently take memory. The caller must hold l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ClassRateLimits holds the limiters of one route class.
+type ClassRateLimits struct {
+	// Organizations has a bucket per organization of the route.
+	Organizations *RateLimiter
+	// Callers has a bucket per authenticated caller: API key, JWT user or
+	// the admin key.
+	Callers *RateLimiter
+}
+
+// Middleware takes a token for the caller, then one for the organization of
+// the route, and rejects the request with 429 and a Retry-After header when
+// either bucket is empty. It must run after authentication.
+func (l ClassRateLimits) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tightest *rateDecision
+		for _, limit := range []struct {
+			limiter *RateLimiter
+			key     string
+			who     string
+		}{
+			{l.Callers, callerID(c), "The caller"},
+			{l.Organizations, organizationBucket(c), "The organization"},
+		} {
+			if limit.limiter == nil || limit.key == "" {
+				continue
+			}
+			d := limit.limiter.take(limit.key)
+			if tightest == nil || d.remaining < tightest.remaining {
+				tightest = &d
+			}
+			if !d.allowed {
+				setRateLimitHeaders(c, d)
+				c.Header("Retry-After", strconv.Itoa(ceilSeconds(d.retryAfter)))
+				abortWithProblem(c, NewProblem(http.StatusTooManyRequests, CodeRateLimited, limit.who+" exceeded its request rate, retry later"))
+				return
+			}
+		}
+		if tightest != nil {
+			setRateLimitHeaders(c, *tightest)
+		}
+		c.Next()
+	}
+}
+
+// organizationBucket returns the organization ID of the route in canonical
+// form, so that "01" and "1" share a bucket, or "" when there is none or
+// it is not valid; the handler rejects the latter.
+func organizationBucket(c *gin.Context) string {
+	orgID, err := strconv.ParseUint(c.Param("organizationId"), 10, 32)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(orgID, 10)
+}
+
+func setRateLimitHeaders(c *gin.Context, d rateDecision) {
+	c.Header(RateLimitLimitHeader, strconv.Itoa(d.limit))
+	c.Header(RateLimitRemainingHeader, strconv.Itoa(d.remaining))
+	c.Header(RateLimitResetHeader, strconv.Itoa(int(math.Ceil(d.reset.Seconds()))))
+}
+
+// ceilSeconds rounds d up to whole seconds, at least one.
+func ceilSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 1)
+}
+
+// RateLimits holds the rate limiters of every route class.
+type RateLimits struct {
+	Reads   ClassRateLimits
+	Writes  ClassRateLimits
+	Reports ClassRateLimits
+}
+
+// NewRateLimits builds the limiters from cfg.
+func NewRateLimits(cfg config.Config) *RateLimits {
+	class := func(c config.RateLimitConfig) ClassRateLimits {
+		return ClassRateLimits{
+			Organizations: NewRateLimiter(c.OrganizationRate, c.OrganizationBurst),
+			Callers:       NewRateLimiter(c.CallerRate, c.CallerBurst),
+		}
+	}
+	return &RateLimits{
+		Reads:   class(cfg.ReadRateLimit),
+		Writes:  class(cfg.WriteRateLimit),
+		Reports: class(cfg.ReportRateLimit),
+	}
+}
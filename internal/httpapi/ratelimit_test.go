@@ -0,0 +1,129 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+// fakeClock is a settable time source for rate limiters.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	l := NewRateLimiter(1, 2)
+	l.now = clock.Now
+
+	for want := 1; want >= 0; want-- {
+		d := l.take("a")
+		require.True(t, d.allowed)
+		assert.Equal(t, 2, d.limit)
+		assert.Equal(t, want, d.remaining)
+	}
+	d := l.take("a")
+	assert.False(t, d.allowed)
+	assert.Equal(t, time.Second, d.retryAfter)
+	assert.Equal(t, 2*time.Second, d.reset)
+
+	// Other keys have their own bucket.
+	assert.True(t, l.take("b").allowed)
+
+	// Tokens refill at the rate.
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	d = l.take("a")
+	assert.False(t, d.allowed)
+	assert.Equal(t, 500*time.Millisecond, d.retryAfter)
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.True(t, l.take("a").allowed)
+
+	// Buckets that refilled are dropped.
+	clock.now = clock.now.Add(2 * time.Minute)
+	l.take("c")
+	assert.Len(t, l.buckets, 1)
+
+	assert.Nil(t, NewRateLimiter(0, 10))
+	assert.Equal(t, float64(3), NewRateLimiter(2.5, 0).burst)
+}
+
+func TestOrganizationRateLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	limits := &RateLimits{Writes: ClassRateLimits{Organizations: NewRateLimiter(1, 2)}}
+	limits.Writes.Organizations.now = clock.Now
+	mem := newMemoryStore()
+	r := NewRouter(Deps{
+		Stores:     store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger:     slog.New(slog.DiscardHandler),
+		RateLimits: limits,
+	})
+
+	for i, name := range []string{"Rent", "Payroll"} {
+		w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "2", w.Header().Get(RateLimitLimitHeader))
+		assert.Equal(t, []string{"1", "0"}[i], w.Header().Get(RateLimitRemainingHeader))
+	}
+	w := serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Taxes"})
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, CodeRateLimited, decode[Problem](t, w).Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	// Leading zeros do not get the organization another bucket.
+	w = serve(r, "POST", "/api/v1/organizations/01/tags", map[string]any{"name": "Taxes"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "2", w.Header().Get(RateLimitResetHeader))
+
+	// Other organizations and other route classes are not affected.
+	w = serve(r, "POST", "/api/v1/organizations/2/tags", map[string]any{"name": "Taxes"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(r, "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(RateLimitLimitHeader))
+
+	clock.now = clock.now.Add(time.Second)
+	w = serve(r, "POST", "/api/v1/organizations/1/tags", map[string]any{"name": "Taxes"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestCallerRateLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	limits := &RateLimits{Reads: ClassRateLimits{Callers: NewRateLimiter(1, 2), Organizations: NewRateLimiter(10, 3)}}
+	limits.Reads.Callers.now = clock.Now
+	limits.Reads.Organizations.now = clock.Now
+	mem := newMemoryStore()
+	r := NewRouter(Deps{
+		Stores:     store.Stores{Organizations: mem, APIKeys: mem, Tags: mem, FinancialRecords: mem, AuditEvents: mem},
+		Logger:     slog.New(slog.DiscardHandler),
+		Auth:       NewAuth(NewAPIKeyAuthenticator(mem, testAdminKey, 0)),
+		RateLimits: limits,
+	})
+	noisy := issue(t, r, grant{1, domain.ScopeRead})
+	quiet := issue(t, r, grant{1, domain.ScopeRead})
+
+	w := serveAs(r, noisy.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	// The headers describe the most depleted bucket, the caller's.
+	assert.Equal(t, "2", w.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "1", w.Header().Get(RateLimitRemainingHeader))
+	w = serveAs(r, noisy.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveAs(r, noisy.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, CodeRateLimited, decode[Problem](t, w).Code)
+
+	// The rejected request did not draw from the organization's bucket,
+	// which has one token left for the other key.
+	w = serveAs(r, quiet.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get(RateLimitRemainingHeader))
+	w = serveAs(r, quiet.Secret, "", "GET", "/api/v1/organizations/1/tags", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
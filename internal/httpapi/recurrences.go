@@ -0,0 +1,181 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+)
+
+func recurrenceNotFound() *Problem {
+	return NewProblem(http.StatusNotFound, CodeRecurrenceNotFound, "The recurrence does not exist in this organization")
+}
+
+// createRecurrence makes the financial record of the :recordId path
+// parameter recur by the rule in the body, and creates its occurrences due
+// within horizon right away, as many as the quota of financial records
+// allows. The scheduler creates the later ones.
+func createRecurrence(recurrenceStore store.RecurrenceStore, quota int, horizon time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rec domain.Recurrence
+		if err := c.ShouldBindJSON(&rec.RecurrenceRule); err != nil {
+			c.Error(bindProblem(err))
+			return
+		}
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "recordId", CodeInvalidRecordID, "financial record")
+		if !ok {
+			return
+		}
+
+		q := newQuota(requestOrganization(c).FinancialRecordLimit(quota))
+		err := recurrenceStore.CreateRecurrence(c.Request.Context(), orgID, id, &rec, time.Now().Add(horizon), q)
+		err = quotaProblem(c, "financial records", err)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			err = recordNotFound()
+		case errors.Is(err, domain.ErrRecordRecurring):
+			err = NewProblem(http.StatusConflict, CodeRecordRecurring, "The financial record already belongs to a recurrence")
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		setQuotaRemaining(c, q)
+		c.JSON(http.StatusCreated, rec)
+	}
+}
+
+func listRecurrences(recurrenceStore store.RecurrenceStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		page := pagination(c)
+
+		recs, total, err := recurrenceStore.ListRecurrences(c.Request.Context(), orgID, page)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, paginated(recs, page, total))
+	}
+}
+
+func getRecurrence(recurrenceStore store.RecurrenceStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "recurrenceId", CodeInvalidRecurrenceID, "recurrence")
+		if !ok {
+			return
+		}
+
+		rec, err := recurrenceStore.GetRecurrence(c.Request.Context(), orgID, id)
+		if errors.Is(err, store.ErrNotFound) {
+			err = recurrenceNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, rec)
+	}
+}
+
+// deleteRecurrence ends the series of the :recurrenceId path parameter.
+// Its occurrences are kept.
+func deleteRecurrence(recurrenceStore store.RecurrenceStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "recurrenceId", CodeInvalidRecurrenceID, "recurrence")
+		if !ok {
+			return
+		}
+
+		err := recurrenceStore.DeleteRecurrence(c.Request.Context(), orgID, id)
+		if errors.Is(err, store.ErrNotFound) {
+			err = recurrenceNotFound()
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		noContent(c)
+	}
+}
+
+// editFinancialRecord changes the fields of the financial record given in
+// the body. With scope=future, the record must be an occurrence, and the
+// change and a new rule also apply to the later occurrences of its
+// recurrence, whose missing occurrences due within horizon are created as
+// the quota of financial records allows.
+func editFinancialRecord(recordStore store.FinancialRecordStore, recurrenceStore store.RecurrenceStore, quota int, horizon time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := c.DefaultQuery("scope", domain.EditScopeThis)
+		if scope != domain.EditScopeThis && scope != domain.EditScopeFuture {
+			c.Error(NewProblem(http.StatusBadRequest, CodeInvalidQuery, "scope must be this or future"))
+			return
+		}
+		var change domain.RecordChange
+		if err := c.ShouldBindJSON(&change); err != nil {
+			c.Error(bindProblem(err))
+			return
+		}
+
+		if scope == domain.EditScopeThis {
+			if change.Recurrence != nil {
+				var vs domain.Violations
+				vs.Add("recurrence", "requires_future_scope", "The recurrence can only change with scope=future")
+				c.Error(vs.Err())
+				return
+			}
+			updateFinancialRecord(c, recordStore, func(r *domain.FinancialRecord) error {
+				change.Apply(r)
+				return r.Validate()
+			})
+			return
+		}
+
+		orgID, ok := organizationID(c)
+		if !ok {
+			return
+		}
+		id, ok := pathID(c, "recordId", CodeInvalidRecordID, "financial record")
+		if !ok {
+			return
+		}
+		edit := func(rec *domain.Recurrence, occurrences []domain.FinancialRecord) error {
+			return rec.EditFuture(occurrences, change)
+		}
+		limit := requestOrganization(c).FinancialRecordLimit(quota)
+		record, err := recurrenceStore.EditRecurrence(c.Request.Context(), orgID, id, edit, time.Now().Add(horizon), limit)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			err = recordNotFound()
+		case errors.Is(err, domain.ErrRecordNotRecurring):
+			err = NewProblem(http.StatusConflict, CodeRecordNotRecurring, "The financial record does not belong to a recurrence")
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		record.MarkOverdue(time.Now())
+		c.JSON(http.StatusOK, record)
+	}
+}
@@ -0,0 +1,123 @@
+// Package httpapi serves the financial records API over HTTP with gin.
+package httpapi
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/config"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/store"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/telemetry"
+)
+
+// APIPrefix is the path prefix of every versioned API route.
+const APIPrefix = "/api/v1"
+
+// Deps are the dependencies of the HTTP router.
+type Deps struct {
+	Stores store.Stores
+
+	// Logger receives the access log. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Config supplies the per-route-class deadlines. The zero value disables
+	// them.
+	Config config.Config
+	// Admission limits concurrency per route class. Nil admits everything.
+	Admission *Admission
+	// RateLimits throttles organizations and callers per route class. Nil
+	// allows every request.
+	RateLimits *RateLimits
+	// Idempotency replays writes retried with the same Idempotency-Key. Nil
+	// ignores the header.
+	Idempotency *Idempotency
+	// Auth authenticates and authorizes every API request. Nil lets every
+	// request through.
+	Auth *Auth
+}
+
+// NewRouter builds the gin engine serving the API, shared by the server and
+// the tests so that both mount exactly the same routes and middleware.
+func NewRouter(deps Deps) *gin.Engine {
+	logger := deps.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	admission := deps.Admission
+	if admission == nil {
+		admission = &Admission{}
+	}
+	rateLimits := deps.RateLimits
+	if rateLimits == nil {
+		rateLimits = &RateLimits{}
+	}
+
+	r := gin.New()
+	// The access log wraps the recovery, so that panics are logged as the
+	// 500 they are answered with.
+	r.Use(RequestID(), AccessLog(logger), gin.CustomRecovery(recoverProblem), otelgin.Middleware(telemetry.ServiceName), Problems())
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(func(c *gin.Context) {
+		c.Error(NewProblem(http.StatusNotFound, CodeNotFound, "No route matches the request path"))
+	})
+	r.NoMethod(func(c *gin.Context) {
+		c.Error(NewProblem(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "The route does not support the request method"))
+	})
+
+	// The metrics cover every organization, so they need the admin key
+	// like the other routes outside one.
+	r.GET("/debug/vars", deps.Auth.Middleware(), gin.WrapH(expvar.Handler()))
+	r.GET("/openapi.json", serveOpenAPI)
+
+	// Authorization runs before idempotency, so that a replayed response
+	// is only served to a caller allowed to make the request. Rate limits
+	// need the caller, and run before admission so that throttled requests
+	// never take a slot.
+	v1 := r.Group(APIPrefix, deps.Auth.Middleware())
+	reads := v1.Group("", Deadline(deps.Config.ReadTimeout), rateLimits.Reads.Middleware(), admission.Reads.Middleware())
+	writes := v1.Group("", Deadline(deps.Config.WriteTimeout), rateLimits.Writes.Middleware(), deps.Idempotency.Middleware(), admission.Writes.Middleware())
+	reports := v1.Group("", Deadline(deps.Config.ReportTimeout), rateLimits.Reports.Middleware(), admission.Reports.Middleware())
+
+	organizations, tags, records := deps.Stores.Organizations, deps.Stores.Tags, deps.Stores.FinancialRecords
+	orgCache := newOrganizationCache(organizations, deps.Config.OrganizationCacheTTL)
+	writes.POST("/organizations", createOrganization(organizations))
+	reads.GET("/organizations", listOrganizations(organizations))
+	reads.GET("/organizations/:organizationId", getOrganization(organizations))
+	writes.PUT("/organizations/:organizationId", updateOrganization(organizations, orgCache))
+	writes.DELETE("/organizations/:organizationId", adminOnly(), deleteOrganization(organizations, orgCache))
+
+	apiKeys := deps.Stores.APIKeys
+	writes.POST("/api-keys", issueAPIKey(apiKeys, organizations))
+	reads.GET("/api-keys", listAPIKeys(apiKeys))
+	writes.DELETE("/api-keys/:keyId", revokeAPIKey(apiKeys, deps.Auth))
+
+	// Routes nested under an organization answer 404 unless it exists. The
+	// quotas are the defaults of the organizations' own limits.
+	orgExists := orgCache.Middleware()
+	tagQuota, recordQuota := deps.Config.MaxTagsPerOrganization, deps.Config.MaxFinancialRecordsPerOrganization
+	writes.POST("/organizations/:organizationId/tags", orgExists, createTag(tags, tagQuota))
+	reads.GET("/organizations/:organizationId/tags", orgExists, listTags(tags))
+	writes.DELETE("/organizations/:organizationId/tags/:tagId", orgExists, deleteTag(tags))
+	writes.POST("/organizations/:organizationId/tags/:tagId/restore", orgExists, restoreTag(tags, tagQuota))
+	writes.POST("/organizations/:organizationId/financial-records", orgExists, createFinancialRecord(records, recordQuota))
+	writes.POST("/organizations/:organizationId/financial-records/bulk", orgExists, createFinancialRecordsBulk(records, recordQuota))
+	reads.GET("/organizations/:organizationId/financial-records", orgExists, listFinancialRecords(records))
+	writes.DELETE("/organizations/:organizationId/financial-records/:recordId", orgExists, deleteFinancialRecord(records))
+	writes.POST("/organizations/:organizationId/financial-records/:recordId/restore", orgExists, restoreFinancialRecord(records, recordQuota))
+	writes.POST("/organizations/:organizationId/financial-records/:recordId/settle", orgExists, settleFinancialRecord(records))
+	writes.POST("/organizations/:organizationId/financial-records/:recordId/cancel", orgExists, cancelFinancialRecord(records))
+	reports.GET("/organizations/:organizationId/financial-records/reports/cash-flow", orgExists, getCashFlowReport(records))
+
+	recurrences, horizon := deps.Stores.Recurrences, deps.Config.RecurrenceHorizon
+	writes.PATCH("/organizations/:organizationId/financial-records/:recordId", orgExists, editFinancialRecord(records, recurrences, recordQuota, horizon))
+	writes.POST("/organizations/:organizationId/financial-records/:recordId/recurrence", orgExists, createRecurrence(recurrences, recordQuota, horizon))
+	reads.GET("/organizations/:organizationId/recurrences", orgExists, listRecurrences(recurrences))
+	reads.GET("/organizations/:organizationId/recurrences/:recurrenceId", orgExists, getRecurrence(recurrences))
+	writes.DELETE("/organizations/:organizationId/recurrences/:recurrenceId", orgExists, deleteRecurrence(recurrences))
+	reads.GET("/organizations/:organizationId/audit-events", orgExists, listAuditEvents(deps.Stores.AuditEvents))
+
+	return r
+}
@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// endpointLine matches the "METHOD /path" lines of the README's API section.
+var endpointLine = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE) (/\S+)`)
+
+// documentedRoutes returns the "METHOD path" pairs documented in README.md,
+// without query strings.
+func documentedRoutes(t *testing.T) []string {
+	t.Helper()
+	f, err := os.Open("../../README.md")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var routes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := endpointLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		path, _, _ := strings.Cut(m[2], "?")
+		routes = append(routes, m[1]+" "+path)
+	}
+	require.NoError(t, scanner.Err())
+	return routes
+}
+
+func TestRouterMountsDocumentedRoutes(t *testing.T) {
+	r, _ := newTestRouter()
+
+	mounted := map[string]bool{}
+	for _, route := range r.Routes() {
+		mounted[route.Method+" "+route.Path] = true
+	}
+
+	documented := documentedRoutes(t)
+	require.NotEmpty(t, documented, "README.md documents no endpoints")
+	for _, route := range documented {
+		assert.True(t, mounted[route], "documented route %q is not mounted", route)
+		delete(mounted, route)
+	}
+
+	// Every API route must be documented too; operational endpoints are
+	// outside the versioned prefix.
+	for route := range mounted {
+		_, path, _ := strings.Cut(route, " ")
+		assert.False(t, strings.HasPrefix(path, APIPrefix+"/"), "route %q is not documented in README.md", route)
+	}
+}
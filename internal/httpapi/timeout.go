@@ -0,0 +1,32 @@
+package httpapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest is the non-standard status (popularized by nginx)
+// recorded when the client disconnects before the response is written.
+const StatusClientClosedRequest = 499
+
+// pgQueryCanceled is the SQLSTATE Postgres reports when a statement is
+// interrupted, either by statement_timeout or by a cancel request.
+const pgQueryCanceled = "57014"
+
+// Deadline bounds the request context to d. Queries built with
+// db.WithContext(c.Request.Context()) are cancelled on the server once it
+// expires. A zero d leaves the request unbounded.
+func Deadline(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/telemetry"
+)
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx naming the caller recorded in the audit
+// events of the changes made with it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or "".
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// newAuditEvent returns the event recording a change of a *domain.Tag or
+// *domain.FinancialRecord from before to after, made by the actor and in
+// the request of ctx.
+func newAuditEvent(ctx context.Context, action, entityType string, orgID, entityID uint, before, after any) (domain.AuditEvent, error) {
+	b, a, err := domain.AuditDiff(before, after)
+	if err != nil {
+		return domain.AuditEvent{}, err
+	}
+	return domain.AuditEvent{
+		CreatedAt:      time.Now(),
+		OrganizationID: orgID,
+		Actor:          ActorFromContext(ctx),
+		Action:         action,
+		EntityType:     entityType,
+		EntityID:       entityID,
+		Before:         b,
+		After:          a,
+		RequestID:      telemetry.RequestIDFromContext(ctx),
+	}, nil
+}
+
+// unchanged reports whether an update left a *domain.FinancialRecord or a
+// *domain.Recurrence as it was.
+func unchanged(before, after any) (bool, error) {
+	b, _, err := domain.AuditDiff(before, after)
+	return string(b) == "{}", err
+}
+
+// recordsCreated returns the events recording the creation of records.
+func recordsCreated(ctx context.Context, records []domain.FinancialRecord) ([]domain.AuditEvent, error) {
+	events := make([]domain.AuditEvent, len(records))
+	for i := range records {
+		var err error
+		events[i], err = newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityFinancialRecord, records[i].OrganizationID, records[i].ID, nil, &records[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
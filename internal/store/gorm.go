@@ -0,0 +1,964 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormStore implements OrganizationStore, APIKeyStore, TagStore,
+// FinancialRecordStore, RecurrenceStore and AuditEventStore with GORM.
+type GormStore struct {
+	db *gorm.DB
+	// tenantRole, when set, runs tag and financial record statements as
+	// this role, restricted by row-level security.
+	tenantRole string
+}
+
+// NewGormStore returns a store backed by db.
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// WithRowLevelSecurity returns a copy of the store running the statements
+// on tags and financial records in transactions switched to role, which
+// only sees the organization of the context (see WithOrganization).
+// EnableRowLevelSecurity must have set up role.
+func (s *GormStore) WithRowLevelSecurity(role string) *GormStore {
+	return &GormStore{db: s.db, tenantRole: role}
+}
+
+// tenant runs fn on the database handle for the tenant tables: with
+// row-level security, a transaction restricted to the organization of ctx.
+func (s *GormStore) tenant(ctx context.Context, fn func(db *gorm.DB) error) error {
+	db := s.db.WithContext(ctx)
+	if s.tenantRole == "" {
+		return fn(db)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(setTenantRole(s.tenantRole)).Error; err != nil {
+			return err
+		}
+		if org, ok := currentOrgOf(ctx); ok {
+			if err := tx.Exec(setCurrentOrg("?"), org).Error; err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+func (s *GormStore) CreateOrganization(ctx context.Context, org *domain.Organization) error {
+	return s.db.WithContext(ctx).Create(org).Error
+}
+
+func (s *GormStore) GetOrganization(ctx context.Context, id uint) (*domain.Organization, error) {
+	var org domain.Organization
+	err := s.db.WithContext(ctx).Take(&org, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (s *GormStore) ListOrganizations(ctx context.Context, page Page) ([]domain.Organization, int64, error) {
+	db := s.db.WithContext(ctx)
+
+	var total int64
+	if err := db.Model(&domain.Organization{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var orgs []domain.Organization
+	if err := db.Order("id").
+		Offset(page.Offset()).
+		Limit(page.Size).
+		Find(&orgs).Error; err != nil {
+		return nil, 0, err
+	}
+	return orgs, total, nil
+}
+
+func (s *GormStore) UpdateOrganization(ctx context.Context, org *domain.Organization) error {
+	db := s.db.WithContext(ctx)
+	result := db.Model(org).Select("name", "time_zone", "currency", "max_tags", "max_financial_records").Updates(org)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return db.Take(org, org.ID).Error
+}
+
+func (s *GormStore) DeleteOrganization(ctx context.Context, id uint) error {
+	result := s.db.WithContext(ctx).Delete(&domain.Organization{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormStore) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	// The key and its grants are inserted in a single transaction
+	return s.db.WithContext(ctx).Create(key).Error
+}
+
+func (s *GormStore) FindAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	var key domain.APIKey
+	err := s.db.WithContext(ctx).Preload("Grants").Where("hash = ?", hash).Take(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *GormStore) ListAPIKeys(ctx context.Context, page Page) ([]domain.APIKey, int64, error) {
+	db := s.db.WithContext(ctx)
+
+	var total int64
+	if err := db.Model(&domain.APIKey{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var keys []domain.APIKey
+	if err := db.Preload("Grants").
+		Order("id").
+		Offset(page.Offset()).
+		Limit(page.Size).
+		Find(&keys).Error; err != nil {
+		return nil, 0, err
+	}
+	return keys, total, nil
+}
+
+func (s *GormStore) RevokeAPIKey(ctx context.Context, id uint) error {
+	result := s.db.WithContext(ctx).Delete(&domain.APIKey{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormStore) CreateTag(ctx context.Context, tag *domain.Tag, quota *Quota) error {
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := takeQuota(tx, tag.OrganizationID, &domain.Tag{}, quota, 1); err != nil {
+				return err
+			}
+			if err := tx.Create(tag).Error; err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityTag, tag.OrganizationID, tag.ID, nil, tag)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	if isTagNameConflict(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *GormStore) ListTags(ctx context.Context, orgID uint, filter TagFilter, page Page) ([]domain.Tag, int64, error) {
+	var total int64
+	var tags []domain.Tag
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		if filter.IncludeDeleted {
+			db = db.Unscoped()
+		}
+		if err := db.Model(&domain.Tag{}).Where("organization_id = ?", orgID).Count(&total).Error; err != nil {
+			return err
+		}
+
+		return db.Where("organization_id = ?", orgID).
+			Order("id").
+			Offset(page.Offset()).
+			Limit(page.Size).
+			Find(&tags).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return tags, total, nil
+}
+
+func (s *GormStore) FindTagByName(ctx context.Context, orgID uint, name string) (*domain.Tag, error) {
+	var tag domain.Tag
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Where("organization_id = ? AND "+normalizedTagName("name")+" = "+normalizedTagName("?"), orgID, name).Take(&tag).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (s *GormStore) CountTags(ctx context.Context, orgID uint) (int64, error) {
+	var n int64
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Model(&domain.Tag{}).Where("organization_id = ?", orgID).Count(&n).Error
+	})
+	return n, err
+}
+
+func (s *GormStore) DeleteTag(ctx context.Context, orgID, id uint) error {
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var tag domain.Tag
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("organization_id = ?", orgID).
+				Take(&tag, id).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&tag).Error; err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityTag, orgID, id, &tag, nil)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *GormStore) RestoreTag(ctx context.Context, orgID, id uint, quota *Quota) (*domain.Tag, error) {
+	var tag domain.Tag
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("organization_id = ?", orgID).
+				Take(&tag, id).Error; err != nil {
+				return err
+			}
+			if !tag.DeletedAt.Valid {
+				return takeQuota(tx, orgID, &domain.Tag{}, quota, 0)
+			}
+			if err := takeQuota(tx, orgID, &domain.Tag{}, quota, 1); err != nil {
+				return err
+			}
+			before := tag
+			tag.DeletedAt = gorm.DeletedAt{}
+			if err := tx.Unscoped().Model(&tag).Select("deleted_at", "updated_at").Updates(&tag).Error; err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditRestore, domain.AuditEntityTag, orgID, id, &before, &tag)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrNotFound
+	case isTagNameConflict(err):
+		return nil, ErrDuplicate
+	case err != nil:
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (s *GormStore) CreateFinancialRecord(ctx context.Context, record *domain.FinancialRecord, quota *Quota) error {
+	return s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := takeQuota(tx, record.OrganizationID, &domain.FinancialRecord{}, quota, 1); err != nil {
+				return err
+			}
+			records := []domain.FinancialRecord{*record}
+			if err := createFinancialRecords(tx, records); err != nil {
+				return err
+			}
+			*record = records[0]
+			event, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityFinancialRecord, record.OrganizationID, record.ID, nil, record)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+}
+
+func (s *GormStore) CreateFinancialRecords(ctx context.Context, records []domain.FinancialRecord, quota *Quota) error {
+	if len(records) == 0 {
+		return nil
+	}
+	// Create all records and their audit events in a single transaction
+	return s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := takeQuota(tx, records[0].OrganizationID, &domain.FinancialRecord{}, quota, len(records)); err != nil {
+				return err
+			}
+			if err := createFinancialRecords(tx, records); err != nil {
+				return err
+			}
+			events, err := recordsCreated(ctx, records)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&events).Error
+		})
+	})
+}
+
+// createBatchSize is how many rows one INSERT creates, well within the
+// 65535 parameters of a Postgres statement.
+const createBatchSize = 1000
+
+// createFinancialRecords inserts records, with the payments of the ones
+// created paid, and links them to the tags they name that belong to their
+// organization and are not deleted, which become their Tags. Saving the associations would let GORM link, or even create,
+// the tags of any organization.
+func createFinancialRecords(tx *gorm.DB, records []domain.FinancialRecord) error {
+	if err := tx.Omit(clause.Associations).CreateInBatches(&records, createBatchSize).Error; err != nil {
+		return err
+	}
+
+	wanted := map[uint][]uint{} // organization ID -> tag IDs
+	for _, r := range records {
+		for _, t := range r.Tags {
+			wanted[r.OrganizationID] = append(wanted[r.OrganizationID], t.ID)
+		}
+	}
+	live := map[uint]map[uint]domain.Tag{} // organization ID -> tag ID -> tag
+	for orgID, ids := range wanted {
+		var tags []domain.Tag
+		if err := tx.Where("organization_id = ? AND id IN ?", orgID, ids).Find(&tags).Error; err != nil {
+			return err
+		}
+		live[orgID] = map[uint]domain.Tag{}
+		for _, t := range tags {
+			live[orgID][t.ID] = t
+		}
+	}
+
+	type recordTag struct{ FinancialRecordID, TagID uint }
+	var links []recordTag
+	for i := range records {
+		r := &records[i]
+		if r.Tags == nil {
+			continue
+		}
+		tags := []domain.Tag{}
+		for _, t := range r.Tags {
+			if tag, ok := live[r.OrganizationID][t.ID]; ok && !slices.ContainsFunc(tags, func(l domain.Tag) bool { return l.ID == t.ID }) {
+				tags = append(tags, tag)
+				links = append(links, recordTag{r.ID, t.ID})
+			}
+		}
+		r.Tags = tags
+	}
+	if len(links) > 0 {
+		if err := tx.Table("financial_record_tags").CreateInBatches(links, createBatchSize).Error; err != nil {
+			return err
+		}
+	}
+	if payments := paymentsMade(records); len(payments) > 0 {
+		return tx.CreateInBatches(&payments, createBatchSize).Error
+	}
+	return nil
+}
+
+func (s *GormStore) ListFinancialRecords(ctx context.Context, orgID uint, filter FinancialRecordFilter, page Page) ([]domain.FinancialRecord, int64, error) {
+	var total int64
+	var records []domain.FinancialRecord
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		query := db.Where("organization_id = ?", orgID)
+		if filter.IncludeDeleted {
+			query = query.Unscoped()
+		}
+
+		// Handle tag filtering; a record carrying several of the tags is
+		// still listed once.
+		if len(filter.TagIDs) > 0 {
+			query = query.Where(`EXISTS (SELECT 1 FROM financial_record_tags frt
+				WHERE frt.financial_record_id = financial_records.id AND frt.tag_id IN ?)`, filter.TagIDs)
+		}
+		if filter.CounterpartyName != "" {
+			query = query.Where("lower(counterparty_name) = lower(?)", filter.CounterpartyName)
+		}
+		if filter.CounterpartyDocument != "" {
+			query = query.Where("counterparty_document = ?", filter.CounterpartyDocument)
+		}
+		if filter.Reference != "" {
+			query = query.Where("reference = ?", filter.Reference)
+		}
+		if filter.Metadata != nil {
+			query = query.Where("metadata @> ?::jsonb", string(filter.Metadata))
+		}
+		if filter.Query != "" {
+			// Count leaves out the ordering by relevance.
+			match, rank := searchSQL("@q")
+			q := sql.Named("q", filter.Query)
+			query = query.Where(match, q).
+				Order(clause.OrderBy{Expression: clause.NamedExpr{SQL: rank + " DESC", Vars: []any{q}}})
+		}
+
+		// Get total count for pagination
+		if err := query.Model(&domain.FinancialRecord{}).Count(&total).Error; err != nil {
+			return err
+		}
+
+		// Unscoped carries over to the preload; leave the deleted tags out
+		// explicitly.
+		return query.Preload("Tags", "tags.deleted_at IS NULL").
+			Order("financial_records.id").
+			Offset(page.Offset()).
+			Limit(page.Size).
+			Find(&records).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+func (s *GormStore) CountFinancialRecords(ctx context.Context, orgID uint) (int64, error) {
+	var n int64
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Model(&domain.FinancialRecord{}).Where("organization_id = ?", orgID).Count(&n).Error
+	})
+	return n, err
+}
+
+func (s *GormStore) DeleteFinancialRecord(ctx context.Context, orgID, id uint) error {
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var record domain.FinancialRecord
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Preload("Tags").
+				Where("organization_id = ?", orgID).
+				Take(&record, id).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&record).Error; err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityFinancialRecord, orgID, id, &record, nil)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *GormStore) RestoreFinancialRecord(ctx context.Context, orgID, id uint, quota *Quota) (*domain.FinancialRecord, error) {
+	var record domain.FinancialRecord
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("organization_id = ?", orgID).
+				Take(&record, id).Error; err != nil {
+				return err
+			}
+			// Unscoped would carry over to a preload and bring back the
+			// deleted tags; load the tags on their own instead.
+			if err := tx.Model(&record).Association("Tags").Find(&record.Tags); err != nil {
+				return err
+			}
+			if !record.DeletedAt.Valid {
+				return takeQuota(tx, orgID, &domain.FinancialRecord{}, quota, 0)
+			}
+			if err := takeQuota(tx, orgID, &domain.FinancialRecord{}, quota, 1); err != nil {
+				return err
+			}
+			before := record
+			record.DeletedAt = gorm.DeletedAt{}
+			if err := tx.Unscoped().Model(&record).Select("deleted_at", "updated_at").Updates(&record).Error; err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditRestore, domain.AuditEntityFinancialRecord, orgID, id, &before, &record)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrNotFound
+	case isOccurrenceConflict(err):
+		return nil, ErrDuplicate
+	case err != nil:
+		return nil, err
+	}
+	return &record, nil
+}
+
+// financialRecordFields are the columns UpdateFinancialRecord saves.
+var financialRecordFields = []string{"direction", "amount", "due_date", "status", "paid_amount", "paid_at",
+	"description", "counterparty_name", "counterparty_document", "reference", "metadata", "updated_at"}
+
+func (s *GormStore) UpdateFinancialRecord(ctx context.Context, orgID, id uint, update func(*domain.FinancialRecord) error) (*domain.FinancialRecord, error) {
+	var record domain.FinancialRecord
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Preload("Tags").
+				Where("organization_id = ?", orgID).
+				Take(&record, id).Error; err != nil {
+				return err
+			}
+			before := record
+			if err := update(&record); err != nil {
+				return err
+			}
+			if same, err := unchanged(&before, &record); err != nil || same {
+				return err
+			}
+			if err := tx.Model(&record).Select(financialRecordFields).Updates(&record).Error; err != nil {
+				return err
+			}
+			if p, ok := record.PaymentSince(before.PaidAmount); ok {
+				if err := tx.Create(&p).Error; err != nil {
+					return err
+				}
+			}
+			event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, id, &before, &record)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// cashFlowBasis returns the rows the cash-flow report aggregates on basis,
+// their date and amount columns, and the condition they must meet besides
+// belonging to a financial record of the organization that is not
+// deleted. The paid basis sums payments, so that a record paid in parts
+// counts in the month of each part.
+func cashFlowBasis(basis string) (from, date, amount, cond string) {
+	if basis == domain.BasisPaid {
+		return "payments JOIN financial_records ON financial_records.id = payments.financial_record_id",
+			"payments.paid_at", "payments.amount", "TRUE"
+	}
+	return "financial_records", "financial_records.due_date", "financial_records.amount",
+		"financial_records.status <> '" + domain.StatusCancelled + "'"
+}
+
+// searchSQL returns the condition a financial record must meet to match the
+// full-text search in param, and the expression ranking the matches. Words
+// are matched against the search_vector column, which indexes the
+// description, counterparty name and reference in Portuguese and English;
+// counterparty names also match on trigram similarity, so misspelled names
+// are found.
+func searchSQL(param string) (match, rank string) {
+	query := "(websearch_to_tsquery('portuguese', " + param + ") || websearch_to_tsquery('english', " + param + "))"
+	match = "(financial_records.search_vector @@ " + query + " OR " + param + " <% financial_records.counterparty_name)"
+	rank = "ts_rank(financial_records.search_vector, " + query + ") + word_similarity(" + param + ", financial_records.counterparty_name)"
+	return match, rank
+}
+
+func (s *GormStore) CashFlowReport(ctx context.Context, orgID uint, basis string, since time.Time, loc *time.Location) ([]domain.MonthlyCashFlow, error) {
+	from, date, amount, cond := cashFlowBasis(basis)
+	// Use raw SQL to aggregate data in the database
+	var monthlyData []domain.MonthlyCashFlow
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Raw(`
+			SELECT
+				EXTRACT(YEAR FROM `+date+` AT TIME ZONE ?)::integer as year,
+				EXTRACT(MONTH FROM `+date+` AT TIME ZONE ?)::integer as month,
+				SUM(CASE WHEN financial_records.direction = 'IN' THEN `+amount+` ELSE 0 END) as in,
+				SUM(CASE WHEN financial_records.direction = 'OUT' THEN `+amount+` ELSE 0 END) as out
+			FROM `+from+`
+			WHERE financial_records.organization_id = ? AND `+date+` >= ? AND `+cond+` AND financial_records.deleted_at IS NULL
+			GROUP BY 1, 2
+			ORDER BY year, month
+		`, loc.String(), loc.String(), orgID, since).Scan(&monthlyData).Error
+	})
+	return monthlyData, err
+}
+
+func (s *GormStore) ListAuditEvents(ctx context.Context, orgID uint, filter AuditEventFilter, page Page) ([]domain.AuditEvent, int64, error) {
+	var total int64
+	var events []domain.AuditEvent
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		query := db.Where("organization_id = ?", orgID)
+		if filter.EntityType != "" {
+			query = query.Where("entity_type = ?", filter.EntityType)
+		}
+		if filter.EntityID != 0 {
+			query = query.Where("entity_id = ?", filter.EntityID)
+		}
+		if filter.Action != "" {
+			query = query.Where("action = ?", filter.Action)
+		}
+		if filter.Actor != "" {
+			query = query.Where("actor = ?", filter.Actor)
+		}
+		if !filter.Since.IsZero() {
+			query = query.Where("created_at >= ?", filter.Since)
+		}
+		if !filter.Until.IsZero() {
+			query = query.Where("created_at < ?", filter.Until)
+		}
+
+		if err := query.Model(&domain.AuditEvent{}).Count(&total).Error; err != nil {
+			return err
+		}
+
+		return query.Order("id DESC").
+			Offset(page.Offset()).
+			Limit(page.Size).
+			Find(&events).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (s *GormStore) CreateRecurrence(ctx context.Context, orgID, recordID uint, rec *domain.Recurrence, until time.Time, quota *Quota) error {
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var record domain.FinancialRecord
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Preload("Tags").
+				Where("organization_id = ?", orgID).
+				Take(&record, recordID).Error; err != nil {
+				return err
+			}
+			if err := rec.Attach(&record); err != nil {
+				return err
+			}
+			// The series needs room for one more record at least.
+			if err := takeQuota(tx, orgID, &domain.FinancialRecord{}, quota, 1); err != nil {
+				return err
+			}
+			if err := tx.Create(rec).Error; err != nil {
+				return err
+			}
+			before := record
+			record.RecurrenceID, record.Occurrence = &rec.ID, 0
+			if err := tx.Model(&record).Select("recurrence_id", "occurrence", "updated_at").Updates(&record).Error; err != nil {
+				return err
+			}
+			created, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityRecurrence, orgID, rec.ID, nil, rec)
+			if err != nil {
+				return err
+			}
+			attached, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, record.ID, &before, &record)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&[]domain.AuditEvent{created, attached}).Error; err != nil {
+				return err
+			}
+			_, err = advanceRecurrence(ctx, tx, rec, until, quota)
+			return err
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *GormStore) GetRecurrence(ctx context.Context, orgID, id uint) (*domain.Recurrence, error) {
+	var rec domain.Recurrence
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Where("organization_id = ?", orgID).Take(&rec, id).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *GormStore) ListRecurrences(ctx context.Context, orgID uint, page Page) ([]domain.Recurrence, int64, error) {
+	var total int64
+	var recs []domain.Recurrence
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		query := db.Model(&domain.Recurrence{}).Where("organization_id = ?", orgID)
+		if err := query.Count(&total).Error; err != nil {
+			return err
+		}
+		return query.Order("id").Offset(page.Offset()).Limit(page.Size).Find(&recs).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return recs, total, nil
+}
+
+func (s *GormStore) DeleteRecurrence(ctx context.Context, orgID, id uint) error {
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			// Lock the recurrence as MaterializeRecurrences does, so that
+			// no occurrence is created once it is deleted.
+			var rec domain.Recurrence
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("organization_id = ?", orgID).
+				Take(&rec, id).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&rec).Error; err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityRecurrence, orgID, id, &rec, nil)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&event).Error
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// recurrenceFields are the columns EditRecurrence saves.
+var recurrenceFields = []string{"frequency", "repeat_interval", "day_of_month", "end_date", "repeat_count",
+	"direction", "amount", "description", "counterparty_name", "counterparty_document", "reference", "metadata", "tag_ids",
+	"start_date", "start_occurrence", "generated", "next_date", "updated_at"}
+
+func (s *GormStore) EditRecurrence(ctx context.Context, orgID, recordID uint, edit func(*domain.Recurrence, []domain.FinancialRecord) error, until time.Time, limit int) (*domain.FinancialRecord, error) {
+	var record domain.FinancialRecord
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("organization_id = ?", orgID).Take(&record, recordID).Error; err != nil {
+				return err
+			}
+			if record.RecurrenceID == nil {
+				return domain.ErrRecordNotRecurring
+			}
+			// Lock the recurrence before its occurrences, as
+			// MaterializeRecurrences does.
+			var rec domain.Recurrence
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("organization_id = ?", orgID).
+				Take(&rec, *record.RecurrenceID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The series has ended.
+				return domain.ErrRecordNotRecurring
+			}
+			if err != nil {
+				return err
+			}
+			var occurrences []domain.FinancialRecord
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Preload("Tags").
+				Where("organization_id = ? AND recurrence_id = ? AND occurrence >= ?", orgID, rec.ID, record.Occurrence).
+				Order("occurrence").
+				Find(&occurrences).Error; err != nil {
+				return err
+			}
+			if len(occurrences) == 0 || occurrences[0].ID != recordID {
+				// Deleted in the meantime.
+				return gorm.ErrRecordNotFound
+			}
+
+			before, beforeRec := slices.Clone(occurrences), rec
+			if err := edit(&rec, occurrences); err != nil {
+				return err
+			}
+			var events []domain.AuditEvent
+			for i := range occurrences {
+				o, b := &occurrences[i], &before[i]
+				if o.DeletedAt.Valid {
+					if err := tx.Delete(o).Error; err != nil {
+						return err
+					}
+					event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityFinancialRecord, orgID, o.ID, b, nil)
+					if err != nil {
+						return err
+					}
+					events = append(events, event)
+					continue
+				}
+				if same, err := unchanged(b, o); err != nil {
+					return err
+				} else if same {
+					continue
+				}
+				if err := tx.Model(o).Select(financialRecordFields).Updates(o).Error; err != nil {
+					return err
+				}
+				event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, o.ID, b, o)
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+			if same, err := unchanged(&beforeRec, &rec); err != nil {
+				return err
+			} else if !same {
+				if err := tx.Model(&rec).Select(recurrenceFields).Updates(&rec).Error; err != nil {
+					return err
+				}
+				event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityRecurrence, orgID, rec.ID, &beforeRec, &rec)
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+			if len(events) > 0 {
+				if err := tx.Create(&events).Error; err != nil {
+					return err
+				}
+			}
+			record = occurrences[0]
+			_, err = advanceRecurrence(ctx, tx, &rec, until, &Quota{Limit: limit})
+			return err
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *GormStore) MaterializeRecurrences(ctx context.Context, until time.Time, quota int) (int, error) {
+	// Find the due series of every organization that is not deleted, with
+	// the limit of the organization, as the table owner, then advance each
+	// as its organization.
+	var due []struct {
+		ID, OrganizationID  uint
+		MaxFinancialRecords *int
+	}
+	if err := s.db.WithContext(ctx).Model(&domain.Recurrence{}).
+		Select("recurrences.id, recurrences.organization_id, organizations.max_financial_records").
+		Joins("JOIN organizations ON organizations.id = recurrences.organization_id AND organizations.deleted_at IS NULL").
+		Where("recurrences.next_date <= ?", until).
+		Order("recurrences.id").
+		Scan(&due).Error; err != nil {
+		return 0, err
+	}
+	var created int
+	var errs []error
+	for _, d := range due {
+		org := domain.Organization{MaxFinancialRecords: d.MaxFinancialRecords}
+		n, err := s.materialize(WithOrganization(ctx, d.OrganizationID), d.OrganizationID, d.ID, until, org.FinancialRecordLimit(quota))
+		created += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recurrence %d: %w", d.ID, err))
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// materialize locks the organization's recurrence and creates its
+// occurrences due up to until that fit in limit.
+func (s *GormStore) materialize(ctx context.Context, orgID, id uint, until time.Time, limit int) (int, error) {
+	var n int
+	err := s.tenant(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var rec domain.Recurrence
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("organization_id = ?", orgID).
+				Take(&rec, id).Error; err != nil {
+				return err
+			}
+			var err error
+			n, err = advanceRecurrence(ctx, tx, &rec, until, &Quota{Limit: limit})
+			return err
+		})
+	})
+	return n, err
+}
+
+// advanceRecurrence creates the occurrences of the locked rec due up to
+// until that fit in quota, linked to the template tags that are not
+// deleted, with their audit events, and saves how far the series got.
+func advanceRecurrence(ctx context.Context, tx *gorm.DB, rec *domain.Recurrence, until time.Time, quota *Quota) (int, error) {
+	held, err := countHeld(tx, rec.OrganizationID, &domain.FinancialRecord{}, quota)
+	if err != nil {
+		return 0, err
+	}
+	occurrences := rec.Advance(until, occurrenceRoom(quota.limit(), held))
+	if err := quota.take(held, len(occurrences)); err != nil {
+		return 0, err
+	}
+	if len(occurrences) == 0 {
+		return 0, nil
+	}
+	if err := createFinancialRecords(tx, occurrences); err != nil {
+		return 0, err
+	}
+	events, err := recordsCreated(ctx, occurrences)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Create(&events).Error; err != nil {
+		return 0, err
+	}
+	return len(occurrences), tx.Model(rec).Select("generated", "next_date", "updated_at").Updates(rec).Error
+}
+
+// takeQuota has quota take n more of the organization's items of model,
+// counted by countHeld.
+func takeQuota(tx *gorm.DB, orgID uint, model any, quota *Quota, n int) error {
+	held, err := countHeld(tx, orgID, model, quota)
+	if err != nil {
+		return err
+	}
+	return quota.take(held, n)
+}
+
+// countHeld locks the organization's row until tx ends and returns how
+// many of its items of model are not deleted, or zero without counting
+// when quota is unlimited. Writes lock the organization after the rows
+// they change, so that they cannot deadlock one another.
+func countHeld(tx *gorm.DB, orgID uint, model any, quota *Quota) (int64, error) {
+	if quota.limit() == 0 {
+		return 0, nil
+	}
+	if err := tx.Exec(`SELECT 1 FROM organizations WHERE id = ? FOR UPDATE`, orgID).Error; err != nil {
+		return 0, err
+	}
+	var held int64
+	err := tx.Model(model).Where("organization_id = ?", orgID).Count(&held).Error
+	return held, err
+}
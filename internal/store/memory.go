@@ -0,0 +1,1106 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"gorm.io/gorm"
+)
+
+// MemoryStore implements OrganizationStore, APIKeyStore, TagStore,
+// FinancialRecordStore, RecurrenceStore and AuditEventStore in process
+// memory. It follows
+// the semantics of the SQL stores (pagination in insertion order, tag
+// filtering, soft-deleted rows hidden from listings, foreign keys to
+// organizations, monthly cash-flow aggregation in UTC) so that handlers can
+// be tested without Postgres. Full-text search is approximated: every word
+// of the query must occur in the record, without stemming or fuzziness.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	nextOrgID        uint
+	nextAPIKeyID     uint
+	nextTagID        uint
+	nextRecordID     uint
+	nextEventID      uint
+	nextRecurrenceID uint
+	nextPaymentID    uint
+	organizations    []domain.Organization
+	apiKeys          []domain.APIKey
+	tags             []domain.Tag
+	records          []domain.FinancialRecord
+	payments         []domain.Payment
+	recurrences      []domain.Recurrence
+	events           []domain.AuditEvent
+	// recordTags maps a financial record ID to the IDs of its tags.
+	recordTags map[uint][]uint
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{recordTags: map[uint][]uint{}}
+}
+
+func (s *MemoryStore) CreateOrganization(ctx context.Context, org *domain.Organization) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.nextOrgID++
+	org.ID = s.nextOrgID
+	org.CreatedAt = now
+	org.UpdatedAt = now
+	s.organizations = append(s.organizations, *org)
+	return nil
+}
+
+func (s *MemoryStore) GetOrganization(ctx context.Context, id uint) (*domain.Organization, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	org := s.organization(id)
+	if org == nil || org.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	found := *org
+	return &found, nil
+}
+
+func (s *MemoryStore) ListOrganizations(ctx context.Context, page Page) ([]domain.Organization, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []domain.Organization
+	for _, org := range s.organizations {
+		if !org.DeletedAt.Valid {
+			matches = append(matches, org)
+		}
+	}
+	return paginate(matches, page), int64(len(matches)), nil
+}
+
+func (s *MemoryStore) UpdateOrganization(ctx context.Context, org *domain.Organization) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.organization(org.ID)
+	if stored == nil || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	stored.Name = org.Name
+	stored.TimeZone = org.TimeZone
+	stored.Currency = org.Currency
+	stored.MaxTags, stored.MaxFinancialRecords = org.MaxTags, org.MaxFinancialRecords
+	stored.UpdatedAt = time.Now()
+	*org = *stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteOrganization(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := s.organization(id)
+	if org == nil || org.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	org.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range key.Grants {
+		if err := s.checkOrganization(g.OrganizationID); err != nil {
+			return err
+		}
+	}
+	for _, k := range s.apiKeys {
+		if k.Hash == key.Hash {
+			return ErrDuplicate
+		}
+	}
+
+	now := time.Now()
+	s.nextAPIKeyID++
+	key.ID = s.nextAPIKeyID
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	for i := range key.Grants {
+		key.Grants[i].APIKeyID = key.ID
+	}
+	stored := *key
+	stored.Grants = slices.Clone(key.Grants)
+	s.apiKeys = append(s.apiKeys, stored)
+	return nil
+}
+
+func (s *MemoryStore) FindAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.Hash == hash && !k.DeletedAt.Valid {
+			k.Grants = slices.Clone(k.Grants)
+			return &k, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) ListAPIKeys(ctx context.Context, page Page) ([]domain.APIKey, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []domain.APIKey
+	for _, k := range s.apiKeys {
+		if !k.DeletedAt.Valid {
+			matches = append(matches, k)
+		}
+	}
+	result := paginate(matches, page)
+	for i := range result {
+		result[i].Grants = slices.Clone(result[i].Grants)
+	}
+	return result, int64(len(matches)), nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.apiKeys {
+		if k := &s.apiKeys[i]; k.ID == id && !k.DeletedAt.Valid {
+			k.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) CreateTag(ctx context.Context, tag *domain.Tag, quota *Quota) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkOrganization(tag.OrganizationID); err != nil {
+		return err
+	}
+	if err := quota.take(s.countTags(tag.OrganizationID), 1); err != nil {
+		return err
+	}
+	if s.findTagByName(tag.OrganizationID, tag.Name) != nil {
+		return ErrDuplicate
+	}
+
+	now := time.Now()
+	tag.ID = s.nextTagID + 1
+	tag.CreatedAt = now
+	tag.UpdatedAt = now
+	event, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityTag, tag.OrganizationID, tag.ID, nil, tag)
+	if err != nil {
+		return err
+	}
+	s.nextTagID++
+	s.tags = append(s.tags, *tag)
+	s.audit(event)
+	return nil
+}
+
+func (s *MemoryStore) ListTags(ctx context.Context, orgID uint, filter TagFilter, page Page) ([]domain.Tag, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []domain.Tag
+	for _, tag := range s.tags {
+		if tag.OrganizationID == orgID && (filter.IncludeDeleted || !tag.DeletedAt.Valid) {
+			matches = append(matches, tag)
+		}
+	}
+	return paginate(matches, page), int64(len(matches)), nil
+}
+
+func (s *MemoryStore) FindTagByName(ctx context.Context, orgID uint, name string) (*domain.Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if tag := s.findTagByName(orgID, name); tag != nil {
+		found := *tag
+		return &found, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) CountTags(ctx context.Context, orgID uint) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.countTags(orgID), nil
+}
+
+// countTags returns the number of the organization's tags that are not
+// deleted. Callers must hold mu.
+func (s *MemoryStore) countTags(orgID uint) int64 {
+	var n int64
+	for _, tag := range s.tags {
+		if tag.OrganizationID == orgID && !tag.DeletedAt.Valid {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemoryStore) DeleteTag(ctx context.Context, orgID, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tag := s.tag(id)
+	if tag == nil || tag.OrganizationID != orgID || tag.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityTag, orgID, id, tag, nil)
+	if err != nil {
+		return err
+	}
+	tag.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.audit(event)
+	return nil
+}
+
+func (s *MemoryStore) RestoreTag(ctx context.Context, orgID, id uint, quota *Quota) (*domain.Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tag := s.tag(id)
+	if tag == nil || tag.OrganizationID != orgID {
+		return nil, ErrNotFound
+	}
+	if !tag.DeletedAt.Valid {
+		restored := *tag
+		return &restored, quota.take(s.countTags(orgID), 0)
+	}
+	if err := quota.take(s.countTags(orgID), 1); err != nil {
+		return nil, err
+	}
+	if s.findTagByName(orgID, tag.Name) != nil {
+		return nil, ErrDuplicate
+	}
+
+	restored := *tag
+	restored.DeletedAt = gorm.DeletedAt{}
+	restored.UpdatedAt = time.Now()
+	event, err := newAuditEvent(ctx, domain.AuditRestore, domain.AuditEntityTag, orgID, id, tag, &restored)
+	if err != nil {
+		return nil, err
+	}
+	*tag = restored
+	s.audit(event)
+	return &restored, nil
+}
+
+// findTagByName returns the live tag of the organization with the same
+// normalized name, or nil. The caller must hold s.mu.
+func (s *MemoryStore) findTagByName(orgID uint, name string) *domain.Tag {
+	key := domain.NormalizeTagName(name)
+	for i := range s.tags {
+		tag := &s.tags[i]
+		if tag.OrganizationID == orgID && !tag.DeletedAt.Valid && domain.NormalizeTagName(tag.Name) == key {
+			return tag
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateFinancialRecord(ctx context.Context, record *domain.FinancialRecord, quota *Quota) error {
+	records := []domain.FinancialRecord{*record}
+	if err := s.CreateFinancialRecords(ctx, records, quota); err != nil {
+		return err
+	}
+	*record = records[0]
+	return nil
+}
+
+// CreateFinancialRecords inserts records and links them to the tags in
+// record.Tags that exist in the record's organization and are not deleted,
+// leaving only those in record.Tags.
+func (s *MemoryStore) CreateFinancialRecords(ctx context.Context, records []domain.FinancialRecord, quota *Quota) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := quota.take(s.countRecords(records[0].OrganizationID), len(records)); err != nil {
+		return err
+	}
+	return s.createFinancialRecords(ctx, records)
+}
+
+// createFinancialRecords inserts records with their tag links and audit
+// events. Callers must hold mu.
+func (s *MemoryStore) createFinancialRecords(ctx context.Context, records []domain.FinancialRecord) error {
+	for _, record := range records {
+		if err := s.checkOrganization(record.OrganizationID); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for i := range records {
+		records[i].ID = s.nextRecordID + uint(i) + 1
+		records[i].CreatedAt = now
+		records[i].UpdatedAt = now
+		if records[i].Tags == nil {
+			continue
+		}
+		linked := []domain.Tag{}
+		for _, tag := range records[i].Tags {
+			t := s.tag(tag.ID)
+			if t != nil && t.OrganizationID == records[i].OrganizationID && !t.DeletedAt.Valid &&
+				!slices.ContainsFunc(linked, func(l domain.Tag) bool { return l.ID == tag.ID }) {
+				linked = append(linked, *t)
+			}
+		}
+		records[i].Tags = linked
+	}
+	events, err := recordsCreated(ctx, records)
+	if err != nil {
+		return err
+	}
+
+	for i := range records {
+		s.nextRecordID++
+
+		stored := records[i]
+		stored.Tags = nil
+		s.records = append(s.records, stored)
+
+		for _, tag := range records[i].Tags {
+			s.recordTags[stored.ID] = append(s.recordTags[stored.ID], tag.ID)
+		}
+	}
+	s.pay(paymentsMade(records)...)
+	s.audit(events...)
+	return nil
+}
+
+// pay stores payments, numbering them. Callers must hold mu.
+func (s *MemoryStore) pay(payments ...domain.Payment) {
+	now := time.Now()
+	for _, p := range payments {
+		s.nextPaymentID++
+		p.ID, p.CreatedAt = s.nextPaymentID, now
+		s.payments = append(s.payments, p)
+	}
+}
+
+func (s *MemoryStore) ListFinancialRecords(ctx context.Context, orgID uint, filter FinancialRecordFilter, page Page) ([]domain.FinancialRecord, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []domain.FinancialRecord
+	ranks := map[uint]float64{}
+	words := strings.FieldsFunc(strings.ToLower(filter.Query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, record := range s.records {
+		if record.OrganizationID != orgID || record.DeletedAt.Valid && !filter.IncludeDeleted {
+			continue
+		}
+		if len(filter.TagIDs) > 0 && !slices.ContainsFunc(s.recordTags[record.ID], func(id uint) bool {
+			return slices.Contains(filter.TagIDs, id)
+		}) {
+			continue
+		}
+		if filter.CounterpartyName != "" && !strings.EqualFold(record.CounterpartyName, filter.CounterpartyName) ||
+			filter.CounterpartyDocument != "" && record.CounterpartyDocument != filter.CounterpartyDocument ||
+			filter.Reference != "" && record.Reference != filter.Reference {
+			continue
+		}
+		if filter.Metadata != nil && !metadataContains(record.Metadata, filter.Metadata) {
+			continue
+		}
+		if filter.Query != "" {
+			rank, ok := searchRank(&record, words)
+			if !ok {
+				continue
+			}
+			ranks[record.ID] = rank
+		}
+		matches = append(matches, record)
+	}
+	if filter.Query != "" {
+		// Order by relevance, keeping insertion order among equals.
+		slices.SortStableFunc(matches, func(a, b domain.FinancialRecord) int {
+			return cmp.Compare(ranks[b.ID], ranks[a.ID])
+		})
+	}
+
+	result := paginate(matches, page)
+	for i := range result {
+		result[i].Tags = s.liveTags(result[i].ID)
+	}
+	return result, int64(len(matches)), nil
+}
+
+// searchRank reports whether every word occurs in the description,
+// counterparty name or reference of the record, and ranks the match like
+// the weights of search_vector: a word found in the counterparty name or
+// reference counts more than one found only in the description.
+func searchRank(record *domain.FinancialRecord, words []string) (float64, bool) {
+	if len(words) == 0 {
+		return 0, false
+	}
+	primary := strings.ToLower(record.CounterpartyName + " " + record.Reference)
+	description := strings.ToLower(record.Description)
+	var rank float64
+	for _, word := range words {
+		switch {
+		case strings.Contains(primary, word):
+			rank += 1
+		case strings.Contains(description, word):
+			rank += 0.4
+		default:
+			return 0, false
+		}
+	}
+	return rank, true
+}
+
+// metadataContains reports whether the metadata document contains the
+// filter document, following the rules of the jsonb @> operator: objects
+// contain the keys of the filter with contained values, arrays contain
+// every element of the filter and scalars must be equal.
+func metadataContains(metadata, filter json.RawMessage) bool {
+	if metadata == nil {
+		return false
+	}
+	var doc, sub any
+	if json.Unmarshal(metadata, &doc) != nil || json.Unmarshal(filter, &sub) != nil {
+		return false
+	}
+	return jsonContains(doc, sub)
+}
+
+func jsonContains(doc, sub any) bool {
+	switch sub := sub.(type) {
+	case map[string]any:
+		doc, ok := doc.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range sub {
+			if v, ok := doc[key]; !ok || !jsonContains(v, value) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		doc, ok := doc.([]any)
+		if !ok {
+			return false
+		}
+		for _, value := range sub {
+			if !slices.ContainsFunc(doc, func(v any) bool { return jsonContains(v, value) }) {
+				return false
+			}
+		}
+		return true
+	default:
+		return doc == sub
+	}
+}
+
+// liveTags returns the tags of the record that are not deleted. Callers
+// must hold mu.
+func (s *MemoryStore) liveTags(recordID uint) []domain.Tag {
+	tags := []domain.Tag{}
+	for _, id := range s.recordTags[recordID] {
+		if t := s.tag(id); t != nil && !t.DeletedAt.Valid {
+			tags = append(tags, *t)
+		}
+	}
+	return tags
+}
+
+func (s *MemoryStore) CountFinancialRecords(ctx context.Context, orgID uint) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.countRecords(orgID), nil
+}
+
+// countRecords returns the number of the organization's records that are
+// not deleted. Callers must hold mu.
+func (s *MemoryStore) countRecords(orgID uint) int64 {
+	var n int64
+	for _, record := range s.records {
+		if record.OrganizationID == orgID && !record.DeletedAt.Valid {
+			n++
+		}
+	}
+	return n
+}
+
+// occurrenceTaken reports whether another record that is not deleted is
+// the same occurrence of the same recurrence as record.
+func (s *MemoryStore) occurrenceTaken(record *domain.FinancialRecord) bool {
+	if record.RecurrenceID == nil {
+		return false
+	}
+	for _, other := range s.records {
+		if other.ID != record.ID && !other.DeletedAt.Valid && other.RecurrenceID != nil &&
+			*other.RecurrenceID == *record.RecurrenceID && other.Occurrence == record.Occurrence {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) DeleteFinancialRecord(ctx context.Context, orgID, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.record(id)
+	if record == nil || record.OrganizationID != orgID || record.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	before := *record
+	before.Tags = s.liveTags(id)
+	event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityFinancialRecord, orgID, id, &before, nil)
+	if err != nil {
+		return err
+	}
+	record.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.audit(event)
+	return nil
+}
+
+func (s *MemoryStore) RestoreFinancialRecord(ctx context.Context, orgID, id uint, quota *Quota) (*domain.FinancialRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.record(id)
+	if record == nil || record.OrganizationID != orgID {
+		return nil, ErrNotFound
+	}
+	before := *record
+	before.Tags = s.liveTags(id)
+	if !record.DeletedAt.Valid {
+		return &before, quota.take(s.countRecords(orgID), 0)
+	}
+	if err := quota.take(s.countRecords(orgID), 1); err != nil {
+		return nil, err
+	}
+	if s.occurrenceTaken(record) {
+		return nil, ErrDuplicate
+	}
+
+	restored := before
+	restored.DeletedAt = gorm.DeletedAt{}
+	restored.UpdatedAt = time.Now()
+	event, err := newAuditEvent(ctx, domain.AuditRestore, domain.AuditEntityFinancialRecord, orgID, id, &before, &restored)
+	if err != nil {
+		return nil, err
+	}
+	record.DeletedAt = restored.DeletedAt
+	record.UpdatedAt = restored.UpdatedAt
+	s.audit(event)
+	return &restored, nil
+}
+
+func (s *MemoryStore) UpdateFinancialRecord(ctx context.Context, orgID, id uint, update func(*domain.FinancialRecord) error) (*domain.FinancialRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.record(id)
+	if record == nil || record.OrganizationID != orgID || record.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	before := *record
+	before.Tags = s.liveTags(id)
+	updated := before
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	if same, err := unchanged(&before, &updated); err != nil || same {
+		return &updated, err
+	}
+
+	updated.UpdatedAt = time.Now()
+	saved := savedFields(before, updated)
+	event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, id, &before, &saved)
+	if err != nil {
+		return nil, err
+	}
+	*record = saved
+	record.Tags = nil
+	if p, ok := saved.PaymentSince(before.PaidAmount); ok {
+		s.pay(p)
+	}
+	s.audit(event)
+	return &saved, nil
+}
+
+// savedFields returns before with the fields of updated that the SQL stores
+// save on an update.
+func savedFields(before, updated domain.FinancialRecord) domain.FinancialRecord {
+	saved := before
+	saved.Direction, saved.Amount, saved.DueDate = updated.Direction, updated.Amount, updated.DueDate
+	saved.Status, saved.PaidAmount, saved.PaidAt = updated.Status, updated.PaidAmount, updated.PaidAt
+	saved.Description, saved.Reference, saved.Metadata = updated.Description, updated.Reference, updated.Metadata
+	saved.CounterpartyName, saved.CounterpartyDocument = updated.CounterpartyName, updated.CounterpartyDocument
+	saved.UpdatedAt = updated.UpdatedAt
+	return saved
+}
+
+func (s *MemoryStore) CashFlowReport(ctx context.Context, orgID uint, basis string, since time.Time, loc *time.Location) ([]domain.MonthlyCashFlow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type month struct{ year, month int }
+	totals := map[month]*domain.MonthlyCashFlow{}
+	add := func(record *domain.FinancialRecord, date time.Time, amount float64) {
+		if record == nil || record.OrganizationID != orgID || record.DeletedAt.Valid || date.Before(since) {
+			return
+		}
+		date = date.In(loc)
+		key := month{date.Year(), int(date.Month())}
+		m, ok := totals[key]
+		if !ok {
+			m = &domain.MonthlyCashFlow{Year: key.year, Month: key.month}
+			totals[key] = m
+		}
+		switch record.Direction {
+		case "IN":
+			m.In += amount
+		case "OUT":
+			m.Out += amount
+		}
+	}
+	if basis == domain.BasisPaid {
+		for _, p := range s.payments {
+			add(s.record(p.FinancialRecordID), p.PaidAt, p.Amount)
+		}
+	} else {
+		for i := range s.records {
+			if record := &s.records[i]; record.Status != domain.StatusCancelled {
+				add(record, record.DueDate, record.Amount)
+			}
+		}
+	}
+
+	report := make([]domain.MonthlyCashFlow, 0, len(totals))
+	for _, m := range totals {
+		report = append(report, *m)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Year != report[j].Year {
+			return report[i].Year < report[j].Year
+		}
+		return report[i].Month < report[j].Month
+	})
+	return report, nil
+}
+
+// organization returns the stored organization with the given ID, deleted
+// or not, or nil. Callers must hold mu.
+func (s *MemoryStore) organization(id uint) *domain.Organization {
+	i, found := slices.BinarySearchFunc(s.organizations, id, func(o domain.Organization, id uint) int {
+		return cmp.Compare(o.ID, id)
+	})
+	if !found {
+		return nil
+	}
+	return &s.organizations[i]
+}
+
+// checkOrganization mimics the foreign keys to organizations: rows may only
+// reference an organization that was created, even if it was deleted since.
+// Callers must hold mu.
+func (s *MemoryStore) checkOrganization(id uint) error {
+	if s.organization(id) == nil {
+		return fmt.Errorf("store: organization %d does not exist", id)
+	}
+	return nil
+}
+
+// tag returns the stored tag with the given ID, or nil. Callers must hold mu.
+func (s *MemoryStore) tag(id uint) *domain.Tag {
+	i, found := slices.BinarySearchFunc(s.tags, id, func(t domain.Tag, id uint) int {
+		return cmp.Compare(t.ID, id)
+	})
+	if !found {
+		return nil
+	}
+	return &s.tags[i]
+}
+
+// audit appends events to the audit log. Callers must hold mu.
+func (s *MemoryStore) audit(events ...domain.AuditEvent) {
+	for _, e := range events {
+		s.nextEventID++
+		e.ID = s.nextEventID
+		s.events = append(s.events, e)
+	}
+}
+
+func (s *MemoryStore) ListAuditEvents(ctx context.Context, orgID uint, filter AuditEventFilter, page Page) ([]domain.AuditEvent, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []domain.AuditEvent
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if e.OrganizationID != orgID ||
+			filter.EntityType != "" && e.EntityType != filter.EntityType ||
+			filter.EntityID != 0 && e.EntityID != filter.EntityID ||
+			filter.Action != "" && e.Action != filter.Action ||
+			filter.Actor != "" && e.Actor != filter.Actor ||
+			!filter.Since.IsZero() && e.CreatedAt.Before(filter.Since) ||
+			!filter.Until.IsZero() && !e.CreatedAt.Before(filter.Until) {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	return paginate(matches, page), int64(len(matches)), nil
+}
+
+// record returns the stored financial record with the given ID, or nil.
+// Callers must hold mu.
+func (s *MemoryStore) record(id uint) *domain.FinancialRecord {
+	i, found := slices.BinarySearchFunc(s.records, id, func(r domain.FinancialRecord, id uint) int {
+		return cmp.Compare(r.ID, id)
+	})
+	if !found {
+		return nil
+	}
+	return &s.records[i]
+}
+
+// paginate returns a copy of the page of items.
+func paginate[T any](items []T, page Page) []T {
+	start := min(page.Offset(), len(items))
+	end := min(start+page.Size, len(items))
+	return append([]T{}, items[start:end]...)
+}
+
+func (s *MemoryStore) CreateRecurrence(ctx context.Context, orgID, recordID uint, rec *domain.Recurrence, until time.Time, quota *Quota) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.record(recordID)
+	if record == nil || record.OrganizationID != orgID || record.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	before := *record
+	before.Tags = s.liveTags(recordID)
+	attached := before
+	if err := rec.Attach(&attached); err != nil {
+		return err
+	}
+	// The series needs room for one more record at least.
+	if err := quota.take(s.countRecords(orgID), 1); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rec.ID = s.nextRecurrenceID + 1
+	rec.CreatedAt, rec.UpdatedAt = now, now
+	id := rec.ID
+	attached.RecurrenceID, attached.Occurrence, attached.UpdatedAt = &id, 0, now
+	created, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityRecurrence, orgID, rec.ID, nil, rec)
+	if err != nil {
+		return err
+	}
+	updated, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, recordID, &before, &attached)
+	if err != nil {
+		return err
+	}
+	s.nextRecurrenceID++
+	s.recurrences = append(s.recurrences, *rec)
+	record.RecurrenceID, record.Occurrence, record.UpdatedAt = attached.RecurrenceID, attached.Occurrence, attached.UpdatedAt
+	s.audit(created, updated)
+
+	stored := &s.recurrences[len(s.recurrences)-1]
+	_, err = s.advance(ctx, stored, until, quota)
+	*rec = *stored
+	return err
+}
+
+func (s *MemoryStore) GetRecurrence(ctx context.Context, orgID, id uint) (*domain.Recurrence, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec := s.recurrence(id)
+	if rec == nil || rec.OrganizationID != orgID || rec.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	found := *rec
+	return &found, nil
+}
+
+func (s *MemoryStore) ListRecurrences(ctx context.Context, orgID uint, page Page) ([]domain.Recurrence, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []domain.Recurrence
+	for _, rec := range s.recurrences {
+		if rec.OrganizationID == orgID && !rec.DeletedAt.Valid {
+			matches = append(matches, rec)
+		}
+	}
+	return paginate(matches, page), int64(len(matches)), nil
+}
+
+func (s *MemoryStore) DeleteRecurrence(ctx context.Context, orgID, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.recurrence(id)
+	if rec == nil || rec.OrganizationID != orgID || rec.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityRecurrence, orgID, id, rec, nil)
+	if err != nil {
+		return err
+	}
+	rec.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.audit(event)
+	return nil
+}
+
+func (s *MemoryStore) EditRecurrence(ctx context.Context, orgID, recordID uint, edit func(*domain.Recurrence, []domain.FinancialRecord) error, until time.Time, limit int) (*domain.FinancialRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.record(recordID)
+	if record == nil || record.OrganizationID != orgID || record.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	if record.RecurrenceID == nil {
+		return nil, domain.ErrRecordNotRecurring
+	}
+	rec := s.recurrence(*record.RecurrenceID)
+	if rec == nil || rec.OrganizationID != orgID || rec.DeletedAt.Valid {
+		// The series has ended.
+		return nil, domain.ErrRecordNotRecurring
+	}
+	var occurrences []domain.FinancialRecord
+	for _, r := range s.records {
+		if r.RecurrenceID != nil && *r.RecurrenceID == rec.ID && r.Occurrence >= record.Occurrence && !r.DeletedAt.Valid {
+			r.Tags = s.liveTags(r.ID)
+			occurrences = append(occurrences, r)
+		}
+	}
+	slices.SortFunc(occurrences, func(a, b domain.FinancialRecord) int {
+		return cmp.Compare(a.Occurrence, b.Occurrence)
+	})
+
+	before, edited := slices.Clone(occurrences), *rec
+	if err := edit(&edited, occurrences); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var events []domain.AuditEvent
+	for i := range occurrences {
+		o, b := &occurrences[i], &before[i]
+		if o.DeletedAt.Valid {
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityFinancialRecord, orgID, o.ID, b, nil)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+			continue
+		}
+		if same, err := unchanged(b, o); err != nil {
+			return nil, err
+		} else if same {
+			continue
+		}
+		o.UpdatedAt = now
+		*o = savedFields(*b, *o)
+		event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, o.ID, b, o)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if same, err := unchanged(rec, &edited); err != nil {
+		return nil, err
+	} else if !same {
+		edited.UpdatedAt = now
+		event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityRecurrence, orgID, rec.ID, rec, &edited)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	for _, o := range occurrences {
+		stored := s.record(o.ID)
+		if o.DeletedAt.Valid {
+			stored.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+			continue
+		}
+		*stored = o
+		stored.Tags = nil
+	}
+	*rec = edited
+	s.audit(events...)
+	result := occurrences[0]
+	_, err := s.advance(ctx, rec, until, &Quota{Limit: limit})
+	return &result, err
+}
+
+func (s *MemoryStore) MaterializeRecurrences(ctx context.Context, until time.Time, quota int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var created int
+	var errs []error
+	for i := range s.recurrences {
+		rec := &s.recurrences[i]
+		if rec.DeletedAt.Valid || rec.NextDate == nil || rec.NextDate.After(until) {
+			continue
+		}
+		org := s.organization(rec.OrganizationID)
+		if org == nil || org.DeletedAt.Valid {
+			continue
+		}
+		n, err := s.advance(ctx, rec, until, &Quota{Limit: org.FinancialRecordLimit(quota)})
+		created += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recurrence %d: %w", rec.ID, err))
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// advance creates the occurrences of rec due up to until that fit in
+// quota, linked to the template tags that are not deleted. Callers must
+// hold mu.
+func (s *MemoryStore) advance(ctx context.Context, rec *domain.Recurrence, until time.Time, quota *Quota) (int, error) {
+	next := *rec
+	held := s.countRecords(rec.OrganizationID)
+	occurrences := next.Advance(until, occurrenceRoom(quota.limit(), held))
+	if err := quota.take(held, len(occurrences)); err != nil {
+		return 0, err
+	}
+	if len(occurrences) == 0 {
+		return 0, nil
+	}
+	if err := s.createFinancialRecords(ctx, occurrences); err != nil {
+		return 0, err
+	}
+	next.UpdatedAt = time.Now()
+	*rec = next
+	return len(occurrences), nil
+}
+
+// recurrence returns the stored recurrence with the given ID, or nil.
+// Callers must hold mu.
+func (s *MemoryStore) recurrence(id uint) *domain.Recurrence {
+	i, found := slices.BinarySearchFunc(s.recurrences, id, func(r domain.Recurrence, id uint) int {
+		return cmp.Compare(r.ID, id)
+	})
+	if !found {
+		return nil
+	}
+	return &s.recurrences[i]
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"gorm.io/gorm"
+)
+
+// Migrate creates or updates the schema, its constraints and indexes.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&domain.Organization{}, &domain.APIKey{}, &domain.APIKeyGrant{}, &domain.Tag{}, &domain.FinancialRecord{}, &domain.Payment{}, &domain.Recurrence{}, &domain.AuditEvent{}); err != nil {
+		return err
+	}
+	if err := organizationForeignKeys(db); err != nil {
+		return fmt.Errorf("add organization foreign keys: %w", err)
+	}
+	if err := uniqueTagNames(db); err != nil {
+		return fmt.Errorf("enforce unique tag names: %w", err)
+	}
+	if err := appendOnlyAuditEvents(db); err != nil {
+		return fmt.Errorf("make audit events append-only: %w", err)
+	}
+	if err := searchableFinancialRecords(db); err != nil {
+		return fmt.Errorf("make financial records searchable: %w", err)
+	}
+	if err := recurringFinancialRecords(db); err != nil {
+		return fmt.Errorf("link financial records to recurrences: %w", err)
+	}
+	if err := financialRecordPayments(db); err != nil {
+		return fmt.Errorf("record payments of financial records: %w", err)
+	}
+	ApplyIndexes(db)
+	return nil
+}
+
+// organizationForeignKeys makes API key grants, tags, financial records,
+// payments, recurrences and audit events reference their organization.
+// Data written before organizations existed refers to bare IDs; an
+// organization is created for each of them first, named after its ID and
+// with the default settings.
+func organizationForeignKeys(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		created := tx.Exec(`
+			INSERT INTO organizations (id, created_at, updated_at, name, time_zone, currency)
+			SELECT organization_id, now(), now(), 'Organization ' || organization_id, ?, ?
+			FROM (
+				SELECT organization_id FROM tags
+				UNION
+				SELECT organization_id FROM financial_records
+			) referenced
+			ON CONFLICT (id) DO NOTHING`, domain.DefaultTimeZone, domain.DefaultCurrency)
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected > 0 {
+			slog.Info("Created organizations for existing data", "count", created.RowsAffected)
+			// The IDs were chosen explicitly; move the sequence past them.
+			if err := tx.Exec(`SELECT setval(pg_get_serial_sequence('organizations', 'id'), (SELECT max(id) FROM organizations))`).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, table := range []string{"api_key_grants", "tags", "financial_records", "payments", "recurrences", "audit_events"} {
+			constraint := "fk_" + table + "_organization"
+			if tx.Migrator().HasConstraint(table, constraint) {
+				continue
+			}
+			if err := tx.Exec(`ALTER TABLE ` + table + ` ADD CONSTRAINT ` + constraint + `
+				FOREIGN KEY (organization_id) REFERENCES organizations (id)`).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tagNameIndex enforces unique tag names per organization among tags that
+// are not deleted.
+const tagNameIndex = "idx_tags_org_name_unique"
+
+// normalizedTagName is the SQL counterpart of domain.NormalizeTagName,
+// applied to expr.
+func normalizedTagName(expr string) string {
+	return `lower(btrim(regexp_replace(` + expr + `, '\s+', ' ', 'g')))`
+}
+
+// uniqueTagNames creates the unique index on normalized tag names. Tags
+// created before the index existed may clash; all but the oldest of each
+// clashing group are soft-deleted first, and their financial records are
+// linked to the tag that is kept instead.
+func uniqueTagNames(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		merged := tx.Exec(`
+			WITH ranked AS (
+				SELECT id, min(id) OVER (PARTITION BY organization_id, ` + normalizedTagName("name") + `) AS keep_id
+				FROM tags
+				WHERE deleted_at IS NULL
+			), duplicates AS (
+				SELECT id, keep_id FROM ranked WHERE id <> keep_id
+			), relinked AS (
+				INSERT INTO financial_record_tags (financial_record_id, tag_id)
+				SELECT DISTINCT frt.financial_record_id, d.keep_id
+				FROM financial_record_tags frt
+				JOIN duplicates d ON d.id = frt.tag_id
+				ON CONFLICT DO NOTHING
+			), unlinked AS (
+				DELETE FROM financial_record_tags frt
+				USING duplicates d
+				WHERE frt.tag_id = d.id
+			)
+			UPDATE tags SET deleted_at = now()
+			FROM duplicates d
+			WHERE tags.id = d.id`)
+		if merged.Error != nil {
+			return merged.Error
+		}
+		if merged.RowsAffected > 0 {
+			slog.Info("Merged duplicate tags", "count", merged.RowsAffected)
+		}
+
+		return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS ` + tagNameIndex + `
+			ON tags (organization_id, ` + normalizedTagName("name") + `)
+			WHERE deleted_at IS NULL`).Error
+	})
+}
+
+// appendOnlyAuditEvents installs a trigger rejecting updates and deletes of
+// audit events, so that not even the table owner rewrites history by
+// mistake. Truncating the table is still possible.
+func appendOnlyAuditEvents(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit events are append-only';
+			END
+			$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+			`CREATE TRIGGER audit_events_append_only
+				BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// searchableFinancialRecords enables pg_trgm and adds the search_vector
+// column the full-text search of financial records matches. The column is
+// generated from the description, counterparty name and reference, stemmed
+// in Portuguese and English, so it never gets out of date. Adding it
+// rewrites the table once.
+func searchableFinancialRecords(db *gorm.DB) error {
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`ALTER TABLE financial_records ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('portuguese', counterparty_name || ' ' || reference), 'A') ||
+			setweight(to_tsvector('english', counterparty_name || ' ' || reference), 'A') ||
+			setweight(to_tsvector('portuguese', description), 'B') ||
+			setweight(to_tsvector('english', description), 'B')
+		) STORED`).Error
+}
+
+// occurrenceIndex keeps the scheduler from creating an occurrence of a
+// recurrence twice. Deleted occurrences are left out, so that the ones
+// trimmed from a shortened series are created again when it is extended.
+const occurrenceIndex = "idx_financial_records_live_occurrence_unique"
+
+// recurringFinancialRecords makes occurrences reference their recurrence
+// and creates occurrenceIndex.
+func recurringFinancialRecords(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		const constraint = "fk_financial_records_recurrence"
+		if !tx.Migrator().HasConstraint("financial_records", constraint) {
+			if err := tx.Exec(`ALTER TABLE financial_records ADD CONSTRAINT ` + constraint + `
+				FOREIGN KEY (recurrence_id) REFERENCES recurrences (id)`).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS ` + occurrenceIndex + `
+			ON financial_records (recurrence_id, occurrence)
+			WHERE recurrence_id IS NOT NULL AND deleted_at IS NULL`).Error
+	})
+}
+
+// financialRecordPayments makes payments reference their financial
+// record, and go with it when it is purged. Records paid before payments
+// were kept get one payment of their paid amount, at their paid_at.
+func financialRecordPayments(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		const constraint = "fk_payments_financial_record"
+		if !tx.Migrator().HasConstraint("payments", constraint) {
+			if err := tx.Exec(`ALTER TABLE payments ADD CONSTRAINT ` + constraint + `
+				FOREIGN KEY (financial_record_id) REFERENCES financial_records (id) ON DELETE CASCADE`).Error; err != nil {
+				return err
+			}
+		}
+		backfilled := tx.Exec(`
+			INSERT INTO payments (created_at, organization_id, financial_record_id, amount, paid_at)
+			SELECT now(), organization_id, id, paid_amount, paid_at
+			FROM financial_records
+			WHERE paid_amount > 0 AND paid_at IS NOT NULL
+				AND NOT EXISTS (SELECT 1 FROM payments WHERE payments.financial_record_id = financial_records.id)`)
+		if backfilled.Error != nil {
+			return backfilled.Error
+		}
+		if backfilled.RowsAffected > 0 {
+			slog.Info("Recorded payments of paid financial records", "count", backfilled.RowsAffected)
+		}
+		return nil
+	})
+}
+
+// isTagNameConflict reports whether err is Postgres rejecting a tag whose
+// name is already used in its organization.
+func isTagNameConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == tagNameIndex
+}
+
+// isOccurrenceConflict reports whether err is Postgres rejecting an
+// occurrence of a recurrence that already has a live occurrence with its
+// number.
+func isOccurrenceConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == occurrenceIndex
+}
+
+// pgUniqueViolation is the SQLSTATE of a unique constraint violation.
+const pgUniqueViolation = "23505"
+
+// ApplyIndexes creates database indexes to optimize queries
+func ApplyIndexes(db *gorm.DB) {
+	slog.Info("Applying database indexes")
+
+	// Index for cash flow report query
+	err := db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_org_date ON financial_records (organization_id, due_date)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "org_date", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_due_date ON financial_records (due_date)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "due_date", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_direction ON financial_records (direction)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "direction", "error", err)
+	}
+
+	// Index for the cash flow report on the paid basis
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_payments_org_paid_at ON payments (organization_id, paid_at)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "payments_org_paid_at", "error", err)
+	}
+
+	// Indexes for the counterparty, reference and metadata filters
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_org_counterparty_name ON financial_records (organization_id, lower(counterparty_name))").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "org_counterparty_name", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_org_counterparty_document ON financial_records (organization_id, counterparty_document) WHERE counterparty_document <> ''").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "org_counterparty_document", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_org_reference ON financial_records (organization_id, reference) WHERE reference <> ''").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "org_reference", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_metadata ON financial_records USING GIN (metadata jsonb_path_ops)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "metadata", "error", err)
+	}
+
+	// Indexes for the full-text search and the fuzzy counterparty match
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_search ON financial_records USING GIN (search_vector)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "search", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_records_counterparty_trgm ON financial_records USING GIN (counterparty_name gin_trgm_ops)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "counterparty_trgm", "error", err)
+	}
+
+	// Indexes for financial_record_tags join table
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_record_tags_record_id ON financial_record_tags (financial_record_id)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "financial_record_tags_record_id", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_financial_record_tags_tag_id ON financial_record_tags (tag_id)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "financial_record_tags_tag_id", "error", err)
+	}
+
+	// Indexes for listing audit events, newest first
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_events_org_id ON audit_events (organization_id, id)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "audit_events_org_id", "error", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_events_org_entity ON audit_events (organization_id, entity_type, entity_id)").Error
+	if err != nil {
+		slog.Warn("Failed to create index", "index", "audit_events_org_entity", "error", err)
+	}
+
+	slog.Info("Database indexes applied")
+}
@@ -0,0 +1,15 @@
+package store
+
+import "github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+
+// paymentsMade returns the payments of records that are created already
+// paid in part or in full.
+func paymentsMade(records []domain.FinancialRecord) []domain.Payment {
+	var payments []domain.Payment
+	for i := range records {
+		if p, ok := records[i].PaymentSince(0); ok {
+			payments = append(payments, p)
+		}
+	}
+	return payments
+}
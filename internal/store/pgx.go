@@ -0,0 +1,1392 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+	"gorm.io/gorm"
+)
+
+// PgxStore implements OrganizationStore, APIKeyStore, TagStore,
+// FinancialRecordStore, RecurrenceStore and AuditEventStore with
+// hand-written SQL on a native pgx pool. The queries mirror the ones GORM
+// generates so that benchmarks compare the two stacks rather than two query
+// plans.
+type PgxStore struct {
+	pool *pgxpool.Pool
+	// tenantRole, when set, runs tag and financial record statements as
+	// this role, restricted by row-level security.
+	tenantRole string
+}
+
+// NewPgxStore returns a store backed by pool.
+func NewPgxStore(pool *pgxpool.Pool) *PgxStore {
+	return &PgxStore{pool: pool}
+}
+
+// WithRowLevelSecurity returns a copy of the store running the statements
+// on tags and financial records in transactions switched to role, which
+// only sees the organization of the context (see WithOrganization).
+// EnableRowLevelSecurity must have set up role.
+func (s *PgxStore) WithRowLevelSecurity(role string) *PgxStore {
+	return &PgxStore{pool: s.pool, tenantRole: role}
+}
+
+// querier runs statements on the pool or in a transaction.
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// tenant runs fn on the querier for the tenant tables: with row-level
+// security, a transaction restricted to the organization of ctx.
+func (s *PgxStore) tenant(ctx context.Context, fn func(q querier) error) error {
+	if s.tenantRole == "" {
+		return fn(s.pool)
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, setTenantRole(s.tenantRole)); err != nil {
+			return err
+		}
+		if org, ok := currentOrgOf(ctx); ok {
+			if _, err := tx.Exec(ctx, setCurrentOrg("$1"), org); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+const organizationColumns = "organizations.id, organizations.created_at, organizations.updated_at, organizations.deleted_at, " +
+	"organizations.name, organizations.time_zone, organizations.currency, " +
+	"organizations.max_tags, organizations.max_financial_records"
+
+const apiKeyColumns = "api_keys.id, api_keys.created_at, api_keys.updated_at, api_keys.deleted_at, " +
+	"api_keys.name, api_keys.prefix, api_keys.hash"
+
+const tagColumns = "tags.id, tags.created_at, tags.updated_at, tags.deleted_at, tags.organization_id, tags.name"
+
+const financialRecordColumns = "financial_records.id, financial_records.created_at, financial_records.updated_at, financial_records.deleted_at, " +
+	"financial_records.organization_id, financial_records.direction, financial_records.amount, financial_records.due_date, " +
+	"financial_records.status, financial_records.paid_amount, financial_records.paid_at, " +
+	"financial_records.description, financial_records.counterparty_name, financial_records.counterparty_document, " +
+	"financial_records.reference, financial_records.metadata, financial_records.recurrence_id, financial_records.occurrence"
+
+func scanOrganization(row pgx.Row, org *domain.Organization) error {
+	return row.Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.DeletedAt, &org.Name, &org.TimeZone, &org.Currency,
+		&org.MaxTags, &org.MaxFinancialRecords)
+}
+
+func scanAPIKey(row pgx.Row, key *domain.APIKey) error {
+	return row.Scan(&key.ID, &key.CreatedAt, &key.UpdatedAt, &key.DeletedAt, &key.Name, &key.Prefix, &key.Hash)
+}
+
+func scanTag(row pgx.Row, tag *domain.Tag) error {
+	return row.Scan(&tag.ID, &tag.CreatedAt, &tag.UpdatedAt, &tag.DeletedAt, &tag.OrganizationID, &tag.Name)
+}
+
+func scanFinancialRecord(row pgx.Row, record *domain.FinancialRecord) error {
+	return row.Scan(&record.ID, &record.CreatedAt, &record.UpdatedAt, &record.DeletedAt,
+		&record.OrganizationID, &record.Direction, &record.Amount, &record.DueDate,
+		&record.Status, &record.PaidAmount, &record.PaidAt,
+		&record.Description, &record.CounterpartyName, &record.CounterpartyDocument, &record.Reference, &record.Metadata,
+		&record.RecurrenceID, &record.Occurrence)
+}
+
+func (s *PgxStore) CreateOrganization(ctx context.Context, org *domain.Organization) error {
+	row := s.pool.QueryRow(ctx, `
+		INSERT INTO organizations (created_at, updated_at, name, time_zone, currency, max_tags, max_financial_records)
+		VALUES (now(), now(), $1, $2, $3, $4, $5)
+		RETURNING `+organizationColumns, org.Name, org.TimeZone, org.Currency, org.MaxTags, org.MaxFinancialRecords)
+	return scanOrganization(row, org)
+}
+
+func (s *PgxStore) GetOrganization(ctx context.Context, id uint) (*domain.Organization, error) {
+	var org domain.Organization
+	row := s.pool.QueryRow(ctx, `
+		SELECT `+organizationColumns+` FROM organizations
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err := scanOrganization(row, &org); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (s *PgxStore) ListOrganizations(ctx context.Context, page Page) ([]domain.Organization, int64, error) {
+	var total int64
+	if err := s.pool.QueryRow(ctx, `
+		SELECT count(*) FROM organizations WHERE deleted_at IS NULL`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+organizationColumns+` FROM organizations
+		WHERE deleted_at IS NULL
+		ORDER BY id
+		LIMIT $1 OFFSET $2`, page.Size, page.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	orgs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Organization, error) {
+		var org domain.Organization
+		err := scanOrganization(row, &org)
+		return org, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return orgs, total, nil
+}
+
+func (s *PgxStore) UpdateOrganization(ctx context.Context, org *domain.Organization) error {
+	row := s.pool.QueryRow(ctx, `
+		UPDATE organizations SET updated_at = now(), name = $2, time_zone = $3, currency = $4,
+			max_tags = $5, max_financial_records = $6
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+organizationColumns, org.ID, org.Name, org.TimeZone, org.Currency, org.MaxTags, org.MaxFinancialRecords)
+	if err := scanOrganization(row, org); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *PgxStore) DeleteOrganization(ctx context.Context, id uint) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE organizations SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateAPIKey inserts the key and its grants in a single transaction.
+func (s *PgxStore) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	orgIDs := make([]int64, len(key.Grants))
+	scopes := make([]string, len(key.Grants))
+	for i, g := range key.Grants {
+		orgIDs[i] = int64(g.OrganizationID)
+		scopes[i] = g.Scope
+	}
+
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			INSERT INTO api_keys (created_at, updated_at, name, prefix, hash)
+			VALUES (now(), now(), $1, $2, $3)
+			RETURNING `+apiKeyColumns, key.Name, key.Prefix, key.Hash)
+		if err := scanAPIKey(row, key); err != nil {
+			return err
+		}
+		for i := range key.Grants {
+			key.Grants[i].APIKeyID = key.ID
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO api_key_grants (api_key_id, organization_id, scope)
+			SELECT $1, organization_id, scope FROM unnest($2::bigint[], $3::text[]) AS g(organization_id, scope)`,
+			key.ID, orgIDs, scopes)
+		return err
+	})
+}
+
+func (s *PgxStore) FindAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	var key domain.APIKey
+	row := s.pool.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE hash = $1 AND deleted_at IS NULL`, hash)
+	if err := scanAPIKey(row, &key); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	keys := []domain.APIKey{key}
+	if err := s.loadGrants(ctx, keys); err != nil {
+		return nil, err
+	}
+	return &keys[0], nil
+}
+
+func (s *PgxStore) ListAPIKeys(ctx context.Context, page Page) ([]domain.APIKey, int64, error) {
+	var total int64
+	if err := s.pool.QueryRow(ctx, `
+		SELECT count(*) FROM api_keys WHERE deleted_at IS NULL`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE deleted_at IS NULL
+		ORDER BY id
+		LIMIT $1 OFFSET $2`, page.Size, page.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.APIKey, error) {
+		var key domain.APIKey
+		err := scanAPIKey(row, &key)
+		return key, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.loadGrants(ctx, keys); err != nil {
+		return nil, 0, err
+	}
+	return keys, total, nil
+}
+
+// loadGrants fills in the grants of keys with a single query, like GORM's
+// Preload("Grants").
+func (s *PgxStore) loadGrants(ctx context.Context, keys []domain.APIKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	byID := make(map[uint]*domain.APIKey, len(keys))
+	ids := make([]int64, len(keys))
+	for i := range keys {
+		byID[keys[i].ID] = &keys[i]
+		ids[i] = int64(keys[i].ID)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT api_key_id, organization_id, scope FROM api_key_grants
+		WHERE api_key_id = ANY($1)`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g domain.APIKeyGrant
+		if err := rows.Scan(&g.APIKeyID, &g.OrganizationID, &g.Scope); err != nil {
+			return err
+		}
+		if k, ok := byID[g.APIKeyID]; ok {
+			k.Grants = append(k.Grants, g)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *PgxStore) RevokeAPIKey(ctx context.Context, id uint) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE api_keys SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PgxStore) CreateTag(ctx context.Context, tag *domain.Tag, quota *Quota) error {
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			if err := s.takeQuota(ctx, tx, tag.OrganizationID, "tags", quota, 1); err != nil {
+				return err
+			}
+			row := tx.QueryRow(ctx, `
+				INSERT INTO tags (created_at, updated_at, organization_id, name)
+				VALUES (now(), now(), $1, $2)
+				RETURNING `+tagColumns, tag.OrganizationID, tag.Name)
+			if err := scanTag(row, tag); err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityTag, tag.OrganizationID, tag.ID, nil, tag)
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	if isTagNameConflict(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *PgxStore) ListTags(ctx context.Context, orgID uint, filter TagFilter, page Page) ([]domain.Tag, int64, error) {
+	where := " WHERE organization_id = $1"
+	if !filter.IncludeDeleted {
+		where += " AND deleted_at IS NULL"
+	}
+
+	var total int64
+	var tags []domain.Tag
+	err := s.tenant(ctx, func(q querier) error {
+		if err := q.QueryRow(ctx, "SELECT count(*) FROM tags"+where, orgID).Scan(&total); err != nil {
+			return err
+		}
+
+		rows, err := q.Query(ctx, "SELECT "+tagColumns+" FROM tags"+where+
+			" ORDER BY id LIMIT $2 OFFSET $3", orgID, page.Size, page.Offset())
+		if err != nil {
+			return err
+		}
+		tags, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Tag, error) {
+			var tag domain.Tag
+			err := scanTag(row, &tag)
+			return tag, err
+		})
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return tags, total, nil
+}
+
+func (s *PgxStore) FindTagByName(ctx context.Context, orgID uint, name string) (*domain.Tag, error) {
+	var tag domain.Tag
+	err := s.tenant(ctx, func(q querier) error {
+		row := q.QueryRow(ctx, `
+			SELECT `+tagColumns+` FROM tags
+			WHERE organization_id = $1 AND `+normalizedTagName("name")+` = `+normalizedTagName("$2::text")+`
+				AND deleted_at IS NULL`, orgID, name)
+		return scanTag(row, &tag)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (s *PgxStore) CountTags(ctx context.Context, orgID uint) (int64, error) {
+	var n int64
+	err := s.tenant(ctx, func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT count(*) FROM tags WHERE organization_id = $1 AND deleted_at IS NULL`, orgID).Scan(&n)
+	})
+	return n, err
+}
+
+func (s *PgxStore) DeleteTag(ctx context.Context, orgID, id uint) error {
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			var tag domain.Tag
+			row := tx.QueryRow(ctx, `
+				UPDATE tags SET deleted_at = now()
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				RETURNING `+tagColumns, id, orgID)
+			if err := scanTag(row, &tag); err != nil {
+				return err
+			}
+			before := tag
+			before.DeletedAt = gorm.DeletedAt{}
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityTag, orgID, id, &before, nil)
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *PgxStore) RestoreTag(ctx context.Context, orgID, id uint, quota *Quota) (*domain.Tag, error) {
+	var tag domain.Tag
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			row := tx.QueryRow(ctx, `
+				SELECT `+tagColumns+` FROM tags
+				WHERE id = $1 AND organization_id = $2
+				FOR UPDATE`, id, orgID)
+			if err := scanTag(row, &tag); err != nil {
+				return err
+			}
+			if !tag.DeletedAt.Valid {
+				return s.takeQuota(ctx, tx, orgID, "tags", quota, 0)
+			}
+			if err := s.takeQuota(ctx, tx, orgID, "tags", quota, 1); err != nil {
+				return err
+			}
+			before := tag
+			row = tx.QueryRow(ctx, `
+				UPDATE tags SET deleted_at = NULL, updated_at = now()
+				WHERE id = $1
+				RETURNING `+tagColumns, id)
+			if err := scanTag(row, &tag); err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditRestore, domain.AuditEntityTag, orgID, id, &before, &tag)
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, ErrNotFound
+	case isTagNameConflict(err):
+		return nil, ErrDuplicate
+	case err != nil:
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (s *PgxStore) CreateFinancialRecord(ctx context.Context, record *domain.FinancialRecord, quota *Quota) error {
+	records := []domain.FinancialRecord{*record}
+	if err := s.CreateFinancialRecords(ctx, records, quota); err != nil {
+		return err
+	}
+	*record = records[0]
+	return nil
+}
+
+// CreateFinancialRecords inserts records with one multi-row statement,
+// links their tags with a second one and records their audit events with a
+// third, in a single transaction. Only tags that belong to the record's
+// organization are linked.
+func (s *PgxStore) CreateFinancialRecords(ctx context.Context, records []domain.FinancialRecord, quota *Quota) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			if err := s.takeQuota(ctx, tx, records[0].OrganizationID, "financial_records", quota, len(records)); err != nil {
+				return err
+			}
+			return insertFinancialRecords(ctx, tx, records)
+		})
+	})
+}
+
+// insertFinancialRecords inserts records with their links to the tags that
+// are not deleted, their payments and their audit events, fills in their IDs
+// and timestamps, and leaves only the linked tags in their Tags.
+func insertFinancialRecords(ctx context.Context, tx pgx.Tx, records []domain.FinancialRecord) error {
+	orgIDs := make([]int64, len(records))
+	directions := make([]string, len(records))
+	amounts := make([]float64, len(records))
+	dueDates := make([]time.Time, len(records))
+	statuses := make([]string, len(records))
+	paidAmounts := make([]float64, len(records))
+	paidAts := make([]*time.Time, len(records))
+	descriptions := make([]string, len(records))
+	counterpartyNames := make([]string, len(records))
+	counterpartyDocuments := make([]string, len(records))
+	references := make([]string, len(records))
+	metadata := make([]json.RawMessage, len(records))
+	recurrenceIDs := make([]*int64, len(records))
+	occurrences := make([]int32, len(records))
+	for i, r := range records {
+		orgIDs[i] = int64(r.OrganizationID)
+		directions[i] = r.Direction
+		amounts[i] = r.Amount
+		dueDates[i] = r.DueDate
+		statuses[i] = r.Status
+		paidAmounts[i] = r.PaidAmount
+		paidAts[i] = r.PaidAt
+		descriptions[i] = r.Description
+		counterpartyNames[i] = r.CounterpartyName
+		counterpartyDocuments[i] = r.CounterpartyDocument
+		references[i] = r.Reference
+		metadata[i] = r.Metadata
+		if r.RecurrenceID != nil {
+			id := int64(*r.RecurrenceID)
+			recurrenceIDs[i] = &id
+		}
+		occurrences[i] = int32(r.Occurrence)
+	}
+
+	// Sequence values are assigned in input order, so ordering the
+	// returned rows by id matches them back to records.
+	rows, err := tx.Query(ctx, `
+		WITH input AS (
+			SELECT * FROM unnest($1::bigint[], $2::text[], $3::numeric[], $4::timestamptz[], $5::text[], $6::numeric[], $7::timestamptz[],
+				$8::text[], $9::text[], $10::text[], $11::text[], $12::jsonb[], $13::bigint[], $14::integer[])
+				WITH ORDINALITY AS i(organization_id, direction, amount, due_date, status, paid_amount, paid_at,
+					description, counterparty_name, counterparty_document, reference, metadata, recurrence_id, occurrence, ord)
+		), inserted AS (
+			INSERT INTO financial_records (created_at, updated_at, organization_id, direction, amount, due_date, status, paid_amount, paid_at,
+				description, counterparty_name, counterparty_document, reference, metadata, recurrence_id, occurrence)
+			SELECT now(), now(), organization_id, direction, amount, due_date, status, paid_amount, paid_at,
+				description, counterparty_name, counterparty_document, reference, metadata, recurrence_id, occurrence
+			FROM input ORDER BY ord
+			RETURNING id, created_at, updated_at
+		)
+		SELECT id, created_at, updated_at FROM inserted ORDER BY id`,
+		orgIDs, directions, amounts, dueDates, statuses, paidAmounts, paidAts,
+		descriptions, counterpartyNames, counterpartyDocuments, references, metadata, recurrenceIDs, occurrences)
+	if err != nil {
+		return err
+	}
+	for i := 0; rows.Next(); i++ {
+		if err := rows.Scan(&records[i].ID, &records[i].CreatedAt, &records[i].UpdatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var recordIDs, tagIDs, tagOrgIDs []int64
+	for _, r := range records {
+		for _, tag := range r.Tags {
+			recordIDs = append(recordIDs, int64(r.ID))
+			tagIDs = append(tagIDs, int64(tag.ID))
+			tagOrgIDs = append(tagOrgIDs, int64(r.OrganizationID))
+		}
+	}
+	if len(tagIDs) > 0 {
+		rows, err := tx.Query(ctx, `
+			WITH linked AS (
+				INSERT INTO financial_record_tags (financial_record_id, tag_id)
+				SELECT l.record_id, tags.id
+				FROM unnest($1::bigint[], $2::bigint[], $3::bigint[]) AS l(record_id, tag_id, organization_id)
+				JOIN tags ON tags.id = l.tag_id AND tags.organization_id = l.organization_id AND tags.deleted_at IS NULL
+				ON CONFLICT DO NOTHING
+				RETURNING financial_record_id, tag_id
+			)
+			SELECT linked.financial_record_id, `+tagColumns+`
+			FROM linked JOIN tags ON tags.id = linked.tag_id`,
+			recordIDs, tagIDs, tagOrgIDs)
+		if err != nil {
+			return err
+		}
+		type link struct{ recordID, tagID uint }
+		linked := map[link]domain.Tag{}
+		for rows.Next() {
+			var recordID uint
+			var tag domain.Tag
+			if err := rows.Scan(&recordID, &tag.ID, &tag.CreatedAt, &tag.UpdatedAt, &tag.DeletedAt, &tag.OrganizationID, &tag.Name); err != nil {
+				rows.Close()
+				return err
+			}
+			linked[link{recordID, tag.ID}] = tag
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		// The events and the caller see the tags that were linked, in
+		// the order they were given.
+		for i := range records {
+			r := &records[i]
+			tags := []domain.Tag{}
+			for _, t := range r.Tags {
+				if tag, ok := linked[link{r.ID, t.ID}]; ok {
+					tags = append(tags, tag)
+					delete(linked, link{r.ID, t.ID})
+				}
+			}
+			if r.Tags != nil {
+				r.Tags = tags
+			}
+		}
+	}
+
+	if payments := paymentsMade(records); len(payments) > 0 {
+		if err := insertPayments(ctx, tx, payments); err != nil {
+			return err
+		}
+	}
+
+	events, err := recordsCreated(ctx, records)
+	if err != nil {
+		return err
+	}
+	return insertAuditEvents(ctx, tx, events)
+}
+
+// insertPayments records payments with one multi-row statement.
+func insertPayments(ctx context.Context, tx pgx.Tx, payments []domain.Payment) error {
+	orgIDs := make([]int64, len(payments))
+	recordIDs := make([]int64, len(payments))
+	amounts := make([]float64, len(payments))
+	paidAts := make([]time.Time, len(payments))
+	for i, p := range payments {
+		orgIDs[i] = int64(p.OrganizationID)
+		recordIDs[i] = int64(p.FinancialRecordID)
+		amounts[i] = p.Amount
+		paidAts[i] = p.PaidAt
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO payments (created_at, organization_id, financial_record_id, amount, paid_at)
+		SELECT now(), * FROM unnest($1::bigint[], $2::bigint[], $3::numeric[], $4::timestamptz[])`,
+		orgIDs, recordIDs, amounts, paidAts)
+	return err
+}
+
+func (s *PgxStore) ListFinancialRecords(ctx context.Context, orgID uint, filter FinancialRecordFilter, page Page) ([]domain.FinancialRecord, int64, error) {
+	const from = " FROM financial_records"
+	where := " WHERE financial_records.organization_id = $1"
+	if !filter.IncludeDeleted {
+		where += " AND financial_records.deleted_at IS NULL"
+	}
+	args := []any{orgID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where += " AND " + strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1)
+	}
+
+	// Handle tag filtering; a record carrying several of the tags is still
+	// listed once.
+	if len(filter.TagIDs) > 0 {
+		add(`EXISTS (SELECT 1 FROM financial_record_tags frt
+			WHERE frt.financial_record_id = financial_records.id AND frt.tag_id = ANY(?))`, filter.TagIDs)
+	}
+	if filter.CounterpartyName != "" {
+		add("lower(financial_records.counterparty_name) = lower(?)", filter.CounterpartyName)
+	}
+	if filter.CounterpartyDocument != "" {
+		add("financial_records.counterparty_document = ?", filter.CounterpartyDocument)
+	}
+	if filter.Reference != "" {
+		add("financial_records.reference = ?", filter.Reference)
+	}
+	if filter.Metadata != nil {
+		add("financial_records.metadata @> ?::jsonb", string(filter.Metadata))
+	}
+	order := " ORDER BY financial_records.id"
+	if filter.Query != "" {
+		args = append(args, filter.Query)
+		match, rank := searchSQL("$" + strconv.Itoa(len(args)))
+		where += " AND " + match
+		order = " ORDER BY " + rank + " DESC, financial_records.id"
+	}
+
+	var total int64
+	var records []domain.FinancialRecord
+	err := s.tenant(ctx, func(q querier) error {
+		// Get total count for pagination
+		if err := q.QueryRow(ctx, "SELECT count(*)"+from+where, args...).Scan(&total); err != nil {
+			return err
+		}
+
+		n := len(args)
+		rows, err := q.Query(ctx, "SELECT "+financialRecordColumns+from+where+
+			order+" LIMIT $"+strconv.Itoa(n+1)+" OFFSET $"+strconv.Itoa(n+2), append(args, page.Size, page.Offset())...)
+		if err != nil {
+			return err
+		}
+		records, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.FinancialRecord, error) {
+			record := domain.FinancialRecord{Tags: []domain.Tag{}}
+			err := scanFinancialRecord(row, &record)
+			return record, err
+		})
+		if err != nil {
+			return err
+		}
+
+		return s.loadTags(ctx, q, records)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+// loadTags fills in the tags of records with a single query, like GORM's
+// Preload("Tags").
+func (s *PgxStore) loadTags(ctx context.Context, q querier, records []domain.FinancialRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	byID := make(map[uint]*domain.FinancialRecord, len(records))
+	ids := make([]int64, len(records))
+	for i := range records {
+		byID[records[i].ID] = &records[i]
+		ids[i] = int64(records[i].ID)
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT financial_record_tags.financial_record_id, `+tagColumns+`
+		FROM financial_record_tags
+		JOIN tags ON tags.id = financial_record_tags.tag_id
+		WHERE financial_record_tags.financial_record_id = ANY($1) AND tags.deleted_at IS NULL`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var recordID uint
+		var tag domain.Tag
+		if err := rows.Scan(&recordID, &tag.ID, &tag.CreatedAt, &tag.UpdatedAt, &tag.DeletedAt, &tag.OrganizationID, &tag.Name); err != nil {
+			return err
+		}
+		if r, ok := byID[recordID]; ok {
+			r.Tags = append(r.Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *PgxStore) CountFinancialRecords(ctx context.Context, orgID uint) (int64, error) {
+	var n int64
+	err := s.tenant(ctx, func(q querier) error {
+		return q.QueryRow(ctx, `
+			SELECT count(*) FROM financial_records WHERE organization_id = $1 AND deleted_at IS NULL`, orgID).Scan(&n)
+	})
+	return n, err
+}
+
+func (s *PgxStore) DeleteFinancialRecord(ctx context.Context, orgID, id uint) error {
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			records := []domain.FinancialRecord{{Tags: []domain.Tag{}}}
+			row := tx.QueryRow(ctx, `
+				UPDATE financial_records SET deleted_at = now()
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				RETURNING `+financialRecordColumns, id, orgID)
+			if err := scanFinancialRecord(row, &records[0]); err != nil {
+				return err
+			}
+			if err := s.loadTags(ctx, tx, records); err != nil {
+				return err
+			}
+			before := records[0]
+			before.DeletedAt = gorm.DeletedAt{}
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityFinancialRecord, orgID, id, &before, nil)
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *PgxStore) RestoreFinancialRecord(ctx context.Context, orgID, id uint, quota *Quota) (*domain.FinancialRecord, error) {
+	records := []domain.FinancialRecord{{Tags: []domain.Tag{}}}
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			row := tx.QueryRow(ctx, `
+				SELECT `+financialRecordColumns+` FROM financial_records
+				WHERE id = $1 AND organization_id = $2
+				FOR UPDATE`, id, orgID)
+			if err := scanFinancialRecord(row, &records[0]); err != nil {
+				return err
+			}
+			if err := s.loadTags(ctx, tx, records); err != nil {
+				return err
+			}
+			if !records[0].DeletedAt.Valid {
+				return s.takeQuota(ctx, tx, orgID, "financial_records", quota, 0)
+			}
+			if err := s.takeQuota(ctx, tx, orgID, "financial_records", quota, 1); err != nil {
+				return err
+			}
+			before := records[0]
+			row = tx.QueryRow(ctx, `
+				UPDATE financial_records SET deleted_at = NULL, updated_at = now()
+				WHERE id = $1
+				RETURNING `+financialRecordColumns, id)
+			if err := scanFinancialRecord(row, &records[0]); err != nil {
+				return err
+			}
+			event, err := newAuditEvent(ctx, domain.AuditRestore, domain.AuditEntityFinancialRecord, orgID, id, &before, &records[0])
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, ErrNotFound
+	case isOccurrenceConflict(err):
+		return nil, ErrDuplicate
+	case err != nil:
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+func (s *PgxStore) UpdateFinancialRecord(ctx context.Context, orgID, id uint, update func(*domain.FinancialRecord) error) (*domain.FinancialRecord, error) {
+	records := []domain.FinancialRecord{{Tags: []domain.Tag{}}}
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			row := tx.QueryRow(ctx, `
+				SELECT `+financialRecordColumns+` FROM financial_records
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				FOR UPDATE`, id, orgID)
+			if err := scanFinancialRecord(row, &records[0]); err != nil {
+				return err
+			}
+			if err := s.loadTags(ctx, tx, records); err != nil {
+				return err
+			}
+			before := records[0]
+			r := &records[0]
+			if err := update(r); err != nil {
+				return err
+			}
+			if same, err := unchanged(&before, r); err != nil || same {
+				return err
+			}
+			if err := saveFinancialRecord(ctx, tx, r); err != nil {
+				return err
+			}
+			if p, ok := r.PaymentSince(before.PaidAmount); ok {
+				if err := insertPayments(ctx, tx, []domain.Payment{p}); err != nil {
+					return err
+				}
+			}
+			event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, id, &before, r)
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// saveFinancialRecord writes the editable fields of r, like GORM's
+// Select(financialRecordFields).Updates.
+func saveFinancialRecord(ctx context.Context, tx pgx.Tx, r *domain.FinancialRecord) error {
+	row := tx.QueryRow(ctx, `
+		UPDATE financial_records
+		SET direction = $2, amount = $3, due_date = $4, status = $5, paid_amount = $6, paid_at = $7,
+			description = $8, counterparty_name = $9, counterparty_document = $10, reference = $11, metadata = $12,
+			updated_at = now()
+		WHERE id = $1
+		RETURNING `+financialRecordColumns,
+		r.ID, r.Direction, r.Amount, r.DueDate, r.Status, r.PaidAmount, r.PaidAt,
+		r.Description, r.CounterpartyName, r.CounterpartyDocument, r.Reference, r.Metadata)
+	return scanFinancialRecord(row, r)
+}
+
+func (s *PgxStore) CashFlowReport(ctx context.Context, orgID uint, basis string, since time.Time, loc *time.Location) ([]domain.MonthlyCashFlow, error) {
+	from, date, amount, cond := cashFlowBasis(basis)
+	var report []domain.MonthlyCashFlow
+	err := s.tenant(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT
+				EXTRACT(YEAR FROM `+date+` AT TIME ZONE $3)::integer as year,
+				EXTRACT(MONTH FROM `+date+` AT TIME ZONE $3)::integer as month,
+				SUM(CASE WHEN financial_records.direction = 'IN' THEN `+amount+` ELSE 0 END)::float8 as in,
+				SUM(CASE WHEN financial_records.direction = 'OUT' THEN `+amount+` ELSE 0 END)::float8 as out
+			FROM `+from+`
+			WHERE financial_records.organization_id = $1 AND `+date+` >= $2 AND `+cond+` AND financial_records.deleted_at IS NULL
+			GROUP BY 1, 2
+			ORDER BY year, month`, orgID, since, loc.String())
+		if err != nil {
+			return err
+		}
+		report, err = pgx.CollectRows(rows, pgx.RowToStructByPos[domain.MonthlyCashFlow])
+		return err
+	})
+	return report, err
+}
+
+const auditEventColumns = "audit_events.id, audit_events.created_at, audit_events.organization_id, audit_events.actor, " +
+	"audit_events.action, audit_events.entity_type, audit_events.entity_id, audit_events.before, audit_events.after, audit_events.request_id"
+
+// insertAuditEvents appends events to the audit log with one multi-row
+// statement.
+func insertAuditEvents(ctx context.Context, tx pgx.Tx, events []domain.AuditEvent) error {
+	orgIDs := make([]int64, len(events))
+	actors := make([]string, len(events))
+	actions := make([]string, len(events))
+	entityTypes := make([]string, len(events))
+	entityIDs := make([]int64, len(events))
+	befores := make([]json.RawMessage, len(events))
+	afters := make([]json.RawMessage, len(events))
+	requestIDs := make([]string, len(events))
+	for i, e := range events {
+		orgIDs[i] = int64(e.OrganizationID)
+		actors[i] = e.Actor
+		actions[i] = e.Action
+		entityTypes[i] = e.EntityType
+		entityIDs[i] = int64(e.EntityID)
+		befores[i] = e.Before
+		afters[i] = e.After
+		requestIDs[i] = e.RequestID
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO audit_events (created_at, organization_id, actor, action, entity_type, entity_id, before, after, request_id)
+		SELECT now(), * FROM unnest($1::bigint[], $2::text[], $3::text[], $4::text[], $5::bigint[], $6::jsonb[], $7::jsonb[], $8::text[])`,
+		orgIDs, actors, actions, entityTypes, entityIDs, befores, afters, requestIDs)
+	return err
+}
+
+func (s *PgxStore) ListAuditEvents(ctx context.Context, orgID uint, filter AuditEventFilter, page Page) ([]domain.AuditEvent, int64, error) {
+	where := " WHERE organization_id = $1"
+	args := []any{orgID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where += " AND " + cond + " $" + strconv.Itoa(len(args))
+	}
+	if filter.EntityType != "" {
+		add("entity_type =", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		add("entity_id =", filter.EntityID)
+	}
+	if filter.Action != "" {
+		add("action =", filter.Action)
+	}
+	if filter.Actor != "" {
+		add("actor =", filter.Actor)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >=", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at <", filter.Until)
+	}
+
+	var total int64
+	var events []domain.AuditEvent
+	err := s.tenant(ctx, func(q querier) error {
+		if err := q.QueryRow(ctx, "SELECT count(*) FROM audit_events"+where, args...).Scan(&total); err != nil {
+			return err
+		}
+
+		n := len(args)
+		rows, err := q.Query(ctx, "SELECT "+auditEventColumns+" FROM audit_events"+where+
+			" ORDER BY id DESC LIMIT $"+strconv.Itoa(n+1)+" OFFSET $"+strconv.Itoa(n+2), append(args, page.Size, page.Offset())...)
+		if err != nil {
+			return err
+		}
+		events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.AuditEvent, error) {
+			var e domain.AuditEvent
+			err := row.Scan(&e.ID, &e.CreatedAt, &e.OrganizationID, &e.Actor, &e.Action, &e.EntityType, &e.EntityID, &e.Before, &e.After, &e.RequestID)
+			return e, err
+		})
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+const recurrenceColumns = "recurrences.id, recurrences.created_at, recurrences.updated_at, recurrences.deleted_at, recurrences.organization_id, " +
+	"recurrences.frequency, recurrences.repeat_interval, recurrences.day_of_month, recurrences.end_date, recurrences.repeat_count, " +
+	"recurrences.direction, recurrences.amount, recurrences.description, recurrences.counterparty_name, recurrences.counterparty_document, " +
+	"recurrences.reference, recurrences.metadata, recurrences.tag_ids, " +
+	"recurrences.start_date, recurrences.start_occurrence, recurrences.generated, recurrences.next_date"
+
+func scanRecurrence(row pgx.Row, rec *domain.Recurrence) error {
+	var tagIDs json.RawMessage
+	if err := row.Scan(&rec.ID, &rec.CreatedAt, &rec.UpdatedAt, &rec.DeletedAt, &rec.OrganizationID,
+		&rec.Frequency, &rec.Interval, &rec.DayOfMonth, &rec.EndDate, &rec.Count,
+		&rec.Direction, &rec.Amount, &rec.Description, &rec.CounterpartyName, &rec.CounterpartyDocument,
+		&rec.Reference, &rec.Metadata, &tagIDs,
+		&rec.StartDate, &rec.StartOccurrence, &rec.Generated, &rec.NextDate); err != nil {
+		return err
+	}
+	rec.TagIDs = nil
+	if tagIDs == nil {
+		return nil
+	}
+	return json.Unmarshal(tagIDs, &rec.TagIDs)
+}
+
+func (s *PgxStore) CreateRecurrence(ctx context.Context, orgID, recordID uint, rec *domain.Recurrence, until time.Time, quota *Quota) error {
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			records := []domain.FinancialRecord{{Tags: []domain.Tag{}}}
+			row := tx.QueryRow(ctx, `
+				SELECT `+financialRecordColumns+` FROM financial_records
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				FOR UPDATE`, recordID, orgID)
+			if err := scanFinancialRecord(row, &records[0]); err != nil {
+				return err
+			}
+			if err := s.loadTags(ctx, tx, records); err != nil {
+				return err
+			}
+			record := &records[0]
+			if err := rec.Attach(record); err != nil {
+				return err
+			}
+			// The series needs room for one more record at least.
+			if err := s.takeQuota(ctx, tx, orgID, "financial_records", quota, 1); err != nil {
+				return err
+			}
+			tagIDs, err := json.Marshal(rec.TagIDs)
+			if err != nil {
+				return err
+			}
+			row = tx.QueryRow(ctx, `
+				INSERT INTO recurrences (created_at, updated_at, organization_id,
+					frequency, repeat_interval, day_of_month, end_date, repeat_count,
+					direction, amount, description, counterparty_name, counterparty_document, reference, metadata, tag_ids,
+					start_date, start_occurrence, generated, next_date)
+				VALUES (now(), now(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+				RETURNING `+recurrenceColumns,
+				rec.OrganizationID, rec.Frequency, rec.Interval, rec.DayOfMonth, rec.EndDate, rec.Count,
+				rec.Direction, rec.Amount, rec.Description, rec.CounterpartyName, rec.CounterpartyDocument, rec.Reference, rec.Metadata, tagIDs,
+				rec.StartDate, rec.StartOccurrence, rec.Generated, rec.NextDate)
+			if err := scanRecurrence(row, rec); err != nil {
+				return err
+			}
+			before := *record
+			row = tx.QueryRow(ctx, `
+				UPDATE financial_records SET recurrence_id = $2, occurrence = 0, updated_at = now()
+				WHERE id = $1
+				RETURNING `+financialRecordColumns, record.ID, rec.ID)
+			if err := scanFinancialRecord(row, record); err != nil {
+				return err
+			}
+			created, err := newAuditEvent(ctx, domain.AuditCreate, domain.AuditEntityRecurrence, orgID, rec.ID, nil, rec)
+			if err != nil {
+				return err
+			}
+			attached, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, record.ID, &before, record)
+			if err != nil {
+				return err
+			}
+			if err := insertAuditEvents(ctx, tx, []domain.AuditEvent{created, attached}); err != nil {
+				return err
+			}
+			_, err = s.advanceRecurrence(ctx, tx, rec, until, quota)
+			return err
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *PgxStore) GetRecurrence(ctx context.Context, orgID, id uint) (*domain.Recurrence, error) {
+	var rec domain.Recurrence
+	err := s.tenant(ctx, func(q querier) error {
+		row := q.QueryRow(ctx, `
+			SELECT `+recurrenceColumns+` FROM recurrences
+			WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`, id, orgID)
+		return scanRecurrence(row, &rec)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *PgxStore) ListRecurrences(ctx context.Context, orgID uint, page Page) ([]domain.Recurrence, int64, error) {
+	const where = " WHERE organization_id = $1 AND deleted_at IS NULL"
+	var total int64
+	var recs []domain.Recurrence
+	err := s.tenant(ctx, func(q querier) error {
+		if err := q.QueryRow(ctx, "SELECT count(*) FROM recurrences"+where, orgID).Scan(&total); err != nil {
+			return err
+		}
+
+		rows, err := q.Query(ctx, "SELECT "+recurrenceColumns+" FROM recurrences"+where+
+			" ORDER BY id LIMIT $2 OFFSET $3", orgID, page.Size, page.Offset())
+		if err != nil {
+			return err
+		}
+		recs, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Recurrence, error) {
+			var rec domain.Recurrence
+			err := scanRecurrence(row, &rec)
+			return rec, err
+		})
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return recs, total, nil
+}
+
+func (s *PgxStore) DeleteRecurrence(ctx context.Context, orgID, id uint) error {
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			// The update locks the recurrence as MaterializeRecurrences
+			// does, so that no occurrence is created once it is deleted.
+			var rec domain.Recurrence
+			row := tx.QueryRow(ctx, `
+				UPDATE recurrences SET deleted_at = now()
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				RETURNING `+recurrenceColumns, id, orgID)
+			if err := scanRecurrence(row, &rec); err != nil {
+				return err
+			}
+			rec.DeletedAt = gorm.DeletedAt{}
+			event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityRecurrence, orgID, id, &rec, nil)
+			if err != nil {
+				return err
+			}
+			return insertAuditEvents(ctx, tx, []domain.AuditEvent{event})
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *PgxStore) EditRecurrence(ctx context.Context, orgID, recordID uint, edit func(*domain.Recurrence, []domain.FinancialRecord) error, until time.Time, limit int) (*domain.FinancialRecord, error) {
+	var record domain.FinancialRecord
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			row := tx.QueryRow(ctx, `
+				SELECT `+financialRecordColumns+` FROM financial_records
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`, recordID, orgID)
+			if err := scanFinancialRecord(row, &record); err != nil {
+				return err
+			}
+			if record.RecurrenceID == nil {
+				return domain.ErrRecordNotRecurring
+			}
+			// Lock the recurrence before its occurrences, as
+			// MaterializeRecurrences does.
+			var rec domain.Recurrence
+			row = tx.QueryRow(ctx, `
+				SELECT `+recurrenceColumns+` FROM recurrences
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				FOR UPDATE`, *record.RecurrenceID, orgID)
+			err := scanRecurrence(row, &rec)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// The series has ended.
+				return domain.ErrRecordNotRecurring
+			}
+			if err != nil {
+				return err
+			}
+			rows, err := tx.Query(ctx, `
+				SELECT `+financialRecordColumns+` FROM financial_records
+				WHERE organization_id = $1 AND recurrence_id = $2 AND occurrence >= $3 AND deleted_at IS NULL
+				ORDER BY occurrence
+				FOR UPDATE`, orgID, rec.ID, record.Occurrence)
+			if err != nil {
+				return err
+			}
+			occurrences, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.FinancialRecord, error) {
+				record := domain.FinancialRecord{Tags: []domain.Tag{}}
+				err := scanFinancialRecord(row, &record)
+				return record, err
+			})
+			if err != nil {
+				return err
+			}
+			if len(occurrences) == 0 || occurrences[0].ID != recordID {
+				// Deleted in the meantime.
+				return pgx.ErrNoRows
+			}
+			if err := s.loadTags(ctx, tx, occurrences); err != nil {
+				return err
+			}
+
+			before, beforeRec := slices.Clone(occurrences), rec
+			if err := edit(&rec, occurrences); err != nil {
+				return err
+			}
+			var events []domain.AuditEvent
+			for i := range occurrences {
+				o, b := &occurrences[i], &before[i]
+				if o.DeletedAt.Valid {
+					if _, err := tx.Exec(ctx, `UPDATE financial_records SET deleted_at = now() WHERE id = $1`, o.ID); err != nil {
+						return err
+					}
+					event, err := newAuditEvent(ctx, domain.AuditDelete, domain.AuditEntityFinancialRecord, orgID, o.ID, b, nil)
+					if err != nil {
+						return err
+					}
+					events = append(events, event)
+					continue
+				}
+				if same, err := unchanged(b, o); err != nil {
+					return err
+				} else if same {
+					continue
+				}
+				if err := saveFinancialRecord(ctx, tx, o); err != nil {
+					return err
+				}
+				event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityFinancialRecord, orgID, o.ID, b, o)
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+			if same, err := unchanged(&beforeRec, &rec); err != nil {
+				return err
+			} else if !same {
+				tagIDs, err := json.Marshal(rec.TagIDs)
+				if err != nil {
+					return err
+				}
+				row = tx.QueryRow(ctx, `
+					UPDATE recurrences
+					SET frequency = $2, repeat_interval = $3, day_of_month = $4, end_date = $5, repeat_count = $6,
+						direction = $7, amount = $8, description = $9, counterparty_name = $10, counterparty_document = $11,
+						reference = $12, metadata = $13, tag_ids = $14,
+						start_date = $15, start_occurrence = $16, generated = $17, next_date = $18, updated_at = now()
+					WHERE id = $1
+					RETURNING `+recurrenceColumns,
+					rec.ID, rec.Frequency, rec.Interval, rec.DayOfMonth, rec.EndDate, rec.Count,
+					rec.Direction, rec.Amount, rec.Description, rec.CounterpartyName, rec.CounterpartyDocument,
+					rec.Reference, rec.Metadata, tagIDs,
+					rec.StartDate, rec.StartOccurrence, rec.Generated, rec.NextDate)
+				if err := scanRecurrence(row, &rec); err != nil {
+					return err
+				}
+				event, err := newAuditEvent(ctx, domain.AuditUpdate, domain.AuditEntityRecurrence, orgID, rec.ID, &beforeRec, &rec)
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+			if len(events) > 0 {
+				if err := insertAuditEvents(ctx, tx, events); err != nil {
+					return err
+				}
+			}
+			record = occurrences[0]
+			_, err = s.advanceRecurrence(ctx, tx, &rec, until, &Quota{Limit: limit})
+			return err
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *PgxStore) MaterializeRecurrences(ctx context.Context, until time.Time, quota int) (int, error) {
+	// Find the due series of every organization that is not deleted, with
+	// the limit of the organization, as the table owner, then advance each
+	// as its organization.
+	rows, err := s.pool.Query(ctx, `
+		SELECT recurrences.id, recurrences.organization_id, organizations.max_financial_records
+		FROM recurrences JOIN organizations ON organizations.id = recurrences.organization_id
+		WHERE recurrences.next_date <= $1 AND recurrences.deleted_at IS NULL AND organizations.deleted_at IS NULL
+		ORDER BY recurrences.id`, until)
+	if err != nil {
+		return 0, err
+	}
+	due, err := pgx.CollectRows(rows, pgx.RowToStructByPos[struct {
+		ID, OrganizationID  uint
+		MaxFinancialRecords *int
+	}])
+	if err != nil {
+		return 0, err
+	}
+	var created int
+	var errs []error
+	for _, d := range due {
+		org := domain.Organization{MaxFinancialRecords: d.MaxFinancialRecords}
+		n, err := s.materialize(WithOrganization(ctx, d.OrganizationID), d.OrganizationID, d.ID, until, org.FinancialRecordLimit(quota))
+		created += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recurrence %d: %w", d.ID, err))
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// materialize locks the organization's recurrence and creates its
+// occurrences due up to until that fit in limit.
+func (s *PgxStore) materialize(ctx context.Context, orgID, id uint, until time.Time, limit int) (int, error) {
+	var n int
+	err := s.tenant(ctx, func(q querier) error {
+		return pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+			var rec domain.Recurrence
+			row := tx.QueryRow(ctx, `
+				SELECT `+recurrenceColumns+` FROM recurrences
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+				FOR UPDATE`, id, orgID)
+			if err := scanRecurrence(row, &rec); err != nil {
+				return err
+			}
+			var err error
+			n, err = s.advanceRecurrence(ctx, tx, &rec, until, &Quota{Limit: limit})
+			return err
+		})
+	})
+	return n, err
+}
+
+// advanceRecurrence creates the occurrences of the locked rec due up to
+// until that fit in quota, linked to the template tags that are not
+// deleted, with their audit events, and saves how far the series got.
+func (s *PgxStore) advanceRecurrence(ctx context.Context, tx pgx.Tx, rec *domain.Recurrence, until time.Time, quota *Quota) (int, error) {
+	held, err := s.countHeld(ctx, tx, rec.OrganizationID, "financial_records", quota)
+	if err != nil {
+		return 0, err
+	}
+	occurrences := rec.Advance(until, occurrenceRoom(quota.limit(), held))
+	if err := quota.take(held, len(occurrences)); err != nil {
+		return 0, err
+	}
+	if len(occurrences) == 0 {
+		return 0, nil
+	}
+	if err := insertFinancialRecords(ctx, tx, occurrences); err != nil {
+		return 0, err
+	}
+	row := tx.QueryRow(ctx, `
+		UPDATE recurrences SET generated = $2, next_date = $3, updated_at = now()
+		WHERE id = $1
+		RETURNING updated_at`, rec.ID, rec.Generated, rec.NextDate)
+	return len(occurrences), row.Scan(&rec.UpdatedAt)
+}
+
+// takeQuota has quota take n more of the organization's rows of table,
+// counted by countHeld.
+func (s *PgxStore) takeQuota(ctx context.Context, tx pgx.Tx, orgID uint, table string, quota *Quota, n int) error {
+	held, err := s.countHeld(ctx, tx, orgID, table, quota)
+	if err != nil {
+		return err
+	}
+	return quota.take(held, n)
+}
+
+// countHeld locks the organization's row until tx ends and returns how
+// many of its rows of table are not deleted, or zero without counting when
+// quota is unlimited. Writes lock the organization after the rows they
+// change, so that they cannot deadlock one another.
+func (s *PgxStore) countHeld(ctx context.Context, tx pgx.Tx, orgID uint, table string, quota *Quota) (int64, error) {
+	if quota.limit() == 0 {
+		return 0, nil
+	}
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+		return 0, err
+	}
+	var held int64
+	err := tx.QueryRow(ctx, `
+		SELECT count(*) FROM `+table+` WHERE organization_id = $1 AND deleted_at IS NULL`, orgID).Scan(&held)
+	return held, err
+}
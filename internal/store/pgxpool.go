@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NewPgxPool opens a native pgx connection pool sized like the database/sql
+// pool used by GORM, so that both backends are benchmarked under the same
+// limits. tracer, if not nil, observes every statement.
+func NewPgxPool(ctx context.Context, dsn string, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse database URL: %w", err)
+	}
+	poolConfig.MinConns = 10
+	poolConfig.MaxConns = 90
+	poolConfig.MaxConnLifetime = time.Hour
+	poolConfig.ConnConfig.Tracer = tracer
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// PgxPoolStats mirrors the fields of sql.DBStats that have a pgxpool
+// equivalent, so that /debug/vars reads the same for both backends.
+func PgxPoolStats(s *pgxpool.Stat) map[string]any {
+	return map[string]any{
+		"MaxOpenConnections": s.MaxConns(),
+		"OpenConnections":    s.TotalConns(),
+		"InUse":              s.AcquiredConns(),
+		"Idle":               s.IdleConns(),
+		"WaitCount":          s.EmptyAcquireCount(),
+		"WaitDuration":       s.AcquireDuration(),
+		"AcquireCount":       s.AcquireCount(),
+		"CanceledAcquires":   s.CanceledAcquireCount(),
+	}
+}
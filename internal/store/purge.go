@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PurgeResult counts the rows PurgeDeleted removed.
+type PurgeResult struct {
+	FinancialRecords int64
+	Tags             int64
+	// Links counts the financial_record_tags rows of the purged records and
+	// tags.
+	Links int64
+}
+
+// purgeBatch hard-deletes up to $2 rows of table soft-deleted before $1,
+// with their links in financial_record_tags, and returns how many rows and
+// links it removed. Rows locked by a concurrent write are skipped until the
+// next run.
+func purgeBatch(table, linkColumn string) string {
+	return `
+		WITH batch AS (
+			SELECT id FROM ` + table + `
+			WHERE deleted_at < ?
+			ORDER BY id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		), links AS (
+			DELETE FROM financial_record_tags
+			WHERE ` + linkColumn + ` IN (SELECT id FROM batch)
+			RETURNING 1
+		), purged AS (
+			DELETE FROM ` + table + `
+			WHERE id IN (SELECT id FROM batch)
+			RETURNING 1
+		)
+		SELECT (SELECT count(*) FROM purged) AS purged, (SELECT count(*) FROM links) AS links`
+}
+
+// PurgeDeleted hard-deletes the financial records and tags soft-deleted
+// before the given time, along with their links, in batches of batchSize
+// rows. Each batch is a statement of its own, so that locks are held
+// briefly and an interrupted purge keeps the batches already done. Their
+// audit events are kept.
+func PurgeDeleted(ctx context.Context, db *gorm.DB, before time.Time, batchSize int) (PurgeResult, error) {
+	var result PurgeResult
+	db = db.WithContext(ctx)
+	for _, target := range []struct {
+		table, linkColumn string
+		purged            *int64
+	}{
+		// Records first, so that their links are gone by the time the tags
+		// they point to are purged.
+		{"financial_records", "financial_record_id", &result.FinancialRecords},
+		{"tags", "tag_id", &result.Tags},
+	} {
+		for {
+			var batch struct{ Purged, Links int64 }
+			if err := db.Raw(purgeBatch(target.table, target.linkColumn), before, batchSize).Scan(&batch).Error; err != nil {
+				return result, err
+			}
+			*target.purged += batch.Purged
+			result.Links += batch.Links
+			if batch.Purged < int64(batchSize) {
+				break
+			}
+		}
+	}
+	return result, nil
+}
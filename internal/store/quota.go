@@ -0,0 +1,46 @@
+package store
+
+import "fmt"
+
+// Quota bounds how many items of a kind an organization may hold, for the
+// writes that create or restore them. Those writes lock the organization
+// before counting its items, so that concurrent writes check the quota one
+// after the other, and set Left to how many more items the organization
+// may hold once they are done. A nil Quota, or a zero Limit, is unlimited.
+type Quota struct {
+	Limit int
+	Left  int64
+}
+
+// QuotaError is returned by a write that would take the organization past
+// the Limit of its quota. The organization may hold Left more items.
+type QuotaError struct {
+	Limit int
+	Left  int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota of %d items exceeded, %d left", e.Limit, e.Left)
+}
+
+// limit returns the number of items q allows, zero when it is unlimited.
+func (q *Quota) limit() int {
+	if q == nil {
+		return 0
+	}
+	return max(q.Limit, 0)
+}
+
+// take checks that an organization holding held items may have n more,
+// and leaves in Left how many it may have after them.
+func (q *Quota) take(held int64, n int) error {
+	if q.limit() == 0 {
+		return nil
+	}
+	left := max(int64(q.Limit)-held, 0)
+	if int64(n) > left {
+		return &QuotaError{Limit: q.Limit, Left: left}
+	}
+	q.Left = left - int64(n)
+	return nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+)
+
+// SchedulerActor is the actor of the audit events recording the
+// occurrences ScheduleRecurrences creates.
+const SchedulerActor = "scheduler"
+
+// ScheduleRecurrences creates the occurrences of recurrences due within
+// horizon right away, then every interval until ctx is done. quota is the
+// financial record limit of the organizations without one of their own.
+func ScheduleRecurrences(ctx context.Context, s RecurrenceStore, interval, horizon time.Duration, quota int) {
+	ctx = WithActor(ctx, SchedulerActor)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		created, err := s.MaterializeRecurrences(ctx, start.Add(horizon), quota)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			slog.Error("Failed to create recurring financial records", "error", err, "created", created)
+		case created > 0:
+			slog.Info("Created recurring financial records", "count", created, "duration", time.Since(start))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// occurrenceRoom returns how many occurrences may be created for an
+// organization holding held financial records under limit, zero being
+// unlimited.
+func occurrenceRoom(limit int, held int64) int {
+	if limit <= 0 {
+		return domain.MaxOccurrencesPerAdvance
+	}
+	return int(max(int64(limit)-held, 0))
+}
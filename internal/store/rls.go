@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// currentOrgSetting is the Postgres setting holding the organization of the
+// current transaction, which the row-level security policies compare
+// organization_id with.
+const currentOrgSetting = "app.current_org"
+
+// currentOrg is the SQL expression of the organization of the current
+// transaction. It is NULL when the setting is missing or empty, which
+// matches no row.
+const currentOrg = `nullif(current_setting('` + currentOrgSetting + `', true), '')::bigint`
+
+// tenantPolicy names the row-level security policy of each tenant table.
+const tenantPolicy = "tenant_isolation"
+
+// tenantPolicies are the privileges of the tenant role and the USING and
+// WITH CHECK expressions of the tables holding organization data. Links are
+// visible when both their record and their tag are, since the subqueries
+// are themselves subject to the policies of financial_records and tags.
+// Payments and audit events can only be read and appended. The role may
+// lock the row of its organization, as the quota checks do, but not
+// change it.
+var tenantPolicies = []struct {
+	table, privileges, expr string
+}{
+	{"organizations", "SELECT, UPDATE (updated_at)", `id = ` + currentOrg},
+	{"tags", "SELECT, INSERT, UPDATE, DELETE", `organization_id = ` + currentOrg},
+	{"financial_records", "SELECT, INSERT, UPDATE, DELETE", `organization_id = ` + currentOrg},
+	{"recurrences", "SELECT, INSERT, UPDATE, DELETE", `organization_id = ` + currentOrg},
+	{"financial_record_tags", "SELECT, INSERT, UPDATE, DELETE", `EXISTS (SELECT 1 FROM financial_records r WHERE r.id = financial_record_id)
+		AND EXISTS (SELECT 1 FROM tags t WHERE t.id = tag_id)`},
+	{"payments", "SELECT, INSERT", `organization_id = ` + currentOrg},
+	{"audit_events", "SELECT, INSERT", `organization_id = ` + currentOrg},
+}
+
+// EnableRowLevelSecurity creates the role the stores switch to when built
+// with WithRowLevelSecurity, grants it access to the tenant tables, and
+// enables policies restricting it to the rows of the organization set in
+// each transaction. The table owner, which runs the migrations and the
+// routes that are not nested under an organization, is not restricted.
+//
+// The connecting user needs the right to create the role and to grant it
+// to itself. EnableRowLevelSecurity is idempotent.
+func EnableRowLevelSecurity(db *gorm.DB, role string) error {
+	ident := pgx.Identifier{role}.Sanitize()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var exists bool
+		if err := tx.Raw(`SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = ?)`, role).Scan(&exists).Error; err != nil {
+			return err
+		}
+		if !exists {
+			if err := tx.Exec(`CREATE ROLE ` + ident + ` NOLOGIN`).Error; err != nil {
+				return fmt.Errorf("create role %s: %w", role, err)
+			}
+		}
+		var member bool
+		if err := tx.Raw(`SELECT pg_has_role(CURRENT_USER, ?, 'MEMBER')`, role).Scan(&member).Error; err != nil {
+			return err
+		}
+		if !member {
+			if err := tx.Exec(`GRANT ` + ident + ` TO CURRENT_USER`).Error; err != nil {
+				return fmt.Errorf("grant role %s: %w", role, err)
+			}
+		}
+
+		for _, p := range tenantPolicies {
+			statements := []string{
+				`GRANT ` + p.privileges + ` ON ` + p.table + ` TO ` + ident,
+				`ALTER TABLE ` + p.table + ` ENABLE ROW LEVEL SECURITY`,
+				`DROP POLICY IF EXISTS ` + tenantPolicy + ` ON ` + p.table,
+				`CREATE POLICY ` + tenantPolicy + ` ON ` + p.table + ` TO ` + ident + `
+					USING (` + p.expr + `) WITH CHECK (` + p.expr + `)`,
+			}
+			for _, stmt := range statements {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return fmt.Errorf("secure %s: %w", p.table, err)
+				}
+			}
+		}
+
+		// Inserts draw IDs from the sequences of the tables.
+		for _, table := range []string{"tags", "financial_records", "payments", "recurrences", "audit_events"} {
+			var sequence string
+			if err := tx.Raw(`SELECT pg_get_serial_sequence(?, 'id')`, table).Scan(&sequence).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(`GRANT USAGE, SELECT ON SEQUENCE ` + sequence + ` TO ` + ident).Error; err != nil {
+				return fmt.Errorf("grant sequence of %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+// setTenantRole returns the statement switching the current transaction
+// to role.
+func setTenantRole(role string) string {
+	return `SET LOCAL ROLE ` + pgx.Identifier{role}.Sanitize()
+}
+
+// setCurrentOrg is the statement setting the organization of the current
+// transaction, with placeholder for the organization ID given by
+// currentOrgOf.
+func setCurrentOrg(placeholder string) string {
+	return `SELECT set_config('` + currentOrgSetting + `', ` + placeholder + `, true)`
+}
+
+// currentOrgOf returns the value of the organization setting for the
+// organization of ctx. Without an organization in ctx the setting is left
+// unset, so the transaction sees no row of the tenant tables at all.
+func currentOrgOf(ctx context.Context) (string, bool) {
+	orgID, ok := OrganizationFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(orgID), 10), true
+}
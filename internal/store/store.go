@@ -0,0 +1,265 @@
+// Package store persists organizations, API keys, tags, financial records
+// and the audit log of their changes. Handlers depend on the
+// OrganizationStore, APIKeyStore, TagStore, FinancialRecordStore and
+// AuditEventStore interfaces, implemented with GORM, with hand-written SQL
+// on pgx, and in memory for tests.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sofia/research-golang-and-postgres-performance/internal/domain"
+)
+
+var (
+	// ErrNotFound is returned when a looked-up row does not exist.
+	ErrNotFound = errors.New("store: not found")
+	// ErrDuplicate is returned when a write would break a uniqueness rule,
+	// such as two tags of an organization with the same name.
+	ErrDuplicate = errors.New("store: duplicate")
+)
+
+// Page selects a page of a listing. Numbers start at 1.
+type Page struct {
+	Number int
+	Size   int
+}
+
+// Offset returns the number of rows that precede the page.
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// TagFilter narrows ListTags. The zero value matches every tag of the
+// organization that is not deleted.
+type TagFilter struct {
+	// IncludeDeleted also lists soft-deleted tags.
+	IncludeDeleted bool
+}
+
+// FinancialRecordFilter narrows ListFinancialRecords. The zero value matches
+// every record of the organization that is not deleted.
+type FinancialRecordFilter struct {
+	// TagIDs keeps records linked to any of the given tags.
+	TagIDs []uint
+	// CounterpartyName keeps records whose counterparty has this name,
+	// ignoring case; CounterpartyDocument and Reference keep records with
+	// exactly this counterparty document and reference.
+	CounterpartyName     string
+	CounterpartyDocument string
+	Reference            string
+	// Metadata keeps records whose metadata contains this JSON object, in
+	// the sense of the Postgres @> operator.
+	Metadata json.RawMessage
+	// Query keeps records whose description, counterparty name or reference
+	// match the words of a full-text search, in Portuguese or English, and
+	// records whose counterparty name is similar to it. Matches are listed
+	// by relevance, then in insertion order.
+	Query string
+	// IncludeDeleted also lists soft-deleted records.
+	IncludeDeleted bool
+}
+
+// OrganizationStore persists organizations. Deleted organizations are
+// soft-deleted: their rows, tags and financial records are kept, but they
+// are no longer found or listed.
+type OrganizationStore interface {
+	// CreateOrganization inserts org and fills in its ID and timestamps.
+	CreateOrganization(ctx context.Context, org *domain.Organization) error
+	// GetOrganization returns the organization with the given ID, or
+	// ErrNotFound.
+	GetOrganization(ctx context.Context, id uint) (*domain.Organization, error)
+	// ListOrganizations returns one page of organizations and the total
+	// number of organizations.
+	ListOrganizations(ctx context.Context, page Page) ([]domain.Organization, int64, error)
+	// UpdateOrganization saves the name and settings of org and fills in
+	// its timestamps. It returns ErrNotFound when org does not exist.
+	UpdateOrganization(ctx context.Context, org *domain.Organization) error
+	// DeleteOrganization soft-deletes the organization, or returns
+	// ErrNotFound.
+	DeleteOrganization(ctx context.Context, id uint) error
+}
+
+// APIKeyStore persists API keys and their grants.
+type APIKeyStore interface {
+	// CreateAPIKey inserts key with its grants and fills in its ID and
+	// timestamps.
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) error
+	// FindAPIKeyByHash returns the key that is not revoked whose secret
+	// hashes to hash, with its grants, or ErrNotFound.
+	FindAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error)
+	// ListAPIKeys returns one page of the keys that are not revoked, with
+	// their grants, and the total number of such keys.
+	ListAPIKeys(ctx context.Context, page Page) ([]domain.APIKey, int64, error)
+	// RevokeAPIKey soft-deletes the key, or returns ErrNotFound.
+	RevokeAPIKey(ctx context.Context, id uint) error
+}
+
+// TagStore persists tags. Deleted tags are soft-deleted: they are hidden
+// from listings and from the records they label until restored, or until
+// PurgeDeleted removes them.
+type TagStore interface {
+	// CreateTag inserts tag, with its audit event, and fills in its ID and
+	// timestamps. It returns ErrDuplicate when the organization already has
+	// a tag with the same name, compared with domain.NormalizeTagName, and
+	// a *QuotaError when it holds as many tags as quota allows.
+	CreateTag(ctx context.Context, tag *domain.Tag, quota *Quota) error
+	// ListTags returns one page of the organization's tags and the total
+	// number of matching tags.
+	ListTags(ctx context.Context, orgID uint, filter TagFilter, page Page) ([]domain.Tag, int64, error)
+	// FindTagByName returns the organization's tag whose name matches name
+	// once both are normalized with domain.NormalizeTagName, or ErrNotFound.
+	FindTagByName(ctx context.Context, orgID uint, name string) (*domain.Tag, error)
+	// CountTags returns the number of the organization's tags that are not
+	// deleted.
+	CountTags(ctx context.Context, orgID uint) (int64, error)
+	// DeleteTag soft-deletes the organization's tag, or returns ErrNotFound
+	// when it does not exist or is already deleted.
+	DeleteTag(ctx context.Context, orgID, id uint) error
+	// RestoreTag undeletes the organization's tag and returns it. A tag
+	// that is not deleted is returned unchanged. It returns ErrNotFound
+	// when the tag does not exist, a *QuotaError when the organization
+	// holds as many tags as quota allows, and ErrDuplicate when another tag
+	// took its name in the meantime.
+	RestoreTag(ctx context.Context, orgID, id uint, quota *Quota) (*domain.Tag, error)
+}
+
+// FinancialRecordStore persists financial records and computes reports over
+// them. Deleted records are soft-deleted: they are hidden from listings and
+// reports until restored, or until PurgeDeleted removes them. Every write
+// that raises the paid amount of a record, its creation included, records
+// the domain.Payment of the difference.
+type FinancialRecordStore interface {
+	// CreateFinancialRecord inserts record, linking it to record.Tags, and
+	// fills in its ID and timestamps. Like every change of tags and
+	// financial records, it records an audit event in the same transaction.
+	// It returns a *QuotaError when the organization holds as many records
+	// as quota allows.
+	CreateFinancialRecord(ctx context.Context, record *domain.FinancialRecord, quota *Quota) error
+	// CreateFinancialRecords inserts records of one organization
+	// atomically, or none when they do not all fit in quota.
+	CreateFinancialRecords(ctx context.Context, records []domain.FinancialRecord, quota *Quota) error
+	// ListFinancialRecords returns one page of the organization's records,
+	// with their tags, and the total number of matching records.
+	ListFinancialRecords(ctx context.Context, orgID uint, filter FinancialRecordFilter, page Page) ([]domain.FinancialRecord, int64, error)
+	// CountFinancialRecords returns the number of the organization's
+	// records that are not deleted.
+	CountFinancialRecords(ctx context.Context, orgID uint) (int64, error)
+	// DeleteFinancialRecord soft-deletes the organization's record, or
+	// returns ErrNotFound when it does not exist or is already deleted.
+	DeleteFinancialRecord(ctx context.Context, orgID, id uint) error
+	// RestoreFinancialRecord undeletes the organization's record and
+	// returns it with its tags. A record that is not deleted is returned
+	// unchanged. It returns ErrNotFound when the record does not exist, a
+	// *QuotaError when the organization holds as many records as quota
+	// allows, and ErrDuplicate when it is an occurrence created again since
+	// it was deleted.
+	RestoreFinancialRecord(ctx context.Context, orgID, id uint, quota *Quota) (*domain.FinancialRecord, error)
+	// UpdateFinancialRecord locks the organization's record, passes it with
+	// its tags to update and saves the fields update leaves it with, all
+	// but its tags, then returns it. An update that changes nothing is not
+	// saved. It returns ErrNotFound when the record does not exist or is
+	// deleted, and the error of update as is, without saving.
+	UpdateFinancialRecord(ctx context.Context, orgID, id uint, update func(*domain.FinancialRecord) error) (*domain.FinancialRecord, error)
+	// CashFlowReport aggregates incoming and outgoing amounts per month of
+	// the time zone loc. On the domain.BasisDue basis, it sums the amounts
+	// of the records due on or after since that are not cancelled, by due
+	// date; on the domain.BasisPaid basis, the payments made on or after
+	// since, by payment date.
+	CashFlowReport(ctx context.Context, orgID uint, basis string, since time.Time, loc *time.Location) ([]domain.MonthlyCashFlow, error)
+}
+
+// RecurrenceStore persists recurrences and creates their occurrences, the
+// financial records they repeat. Each occurrence is created once: a series
+// counts the occurrences it created, so that a deleted or purged one is
+// not created again, unless EditRecurrence deleted it at the end of a
+// series that is extended later. Occurrences are created while the
+// organization holds fewer financial records than its limit, zero being
+// unlimited, and at most domain.MaxOccurrencesPerAdvance per series at a
+// time; the others stay due.
+type RecurrenceStore interface {
+	// CreateRecurrence attaches rec to the organization's record with
+	// domain.Recurrence.Attach, inserts it with the occurrences due up to
+	// until that fit in quota, and fills in its ID and timestamps. It
+	// returns ErrNotFound when the record does not exist or is deleted,
+	// the error of Attach as is, and a *QuotaError when the quota leaves
+	// no room for an occurrence.
+	CreateRecurrence(ctx context.Context, orgID, recordID uint, rec *domain.Recurrence, until time.Time, quota *Quota) error
+	// GetRecurrence returns the organization's recurrence, or ErrNotFound.
+	GetRecurrence(ctx context.Context, orgID, id uint) (*domain.Recurrence, error)
+	// ListRecurrences returns one page of the organization's recurrences
+	// and their total number.
+	ListRecurrences(ctx context.Context, orgID uint, page Page) ([]domain.Recurrence, int64, error)
+	// DeleteRecurrence soft-deletes the organization's recurrence, which
+	// ends the series: no occurrence is created after, and the ones
+	// created are kept. It returns ErrNotFound when the recurrence does
+	// not exist or is already deleted.
+	DeleteRecurrence(ctx context.Context, orgID, id uint) error
+	// EditRecurrence locks the recurrence of the organization's record,
+	// then passes it to edit with the record, and its tags, followed by
+	// the later occurrences that are not deleted, in order. It saves the
+	// recurrence and the occurrences edit changed or deleted, creates the
+	// occurrences due up to until that fit in limit and returns the
+	// record. It returns
+	// ErrNotFound when the record does not exist or is deleted,
+	// domain.ErrRecordNotRecurring when it has no recurrence or its
+	// recurrence is deleted, and the error of edit as is, without saving.
+	EditRecurrence(ctx context.Context, orgID, recordID uint, edit func(*domain.Recurrence, []domain.FinancialRecord) error, until time.Time, limit int) (*domain.FinancialRecord, error)
+	// MaterializeRecurrences creates the occurrences of the recurrences of
+	// every organization that is not deleted due up to until, and returns
+	// how many it created. The limit of each organization is its FinancialRecordLimit
+	// with quota as the default. Concurrent calls, from several servers,
+	// create each occurrence once.
+	MaterializeRecurrences(ctx context.Context, until time.Time, quota int) (int, error)
+}
+
+// AuditEventFilter narrows ListAuditEvents. The zero value matches every
+// event of the organization.
+type AuditEventFilter struct {
+	EntityType string
+	EntityID   uint
+	Action     string
+	Actor      string
+	// Since and Until bound the creation time of the events, Since
+	// included and Until excluded. Zero times leave the range open.
+	Since time.Time
+	Until time.Time
+}
+
+// AuditEventStore reads the audit log. Its events are written by the tag
+// and financial record stores, in the transaction of the change they
+// record.
+type AuditEventStore interface {
+	// ListAuditEvents returns one page of the organization's events, newest
+	// first, and the total number of matching events.
+	ListAuditEvents(ctx context.Context, orgID uint, filter AuditEventFilter, page Page) ([]domain.AuditEvent, int64, error)
+}
+
+// Stores bundles the storage implementations the handlers depend on.
+type Stores struct {
+	Organizations    OrganizationStore
+	APIKeys          APIKeyStore
+	Tags             TagStore
+	FinancialRecords FinancialRecordStore
+	AuditEvents      AuditEventStore
+	Recurrences      RecurrenceStore
+}
+
+type organizationKey struct{}
+
+// WithOrganization returns a copy of ctx naming the organization the
+// request acts on. With row-level security enabled, the GORM and pgx stores
+// only see the tags and financial records of that organization, whatever
+// the organization ID passed to their methods.
+func WithOrganization(ctx context.Context, orgID uint) context.Context {
+	return context.WithValue(ctx, organizationKey{}, orgID)
+}
+
+// OrganizationFromContext returns the organization set by WithOrganization.
+func OrganizationFromContext(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(organizationKey{}).(uint)
+	return id, ok
+}
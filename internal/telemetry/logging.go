@@ -0,0 +1,155 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// NewLogger builds the JSON slog logger used by the whole process.
+func NewLogger(level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	return slog.New(handler)
+}
+
+type requestIDKey struct{}
+
+type dbStatsKey struct{}
+
+// dbStats accumulates the time spent in database statements issued while
+// serving a single request.
+type dbStats struct {
+	mu       sync.Mutex
+	duration time.Duration
+	queries  int
+}
+
+func (s *dbStats) add(d time.Duration) {
+	s.mu.Lock()
+	s.duration += d
+	s.queries++
+	s.mu.Unlock()
+}
+
+func (s *dbStats) snapshot() (time.Duration, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duration, s.queries
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID and a fresh
+// database time accumulator for the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return context.WithValue(ctx, dbStatsKey{}, &dbStats{})
+}
+
+// RequestIDFromContext returns the request ID attached by WithRequestID, if
+// any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AddDBTime records a database statement that took d against the request in
+// ctx.
+func AddDBTime(ctx context.Context, d time.Duration) {
+	if stats, ok := ctx.Value(dbStatsKey{}).(*dbStats); ok {
+		stats.add(d)
+	}
+}
+
+// DBTimeFromContext returns the total time spent in database statements for
+// the request in ctx, and the number of statements. ok is false when ctx does
+// not carry an accumulator.
+func DBTimeFromContext(ctx context.Context) (total time.Duration, queries int, ok bool) {
+	stats, ok := ctx.Value(dbStatsKey{}).(*dbStats)
+	if !ok {
+		return 0, 0, false
+	}
+	total, queries = stats.snapshot()
+	return total, queries, true
+}
+
+// gormSlogLogger routes GORM's statement logging through slog. Failed
+// statements are logged as errors, statements slower than slowThreshold as
+// warnings and, at debug level, every statement is logged. Statement time is
+// also added to the per-request DB time.
+type gormSlogLogger struct {
+	logger        *slog.Logger
+	level         gormlogger.LogLevel
+	slowThreshold time.Duration
+}
+
+// NewGormLogger returns a GORM logger backed by logger. A zero
+// slowThreshold disables slow-query warnings. Every statement is logged when
+// logger is enabled at debug level.
+func NewGormLogger(logger *slog.Logger, slowThreshold time.Duration) gormlogger.Interface {
+	level := gormlogger.Warn
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		level = gormlogger.Info
+	}
+	return &gormSlogLogger{
+		logger:        logger.With(slog.String("component", "gorm")),
+		level:         level,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (l *gormSlogLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	clone := *l
+	clone.level = level
+	return &clone
+}
+
+func (l *gormSlogLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Info {
+		l.logger.InfoContext(ctx, fmt.Sprintf(msg, args...), slog.String("request_id", RequestIDFromContext(ctx)))
+	}
+}
+
+func (l *gormSlogLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		l.logger.WarnContext(ctx, fmt.Sprintf(msg, args...), slog.String("request_id", RequestIDFromContext(ctx)))
+	}
+}
+
+func (l *gormSlogLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Error {
+		l.logger.ErrorContext(ctx, fmt.Sprintf(msg, args...), slog.String("request_id", RequestIDFromContext(ctx)))
+	}
+}
+
+func (l *gormSlogLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	AddDBTime(ctx, elapsed)
+	if l.level <= gormlogger.Silent {
+		return
+	}
+
+	attrs := func() []slog.Attr {
+		sql, rows := fc()
+		return []slog.Attr{
+			slog.String("request_id", RequestIDFromContext(ctx)),
+			slog.String("sql", sql),
+			slog.Int64("rows", rows),
+			slog.Duration("elapsed", elapsed),
+		}
+	}
+
+	switch {
+	case err != nil && l.level >= gormlogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		l.logger.LogAttrs(ctx, slog.LevelError, "query failed", append(attrs(), slog.String("error", err.Error()))...)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= gormlogger.Warn:
+		l.logger.LogAttrs(ctx, slog.LevelWarn, "slow query", append(attrs(), slog.Duration("threshold", l.slowThreshold))...)
+	case l.level >= gormlogger.Info:
+		l.logger.LogAttrs(ctx, slog.LevelDebug, "query", attrs()...)
+	}
+}
@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewPgxTracer returns the pgx query tracer used by the native pool.
+func NewPgxTracer(logger *slog.Logger, slowThreshold time.Duration) pgx.QueryTracer {
+	return &pgxTracer{
+		tracer:        otel.Tracer("github.com/jackc/pgx/v5"),
+		logger:        logger.With(slog.String("component", "pgx")),
+		slowThreshold: slowThreshold,
+	}
+}
+
+// pgxTracer is the pgx counterpart of the GORM tracing plugin and slog
+// logger: it opens a span per statement, adds the statement time to the
+// request's DB time and logs failed and slow statements.
+type pgxTracer struct {
+	tracer        trace.Tracer
+	logger        *slog.Logger
+	slowThreshold time.Duration
+}
+
+type pgxQueryKey struct{}
+
+type pgxQuery struct {
+	sql   string
+	start time.Time
+	span  trace.Span
+}
+
+func (t *pgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, span := t.tracer.Start(ctx, "pgx.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBQueryText(data.SQL),
+		),
+	)
+	return context.WithValue(ctx, pgxQueryKey{}, &pgxQuery{sql: data.SQL, start: time.Now(), span: span})
+}
+
+func (t *pgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(pgxQueryKey{}).(*pgxQuery)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(q.start)
+	AddDBTime(ctx, elapsed)
+
+	rows := data.CommandTag.RowsAffected()
+	q.span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+	if data.Err != nil {
+		q.span.RecordError(data.Err)
+		q.span.SetStatus(codes.Error, data.Err.Error())
+	}
+	q.span.End()
+
+	attrs := []slog.Attr{
+		slog.String("request_id", RequestIDFromContext(ctx)),
+		slog.String("sql", q.sql),
+		slog.Int64("rows", rows),
+		slog.Duration("elapsed", elapsed),
+	}
+	switch {
+	case data.Err != nil:
+		t.logger.LogAttrs(ctx, slog.LevelError, "query failed", append(attrs, slog.String("error", data.Err.Error()))...)
+	case t.slowThreshold > 0 && elapsed > t.slowThreshold:
+		t.logger.LogAttrs(ctx, slog.LevelWarn, "slow query", append(attrs, slog.Duration("threshold", t.slowThreshold))...)
+	default:
+		t.logger.LogAttrs(ctx, slog.LevelDebug, "query", attrs...)
+	}
+}
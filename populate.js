@@ -9,7 +9,46 @@ export const options = {
 };
 
 // Base URL for the API
-const BASE_URL = 'http://localhost:8080';
+const BASE_URL = 'http://localhost:8080/api/v1';
+
+// API key sent with every request, the admin key of docker-compose.yml by
+// default
+const API_KEY = __ENV.API_KEY || 'local-admin-key';
+
+// Headers of every request
+export const headers = {
+  'Authorization': `Bearer ${API_KEY}`,
+  'Content-Type': 'application/json',
+};
+
+// Number of organizations the load tests spread their requests over
+const nOrganizations = 10;
+
+// Function to make sure nOrganizations organizations exist, creating the
+// missing ones, and return their IDs
+export function ensureOrganizations() {
+  const response = http.get(`${BASE_URL}/organizations?page_size=${nOrganizations}`, { headers });
+  if (response.status !== 200) {
+    throw new Error(`Failed to list organizations: ${response.status} ${response.body}`);
+  }
+  const orgIds = JSON.parse(response.body).data.map((org) => org.ID);
+
+  while (orgIds.length < nOrganizations) {
+    const created = http.post(
+      `${BASE_URL}/organizations`,
+      JSON.stringify({ name: `Load Test Organization ${orgIds.length + 1}` }),
+      {
+        headers,
+      }
+    );
+    if (created.status !== 201) {
+      throw new Error(`Failed to create organization: ${created.status} ${created.body}`);
+    }
+    orgIds.push(JSON.parse(created.body).ID);
+  }
+
+  return orgIds;
+}
 
 // Function to generate a random tag name
 function generateRandomTagName() {
@@ -31,11 +70,37 @@ function generateRandomDate() {
   return new Date(randomTime).toISOString()
 }
 
+// Counterparties and descriptions of the generated records, in Portuguese
+// and English, for the search scenario
+export const counterparties = [
+  'Imobiliária Central', 'Distribuidora Santos', 'Pagamentos Rápidos', 'Companhia Energética', 'Águas do Vale',
+  'Transportadora Silva', 'Acme Corporation', 'Globex Software', 'Initech Consulting', 'Umbrella Supplies',
+];
+export const descriptionWords = [
+  'aluguel', 'escritório', 'energia', 'água', 'salários', 'impostos', 'frete', 'consultoria', 'licença', 'manutenção',
+  'rent', 'office', 'payroll', 'taxes', 'shipping', 'consulting', 'license', 'maintenance', 'subscription', 'invoice',
+];
+
+// Function to pick a random element of an array
+function pick(values) {
+  return values[Math.floor(Math.random() * values.length)];
+}
+
+// Function to generate a random description of two to four words
+function generateRandomDescription() {
+  const words = [];
+  const numWords = 2 + Math.floor(Math.random() * 3);
+  for (let i = 0; i < numWords; i++) {
+    words.push(pick(descriptionWords));
+  }
+  return words.join(' ');
+}
+
 // Function to get all tags for an organization
 function getAllTags(orgId) {
   for(let attempt = 0; attempt < 32; attempt++) {
     try {
-      const response = http.get(`${BASE_URL}/organizations/${orgId}/tags`);
+      const response = http.get(`${BASE_URL}/organizations/${orgId}/tags`, { headers });
       
       if (response.status !== 200) {
         console.log(`Error getting tags for organization ${orgId}: API returned status ${response.status}`);
@@ -89,7 +154,10 @@ export function createFinancialRecords(orgId, count) {
       direction,
       amount,
       dueDate,
-      tags: selectRandomTags(tags, numTags)
+      tags: selectRandomTags(tags, numTags),
+      description: generateRandomDescription(),
+      counterpartyName: pick(counterparties),
+      reference: `NF-${Math.floor(Math.random() * 1000000)}`,
     };
 
     payloads.push(payload);
@@ -98,9 +166,7 @@ export function createFinancialRecords(orgId, count) {
   // console.log({payloads});
   
   const response = http.post(`${BASE_URL}/organizations/${orgId}/financial-records/bulk`, JSON.stringify(payloads), {
-    headers: {
-      'Content-Type': 'application/json',
-    },
+    headers,
   });
 
   if (response.status !== 201) {
@@ -139,9 +205,7 @@ function createTagsForOrganization(orgId) {
         `${BASE_URL}/organizations/${orgId}/tags`,
         JSON.stringify({ name: tagName }),
         {
-          headers: {
-            'Content-Type': 'application/json',
-          },
+          headers,
         }
       );
 
@@ -175,7 +239,7 @@ function createTagsForOrganization(orgId) {
 function getNumberOfTags(orgId) {
   for(let attempt = 0; attempt < 32; attempt++) {
     try {
-      const response = http.get(`${BASE_URL}/organizations/${orgId}/tags`);
+      const response = http.get(`${BASE_URL}/organizations/${orgId}/tags`, { headers });
       
       if (response.status !== 200) {
         console.log(`Error getting number of tags for organization ${orgId}: API returned status ${response.status}`);
@@ -199,9 +263,13 @@ function getNumberOfTags(orgId) {
   return 0;
 }
 
-export default function () {
-  // Randomly select an organization ID (1-10)
-  const orgId = Math.max(1, (exec.vu.idInTest % 10) + 1);
+export function setup() {
+  return { orgIds: ensureOrganizations() };
+}
+
+export default function (data) {
+  // Select one of the organizations created in setup
+  const orgId = data.orgIds[exec.vu.idInTest % data.orgIds.length];
 
   createTagsForOrganization(orgId);
   createFinancialRecords(orgId, 400);
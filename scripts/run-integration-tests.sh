@@ -18,7 +18,7 @@ docker exec -it research-golang-and-postgres-performance-db-1 psql -U postgres -
 echo "Running integration tests..."
 
 # Run integration tests
-go test -v
+go test -v -tags integration ./...
 
 # Record end time and calculate duration
 end_time=$(date +%s)
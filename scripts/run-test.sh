@@ -3,6 +3,8 @@
 # Default values
 TEST_NUMBER=${1:-0}
 DURATION=${2:-60s}
+# Admin key of docker-compose.yml unless set
+export API_KEY=${API_KEY:-${ADMIN_API_KEY:-local-admin-key}}
 
 echo "Running test ${TEST_NUMBER} for ${DURATION}..."
 
@@ -15,7 +17,7 @@ echo "Deleting financial_records..."
 docker exec -it research-golang-and-postgres-performance-db-1 psql -U postgres -d financial_db -c "DELETE FROM financial_records;"
 
 echo "Running populate.js..."
-K6_WEB_DASHBOARD=true K6_WEB_DASHBOARD_EXPORT=./reports/test-${TEST_NUMBER}-populate.html k6 run --vus 100 --duration ${DURATION} populate.js
+K6_WEB_DASHBOARD=true K6_WEB_DASHBOARD_EXPORT=./reports/test-${TEST_NUMBER}-populate.html k6 run --vus 100 --duration ${DURATION} -e API_KEY=${API_KEY} populate.js
 
 # Connect to the database and getting count of tags
 docker exec -it research-golang-and-postgres-performance-db-1 psql -U postgres -d financial_db -c "SELECT COUNT(*) FROM tags;" > ./reports/test-${TEST_NUMBER}-populate-tags-count.txt
@@ -25,5 +27,17 @@ echo "Tags:\n $(cat ./reports/test-${TEST_NUMBER}-populate-tags-count.txt)"
 docker exec -it research-golang-and-postgres-performance-db-1 psql -U postgres -d financial_db -c "SELECT COUNT(*) FROM financial_records;" > ./reports/test-${TEST_NUMBER}-populate-financial-records-count.txt
 echo "Financial records:\n $(cat ./reports/test-${TEST_NUMBER}-populate-financial-records-count.txt)"
 
+# Snapshot admission control and pool metrics after the write-heavy phase
+curl -s -H "Authorization: Bearer ${API_KEY}" http://localhost:8080/debug/vars > ./reports/test-${TEST_NUMBER}-populate-vars.json
+
 echo "Running cash-flow.js..."
-K6_WEB_DASHBOARD=true K6_WEB_DASHBOARD_EXPORT=./reports/test-${TEST_NUMBER}-cash-flow.html k6 run --vus 100 --duration 60s cash-flow.js
+K6_WEB_DASHBOARD=true K6_WEB_DASHBOARD_EXPORT=./reports/test-${TEST_NUMBER}-cash-flow.html k6 run --vus 100 --duration 60s -e API_KEY=${API_KEY} cash-flow.js
+
+# Snapshot admission control and pool metrics after the report phase
+curl -s -H "Authorization: Bearer ${API_KEY}" http://localhost:8080/debug/vars > ./reports/test-${TEST_NUMBER}-cash-flow-vars.json
+
+echo "Running search.js..."
+K6_WEB_DASHBOARD=true K6_WEB_DASHBOARD_EXPORT=./reports/test-${TEST_NUMBER}-search.html k6 run --vus 100 --duration 60s -e API_KEY=${API_KEY} search.js
+
+# Snapshot admission control and pool metrics after the search phase
+curl -s -H "Authorization: Bearer ${API_KEY}" http://localhost:8080/debug/vars > ./reports/test-${TEST_NUMBER}-search-vars.json
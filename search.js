@@ -0,0 +1,53 @@
+import http from 'k6/http';
+import { check } from 'k6';
+import exec from 'k6/execution';
+import { counterparties, descriptionWords, ensureOrganizations, headers } from './populate.js';
+export const options = {
+  vus: 100,
+  duration: '15s',
+  // duration: '60s',
+};
+
+const BASE_URL = 'http://localhost:8080/api/v1';
+
+// Misspelled counterparty names, found by trigram similarity only
+const misspelled = ['imobiliaria centarl', 'distribuidora sanots', 'companhia energetca', 'globex sofware'];
+
+// Function to pick a random element of an array
+function pick(values) {
+  return values[Math.floor(Math.random() * values.length)];
+}
+
+// Function to generate a search: one or two words of the descriptions, a
+// counterparty name or a misspelled one
+function generateRandomSearch() {
+  const kind = Math.random();
+  if (kind < 0.5) {
+    return Math.random() < 0.5 ? pick(descriptionWords) : `${pick(descriptionWords)} ${pick(descriptionWords)}`;
+  }
+  if (kind < 0.8) {
+    return pick(counterparties);
+  }
+  return pick(misspelled);
+}
+
+export function setup() {
+  return { orgIds: ensureOrganizations() };
+}
+
+export default function (data) {
+  const orgId = data.orgIds[exec.vu.idInTest % data.orgIds.length];
+  const q = encodeURIComponent(generateRandomSearch());
+
+  const response = http.get(`${BASE_URL}/organizations/${orgId}/financial-records?q=${q}&page_size=20`, {
+    headers,
+    tags: { name: 'search' },
+  });
+
+  check(response, {
+    'is status 200': (r) => r.status === 200,
+  });
+  if (response.status !== 200) {
+    console.log(`Failed to search financial records for organization ${orgId}: ${response.status} ${response.body}`);
+  }
+}